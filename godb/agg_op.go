@@ -1,5 +1,7 @@
 package godb
 
+import "hash/fnv"
+
 type Aggregator struct {
 	// Expressions that when applied to tuples from the child operators,
 	// respectively, return the value of the group by key tuple
@@ -10,6 +12,14 @@ type Aggregator struct {
 	newAggState []AggState
 
 	child Operator // the child operator for the inputs to aggregate
+
+	// How the tuples of a group are found.  If sorted is set, the child is
+	// sorted on the group by fields, so that the tuples of each group are
+	// adjacent.  Otherwise, they are found by hashing their group by key, in
+	// the given number of passes over the child, each of which aggregates the
+	// groups whose keys fall in one partition (see [partitionOf]).
+	sorted bool
+	passes int
 }
 
 type AggType int
@@ -23,12 +33,12 @@ const DefaultGroup int = 0 // for handling the case of no group-by
 
 // Construct an aggregator with a group-by.
 func NewGroupedAggregator(emptyAggState []AggState, groupByFields []Expr, child Operator) *Aggregator {
-	return &Aggregator{groupByFields, emptyAggState, child}
+	return &Aggregator{groupByFields, emptyAggState, child, false, 1}
 }
 
 // Construct an aggregator with no group-by.
 func NewAggregator(emptyAggState []AggState, child Operator) *Aggregator {
-	return &Aggregator{nil, emptyAggState, child}
+	return &Aggregator{nil, emptyAggState, child, false, 1}
 }
 
 // Return a TupleDescriptor for this aggregation.
@@ -68,13 +78,20 @@ func (a *Aggregator) Descriptor() *TupleDesc {
 // the iterator simply iterates through only one tuple, representing the
 // aggregation of all child tuples.
 func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(a.Open(tid))
+}
+
+// Open an iterator over the results of the aggregate.  Closing it closes the
+// iterator of the child and drops the state of the groups.
+func (a *Aggregator) Open(tid TransactionID) (*OpIterator, error) {
 	// the child iterator
-	childIter, err := a.child.Iterator(tid)
+	childIter, err := a.child.Open(tid)
 	if err != nil {
 		return nil, err
 	}
-	if childIter == nil {
-		return nil, GoDBError{MalformedDataError, "child iter unexpectedly nil"}
+
+	if a.sorted && a.groupByFields != nil {
+		return a.sortedIterator(tid, childIter), nil
 	}
 
 	// the map that stores the aggregation state of each group
@@ -84,6 +101,7 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 		for _, as := range a.newAggState {
 			copy := as.Copy()
 			if copy == nil {
+				childIter.Close()
 				return nil, GoDBError{MalformedDataError, "aggState Copy unexpectedly returned nil"}
 			}
 			newAggState = append(newAggState, copy)
@@ -96,53 +114,135 @@ func (a *Aggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error)
 	var groupByList []*Tuple
 	// the iterator for iterating thru the finalized aggregation results for each group
 	var finalizedIter func() (*Tuple, error)
+	// the partition of the groups aggregated by this pass over the child
+	pass := 0
 
-	return func() (*Tuple, error) {
-		// iterates thru all child tuples
-		for t, err := childIter(); t != nil || err != nil; t, err = childIter() {
-			if err != nil {
-				return nil, err
-			}
-			if t == nil {
-				return nil, nil
-			}
-
-			if a.groupByFields == nil { // adds tuple to the aggregation in the case of no group-by
-				for i := 0; i < len(a.newAggState); i++ {
-					(*aggState[DefaultGroup])[i].AddTuple(t)
-				}
-			} else { // adds tuple to the aggregation with grouping
-				keygenTup, err := extractGroupByKeyTuple(a, t)
+	return newOpIterator(func() (*Tuple, error) {
+		for {
+			// iterates thru all child tuples
+			for t, err := childIter.Next(); t != nil || err != nil; t, err = childIter.Next() {
 				if err != nil {
 					return nil, err
 				}
+				if t == nil {
+					return nil, nil
+				}
+				if err := checkCanceled(tid); err != nil {
+					return nil, err
+				}
 
-				key := keygenTup.tupleKey()
-				if aggState[key] == nil {
-					asNew := make([]AggState, len(a.newAggState))
-					aggState[key] = &asNew
-					groupByList = append(groupByList, keygenTup)
+				if a.groupByFields == nil { // adds tuple to the aggregation in the case of no group-by
+					for i := 0; i < len(a.newAggState); i++ {
+						(*aggState[DefaultGroup])[i].AddTuple(t)
+					}
+				} else { // adds tuple to the aggregation with grouping
+					keygenTup, err := extractGroupByKeyTuple(a, t)
+					if err != nil {
+						return nil, err
+					}
+
+					key := keygenTup.tupleKey()
+					if a.passes > 1 && partitionOf(key, a.passes) != pass {
+						continue
+					}
+					if aggState[key] == nil {
+						asNew := make([]AggState, len(a.newAggState))
+						aggState[key] = &asNew
+						groupByList = append(groupByList, keygenTup)
+					}
+
+					addTupleToGrpAggState(a, t, aggState[key])
 				}
+			}
 
-				addTupleToGrpAggState(a, t, aggState[key])
+			if finalizedIter == nil { // builds the iterator for iterating thru the finalized aggregation results for each group
+				if a.groupByFields == nil {
+					var tup *Tuple
+					for i := 0; i < len(a.newAggState); i++ {
+						newTup := (*aggState[DefaultGroup])[i].Finalize()
+						tup = joinTuples(tup, newTup)
+					}
+					finalizedIter = func() (*Tuple, error) { return nil, nil }
+					return tup, nil
+				} else {
+					finalizedIter = getFinalizedTuplesIterator(a, groupByList, aggState)
+				}
+			}
+			t, err := finalizedIter()
+			if t != nil || err != nil || a.groupByFields == nil || pass+1 >= a.passes {
+				return t, err
+			}
+
+			// start the next pass over the child
+			pass++
+			aggState = make(map[any]*[]AggState)
+			groupByList = nil
+			finalizedIter = nil
+			if childIter, err = a.child.Open(tid); err != nil {
+				return nil, err
 			}
 		}
+	}, func() error {
+		aggState, groupByList, finalizedIter = nil, nil, nil
+		return closeIterators(childIter)
+	}), nil
+}
+
+// Returns an iterator over the groups of a child iterator whose tuples are
+// sorted on the group by fields, which keeps the state of a single group.
+func (a *Aggregator) sortedIterator(tid TransactionID, childIter *OpIterator) *OpIterator {
+	var gby *Tuple // the key tuple of the current group
+	var gbyKey any
+	var state []AggState
+	done := false
+	return newOpIterator(func() (*Tuple, error) {
+		for !done {
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			t, err := childIter.Next()
+			if err != nil {
+				return nil, err
+			}
+			var keygenTup *Tuple
+			var key any
+			if t == nil {
+				done = true
+			} else {
+				if keygenTup, err = extractGroupByKeyTuple(a, t); err != nil {
+					return nil, err
+				}
+				key = keygenTup.tupleKey()
+				if gby != nil && key == gbyKey {
+					addTupleToGrpAggState(a, t, &state)
+					continue
+				}
+			}
 
-		if finalizedIter == nil { // builds the iterator for iterating thru the finalized aggregation results for each group
-			if a.groupByFields == nil {
+			// t starts a new group, so the current one is complete
+			finished, finishedState := gby, state
+			gby, gbyKey, state = keygenTup, key, make([]AggState, len(a.newAggState))
+			if t != nil {
+				addTupleToGrpAggState(a, t, &state)
+			}
+			if finished != nil {
 				var tup *Tuple
-				for i := 0; i < len(a.newAggState); i++ {
-					newTup := (*aggState[DefaultGroup])[i].Finalize()
-					tup = joinTuples(tup, newTup)
+				for _, as := range finishedState {
+					tup = joinTuples(tup, as.Finalize())
 				}
-				finalizedIter = func() (*Tuple, error) { return nil, nil }
-				return tup, nil
-			} else {
-				finalizedIter = getFinalizedTuplesIterator(a, groupByList, aggState)
+				return joinTuples(finished, tup), nil
 			}
 		}
-		return finalizedIter()
-	}, nil
+		return nil, nil
+	}, childIter.Close)
+}
+
+// Returns the partition, between 0 and passes - 1, of a key returned by
+// [Tuple.tupleKey].
+func partitionOf(key any, passes int) int {
+	h := fnv.New32a()
+	h.Write([]byte(key.(string)))
+	return int(h.Sum32() % uint32(passes))
 }
 
 // Given a tuple t from a child iterator, return a tuple that identifies t's
@@ -1,5 +1,7 @@
 package godb
 
+import "math/big"
+
 // interface for an aggregation state
 type AggState interface {
 	// Initializes an aggregation state. Is supplied with an alias, an expr to
@@ -20,6 +22,30 @@ type AggState interface {
 	GetTupleDesc() *TupleDesc
 }
 
+// An aggregation state whose input is the value of its expression for each
+// tuple, which a [BatchAggregator] evaluates over a batch at once.
+type valueAggState interface {
+	AggState
+
+	// Returns the expression whose values are aggregated, or nil if the
+	// aggregate does not depend on them.
+	valueExpr() Expr
+
+	// Adds the value of the expression for a tuple.
+	addValue(v DBValue)
+}
+
+// An aggregation state that can be combined with a state of the same
+// aggregate over other tuples, so that a [ParallelAggregator] can aggregate
+// parts of its input separately and merge the partial states.
+type mergeableAggState interface {
+	AggState
+
+	// Adds the tuples aggregated by other, a state of the same type, to the
+	// state.
+	merge(other AggState)
+}
+
 // Implements the aggregation state for COUNT
 type CountAggState struct {
 	alias string
@@ -42,6 +68,18 @@ func (a *CountAggState) AddTuple(t *Tuple) {
 	a.count++
 }
 
+func (a *CountAggState) valueExpr() Expr {
+	return nil
+}
+
+func (a *CountAggState) addValue(v DBValue) {
+	a.count++
+}
+
+func (a *CountAggState) merge(other AggState) {
+	a.count += other.(*CountAggState).count
+}
+
 func (a *CountAggState) Finalize() *Tuple {
 	td := a.GetTupleDesc()
 	f := IntField{int64(a.count)}
@@ -58,11 +96,78 @@ func (a *CountAggState) GetTupleDesc() *TupleDesc {
 	return &td
 }
 
+// A running sum of int, float or decimal values.  The decimal sum is kept
+// exact, and big.Rat values are never modified in place, so copies of a
+// numericSum do not share state.
+type numericSum struct {
+	i     int64
+	f     float64
+	d     *big.Rat
+	scale int // largest scale of the decimals added
+}
+
+func (s *numericSum) add(v DBValue) {
+	switch v := v.(type) {
+	case IntField:
+		s.i += v.Value
+	case FloatField:
+		s.f += v.Value
+	case DecimalField:
+		if s.d == nil {
+			s.d = new(big.Rat)
+		}
+		s.d = new(big.Rat).Add(s.d, v.rat())
+		s.scale = max(s.scale, v.Scale)
+	}
+}
+
+// Adds the values summed by other to the sum.
+func (s *numericSum) merge(other numericSum) {
+	s.i += other.i
+	s.f += other.f
+	if other.d != nil {
+		if s.d == nil {
+			s.d = new(big.Rat)
+		}
+		s.d = new(big.Rat).Add(s.d, other.d)
+	}
+	s.scale = max(s.scale, other.scale)
+}
+
+// Returns the sum as a value of type t, divided by n.
+func (s *numericSum) result(t DBType, n int64) DBValue {
+	switch t {
+	case FloatType:
+		return FloatField{s.f / float64(n)}
+	case DecimalType:
+		r := new(big.Rat)
+		if s.d != nil {
+			r.Quo(s.d, new(big.Rat).SetInt64(n))
+		}
+		d, err := decimalFromRat(r, s.scale)
+		if err != nil {
+			return DecimalField{}
+		}
+		return d
+	}
+	return IntField{s.i / n}
+}
+
+// The type of SUM and AVG over expr: the type of expr if it is a float or a
+// decimal, and int otherwise.
+func sumType(expr Expr) DBType {
+	t := expr.GetExprType().Ftype
+	if t == FloatType || t == DecimalType {
+		return t
+	}
+	return IntType
+}
+
 // Implements the aggregation state for SUM
 type SumAggState struct {
 	alias string
 	expr  Expr
-	sum   int64
+	sum   numericSum
 }
 
 func (a *SumAggState) Copy() AggState {
@@ -80,7 +185,7 @@ func stringAggGetter(v DBValue) any {
 }
 
 func (a *SumAggState) Init(alias string, expr Expr) error {
-	a.sum = 0
+	a.sum = numericSum{}
 	a.expr = expr
 	a.alias = alias
 	return nil
@@ -91,18 +196,27 @@ func (a *SumAggState) AddTuple(t *Tuple) {
 	if err != nil {
 		return
 	}
-	switch v.(type) {
-	case IntField:
-		a.sum += v.(IntField).Value
-	}
+	a.addValue(v)
+}
+
+func (a *SumAggState) valueExpr() Expr {
+	return a.expr
+}
+
+func (a *SumAggState) addValue(v DBValue) {
+	a.sum.add(v)
+}
+
+func (a *SumAggState) merge(other AggState) {
+	a.sum.merge(other.(*SumAggState).sum)
 }
 
 func (a *SumAggState) GetTupleDesc() *TupleDesc {
-	return &TupleDesc{[]FieldType{{a.alias, "", IntType}}}
+	return &TupleDesc{[]FieldType{{a.alias, "", sumType(a.expr)}}}
 }
 
 func (a *SumAggState) Finalize() *Tuple {
-	return &Tuple{*a.GetTupleDesc(), []DBValue{IntField{a.sum}}, nil}
+	return &Tuple{*a.GetTupleDesc(), []DBValue{a.sum.result(sumType(a.expr), 1)}, nil}
 }
 
 // Implements the aggregation state for AVG
@@ -111,7 +225,7 @@ func (a *SumAggState) Finalize() *Tuple {
 type AvgAggState struct {
 	alias string
 	expr  Expr
-	sum   int64
+	sum   numericSum
 	count int64
 }
 
@@ -120,7 +234,7 @@ func (a *AvgAggState) Copy() AggState {
 }
 
 func (a *AvgAggState) Init(alias string, expr Expr) error {
-	a.sum = 0
+	a.sum = numericSum{}
 	a.count = 0
 	a.expr = expr
 	a.alias = alias
@@ -132,19 +246,30 @@ func (a *AvgAggState) AddTuple(t *Tuple) {
 	if err != nil {
 		return
 	}
-	switch v.(type) {
-	case IntField:
-		a.sum += v.(IntField).Value
-	}
+	a.addValue(v)
+}
+
+func (a *AvgAggState) valueExpr() Expr {
+	return a.expr
+}
+
+func (a *AvgAggState) addValue(v DBValue) {
+	a.sum.add(v)
 	a.count++
 }
 
+func (a *AvgAggState) merge(other AggState) {
+	o := other.(*AvgAggState)
+	a.sum.merge(o.sum)
+	a.count += o.count
+}
+
 func (a *AvgAggState) GetTupleDesc() *TupleDesc {
-	return &TupleDesc{[]FieldType{{a.alias, "", IntType}}}
+	return &TupleDesc{[]FieldType{{a.alias, "", sumType(a.expr)}}}
 }
 
 func (a *AvgAggState) Finalize() *Tuple {
-	return &Tuple{*a.GetTupleDesc(), []DBValue{IntField{a.sum / a.count}}, nil}
+	return &Tuple{*a.GetTupleDesc(), []DBValue{a.sum.result(sumType(a.expr), a.count)}, nil}
 }
 
 // Implements the aggregation state for MAX
@@ -175,7 +300,14 @@ func (a *MaxAggState) AddTuple(t *Tuple) {
 	if err != nil {
 		return
 	}
+	a.addValue(v)
+}
 
+func (a *MaxAggState) valueExpr() Expr {
+	return a.expr
+}
+
+func (a *MaxAggState) addValue(v DBValue) {
 	if a.null {
 		a.val = v
 		a.null = false
@@ -184,6 +316,12 @@ func (a *MaxAggState) AddTuple(t *Tuple) {
 	}
 }
 
+func (a *MaxAggState) merge(other AggState) {
+	if o := other.(*MaxAggState); !o.null {
+		a.addValue(o.val)
+	}
+}
+
 func (a *MaxAggState) GetTupleDesc() *TupleDesc {
 	return &TupleDesc{[]FieldType{{a.alias, "", a.expr.GetExprType().Ftype}}}
 }
@@ -217,6 +355,10 @@ func (a *MinAggState) AddTuple(t *Tuple) {
 	if err != nil {
 		return
 	}
+	a.addValue(v)
+}
+
+func (a *MinAggState) addValue(v DBValue) {
 	if a.null {
 		a.val = v
 		a.null = false
@@ -225,6 +367,12 @@ func (a *MinAggState) AddTuple(t *Tuple) {
 	}
 }
 
+func (a *MinAggState) merge(other AggState) {
+	if o := other.(*MinAggState); !o.null {
+		a.addValue(o.val)
+	}
+}
+
 func (a *MinAggState) GetTupleDesc() *TupleDesc {
 	return &TupleDesc{[]FieldType{{a.alias, "", a.expr.GetExprType().Ftype}}}
 }
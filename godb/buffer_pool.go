@@ -7,6 +7,8 @@ package godb
 
 //<silentstrip lab2|lab3|lab4>
 
+import "sync"
+
 // Permissions used to when reading / locking pages
 type RWPerm int
 
@@ -20,12 +22,21 @@ type BufferPool struct {
 	pages    map[any]Page
 	maxPages int
 	logFile  *LogFile
+
+	// number of calls to GetPage, and how many of them found the page in
+	// the buffer pool; see EXPLAIN ANALYZE
+	pageRequests int64
+	pageHits     int64
+
+	// guards the pages and the counters, which the workers of a parallel
+	// query use concurrently
+	mu sync.Mutex
 }
 
 // Create a new BufferPool with the specified number of pages
 func NewBufferPool(numPages int) (*BufferPool, error) {
 	// TODO: some code goes here
-	return &BufferPool{make(map[any]Page), numPages, nil}, nil
+	return &BufferPool{make(map[any]Page), numPages, nil, 0, 0, sync.Mutex{}}, nil
 
 }
 
@@ -33,12 +44,26 @@ func NewBufferPool(numPages int) (*BufferPool, error) {
 // and flush them using [DBFile.flushPage]. Does not need to be thread/transaction safe.
 // Mark pages as not dirty after flushing them.
 func (bp *BufferPool) FlushAllPages() {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
 	for _, page := range bp.pages {
 		page.getFile().flushPage(page)
 		page.setDirty(-1, false)
 	}
 }
 
+// Remove the pages of file from the buffer pool without flushing them, e.g.
+// because the file has been truncated.
+func (bp *BufferPool) discardPages(file *HeapFile) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	for key := range bp.pages {
+		if h, ok := key.(heapHash); ok && h.FileName == file.backingFile {
+			delete(bp.pages, key)
+		}
+	}
+}
+
 // Abort the transaction, releasing locks. Because GoDB is FORCE/NO STEAL, none
 // of the pages tid has dirtied will be on disk so it is sufficient to just
 // release locks to abort. You do not need to implement this for lab 1.
@@ -73,9 +98,14 @@ func (bp *BufferPool) BeginTransaction(tid TransactionID) error {
 // of pages in the BufferPool in a map keyed by the [DBFile.pageKey].
 func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm RWPerm) (Page, error) {
 	// TODO: some code goes here
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
 	hashCode := file.pageKey(pageNo)
 	pg, ok := bp.pages[hashCode]
-	if !ok {
+	bp.pageRequests++
+	if ok {
+		bp.pageHits++
+	} else {
 		err := bp.evictPage()
 		if err != nil {
 			return nil, err
@@ -89,6 +119,14 @@ func (bp *BufferPool) GetPage(file DBFile, pageNo int, tid TransactionID, perm R
 	return pg, nil
 }
 
+// Returns the number of calls to GetPage, and how many of them found the page
+// in the buffer pool.
+func (bp *BufferPool) pageStats() (requests, hits int64) {
+	bp.mu.Lock()
+	defer bp.mu.Unlock()
+	return bp.pageRequests, bp.pageHits
+}
+
 // Hint: GetPage function need function there: func (bp *BufferPool) evictPage() error
 func (bp *BufferPool) evictPage() error {
 	if len(bp.pages) < bp.maxPages {
@@ -8,7 +8,7 @@ import (
 func TestBufferPoolGetPage(t *testing.T) {
 	_, t1, t2, hf, bp, _ := makeTestVars(t)
 	tid := NewTID()
-	for i := 0; i < 300; i++ {
+	for i := 0; i < 500; i++ {
 		bp.BeginTransaction(tid)
 		err := hf.insertTuple(&t1, tid)
 		if err != nil {
@@ -27,7 +27,7 @@ func TestBufferPoolGetPage(t *testing.T) {
 		bp.CommitTransaction(tid)
 	}
 	bp.BeginTransaction(tid)
-	//expect 6 pages
+	//expect 6 pages, with 173 or 174 of the variable length tuples per page
 	for i := 0; i < 6; i++ {
 		pg, err := bp.GetPage(hf, i, tid, ReadPerm)
 		if pg == nil || err != nil {
@@ -44,9 +44,10 @@ func TestSetDirty(t *testing.T) {
 	_, t1, _, hf, bp, _ := makeTestVars(t)
 	tid := NewTID()
 	bp.BeginTransaction(tid)
-	for i := 0; i < 308; i++ {
+	// the buffer pool holds 3 pages
+	for i := 0; i < 3*samTuplesPerPage+2; i++ {
 		err := hf.insertTuple(&t1, tid)
-		if err != nil && (i == 306 || i == 307) {
+		if err != nil && (i == 3*samTuplesPerPage || i == 3*samTuplesPerPage+1) {
 			return
 		} else if err != nil {
 			t.Fatalf("%v", err)
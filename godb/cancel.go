@@ -0,0 +1,51 @@
+package godb
+
+// Cancellation of running statements.
+//
+// A statement is run by iterating over its plan on behalf of a transaction,
+// so it is canceled through that transaction: while the iterators of tid are
+// called under the context set with [SetStatementContext], the operators
+// check it in their loops, including the ones that run to completion before
+// returning a tuple, such as sorting, aggregation and building the table of a
+// hash join, and return the error of the context once it is done.  An
+// operator that holds resources, such as the runs of an external sort,
+// releases them before returning the error.
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// The contexts of the statements running in each transaction.
+var statementContexts sync.Map // TransactionID -> context.Context
+
+// Run the statements of tid under ctx, until the returned function is
+// called, which restores the context tid had before.
+func SetStatementContext(tid TransactionID, ctx context.Context) (restore func()) {
+	prev, hadPrev := statementContexts.Load(tid)
+	statementContexts.Store(tid, ctx)
+	return func() {
+		if hadPrev {
+			statementContexts.Store(tid, prev)
+		} else {
+			statementContexts.Delete(tid)
+		}
+	}
+}
+
+// Returns the error of the context of the statement of tid, if it has been
+// canceled or its deadline has passed, and nil otherwise.
+func checkCanceled(tid TransactionID) error {
+	ctx, ok := statementContexts.Load(tid)
+	if !ok {
+		return nil
+	}
+	return ctx.(context.Context).Err()
+}
+
+// Returns true if err is the result of the cancellation or timeout of a
+// statement.
+func IsCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,102 @@
+package godb
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatementTimeout(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (x int)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	values := make([]string, 1000)
+	for i := range values {
+		values[i] = "(1)"
+	}
+	if _, err := db.Exec("insert into t values " + strings.Join(values, ", ")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// a join of a billion tuples is stopped by the timeout
+	const slow = "select count(*) from t a, t b, t c where a.x = b.x and b.x = c.x"
+	db.SetStatementTimeout(50 * time.Millisecond)
+	start := time.Now()
+	rows, err := db.Query(slow)
+	if err == nil {
+		if rows.Next() {
+			t.Errorf("expected the query to time out")
+		}
+		err = rows.Err()
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("expected the query to stop soon after its timeout, took %v", d)
+	}
+
+	// and by canceling its context
+	db.SetStatementTimeout(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := db.ExecContext(ctx, slow); !errors.Is(err, context.Canceled) || !IsCanceled(err) {
+		t.Errorf("expected the query to be canceled, got %v", err)
+	}
+
+	// the database is usable afterwards
+	rows, err = db.Query("select count(*) from t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var n int
+	if !rows.Next() || rows.Scan(&n) != nil || n != 1000 {
+		t.Errorf("expected 1000 rows, got %d (%v)", n, rows.Err())
+	}
+	rows.Close()
+}
+
+func TestCancelExternalSort(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	td := TupleDesc{Fields: []FieldType{{Fname: "x", Ftype: IntType}}}
+	mf := &MemFile{desc: &td}
+	for i := 0; i < 1000; i++ {
+		mf.insertTuple(&Tuple{td, []DBValue{IntField{int64(1000 - i)}}, nil}, 0)
+	}
+	o, err := NewOrderBy([]Expr{&FieldExpr{td.Fields[0]}}, mf, []bool{true})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	o.maxBufferSize = 1000
+
+	tid := NewTID()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer SetStatementContext(tid, ctx)()
+	iter, err := o.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup, err := iter(); err != nil || tup.Fields[0].(IntField).Value != 1 {
+		t.Fatalf("expected the first tuple to be 1, got %v (%v)", tup, err)
+	}
+	if runs, _ := filepath.Glob(filepath.Join(os.TempDir(), "godb-sort-*")); len(runs) < 2 {
+		t.Fatalf("expected the sort to spill runs, found %v", runs)
+	}
+	cancel()
+	if _, err := iter(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the sort to be canceled, got %v", err)
+	}
+	if runs, _ := filepath.Glob(filepath.Join(os.TempDir(), "godb-sort-*")); len(runs) != 0 {
+		t.Errorf("expected the runs to be removed, found %v", runs)
+	}
+}
@@ -1,11 +1,12 @@
 package godb
 
 import (
-	"bufio"
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -18,33 +19,84 @@ type Table struct {
 	stats *TableStats
 
 	file DBFile
+
+	// FOREIGN KEY constraints on columns of this table
+	foreignKeys []*ForeignKey
 }
 
 type Catalog struct {
 	tableMap   map[string]*Table
 	columnMap  map[string][]*Table
+	viewMap    map[string]*View
 	bufferPool *BufferPool
 	rootPath   string
 	filePath   string
+
+	schema  string     // the name of this schema
+	schemas *schemaSet // all schemas of the database, including this one
+
+	// the common table expressions in scope while a query is planned, in a
+	// copy of the catalog made by [Catalog.withCommonTables]
+	ctes map[string]*cteBinding
 }
 
+// Save the catalog to a file in the binary format described in catalog_file.go.
+// The file is replaced atomically, so a crash leaves either the old or the new
+// catalog.
+//
+// Saving the default schema also saves every other schema to the catalog file
+// in its own root path.
 func (c *Catalog) SaveToFile(catalogFile string, rootPath string) error {
-	f, err := os.OpenFile(rootPath+"/"+catalogFile, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
-	if err != nil {
+	if err := writeFileAtomic(rootPath+"/"+catalogFile, c.encode()); err != nil {
 		return err
 	}
-	f.WriteString(c.String())
-	f.Close()
+	if c != c.schemas.main {
+		return nil
+	}
+	for _, s := range c.allSchemas()[1:] {
+		if err := os.MkdirAll(s.rootPath, 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(s.rootPath+"/"+s.filePath, s.encode()); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Returns the schema version of the database, which increases every time a
+// table, view or schema is created or dropped.
+func (c *Catalog) SchemaVersion() uint64 {
+	return c.schemas.version
+}
+
+// Returns the statistics version of the database, which increases every time
+// the statistics of a table are computed or become stale.  Plans made with
+// older statistics may no longer be the best ones.
+func (c *Catalog) StatsVersion() uint64 {
+	return c.schemas.statsVersion
+}
+
 func (c *Catalog) dropTable(tableName string) error {
-	_, ok := c.tableMap[tableName]
+	t, ok := c.tableMap[tableName]
 	if !ok {
 		return GoDBError{NoSuchTableError, "couldn't find table to drop"}
 	}
+	links, err := c.referencingKeys(t)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.child != t {
+			return GoDBError{ConstraintViolationError, fmt.Sprintf("cannot drop %s, it is referenced by a foreign key of %s", tableName, link.child.name)}
+		}
+	}
+	if err := c.checkNotReferencedByView(tableName); err != nil {
+		return err
+	}
 
 	delete(c.tableMap, tableName)
+	c.schemas.version++
 	for cn, ts := range c.columnMap {
 		tsFiltered := make([]*Table, 0)
 		for _, t := range ts {
@@ -54,6 +106,10 @@ func (c *Catalog) dropTable(tableName string) error {
 		}
 		c.columnMap[cn] = tsFiltered
 	}
+	// remove the table from the statistics file, if there is one
+	if _, err := os.Stat(c.statsFilePath()); err == nil {
+		return c.saveStats()
+	}
 	return nil
 }
 
@@ -74,6 +130,9 @@ func ImportCatalogFromCSVs(
 		if err != nil {
 			return err
 		}
+		if tf, ok := t.file.(*HeapFile); ok {
+			hf.columnSpecs = tf.columnSpecs
+		}
 		f, err := os.Open(fileName)
 		if err != nil {
 			return err
@@ -86,71 +145,38 @@ func ImportCatalogFromCSVs(
 	return nil
 }
 
+// Load the tables and views in the catalog file, which may be in either the
+// binary or the older text format.
 func (c *Catalog) parseCatalogFile() error {
-	f, err := os.Open(c.rootPath + "/" + c.filePath)
+	data, err := os.ReadFile(c.rootPath + "/" + c.filePath)
 	if err != nil {
 		return err
 	}
-	scanner := bufio.NewScanner(f)
-
-	for scanner.Scan() {
-		// code to read each line
-		line := strings.ToLower(scanner.Text())
-		sep := strings.Split(line, "(")
-		if len(sep) != 2 {
-			return GoDBError{ParseError, fmt.Sprintf("expected one paren in catalog entry, got %d (%s)", len(sep), line)}
-		}
-		tableName := strings.TrimSpace(sep[0])
-		rest := strings.Trim(sep[1], "()")
-		fields := strings.Split(rest, ",")
-
-		var fieldArray []FieldType
-		for _, f := range fields {
-			f := strings.TrimSpace(f)
-			nameType := strings.Split(f, " ")
-			if len(nameType) < 2 || len(nameType) > 4 {
-				return GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
-			}
-
-			name := nameType[0]
-			fieldType := FieldType{name, "", IntType}
-			switch nameType[1] {
-			case "int":
-				fallthrough
-			case "integer":
-				fieldType.Ftype = IntType
-			case "string":
-				fallthrough
-			case "varchar":
-				fallthrough
-			case "text":
-				fieldType.Ftype = StringType
-			default:
-				return GoDBError{ParseError, fmt.Sprintf("unknown type %s (line %s)", nameType[1], line)}
-			}
-			fieldArray = append(fieldArray, fieldType)
-		}
-
-		_, err := c.addTable(tableName, TupleDesc{fieldArray})
-		if err != nil {
-			return err
-		}
+	if isBinaryCatalog(data) {
+		return c.decode(data)
 	}
-	return nil
+	return c.parseTextCatalog(string(data))
 }
 
 func NewCatalog(catalogFile string, bp *BufferPool, rootPath string) *Catalog {
-	return &Catalog{make(map[string]*Table), make(map[string][]*Table), bp, rootPath, catalogFile}
+	c := &Catalog{make(map[string]*Table), make(map[string][]*Table), make(map[string]*View), bp, rootPath, catalogFile, DefaultSchema, nil, nil}
+	c.schemas = newSchemaSet(c)
+	return c
 }
 
 func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*Catalog, error) {
+	return loadCatalog(catalogFile, bp, rootPath, fmt.Sprintf("%s.log", catalogFile))
+}
+
+// Load the catalog in rootPath/catalogFile, recovering from the log in
+// logFile and loading the statistics of its tables.
+func loadCatalog(catalogFile string, bp *BufferPool, rootPath string, logFile string) (*Catalog, error) {
 	c := NewCatalog(catalogFile, bp, rootPath)
 	if err := c.parseCatalogFile(); err != nil {
 		return nil, err
 	}
-	catFile := fmt.Sprintf("%s.log", catalogFile)
-	//os.Remove(catFile)
-	lf, err := NewLogFile(catFile, bp, c)
+	//os.Remove(logFile)
+	lf, err := NewLogFile(logFile, bp, c)
 	if err != nil {
 		return nil, err
 	}
@@ -159,8 +185,18 @@ func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*C
 		return nil, err
 	}
 
-	if err := c.ComputeTableStats(); err != nil {
-		return nil, err
+	for _, s := range c.allSchemas() {
+		if err := s.loadStats(); err != nil {
+			return nil, err
+		}
+		// tables that have never been analyzed
+		for _, t := range s.tableMap {
+			if t.stats == nil {
+				if err := s.analyzeTable(t); err != nil {
+					return nil, err
+				}
+			}
+		}
 	}
 
 	return c, nil
@@ -170,6 +206,11 @@ func NewCatalogFromFile(catalogFile string, bp *BufferPool, rootPath string) (*C
 //
 // Returns an error if the table already exists.
 func (c *Catalog) addTable(named string, desc TupleDesc) (DBFile, error) {
+	return c.addTableWithId(named, desc, c.schemas.nextTableId)
+}
+
+// Add a new table with the given id to the catalog.
+func (c *Catalog) addTableWithId(named string, desc TupleDesc, id int) (DBFile, error) {
 	f, err := c.GetTable(named)
 	if err == nil {
 		return f, GoDBError{DuplicateTableError, fmt.Sprintf("a table named '%s' already exists", named)}
@@ -180,26 +221,228 @@ func (c *Catalog) addTable(named string, desc TupleDesc) (DBFile, error) {
 		return nil, err
 	}
 
-	t := &Table{len(c.tableMap), named, desc, nil, hf}
-	c.tableMap[named] = t
-	for _, f := range desc.Fields {
+	c.registerTable(&Table{id, named, desc, nil, hf, nil})
+	return hf, nil
+}
+
+// Add a table whose file has already been created to the catalog.
+func (c *Catalog) registerTable(t *Table) {
+	c.tableMap[t.name] = t
+	c.schemas.nextTableId = max(c.schemas.nextTableId, t.id+1)
+	c.schemas.version++
+	for _, f := range t.desc.Fields {
 		mapList := c.columnMap[f.Fname]
 		if mapList == nil {
 			mapList = make([]*Table, 0)
 		}
 		c.columnMap[f.Fname] = append(mapList, t)
 	}
+}
 
-	return hf, nil
+// Split a column type such as varchar(20) or decimal(10,2) into its name and
+// its length, or precision and scale.
+func splitTypeSpec(typ string) (string, columnSpec, error) {
+	open := strings.Index(typ, "(")
+	if open == -1 {
+		return typ, columnSpec{}, nil
+	}
+	if !strings.HasSuffix(typ, ")") {
+		return "", columnSpec{}, GoDBError{ParseError, fmt.Sprintf("malformed type %s", typ)}
+	}
+	name := typ[:open]
+	args := strings.Split(typ[open+1:len(typ)-1], ",")
+	nums := make([]int, len(args))
+	for i, a := range args {
+		n, err := strconv.Atoi(strings.TrimSpace(a))
+		if err != nil || n < 0 {
+			return "", columnSpec{}, GoDBError{ParseError, fmt.Sprintf("invalid size in type %s", typ)}
+		}
+		nums[i] = n
+	}
+	switch {
+	case (name == "decimal" || name == "numeric") && len(nums) <= 2:
+		spec := columnSpec{0, nums[0], 0}
+		if len(nums) == 2 {
+			spec.scale = nums[1]
+		}
+		return name, spec, checkDecimalSpec(spec, typ)
+	case len(nums) == 1 && nums[0] > 0:
+		return name, columnSpec{nums[0], 0, 0}, nil
+	}
+	return "", columnSpec{}, GoDBError{ParseError, fmt.Sprintf("invalid size in type %s", typ)}
+}
+
+func checkDecimalSpec(spec columnSpec, typ string) error {
+	if spec.precision <= 0 || spec.precision > maxDecimalScale || spec.scale > spec.precision {
+		return GoDBError{ParseError, fmt.Sprintf("invalid precision or scale in type %s", typ)}
+	}
+	return nil
+}
+
+// Map the name of a column type, as written in CREATE TABLE or the catalog
+// file, to a DBType.
+func typeFromName(name string) (DBType, bool) {
+	switch strings.ToLower(name) {
+	case "int", "integer":
+		return IntType, true
+	case "string", "varchar", "text":
+		return StringType, true
+	case "float", "double", "real":
+		return FloatType, true
+	case "bool", "boolean", "bit":
+		return BoolType, true
+	case "date":
+		return DateType, true
+	case "timestamp", "datetime":
+		return TimestampType, true
+	case "decimal", "numeric":
+		return DecimalType, true
+	}
+	return UnknownType, false
+}
+
+// Set the declared size of each column of a table.
+func (c *Catalog) setColumnSpecs(named string, specs []columnSpec) {
+	t, ok := c.tableMap[named]
+	if !ok {
+		return
+	}
+	hf, ok := t.file.(*HeapFile)
+	if !ok {
+		return
+	}
+	for _, spec := range specs {
+		if spec != (columnSpec{}) {
+			hf.columnSpecs = specs
+			return
+		}
+	}
+	hf.columnSpecs = nil
+}
+
+// Return the declared size of the i-th column of the table.
+func (t *Table) columnSpec(i int) columnSpec {
+	hf, ok := t.file.(*HeapFile)
+	if !ok {
+		return columnSpec{}
+	}
+	return hf.columnSpec(i)
+}
+
+// Return the type of the i-th column as it is written in the catalog file.
+func (t *Table) typeName(i int) string {
+	spec := t.columnSpec(i)
+	switch {
+	case spec.length > 0:
+		return fmt.Sprintf("varchar(%d)", spec.length)
+	case spec.precision > 0:
+		return fmt.Sprintf("decimal(%d,%d)", spec.precision, spec.scale)
+	}
+	return t.desc.Fields[i].Ftype.String()
 }
 
 func (c *Catalog) ComputeTableStats() error {
 	for _, t := range c.tableMap {
-		stats, err := ComputeTableStats(c.bufferPool, t.file)
-		if err != nil {
+		if err := c.analyzeTable(t); err != nil {
 			return err
 		}
-		t.stats = stats
+	}
+	return nil
+}
+
+func (c *Catalog) analyzeTable(t *Table) error {
+	stats, err := ComputeTableStats(c.bufferPool, t.file)
+	if err != nil {
+		return err
+	}
+	t.stats = stats
+	c.schemas.statsVersion++
+	return nil
+}
+
+// Compute the statistics of the named table, or of all tables if named is
+// empty, and save them to the statistics file.
+func (c *Catalog) Analyze(named string) error {
+	if named == "" {
+		if err := c.ComputeTableStats(); err != nil {
+			return err
+		}
+		return c.saveStats()
+	}
+	t, err := c.GetTableInfo(named)
+	if err != nil {
+		return err
+	}
+	if err := c.analyzeTable(t); err != nil {
+		return err
+	}
+	return c.saveStats()
+}
+
+// Record that n tuples were inserted into or deleted from file.  Once enough
+// tuples have changed, the table's statistics are stale and are recomputed by
+// the next call to [Catalog.RefreshStaleStats].
+func (c *Catalog) noteModifications(file DBFile, n int64) {
+	t, err := c.GetTableInfoDBFile(file)
+	if err != nil || t.stats == nil {
+		return
+	}
+	stale := t.stats.stale()
+	t.stats.modifications += n
+	if !stale && t.stats.stale() {
+		c.schemas.statsVersion++
+	}
+}
+
+// The statistics file is stored next to the catalog file.
+func (c *Catalog) statsFilePath() string {
+	return c.rootPath + "/" + c.filePath + ".stats"
+}
+
+// Write the statistics of all tables that have them to the statistics file.
+// The file is replaced atomically, so a crash does not leave a partially
+// written file.
+func (c *Catalog) saveStats() error {
+	var sf statsFile
+	names := make([]string, 0, len(c.tableMap))
+	for name := range c.tableMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if t := c.tableMap[name]; t.stats != nil {
+			sf.Tables = append(sf.Tables, t.stats.toEntry(name))
+		}
+	}
+	data, err := json.MarshalIndent(&sf, "", " ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(c.statsFilePath(), data)
+}
+
+// Load the statistics file, if there is one.  Entries for tables that no
+// longer exist, or whose columns have changed, are ignored.
+func (c *Catalog) loadStats() error {
+	data, err := os.ReadFile(c.statsFilePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var sf statsFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return GoDBError{MalformedDataError, fmt.Sprintf("malformed statistics file %s: %s", c.statsFilePath(), err.Error())}
+	}
+	for _, e := range sf.Tables {
+		t, ok := c.tableMap[e.Table]
+		if !ok {
+			continue
+		}
+		if stats, err := e.toStats(&t.desc); err == nil {
+			t.stats = stats
+		}
 	}
 	return nil
 }
@@ -224,19 +467,25 @@ func (c *Catalog) GetTable(named string) (DBFile, error) {
 	return t.file, nil
 }
 
+// Returns the table with the given id, which may be in any schema.
 func (c *Catalog) GetTableInfoId(id int) (*Table, error) {
-	for _, t := range c.tableMap {
-		if t.id == id {
-			return t, nil
+	for _, s := range c.schemas.schemas {
+		for _, t := range s.tableMap {
+			if t.id == id {
+				return t, nil
+			}
 		}
 	}
 	return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no table '%d' found", id)}
 }
 
+// Returns the table stored in f, which may be in any schema.
 func (c *Catalog) GetTableInfoDBFile(f DBFile) (*Table, error) {
-	for _, t := range c.tableMap {
-		if t.file == f {
-			return t, nil
+	for _, s := range c.schemas.schemas {
+		for _, t := range s.tableMap {
+			if t.file == f {
+				return t, nil
+			}
 		}
 	}
 	return nil, GoDBError{NoSuchTableError, "table not found"}
@@ -245,6 +494,9 @@ func (c *Catalog) GetTableInfoDBFile(f DBFile) (*Table, error) {
 // Get the statistics for a table.
 //
 // Returns nil if the table does not exist.
+//
+// Stale statistics are returned as they are; planning a query never scans a
+// table.  They are recomputed by ANALYZE, or by [Catalog.RefreshStaleStats].
 func (c *Catalog) GetTableStats(named string) *TableStats {
 	t, err := c.GetTableInfo(named)
 	if err != nil {
@@ -253,6 +505,71 @@ func (c *Catalog) GetTableStats(named string) *TableStats {
 	return t.stats
 }
 
+// Returns true if the statistics of any table, in any schema, are stale.
+func (c *Catalog) hasStaleStats() bool {
+	for _, s := range c.allSchemas() {
+		for _, t := range s.tableMap {
+			if t.stats != nil && t.stats.stale() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Recompute and save the stale statistics of the tables of all schemas.
+func (c *Catalog) RefreshStaleStats() error {
+	for _, s := range c.allSchemas() {
+		analyzed := false
+		for _, t := range s.tableMap {
+			if t.stats != nil && t.stats.stale() {
+				if err := s.analyzeTable(t); err != nil {
+					return err
+				}
+				analyzed = true
+			}
+		}
+		if analyzed {
+			if err := s.saveStats(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Get the view with the given name, or nil if there is no such view.
+func (c *Catalog) getView(named string) *View {
+	return c.viewMap[named]
+}
+
+func (c *Catalog) addView(named string, sql string) {
+	c.viewMap[named] = &View{named, sql}
+	c.schemas.version++
+}
+
+func (c *Catalog) dropView(named string) error {
+	if c.getView(named) == nil {
+		return GoDBError{NoSuchTableError, "couldn't find view to drop"}
+	}
+	if err := c.checkNotReferencedByView(named); err != nil {
+		return err
+	}
+	delete(c.viewMap, named)
+	c.schemas.version++
+	return nil
+}
+
+// Returns an error if any view other than the named one reads from it.
+func (c *Catalog) checkNotReferencedByView(named string) error {
+	for _, v := range c.viewMap {
+		if v.name != named && v.references(named) {
+			return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop %s, view %s depends on it", named, v.name)}
+		}
+	}
+	return nil
+}
+
 func (c *Catalog) findTablesWithColumn(named string) []*Table {
 	return c.columnMap[named]
 }
@@ -271,7 +588,13 @@ func (t *Table) String() string {
 		}
 		buf.WriteString(f.Fname)
 		buf.WriteByte(' ')
-		buf.WriteString(f.Ftype.String())
+		buf.WriteString(t.typeName(i))
+		for _, fk := range t.foreignKeys {
+			if fk.Column == f.Fname {
+				buf.WriteByte(' ')
+				buf.WriteString(fk.String())
+			}
+		}
 	}
 	buf.WriteString(")\n")
 	return buf.String()
@@ -287,9 +610,19 @@ func (c *Catalog) String() string {
 	for _, t := range keys {
 		buf.WriteString(c.tableMap[t].String())
 	}
+	// views are written after all tables, since they are planned against them
+	keys = keys[:0]
+	for k := range c.viewMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, v := range keys {
+		buf.WriteString(c.viewMap[v].String())
+	}
 	return buf.String()
 }
 
+// Returns the tables and views of the current schema.
 func (c *Catalog) CatalogString() string {
-	return c.String()
+	return c.schemas.session.schema.String()
 }
@@ -0,0 +1,327 @@
+package godb
+
+// The catalog file.
+//
+// The catalog is saved in a binary format:
+//
+//	magic "GDBC" | format version (uint16) | schema version (uint64) |
+//	next table id (uint32) | number of tables (uint32) | tables... |
+//	number of views (uint32) | views... | number of schemas (uint32) |
+//	schema names... | CRC-32 of all preceding bytes (uint32)
+//
+// Each table is written as its id (uint32), its name and its number of
+// columns (uint16), followed by the name, type (e.g., "decimal(8,2)") and
+// REFERENCES clause ("" if none) of each column.  Each view is written as its
+// name and SQL text.  Only the catalog of the default schema lists the other
+// schemas (see [schemaSet]), each of which has a catalog file of its own.
+// Strings are written as their length (uint32) followed by their bytes, and
+// all integers are little endian.
+//
+// Table ids are assigned when a table is created and never reused, since log
+// records refer to tables by id.  The schema version is incremented whenever a
+// table, view or schema is created or dropped.
+//
+// Version 1 of the format had no list of schemas.
+//
+// Catalogs in the older text format, with one "name(col type, ...)" line per
+// table, are still read; they are converted to the binary format the next
+// time the catalog is saved.
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var catalogMagic = []byte("GDBC")
+
+// The version of the catalog file format written by this code.
+const catalogFormatVersion = 2
+
+// Encode the catalog in the binary format.
+func (c *Catalog) encode() []byte {
+	var buf bytes.Buffer
+	w := func(v any) { binary.Write(&buf, binary.LittleEndian, v) }
+	ws := func(s string) {
+		w(uint32(len(s)))
+		buf.WriteString(s)
+	}
+
+	buf.Write(catalogMagic)
+	w(uint16(catalogFormatVersion))
+	w(c.schemas.version)
+	w(uint32(c.schemas.nextTableId))
+
+	// tables are written in id order, so that they are loaded in the same
+	// order they were created in
+	tables := make([]*Table, 0, len(c.tableMap))
+	for _, t := range c.tableMap {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].id < tables[j].id })
+	w(uint32(len(tables)))
+	for _, t := range tables {
+		w(uint32(t.id))
+		ws(t.name)
+		w(uint16(len(t.desc.Fields)))
+		for i, f := range t.desc.Fields {
+			ws(f.Fname)
+			ws(t.typeName(i))
+			ref := ""
+			for _, fk := range t.foreignKeys {
+				if fk.Column == f.Fname {
+					ref = fk.String()
+				}
+			}
+			ws(ref)
+		}
+	}
+
+	names := make([]string, 0, len(c.viewMap))
+	for name := range c.viewMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	w(uint32(len(names)))
+	for _, name := range names {
+		ws(name)
+		ws(c.viewMap[name].sql)
+	}
+
+	var schemas []*Catalog
+	if c == c.schemas.main {
+		schemas = c.allSchemas()[1:]
+	}
+	w(uint32(len(schemas)))
+	for _, s := range schemas {
+		ws(s.schema)
+	}
+
+	w(crc32.ChecksumIEEE(buf.Bytes()))
+	return buf.Bytes()
+}
+
+// Returns true if data is a catalog in the binary format, rather than the
+// text format.
+func isBinaryCatalog(data []byte) bool {
+	return bytes.HasPrefix(data, catalogMagic)
+}
+
+// Load the tables and views of a catalog in the binary format.
+func (c *Catalog) decode(data []byte) error {
+	malformed := func(msg string) error {
+		return GoDBError{MalformedDataError, fmt.Sprintf("catalog %s: %s", c.filePath, msg)}
+	}
+	if len(data) < len(catalogMagic)+4 {
+		return malformed("file is truncated")
+	}
+	body, sum := data[:len(data)-4], binary.LittleEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != sum {
+		return malformed("checksum mismatch")
+	}
+
+	r := bytes.NewReader(body[len(catalogMagic):])
+	var err error
+	rd := func(v any) {
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, v)
+		}
+	}
+	rs := func() string {
+		var n uint32
+		rd(&n)
+		if err != nil || int64(n) > int64(r.Len()) {
+			err = io.ErrUnexpectedEOF
+			return ""
+		}
+		b := make([]byte, n)
+		rd(b)
+		return string(b)
+	}
+
+	var format uint16
+	rd(&format)
+	if err == nil && format > catalogFormatVersion {
+		return malformed(fmt.Sprintf("unsupported format version %d", format))
+	}
+	loadedVersion := c.schemas.version
+	var version uint64
+	var nextId, nTables uint32
+	rd(&version)
+	rd(&nextId)
+	rd(&nTables)
+	for i := uint32(0); i < nTables && err == nil; i++ {
+		var id uint32
+		var nCols uint16
+		rd(&id)
+		name := rs()
+		rd(&nCols)
+		fields := make([]FieldType, 0, nCols)
+		specs := make([]columnSpec, 0, nCols)
+		var fks []*ForeignKey
+		for j := uint16(0); j < nCols && err == nil; j++ {
+			col, typ, ref := rs(), rs(), rs()
+			if err != nil {
+				break
+			}
+			ftype, spec, terr := parseColumnType(typ)
+			if terr != nil {
+				return malformed(terr.Error())
+			}
+			fields = append(fields, FieldType{col, "", ftype})
+			specs = append(specs, spec)
+			if ref != "" {
+				fk, ferr := parseReferences(col, ref)
+				if ferr != nil {
+					return malformed(ferr.Error())
+				}
+				fks = append(fks, fk)
+			}
+		}
+		if err != nil {
+			break
+		}
+		if _, err := c.addTableWithId(name, TupleDesc{fields}, int(id)); err != nil {
+			return err
+		}
+		c.tableMap[name].foreignKeys = fks
+		c.setColumnSpecs(name, specs)
+	}
+	var nViews uint32
+	rd(&nViews)
+	for i := uint32(0); i < nViews && err == nil; i++ {
+		name, sql := rs(), rs()
+		if err == nil {
+			c.addView(name, sql)
+		}
+	}
+	var schemas []string
+	if format >= 2 {
+		var nSchemas uint32
+		rd(&nSchemas)
+		for i := uint32(0); i < nSchemas && err == nil; i++ {
+			schemas = append(schemas, rs())
+		}
+	}
+	if err != nil {
+		return malformed(err.Error())
+	}
+	if r.Len() != 0 {
+		return malformed("unexpected data after the last schema")
+	}
+	// loading the tables does not change the version
+	c.schemas.version = max(loadedVersion, version)
+	c.schemas.nextTableId = max(int(nextId), c.schemas.nextTableId)
+	if len(schemas) > 0 && c != c.schemas.main {
+		return malformed("only the default schema may list other schemas")
+	}
+	return c.loadSchemas(schemas)
+}
+
+// Parse a column type such as "int" or "varchar(20)".
+func parseColumnType(typ string) (DBType, columnSpec, error) {
+	name, spec, err := splitTypeSpec(typ)
+	if err != nil {
+		return UnknownType, spec, err
+	}
+	ftype, ok := typeFromName(name)
+	if !ok {
+		return UnknownType, spec, GoDBError{ParseError, fmt.Sprintf("unknown type %s", typ)}
+	}
+	return ftype, spec, nil
+}
+
+// Load the tables and views of a catalog in the text format.  Tables are given
+// ids in the order they appear in the file, which is the order older versions
+// numbered them in, so that existing log files still refer to the right
+// tables.
+func (c *Catalog) parseTextCatalog(text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if name, sql, ok := parseViewEntry(line); ok {
+			c.addView(name, sql)
+			continue
+		}
+		line = strings.ToLower(line)
+		open := strings.Index(line, "(")
+		close := strings.LastIndex(line, ")")
+		if open == -1 || close < open {
+			return GoDBError{ParseError, fmt.Sprintf("expected parenthesized field list in catalog entry (%s)", line)}
+		}
+		tableName := strings.TrimSpace(line[:open])
+		fields := splitTopLevel(line[open+1:close], ',')
+
+		var fieldArray []FieldType
+		var fks []*ForeignKey
+		var specs []columnSpec
+		for _, f := range fields {
+			f := strings.TrimSpace(f)
+			nameType := strings.Fields(f)
+			if len(nameType) >= 3 && nameType[2] == "references" {
+				fk, err := parseReferences(nameType[0], strings.Join(nameType[2:], " "))
+				if err != nil {
+					return err
+				}
+				fks = append(fks, fk)
+				nameType = nameType[:2]
+			}
+			if len(nameType) < 2 || len(nameType) > 4 {
+				return GoDBError{ParseError, fmt.Sprintf("malformed catalog entry %s (line %s)", nameType, line)}
+			}
+
+			ftype, spec, err := parseColumnType(nameType[1])
+			if err != nil {
+				return GoDBError{ParseError, fmt.Sprintf("%s (line %s)", err.Error(), line)}
+			}
+			specs = append(specs, spec)
+			fieldArray = append(fieldArray, FieldType{nameType[0], "", ftype})
+		}
+
+		_, err := c.addTable(tableName, TupleDesc{fieldArray})
+		if err != nil {
+			return err
+		}
+		c.tableMap[tableName].foreignKeys = fks
+		c.setColumnSpecs(tableName, specs)
+	}
+	return nil
+}
+
+// Write data to path so that either the old or the new contents of the file
+// survive a crash: the data is written and synced to a temporary file, which
+// is then renamed over path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// sync the directory, so the rename itself is durable
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
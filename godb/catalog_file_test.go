@@ -0,0 +1,128 @@
+package godb
+
+import (
+	"os"
+	"testing"
+)
+
+func reloadCatalogForTest(t *testing.T, c *Catalog) (*Catalog, error) {
+	t.Helper()
+	c2 := NewCatalog(c.filePath, c.bufferPool, c.rootPath)
+	return c2, c2.parseCatalogFile()
+}
+
+func TestCatalogFileRoundTrip(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table a (x int)")
+	mustExecForTest(t, c, bp, "create table b (id int, name varchar(10), price decimal(6,2))")
+	mustExecForTest(t, c, bp, "create table c (id int, b_id int references b(id) on delete cascade)")
+	mustExecForTest(t, c, bp, "create view cheap as select name from b where price < 10")
+	mustExecForTest(t, c, bp, "drop table a")
+	mustExecForTest(t, c, bp, "create table d (y int)")
+
+	// ids are never reused, since log records refer to tables by id
+	ids := map[string]int{"b": 1, "c": 2, "d": 3}
+	for name, id := range ids {
+		if info, _ := c.GetTableInfo(name); info.id != id {
+			t.Errorf("expected table %s to have id %d, got %d", name, id, info.id)
+		}
+	}
+
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	data, err := os.ReadFile(c.rootPath + "/" + c.filePath)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !isBinaryCatalog(data) {
+		t.Errorf("expected the catalog to be saved in the binary format")
+	}
+	if _, err := os.Stat(c.rootPath + "/" + c.filePath + ".tmp"); err == nil {
+		t.Errorf("temporary catalog file was not renamed")
+	}
+
+	c2, err := reloadCatalogForTest(t, c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c2.String() != c.String() {
+		t.Errorf("expected catalog\n%s\ngot\n%s", c.String(), c2.String())
+	}
+	if c2.SchemaVersion() != c.SchemaVersion() {
+		t.Errorf("expected schema version %d, got %d", c.SchemaVersion(), c2.SchemaVersion())
+	}
+	for name, id := range ids {
+		if info, _ := c2.GetTableInfo(name); info.id != id {
+			t.Errorf("expected table %s to have id %d after reloading, got %d", name, id, info.id)
+		}
+	}
+	mustExecForTest(t, c2, bp, "create table e (z int)")
+	if info, _ := c2.GetTableInfo("e"); info.id != 4 {
+		t.Errorf("expected new table to have id 4, got %d", info.id)
+	}
+	if c2.SchemaVersion() <= c.SchemaVersion() {
+		t.Errorf("expected creating a table to increase the schema version")
+	}
+}
+
+func TestCatalogFileChecksum(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table t (x int, y varchar)")
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	path := c.rootPath + "/" + c.filePath
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, corrupt := range [][]byte{
+		append(append([]byte{}, data[:20]...), data[21:]...),
+		append(append(append([]byte{}, data[:20]...), data[20]^1), data[21:]...),
+		data[:len(data)-3],
+	} {
+		if err := os.WriteFile(path, corrupt, 0644); err != nil {
+			t.Fatalf(err.Error())
+		}
+		_, err := reloadCatalogForTest(t, c)
+		if gerr, ok := err.(GoDBError); !ok || gerr.code != MalformedDataError {
+			t.Errorf("expected MalformedDataError loading a corrupt catalog, got %v", err)
+		}
+	}
+}
+
+func TestCatalogFileMigration(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	text := "zeta(x int)\nalpha(id int, name varchar(5))\nbeta(id int, a_id int references alpha(id) on delete restrict)\nview v as select name from alpha\n"
+	if err := os.WriteFile(c.rootPath+"/"+c.filePath, []byte(text), 0644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c, err := reloadCatalogForTest(t, c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	// tables in a text catalog are numbered in the order they appear
+	for name, id := range map[string]int{"zeta": 0, "alpha": 1, "beta": 2} {
+		if info, _ := c.GetTableInfo(name); info.id != id {
+			t.Errorf("expected table %s to have id %d, got %d", name, id, info.id)
+		}
+	}
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2, err := reloadCatalogForTest(t, c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if c2.String() != c.String() {
+		t.Errorf("expected catalog\n%s\ngot\n%s", c.String(), c2.String())
+	}
+	mustExecForTest(t, c2, bp, "insert into alpha values (1, 'ann')")
+	if _, err := execForTest(t, c2, bp, "insert into beta values (1, 2)"); err == nil {
+		t.Errorf("expected the migrated foreign key to be enforced")
+	}
+	if n := len(mustExecForTest(t, c2, bp, "select * from v")); n != 1 {
+		t.Errorf("expected 1 row from the migrated view, got %d", n)
+	}
+}
@@ -0,0 +1,328 @@
+package godb
+
+// Type checking of expressions.  The planner uses the functions in this file to
+// make the operands of comparisons and the arguments of functions have the
+// types they are used at, inserting [CastExpr]s where needed, so that type
+// errors are reported with TypeMismatchError when a query is planned rather
+// than producing wrong results when it runs.
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Returns true if values of type from can be used where values of type to are
+// expected without an explicit cast: numeric types widen from int to decimal
+// to float, and dates widen to timestamps.
+func implicitlyCoercible(from DBType, to DBType) bool {
+	if from == to {
+		return true
+	}
+	if isNumericType(from) && isNumericType(to) {
+		return numericRank[from] < numericRank[to]
+	}
+	return from == DateType && to == TimestampType
+}
+
+// Returns the type two values of types t1 and t2 are converted to in order to
+// compare them, if there is one.
+func commonType(t1 DBType, t2 DBType) (DBType, bool) {
+	switch {
+	case implicitlyCoercible(t1, t2):
+		return t2, true
+	case implicitlyCoercible(t2, t1):
+		return t1, true
+	}
+	return UnknownType, false
+}
+
+// Convert v to type t, as CAST(v AS t) does.  In addition to the conversions
+// done by [convertValue], any value can be cast to a string, floats and
+// decimals are rounded when cast to an int, booleans cast to and from numbers
+// as 1 and 0, and timestamps cast to their date.  If t is DecimalType and
+// scale is not -1, the result is rounded to that scale.
+func castValue(v DBValue, t DBType, scale int) (DBValue, error) {
+	from := valueType(v)
+	var res DBValue
+	var err error
+	switch {
+	case from == t:
+		res = v
+	case t == StringType:
+		res = StringField{valueString(v)}
+	case t == IntType && from == FloatType:
+		f := math.Round(v.(FloatField).Value)
+		if math.Abs(f) >= math.MaxInt64 || math.IsNaN(f) {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("value %s out of range for int", valueString(v))}
+		}
+		res = IntField{int64(f)}
+	case t == IntType && from == DecimalType:
+		var d DecimalField
+		if d, err = v.(DecimalField).rescale(0); err == nil {
+			res = IntField{d.Value}
+		}
+	case from == BoolType && isNumericType(t):
+		res, err = convertValue(IntField{boolToInt(v.(BoolField).Value)}, t)
+	case isNumericType(from) && t == BoolType:
+		cmp, _ := compareValues(v, IntField{0})
+		res = BoolField{cmp != 0}
+	case from == DateType && t == TimestampType:
+		res = NewTimestampField(v.(DateField).Time())
+	case from == TimestampType && t == DateType:
+		res = NewDateField(v.(TimestampField).Time())
+	case from == StringType || (isNumericType(from) && isNumericType(t)):
+		res, err = convertValue(v, t)
+	default:
+		err = GoDBError{TypeMismatchError, fmt.Sprintf("cannot cast %s value %s to %s", from, valueString(v), t)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := res.(DecimalField); ok && scale >= 0 {
+		return d.rescale(scale)
+	}
+	return res, nil
+}
+
+// Returns true if values of type from can be cast to type t.  Whether a
+// particular value can be cast may still depend on the value, e.g., for
+// strings, which are parsed.
+func castable(from DBType, t DBType) bool {
+	switch {
+	case from == t || from == StringType || t == StringType:
+		return true
+	case (isNumericType(from) || from == BoolType) && (isNumericType(t) || t == BoolType):
+		return true
+	}
+	return (from == DateType || from == TimestampType) && (t == DateType || t == TimestampType)
+}
+
+// Returns e converted to type t.  Constants are converted immediately, so that
+// a constant that cannot be converted is an error when the query is planned;
+// parameters are converted when the query runs, since their values change.
+func castExpr(e Expr, t DBType) (Expr, error) {
+	if e.GetExprType().Ftype == t {
+		return e, nil
+	}
+	if ce, ok := e.(*ConstExpr); ok {
+		v, err := castValue(ce.val, t, -1)
+		if err != nil {
+			return nil, err
+		}
+		return &ConstExpr{v, t}, nil
+	}
+	return &CastExpr{e, t, -1}, nil
+}
+
+// Make the two operands of a comparison have the same type.  If their types
+// differ, they are converted to a common type if they have one (e.g., an int
+// column compared to a decimal is compared as a decimal); otherwise a string
+// literal compared to a date, timestamp or boolean is parsed as one.  Any other
+// mismatch, such as a string compared to a number, is a TypeMismatchError.
+func coerceComparison(left Expr, right Expr) (Expr, Expr, error) {
+	lt, rt := left.GetExprType().Ftype, right.GetExprType().Ftype
+	if lt == rt {
+		return left, right, nil
+	}
+	var err error
+	if t, ok := commonType(lt, rt); ok {
+		if left, err = castExpr(left, t); err != nil {
+			return nil, nil, err
+		}
+		right, err = castExpr(right, t)
+		return left, right, err
+	}
+	switch {
+	case isStringLiteral(right) && parsesLosslessly(lt):
+		right, err = castExpr(right, lt)
+	case isStringLiteral(left) && parsesLosslessly(rt):
+		left, err = castExpr(left, rt)
+	default:
+		return nil, nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compare %s %s with %s %s", exprToStr(left), lt, exprToStr(right), rt)}
+	}
+	if err != nil {
+		return nil, nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compare %s %s with %s %s: %s", exprToStr(left), lt, exprToStr(right), rt, err.Error())}
+	}
+	return left, right, nil
+}
+
+func isStringLiteral(e Expr) bool {
+	_, ok := literalValue(e)
+	return ok && e.GetExprType().Ftype == StringType
+}
+
+// Returns the value of a constant, or the current value of a parameter of a
+// prepared statement.  The planner converts both to the types of the
+// expressions they are used with, e.g., a string parameter compared to a date
+// to a date.
+func literalValue(e Expr) (DBValue, bool) {
+	switch e := e.(type) {
+	case *ConstExpr:
+		return e.val, true
+	case *ParamExpr:
+		return e.slot.val, true
+	}
+	return nil, false
+}
+
+// Returns true if a string literal compared to a value of type t can be parsed
+// as a t without changing the meaning of the comparison.  Numbers are not,
+// since e.g. '010' = 10 as numbers but not as strings.
+func parsesLosslessly(t DBType) bool {
+	return t == DateType || t == TimestampType || t == BoolType
+}
+
+// Build a call of function op, choosing its signature with [resolveFunc] and
+// converting the arguments to the parameter types of that signature.
+func bindFunc(op string, args []*Expr) (*FuncExpr, error) {
+	sig, err := resolveFunc(op, args)
+	if err != nil {
+		return nil, err
+	}
+	bound := make([]*Expr, len(args))
+	for i, arg := range args {
+		e, err := castExpr(*arg, sig.argTypes[i])
+		if err != nil {
+			return nil, err
+		}
+		bound[i] = &e
+	}
+	return &FuncExpr{op, bound}, nil
+}
+
+// Returns true if a value of type from can be stored in a column of type to.
+// Strings can be stored in columns of any type, since they are parsed when the
+// tuple is inserted.
+func storableAs(from DBType, to DBType) bool {
+	return from == StringType || implicitlyCoercible(from, to) || (isNumericType(from) && isNumericType(to))
+}
+
+// Parse the target type of a cast, e.g., "float" or "decimal(10,2)",
+// returning the type and the scale of the result (-1 if none is given).
+func parseCastType(typ string) (DBType, int, error) {
+	name, spec, err := splitTypeSpec(strings.ToLower(strings.ReplaceAll(typ, " ", "")))
+	if err != nil {
+		return UnknownType, -1, err
+	}
+	var t DBType
+	switch name {
+	case "signed", "unsigned", "bigint":
+		t = IntType
+	case "char", "nchar", "binary":
+		t = StringType
+	default:
+		var ok bool
+		if t, ok = typeFromName(name); !ok {
+			return UnknownType, -1, GoDBError{ParseError, fmt.Sprintf("cannot cast to unknown type %s", typ)}
+		}
+	}
+	if t == DecimalType && spec.precision > 0 {
+		return t, spec.scale, nil
+	}
+	return t, -1, nil
+}
+
+// The prefix of the function calls that [rewriteCasts] turns casts into.
+const castFuncPrefix = "cast_as_"
+
+var castTypeRe = regexp.MustCompile(`(?is)^(.*\S)\s+as\s+([a-z]+(\s*\(\s*\d+\s*(,\s*\d+\s*)?\))?)\s*$`)
+
+// sqlparser only accepts the MySQL cast types (SIGNED, CHAR, DATE, DATETIME,
+// DECIMAL, ...), so every CAST(expr AS type) in query is rewritten to a call
+// cast_as_type(expr) that parseExpr turns back into a cast.  A type with
+// parameters, such as decimal(10,2), is written as cast_as_decimal_10_2.
+func rewriteCasts(query string) string {
+	lower := strings.ToLower(query)
+	// rewrite from the last cast backwards, so nested casts are rewritten first
+	for start := len(query); start > 0; {
+		i := strings.LastIndex(lower[:start], "cast")
+		if i == -1 {
+			break
+		}
+		start = i
+		if i > 0 && isIdentByte(lower[i-1]) || inStringLiteral(query, i) {
+			continue
+		}
+		open := i + len("cast")
+		for open < len(query) && query[open] == ' ' {
+			open++
+		}
+		if open == len(query) || query[open] != '(' {
+			continue
+		}
+		close := matchingParen(query, open)
+		if close == -1 {
+			continue
+		}
+		m := castTypeRe.FindStringSubmatch(query[open+1 : close])
+		if m == nil {
+			continue
+		}
+		typ := strings.ToLower(m[2])
+		typ = strings.NewReplacer(" ", "", "(", "_", ",", "_", ")", "").Replace(typ)
+		query = query[:i] + castFuncPrefix + typ + "(" + strings.TrimSpace(m[1]) + ")" + query[close+1:]
+		lower = strings.ToLower(query)
+	}
+	return query
+}
+
+// Parse the target type of a cast_as_ function name produced by rewriteCasts.
+func castFuncType(name string) (DBType, int, error) {
+	parts := strings.Split(strings.TrimPrefix(name, castFuncPrefix), "_")
+	typ := parts[0]
+	if len(parts) > 1 {
+		for _, p := range parts[1:] {
+			if _, err := strconv.Atoi(p); err != nil {
+				return UnknownType, -1, GoDBError{ParseError, fmt.Sprintf("malformed cast type %s", name)}
+			}
+		}
+		typ += "(" + strings.Join(parts[1:], ",") + ")"
+	}
+	return parseCastType(typ)
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9'
+}
+
+// Returns true if position i of query is inside a quoted string.
+func inStringLiteral(query string, i int) bool {
+	var quote byte
+	for j := 0; j < i; j++ {
+		switch {
+		case quote == 0 && (query[j] == '\'' || query[j] == '"'):
+			quote = query[j]
+		case quote != 0 && query[j] == quote:
+			quote = 0
+		}
+	}
+	return quote != 0
+}
+
+// Returns the index of the parenthesis closing the one at open, or -1.
+func matchingParen(query string, open int) int {
+	depth := 0
+	var quote byte
+	for j := open; j < len(query); j++ {
+		c := query[j]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+			if depth == 0 {
+				return j
+			}
+		}
+	}
+	return -1
+}
@@ -0,0 +1,129 @@
+package godb
+
+import (
+	"testing"
+)
+
+var coerceTestTables = []string{
+	"create table items (id int, code varchar, price decimal(6,2), ratio float, added date)",
+	"insert into items values (1, '10', 1.50, 0.5, '2024-01-10'), (2, 'x7', 20.00, 1.5, '2024-02-01'), (3, '30', 3.25, 2, '2024-02-15')",
+}
+
+func TestRewriteCasts(t *testing.T) {
+	cases := map[string]string{
+		"select cast(a as float) from t":                       "select cast_as_float(a) from t",
+		"select CAST( f(a, b) AS Decimal(10, 2) ) from t":      "select cast_as_decimal_10_2(f(a, b)) from t",
+		"select cast(cast(a as int) as varchar) from t":        "select cast_as_varchar(cast_as_int(a)) from t",
+		"select 'cast(a as int)', podcast(a) from t":           "select 'cast(a as int)', podcast(a) from t",
+		"select a from t where cast(b as date) > '2020-01-01'": "select a from t where cast_as_date(b) > '2020-01-01'",
+	}
+	for in, want := range cases {
+		if got := rewriteCasts(in); got != want {
+			t.Errorf("rewriteCasts(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+func TestCastValue(t *testing.T) {
+	cases := []struct {
+		v     DBValue
+		t     DBType
+		scale int
+		want  DBValue
+	}{
+		{FloatField{2.5}, IntType, -1, IntField{3}},
+		{DecimalField{-250, 2}, IntType, -1, IntField{-3}},
+		{IntField{7}, StringType, -1, StringField{"7"}},
+		{StringField{" 42 "}, IntType, -1, IntField{42}},
+		{BoolField{true}, IntType, -1, IntField{1}},
+		{IntField{0}, BoolType, -1, BoolField{false}},
+		{DateField{1}, TimestampType, -1, TimestampField{86400 * 1000000}},
+		{TimestampField{86400*1000000 + 5}, DateType, -1, DateField{1}},
+		{FloatField{1.005}, DecimalType, 1, DecimalField{10, 1}},
+	}
+	for _, c := range cases {
+		got, err := castValue(c.v, c.t, c.scale)
+		if err != nil {
+			t.Errorf("cast of %v to %s: %s", c.v, c.t, err.Error())
+		} else if got != c.want {
+			t.Errorf("cast of %v to %s: expected %v, got %v", c.v, c.t, c.want, got)
+		}
+	}
+	if _, err := castValue(DateField{1}, IntType, -1); err == nil {
+		t.Errorf("expected error casting a date to int")
+	}
+}
+
+func TestCastExpressions(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, coerceTestTables...)
+	tups := mustExecForTest(t, c, bp, "select cast(price as int), cast(ratio as decimal(4,1)), cast(id as varchar), cast(added as timestamp), convert(code, signed) from items where id = 3")
+	want := []DBValue{IntField{3}, DecimalField{20, 1}, StringField{"3"}, TimestampField{0}, IntField{30}}
+	want[3], _ = castValue(DateField{19768}, TimestampType, -1)
+	for i, w := range want {
+		if tups[0].Fields[i] != w {
+			t.Errorf("cast %d: expected %v, got %v", i, w, tups[0].Fields[i])
+		}
+	}
+	// code 'x7' cannot be cast to an int, so the query fails rather than
+	// skipping the row
+	if _, err := execForTest(t, c, bp, "select id from items where cast(code as int) > 10"); err == nil {
+		t.Errorf("expected error casting 'x7' to int")
+	}
+}
+
+func TestImplicitCoercion(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, coerceTestTables...)
+	queries := map[string]int{
+		"select id from items where added >= '2024-02-01'":     2, // string literal parsed as a date
+		"select id from items where price > 2":                 2, // int literal widened to decimal
+		"select id from items where ratio > price":             0,
+		"select id from items where id < 2.5":                  2, // int column widened to decimal
+		"select id from items where year(added) = 2024":        3,
+		"select id from items where added < '2024-01-31'":      1,
+		"select id from items where added + 1 = '2024-01-11'":  1,
+		"select id from items where price * 2 > ratio":         3,
+		"select id from items where cast(ratio as int) = 2":    2, // 1.5 rounds to 2
+		"select id from items where cast(price as float) = 20": 1,
+	}
+	for q, want := range queries {
+		tups, err := execForTest(t, c, bp, q)
+		if err != nil {
+			t.Errorf("%s: %s", q, err.Error())
+			continue
+		}
+		if len(tups) != want {
+			t.Errorf("%s: expected %d rows, got %d", q, want, len(tups))
+		}
+	}
+}
+
+func TestTypeErrorsAtPlanTime(t *testing.T) {
+	_, c := makeTestCatalogWith(t, coerceTestTables...)
+	queries := []string{
+		"select id from items where added = 5",
+		"select id from items where added > '2024-13-01'",
+		"select id from items where id = 'abc'",
+		"select id from items where code = 10",
+		"select id from items where id = '1'",
+		"select id from items where 2.5 < code",
+		"select sum(code) from items",
+		"select avg(added) from items",
+		"select id + code from items",
+		"select year(id) from items",
+		"select cast(added as float) from items",
+		"select items.id from items join items i2 on items.added = i2.price",
+		"insert into items values (4, 'y', 'cheap', 1, '2024-01-01')",
+		"insert into items values (4, 'y', 1)",
+		"insert into items select id, added, price, ratio, added from items",
+	}
+	for _, q := range queries {
+		_, _, err := Parse(c, q)
+		if err == nil {
+			t.Errorf("%s: expected a type error", q)
+			continue
+		}
+		if gerr, ok := err.(GoDBError); !ok || (gerr.code != TypeMismatchError && gerr.code != ParseError) {
+			t.Errorf("%s: expected a TypeMismatchError, got %s", q, err.Error())
+		}
+	}
+}
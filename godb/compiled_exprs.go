@@ -0,0 +1,180 @@
+package godb
+
+// Compiled expressions.
+//
+// [Expr.EvalExpr] interprets an expression on every call: a [FieldExpr] finds
+// its field in the descriptor of the tuple by name, and a [FuncExpr] resolves
+// the signature of its function and boxes its arguments.  Operators instead
+// compile their expressions when they are opened, for the descriptor of the
+// tuples they evaluate them over, into closures in which fields are bound to
+// their index, signatures are resolved, and integer arithmetic is done
+// without boxing.
+
+// An expression compiled for the tuples of one descriptor.  A compiled
+// expression may reuse its buffers between calls, so it must not be called
+// concurrently.
+type evalFunc func(t *Tuple) (DBValue, error)
+
+// Compile e for tuples described by desc.  Expressions that cannot be
+// compiled, e.g., fields that are not in desc, are evaluated with EvalExpr,
+// so that they fail as they would have.
+func compileExpr(e Expr, desc *TupleDesc) evalFunc {
+	switch e := e.(type) {
+	case *FieldExpr:
+		i, err := findFieldInTd(e.selectField, desc)
+		if err != nil {
+			break
+		}
+		width := len(desc.Fields)
+		return func(t *Tuple) (DBValue, error) {
+			if len(t.Fields) != width {
+				// not a tuple of desc
+				return e.EvalExpr(t)
+			}
+			return t.Fields[i], nil
+		}
+	case *ConstExpr:
+		// the value of a parameter of a prepared statement is set again for
+		// every execution of the plan, so it is read on every call
+		return func(*Tuple) (DBValue, error) {
+			return e.val, nil
+		}
+	case *CastExpr:
+		arg := compileExpr(e.expr, desc)
+		return func(t *Tuple) (DBValue, error) {
+			v, err := arg(t)
+			if err != nil {
+				return nil, err
+			}
+			return castValue(v, e.ftype, e.scale)
+		}
+	case *FuncExpr:
+		if f, err := compileFunc(e, desc); err == nil {
+			return f
+		}
+	case *RenamedExpr:
+		return compileExpr(e.expr, desc)
+	case lazyExpr:
+		operands := compileExprs(e.operands(), desc)
+		return func(t *Tuple) (DBValue, error) {
+			return e.evaluate(t, operands)
+		}
+	}
+	return e.EvalExpr
+}
+
+func compileExprs(es []Expr, desc *TupleDesc) []evalFunc {
+	fs := make([]evalFunc, len(es))
+	for i, e := range es {
+		fs[i] = compileExpr(e, desc)
+	}
+	return fs
+}
+
+// Typed implementations of the integer functions of [funcs], which compiled
+// expressions call without boxing their arguments.
+var intFuncs = map[string]func(a, b int64) (int64, error){
+	"+": func(a, b int64) (int64, error) { return a + b, nil },
+	"-": func(a, b int64) (int64, error) { return a - b, nil },
+	"*": func(a, b int64) (int64, error) { return a * b, nil },
+	"/": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, GoDBError{IllegalOperationError, "division by zero"}
+		}
+		return a / b, nil
+	},
+	"mod": func(a, b int64) (int64, error) {
+		if b == 0 {
+			return 0, GoDBError{IllegalOperationError, "division by zero"}
+		}
+		return a % b, nil
+	},
+	"imin": func(a, b int64) (int64, error) { return min(a, b), nil },
+	"imax": func(a, b int64) (int64, error) { return max(a, b), nil },
+}
+
+// Compile a function call, whose signature is resolved once.
+func compileFunc(e *FuncExpr, desc *TupleDesc) (evalFunc, error) {
+	sig, err := resolveFunc(e.op, e.args)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]evalFunc, len(sig.argTypes))
+	exact := true // whether every argument has the type of its parameter
+	for i, argType := range sig.argTypes {
+		arg := *e.args[i]
+		args[i] = compileExpr(arg, desc)
+		if arg.GetExprType().Ftype != argType {
+			exact = false
+			args[i] = castTo(args[i], argType)
+		}
+	}
+
+	if op, ok := intFuncs[e.op]; ok && exact && sig.outType == IntType && len(args) == 2 && sig.argTypes[0] == IntType && sig.argTypes[1] == IntType {
+		left, right := args[0], args[1]
+		return func(t *Tuple) (DBValue, error) {
+			a, err := left(t)
+			if err != nil {
+				return nil, err
+			}
+			b, err := right(t)
+			if err != nil {
+				return nil, err
+			}
+			x, err := op(a.(IntField).Value, b.(IntField).Value)
+			if err != nil {
+				return nil, err
+			}
+			return IntField{x}, nil
+		}, nil
+	}
+
+	argvals := make([]any, len(args))
+	return func(t *Tuple) (DBValue, error) {
+		for i, arg := range args {
+			val, err := arg(t)
+			if err != nil {
+				return nil, err
+			}
+			switch sig.argTypes[i] {
+			case IntType:
+				argvals[i] = val.(IntField).Value
+			case StringType:
+				argvals[i] = val.(StringField).Value
+			default:
+				argvals[i] = val
+			}
+		}
+		return funcResult(sig.f(argvals))
+	}, nil
+}
+
+// Returns a compiled expression that casts the values of f to type t.
+func castTo(f evalFunc, t DBType) evalFunc {
+	return func(tup *Tuple) (DBValue, error) {
+		v, err := f(tup)
+		if err != nil {
+			return nil, err
+		}
+		return castValue(v, t, -1)
+	}
+}
+
+// Converts the result of the function of a [FuncType] to a value.
+func funcResult(result any) (DBValue, error) {
+	switch result := result.(type) {
+	case error:
+		return nil, result
+	case DBValue:
+		return result, nil
+	case int64:
+		return IntField{result}, nil
+	case string:
+		return StringField{result}, nil
+	case float64:
+		return FloatField{result}, nil
+	case bool:
+		return BoolField{result}, nil
+	}
+	return nil, GoDBError{ParseError, "unknown result type in function"}
+}
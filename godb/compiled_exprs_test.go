@@ -0,0 +1,91 @@
+package godb
+
+import (
+	"fmt"
+	"testing"
+)
+
+func funcExprForTest(op string, args ...Expr) *FuncExpr {
+	ptrs := make([]*Expr, len(args))
+	for i := range args {
+		ptrs[i] = &args[i]
+	}
+	return &FuncExpr{op, ptrs}
+}
+
+// Compiled expressions return the same values as EvalExpr.
+func TestCompiledExprs(t *testing.T) {
+	day, err := castValue(StringField{"2024-03-05"}, DateType, -1)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	td := TupleDesc{Fields: []FieldType{
+		{Fname: "a", Ftype: IntType},
+		{Fname: "b", Ftype: IntType},
+		{Fname: "f", Ftype: FloatType},
+		{Fname: "s", Ftype: StringType},
+		{Fname: "d", Ftype: DateType},
+	}}
+	tup := &Tuple{td, []DBValue{IntField{17}, IntField{5}, FloatField{2.5}, StringField{"hello"}, day}, nil}
+	a, b, f, s, d := &FieldExpr{td.Fields[0]}, &FieldExpr{td.Fields[1]}, &FieldExpr{td.Fields[2]}, &FieldExpr{td.Fields[3]}, &FieldExpr{td.Fields[4]}
+
+	exprs := []Expr{
+		a,
+		s,
+		&ConstExpr{IntField{3}, IntType},
+		&CastExpr{a, FloatType, -1},
+		funcExprForTest("+", a, b),
+		funcExprForTest("-", a, &ConstExpr{IntField{20}, IntType}),
+		funcExprForTest("/", a, b),
+		funcExprForTest("mod", a, b),
+		funcExprForTest("imax", a, funcExprForTest("*", b, b)),
+		funcExprForTest("+", a, f),
+		funcExprForTest("sq", b),
+		funcExprForTest("getsubstr", s, &ConstExpr{IntField{1}, IntType}, b),
+		funcExprForTest("year", d),
+		funcExprForTest("-", d, &ConstExpr{IntField{10}, IntType}),
+	}
+	for _, e := range exprs {
+		want, err := e.EvalExpr(tup)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		got, err := compileExpr(e, &td)(tup)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%v: compiled expression returned %v, expected %v", e, got, want)
+		}
+	}
+
+	div := compileExpr(funcExprForTest("/", a, &ConstExpr{IntField{0}, IntType}), &td)
+	if _, err := div(tup); err == nil {
+		t.Errorf("expected an error dividing by zero")
+	}
+
+	// fields that are not in the descriptor fail when they are evaluated
+	missing := compileExpr(&FieldExpr{FieldType{Fname: "z", Ftype: IntType}}, &td)
+	if _, err := missing(tup); err == nil {
+		t.Errorf("expected an error evaluating an unknown field")
+	}
+}
+
+// The parameters of prepared statements are constants whose value changes
+// between executions of a compiled plan.
+func TestCompiledExprConstants(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{Fname: "a", Ftype: IntType}}}
+	tup := &Tuple{td, []DBValue{IntField{10}}, nil}
+	param := &ConstExpr{IntField{1}, IntType}
+	sum := compileExpr(funcExprForTest("+", &FieldExpr{td.Fields[0]}, param), &td)
+	for _, v := range []int64{1, 5, -3} {
+		param.val = IntField{v}
+		got, err := sum(tup)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if got != (IntField{10 + v}) {
+			t.Errorf("expected %d, got %v", 10+v, got)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package godb
+
+// Conditional expressions: CASE, IF, COALESCE, NULLIF, GREATEST and LEAST, and
+// the conditions of CASE and IF.
+//
+// They are parsed into calls of functions named after them (the conditions
+// into calls of =, <, AND, ...; see [parseCondition]), so that the planner
+// handles them as it does other functions, e.g., aggregates in their
+// arguments are computed before them.  When the plan is built,
+// [conditionalFuncs] binds them to the expressions below, which evaluate
+// their operands as they go, so that a branch that is not taken is not
+// evaluated.
+//
+// GoDB has no NULL, so a CASE without ELSE whose conditions all fail is the
+// zero value of its type (see [zeroValue]), and COALESCE, whose arguments
+// cannot be NULL, returns its first argument.  NULLIF, which returns NULL if
+// its arguments are equal, is not supported.
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// An expression that is evaluated from the values of its operands, which it
+// evaluates lazily with the evaluators it is given, so that both
+// [Expr.EvalExpr] and compiled expressions can evaluate it.
+type lazyExpr interface {
+	Expr
+	operands() []Expr
+	evaluate(t *Tuple, operands []evalFunc) (DBValue, error)
+}
+
+// Returns the evaluators of es that interpret them with EvalExpr.
+func interpreted(es []Expr) []evalFunc {
+	fs := make([]evalFunc, len(es))
+	for i, e := range es {
+		fs[i] = e.EvalExpr
+	}
+	return fs
+}
+
+// A comparison in a condition of CASE or IF.
+type CompareExpr struct {
+	left, right Expr
+	op          BoolOp
+}
+
+func (c *CompareExpr) GetExprType() FieldType {
+	return FieldType{strings.TrimSpace(c.op.String()), "", BoolType}
+}
+
+func (c *CompareExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	return c.evaluate(t, interpreted(c.operands()))
+}
+
+func (c *CompareExpr) operands() []Expr {
+	return []Expr{c.left, c.right}
+}
+
+func (c *CompareExpr) evaluate(t *Tuple, operands []evalFunc) (DBValue, error) {
+	left, err := operands[0](t)
+	if err != nil {
+		return nil, err
+	}
+	right, err := operands[1](t)
+	if err != nil {
+		return nil, err
+	}
+	return BoolField{left.EvalPred(right, c.op)}, nil
+}
+
+// AND, OR or NOT of conditions.  AND and OR stop at the first operand that
+// decides their value.
+type LogicExpr struct {
+	op   string // and, or or not
+	args []Expr
+}
+
+func (l *LogicExpr) GetExprType() FieldType {
+	return FieldType{l.op, "", BoolType}
+}
+
+func (l *LogicExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	return l.evaluate(t, interpreted(l.args))
+}
+
+func (l *LogicExpr) operands() []Expr {
+	return l.args
+}
+
+func (l *LogicExpr) evaluate(t *Tuple, operands []evalFunc) (DBValue, error) {
+	if l.op == "not" {
+		v, err := operands[0](t)
+		if err != nil {
+			return nil, err
+		}
+		return BoolField{!v.(BoolField).Value}, nil
+	}
+	// AND is false at the first false operand, OR true at the first true one
+	decided := l.op == "or"
+	for _, operand := range operands {
+		v, err := operand(t)
+		if err != nil {
+			return nil, err
+		}
+		if v.(BoolField).Value == decided {
+			return BoolField{decided}, nil
+		}
+	}
+	return BoolField{!decided}, nil
+}
+
+// A searched CASE expression, or IF(condition, then, else).  Its value is
+// that of the result of its first condition that holds, or of its ELSE
+// result if none does.
+type CaseExpr struct {
+	name  string // case or if
+	args  []Expr // the conditions and their results, followed by the ELSE result, if any
+	ftype DBType
+}
+
+func (c *CaseExpr) GetExprType() FieldType {
+	return FieldType{c.name, "", c.ftype}
+}
+
+func (c *CaseExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	return c.evaluate(t, interpreted(c.args))
+}
+
+func (c *CaseExpr) operands() []Expr {
+	return c.args
+}
+
+func (c *CaseExpr) evaluate(t *Tuple, operands []evalFunc) (DBValue, error) {
+	i := 0
+	for ; i+1 < len(operands); i += 2 {
+		cond, err := operands[i](t)
+		if err != nil {
+			return nil, err
+		}
+		if cond.(BoolField).Value {
+			return operands[i+1](t)
+		}
+	}
+	if i < len(operands) {
+		return operands[i](t)
+	}
+	return zeroValue(c.ftype), nil
+}
+
+// COALESCE, GREATEST or LEAST, whose value is the value of one of its
+// arguments, which have been converted to its type.
+type ChoiceExpr struct {
+	op    string
+	args  []Expr
+	ftype DBType
+}
+
+func (c *ChoiceExpr) GetExprType() FieldType {
+	return FieldType{c.op, "", c.ftype}
+}
+
+func (c *ChoiceExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	return c.evaluate(t, interpreted(c.args))
+}
+
+func (c *ChoiceExpr) operands() []Expr {
+	return c.args
+}
+
+func (c *ChoiceExpr) evaluate(t *Tuple, operands []evalFunc) (DBValue, error) {
+	if c.op == "coalesce" {
+		// the first argument is never NULL, so the others are not evaluated
+		return operands[0](t)
+	}
+	values := make([]DBValue, 0, len(operands))
+	for _, operand := range operands {
+		v, err := operand(t)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	op := OpGt
+	if c.op == "least" {
+		op = OpLt
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		if v.EvalPred(result, op) {
+			result = v
+		}
+	}
+	return result, nil
+}
+
+// The conditional functions, and the functions that bind their calls to
+// expressions, checking the types of their arguments.
+var conditionalFuncs = map[string]func(op string, args []Expr) (Expr, error){
+	"case":     bindCase,
+	"if":       bindCase,
+	"coalesce": bindChoice,
+	"nullif":   bindChoice,
+	"greatest": bindChoice,
+	"least":    bindChoice,
+	"and":      bindLogic,
+	"or":       bindLogic,
+	"not":      bindLogic,
+	"=":        bindCompare,
+	"<>":       bindCompare,
+	"<":        bindCompare,
+	"<=":       bindCompare,
+	">":        bindCompare,
+	">=":       bindCompare,
+	"like":     bindCompare,
+}
+
+// Returns an error unless e is a condition.
+func checkCondition(op string, e Expr) error {
+	if t := e.GetExprType().Ftype; t != BoolType {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("argument of %s must be a condition, not %s %s", strings.ToUpper(op), exprToStr(e), t)}
+	}
+	return nil
+}
+
+// Returns exprs converted to the type of the result of an expression whose
+// value is the value of one of them, and that type.  Their types must have a
+// common type (see [commonType]), except that constants are converted to the
+// type of the others, e.g., a string literal among dates is parsed as a date.
+func unifyTypes(op string, exprs []Expr) ([]Expr, DBType, error) {
+	t := UnknownType
+	for _, constants := range []bool{false, true} {
+		for _, e := range exprs {
+			if _, ok := literalValue(e); ok != constants {
+				continue
+			}
+			et := e.GetExprType().Ftype
+			if t == UnknownType {
+				t = et
+			} else if common, ok := commonType(t, et); ok {
+				t = common
+			} else if !constants {
+				return nil, UnknownType, GoDBError{TypeMismatchError, fmt.Sprintf("%s types %s and %s cannot be matched", strings.ToUpper(op), t, et)}
+			}
+		}
+	}
+	unified := make([]Expr, len(exprs))
+	for i, e := range exprs {
+		var err error
+		if unified[i], err = castExpr(e, t); err != nil {
+			return nil, UnknownType, GoDBError{TypeMismatchError, fmt.Sprintf("%s types %s and %s cannot be matched: %s", strings.ToUpper(op), t, e.GetExprType().Ftype, err.Error())}
+		}
+	}
+	return unified, t, nil
+}
+
+func bindCase(op string, args []Expr) (Expr, error) {
+	if op == "if" && len(args) != 3 {
+		return nil, GoDBError{ParseError, "function if expected 3 args"}
+	}
+	var results []Expr
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			results = append(results, args[i])
+			break
+		}
+		if err := checkCondition(op, args[i]); err != nil {
+			return nil, err
+		}
+		results = append(results, args[i+1])
+	}
+	results, t, err := unifyTypes(op, results)
+	if err != nil {
+		return nil, err
+	}
+	bound := make([]Expr, len(args))
+	for i := range args {
+		if i%2 == 0 && i+1 < len(args) {
+			bound[i] = args[i]
+		} else {
+			bound[i] = results[i/2]
+		}
+	}
+	return &CaseExpr{op, bound, t}, nil
+}
+
+func bindChoice(op string, args []Expr) (Expr, error) {
+	if op == "nullif" {
+		return nil, GoDBError{IllegalOperationError, "NULLIF is not supported, since GoDB has no NULL"}
+	}
+	if len(args) == 0 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected at least 1 arg", op)}
+	}
+	args, t, err := unifyTypes(op, args)
+	if err != nil {
+		return nil, err
+	}
+	return &ChoiceExpr{op, args, t}, nil
+}
+
+func bindLogic(op string, args []Expr) (Expr, error) {
+	for _, arg := range args {
+		if err := checkCondition(op, arg); err != nil {
+			return nil, err
+		}
+	}
+	return &LogicExpr{op, args}, nil
+}
+
+func bindCompare(op string, args []Expr) (Expr, error) {
+	left, right, err := coerceComparison(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return &CompareExpr{left, right, BoolOpMap[op]}, nil
+}
+
+// Parse a CASE expression into a call of case, whose arguments are its
+// conditions and their results, followed by its ELSE result, if any.  The
+// conditions of a simple CASE, CASE x WHEN v THEN ..., are the comparisons
+// x = v.
+func parseCase(c *Catalog, expr *sqlparser.CaseExpr, alias string) (*LogicalSelectNode, error) {
+	var operand *LogicalSelectNode
+	if expr.Expr != nil {
+		var err error
+		if operand, err = parseExpr(c, expr.Expr, ""); err != nil {
+			return nil, err
+		}
+	}
+	var args []*LogicalSelectNode
+	for _, when := range expr.Whens {
+		var cond *LogicalSelectNode
+		var err error
+		if operand != nil {
+			var value *LogicalSelectNode
+			if value, err = parseExpr(c, when.Cond, ""); err == nil {
+				eq := NewFuncSelectNode("=", []*LogicalSelectNode{operand, value}, "")
+				cond = &eq
+			}
+		} else {
+			cond, err = parseCondition(c, when.Cond)
+		}
+		if err != nil {
+			return nil, err
+		}
+		val, err := parseCondition(c, when.Val)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, cond, val)
+	}
+	if expr.Else != nil {
+		els, err := parseCondition(c, expr.Else)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, els)
+	}
+	node := NewFuncSelectNode("case", args, alias)
+	return &node, nil
+}
+
+// Parse a call of IF, whose first argument is a condition.
+func parseIf(c *Catalog, expr *sqlparser.FuncExpr, alias string) (*LogicalSelectNode, error) {
+	if len(expr.Exprs) != 3 {
+		return nil, GoDBError{ParseError, "function if expected 3 args"}
+	}
+	args := make([]*LogicalSelectNode, len(expr.Exprs))
+	for i, e := range expr.Exprs {
+		arg, ok := e.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, GoDBError{ParseError, "unexpected * in arguments of if"}
+		}
+		var err error
+		if args[i], err = parseCondition(c, arg.Expr); err != nil {
+			return nil, err
+		}
+	}
+	node := NewFuncSelectNode("if", args, alias)
+	return &node, nil
+}
+
+// Parse a condition of CASE or IF, or one of their results, which may also be
+// a condition: a comparison, AND, OR or NOT of conditions, or any other
+// expression.
+func parseCondition(c *Catalog, expr sqlparser.Expr) (*LogicalSelectNode, error) {
+	var op string
+	var args []sqlparser.Expr
+	switch expr := expr.(type) {
+	case *sqlparser.ParenExpr:
+		return parseCondition(c, expr.Expr)
+	case *sqlparser.AndExpr:
+		op, args = "and", []sqlparser.Expr{expr.Left, expr.Right}
+	case *sqlparser.OrExpr:
+		op, args = "or", []sqlparser.Expr{expr.Left, expr.Right}
+	case *sqlparser.NotExpr:
+		op, args = "not", []sqlparser.Expr{expr.Expr}
+	case *sqlparser.ComparisonExpr:
+		boolOp, ok := BoolOpMap[expr.Operator]
+		if !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s in condition", expr.Operator)}
+		}
+		left, err := parseExpr(c, expr.Left, "")
+		if err != nil {
+			return nil, err
+		}
+		right, err := parseExpr(c, expr.Right, "")
+		if err != nil {
+			return nil, err
+		}
+		node := NewFuncSelectNode(strings.TrimSpace(strings.ToLower(boolOp.String())), []*LogicalSelectNode{left, right}, "")
+		return &node, nil
+	default:
+		return parseExpr(c, expr, "")
+	}
+	nodes := make([]*LogicalSelectNode, len(args))
+	for i, arg := range args {
+		var err error
+		if nodes[i], err = parseCondition(c, arg); err != nil {
+			return nil, err
+		}
+	}
+	node := NewFuncSelectNode(op, nodes, "")
+	return &node, nil
+}
@@ -0,0 +1,69 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConditionalExpressions(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (name varchar, dept varchar, salary int, bonus int, hired date)")
+	mustExecForTest(t, c, bp, "create table dept (dname varchar, floor int)")
+	mustExecForTest(t, c, bp, `insert into emp values ('a', 'eng', 100, 0, '2020-01-01'), ('b', 'eng', 200, 20, '2021-06-01'),
+		('c', 'ops', 300, 0, '2019-03-15'), ('d', '', 50, 5, '2022-02-02')`)
+	mustExecForTest(t, c, bp, "insert into dept values ('eng', 3), ('ops', 1)")
+
+	for sql, want := range map[string]string{
+		"select name, case when salary >= 200 then 'high' when salary >= 100 then 'mid' else 'low' end from emp order by name": "a,mid b,high c,high d,low",
+		"select name, case dept when 'eng' then 1 when 'ops' then 2 end from emp order by name":                                "a,1 b,1 c,2 d,0",
+		// conditions with AND, OR and NOT, and results of different numeric types
+		"select name, case when dept = 'eng' and not salary > 100 or (bonus > 0 and bonus < 10) then 1.5 else salary end from emp order by name": "a,1.5 b,200 c,300 d,1.5",
+		"select name, if(bonus > 0, bonus, -1) b from emp order by name":                                                                         "a,-1 b,20 c,-1 d,5",
+		// there is no NULL, so COALESCE returns its first argument, even a zero value
+		"select name, coalesce(bonus, salary / 10, 7), coalesce(dept, 'none') from emp order by name": "a,0,eng b,20,eng c,0,ops d,5,",
+		"select name, coalesce(bonus, salary / bonus) from emp order by name":                         "a,0 b,20 c,0 d,5",
+		"select name, greatest(salary, bonus * 20, 150), least(salary, 120) from emp order by name":   "a,150,100 b,400,120 c,300,120 d,150,50",
+		"select greatest(hired, '2021-01-01') g from emp where name = 'c'":                            "2021-01-01",
+		// in WHERE, GROUP BY, ORDER BY and aggregates
+		"select name from emp where case when bonus > 0 then bonus else salary end < 60 order by name":                                                            "b d",
+		"select name from emp where case when dept = 'eng' then salary > 150 else true end order by name":                                                         "b c d",
+		"select case when salary >= 100 then 'big' else 'small' end s, count(*) from emp group by case when salary >= 100 then 'big' else 'small' end order by s": "big,3 small,1",
+		"select if(bonus > 0, 'y', 'n') i, greatest(salary, 150) g, count(*) from emp group by if(bonus > 0, 'y', 'n'), greatest(salary, 150) order by i, g":      "n,150,1 n,300,1 y,150,1 y,200,1",
+		"select name, dept, salary from emp order by case dept when '' then 0 else 1 end, salary desc":                                                            "d,,50 c,ops,300 b,eng,200 a,eng,100",
+		"select dept, sum(case when bonus > 0 then 1 else 0 end) from emp group by dept order by dept":                                                            ",1 eng,1 ops,0",
+		"select dept, case when count(*) > 1 then 'many' else 'one' end from emp group by dept order by dept":                                                     ",one eng,many ops,one",
+		"select e.name, coalesce(d.floor, 0) from emp e join dept d on e.dept = d.dname where if(d.floor > 2, 1, 0) = 1 order by e.name":                          "a,3 b,3",
+		// the select list reads computed GROUP BY expressions from the groups
+		"select salary / 100 + 1 s, count(*) from emp group by salary / 100 + 1 order by s": "1,1 2,1 3,1 4,1",
+		// constant expressions are computed when the query is planned
+		"select case when 1 = 1 then 'y' end, coalesce(0, 2), least(3, 1, 2) from emp where name = 'a'": "y,0,1",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+
+	// branches that are not taken are not evaluated
+	if got := rowsForTest(t, c, bp, "select name, case when bonus = 0 then 0 else salary / bonus end from emp order by name", false); got != "a,0 b,10 c,0 d,10" {
+		t.Errorf("got %q, expected no division by zero", got)
+	}
+
+	plan := strings.Join(explainForTest(t, c, bp, "explain select case when salary > 100 then name else dept end from emp"), "\n")
+	if !strings.Contains(plan, "case when emp.salary > 100 then emp.name else emp.dept end") {
+		t.Errorf("expected the CASE expression in the plan:\n%s", plan)
+	}
+
+	for _, sql := range []string{
+		"select case when salary then 1 end from emp",
+		"select case when salary > 1 then name else salary end from emp",
+		"select if(salary > 1, 1) from emp",
+		"select nullif(salary) from emp",
+		"select nullif(bonus, 20) from emp",
+		"select coalesce(hired, salary) from emp",
+		"select case when salary in (1, 2) then 1 end from emp",
+	} {
+		if _, err := execForTest(t, c, bp, sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
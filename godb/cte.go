@@ -0,0 +1,256 @@
+package godb
+
+// Common table expressions, the named queries of a WITH clause, e.g.,
+//
+//	WITH big AS (SELECT * FROM emp WHERE salary > 100)
+//	SELECT b1.name, b2.name FROM big b1 JOIN big b2 ON b1.dept = b2.dept
+//
+//	WITH RECURSIVE reports(id) AS (
+//	    SELECT id FROM emp WHERE id = 1
+//	    UNION SELECT emp.id FROM emp, reports WHERE emp.manager = reports.id)
+//	SELECT * FROM reports
+//
+// sqlparser does not know WITH, so [parseWith] parses the WITH clause of a
+// query and the query that follows it separately into a [withSelect].  While
+// the query is planned, the names of its common table expressions are in the
+// scope of the catalog it is planned with, where parseFrom finds them before
+// the tables of the schema.  A common table expression that is read once is
+// expanded into a subquery, like a view, so that predicates can be pushed
+// into it.  One that is read more than once, or that refers to itself, is
+// computed once into a [CommonTable] that each reference scans.
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// A common table expression of a WITH clause.
+type commonTableExpr struct {
+	name    string
+	columns []string // the names of its columns, if given
+	stmt    sqlparser.SelectStatement
+}
+
+// A query preceded by a WITH clause.  It embeds the query, so that it can be
+// used as the query wherever sqlparser expects one, but must be planned with
+// [planSelectStatement] to bring the common table expressions into scope.
+type withSelect struct {
+	sqlparser.SelectStatement
+	recursive bool
+	ctes      []*commonTableExpr
+}
+
+// A common table expression in the scope of a catalog.
+type cteBinding struct {
+	cte   *commonTableExpr
+	scope *Catalog     // the catalog its query is planned with
+	table *CommonTable // the table its tuples are computed into; nil if it is expanded into a subquery
+}
+
+var withRe = regexp.MustCompile(`(?is)^\s*with\s+(recursive\s+)?`)
+
+var cteNameRe = regexp.MustCompile(`(?is)^\s*([a-z_][a-z0-9_]*)\s*(?:\(([^()]*)\))?\s*as\s*\(`)
+
+// Parse a query that starts with a WITH clause.
+func parseWith(query string) (*withSelect, error) {
+	m := withRe.FindStringSubmatch(query)
+	w := &withSelect{recursive: m[1] != ""}
+	rest := query[len(m[0]):]
+	for {
+		m := cteNameRe.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, GoDBError{ParseError, "expected name AS (query) in WITH clause"}
+		}
+		open := len(m[0]) - 1
+		close := matchingParen(rest, open)
+		if close == -1 {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unterminated query %s in WITH clause", m[1])}
+		}
+		cte := &commonTableExpr{name: strings.ToLower(m[1])}
+		for _, w := range w.ctes {
+			if w.name == cte.name {
+				return nil, GoDBError{DuplicateTableError, fmt.Sprintf("WITH query name %s specified more than once", cte.name)}
+			}
+		}
+		if m[2] != "" {
+			for _, col := range strings.Split(m[2], ",") {
+				col = strings.ToLower(strings.TrimSpace(col))
+				if col == "" {
+					return nil, GoDBError{ParseError, fmt.Sprintf("malformed column list of WITH query %s", cte.name)}
+				}
+				cte.columns = append(cte.columns, col)
+			}
+		}
+		stmt, _, err := parseSQL(rest[open+1 : close])
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if cte.stmt, ok = stmt.(sqlparser.SelectStatement); !ok {
+			return nil, GoDBError{ParseError, fmt.Sprintf("WITH query %s must be a select statement", cte.name)}
+		}
+		w.ctes = append(w.ctes, cte)
+
+		rest = strings.TrimLeft(rest[close+1:], " \t\r\n")
+		if !strings.HasPrefix(rest, ",") {
+			break
+		}
+		rest = rest[1:]
+	}
+	stmt, _, err := parseSQL(rest)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return nil, GoDBError{ParseError, "WITH clause must be followed by a select statement"}
+	}
+	w.SelectStatement = sel
+	return w, nil
+}
+
+// Returns the number of references in FROM clauses of nodes to the table
+// name without a schema.
+func countReferences(name string, nodes ...sqlparser.SQLNode) int {
+	n := 0
+	for _, node := range nodes {
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			if t, ok := node.(*sqlparser.AliasedTableExpr); ok {
+				if tn, ok := t.Expr.(sqlparser.TableName); ok && tn.Qualifier.IsEmpty() && strings.ToLower(tn.Name.CompliantName()) == name {
+					n++
+				}
+			}
+			return true, nil
+		}, node)
+	}
+	return n
+}
+
+// Returns a copy of c in whose scope are the common table expressions of w,
+// in addition to those already in the scope of c.  Those that are not
+// expanded into subqueries are planned.
+func (c *Catalog) withCommonTables(w *withSelect) (*Catalog, error) {
+	scope := *c
+	scope.ctes = maps.Clone(c.ctes)
+	if scope.ctes == nil {
+		scope.ctes = make(map[string]*cteBinding)
+	}
+	for i, cte := range w.ctes {
+		// each query sees the ones defined before it
+		defScope := scope
+		defScope.ctes = maps.Clone(scope.ctes)
+		b := &cteBinding{cte, &defScope, nil}
+
+		readers := []sqlparser.SQLNode{w.SelectStatement}
+		for _, later := range w.ctes[i+1:] {
+			readers = append(readers, later.stmt)
+		}
+		sel, simple := cte.stmt.(*sqlparser.Select)
+		if simple && len(cte.columns) > 0 {
+			for _, e := range sel.SelectExprs {
+				if _, ok := e.(*sqlparser.StarExpr); ok {
+					simple = false
+				}
+			}
+		}
+
+		var err error
+		switch {
+		case w.recursive && countReferences(cte.name, cte.stmt) > 0:
+			b.table, err = planRecursiveCTE(&defScope, cte)
+		case !simple || countReferences(cte.name, readers...) > 1:
+			var op *OperatorCard
+			if op, err = planSelectStatement(&defScope, cte.stmt); err == nil {
+				b.table, err = NewCommonTable(cte.name, cte.columns, op)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		scope.ctes[cte.name] = b
+	}
+	return &scope, nil
+}
+
+// Plan a recursive common table expression, which must be of the form
+//
+//	initial query UNION [ALL] recursive query
+//
+// where only the recursive query refers to the common table expression, and
+// only once, so that it can be computed by semi-naive iteration.
+func planRecursiveCTE(c *Catalog, cte *commonTableExpr) (*CommonTable, error) {
+	u, ok := cte.stmt.(*sqlparser.Union)
+	if !ok || u.Type != sqlparser.UnionStr && u.Type != sqlparser.UnionAllStr && u.Type != sqlparser.UnionDistinctStr || len(u.OrderBy) > 0 || u.Limit != nil {
+		return nil, GoDBError{ParseError, fmt.Sprintf("recursive WITH query %s must be of the form initial query UNION [ALL] recursive query", cte.name)}
+	}
+	if countReferences(cte.name, u.Left) > 0 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("the initial query of recursive WITH query %s must not refer to it", cte.name)}
+	}
+	if countReferences(cte.name, u.Right) > 1 {
+		return nil, GoDBError{ParseError, fmt.Sprintf("recursive WITH query %s must not refer to itself more than once", cte.name)}
+	}
+	initial, err := planSelectStatement(c, u.Left)
+	if err != nil {
+		return nil, err
+	}
+	table, err := NewCommonTable(cte.name, cte.columns, initial)
+	if err != nil {
+		return nil, err
+	}
+
+	// the recursive query reads the working table in place of the table
+	table.work = &CommonTable{name: cte.name, desc: table.desc, card: table.card, file: &MemFile{desc: table.desc}}
+	scope := *c
+	scope.ctes = maps.Clone(c.ctes)
+	scope.ctes[cte.name] = &cteBinding{cte, c, table.work}
+	step, err := planSelectStatement(&scope, u.Right)
+	if err != nil {
+		return nil, err
+	}
+	desc := step.Descriptor()
+	if len(desc.Fields) != len(table.desc.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("the queries of recursive WITH query %s must have the same number of columns, got %d and %d", cte.name, len(table.desc.Fields), len(desc.Fields))}
+	}
+	types := make([]DBType, len(desc.Fields))
+	for i, f := range table.desc.Fields {
+		if t := desc.Fields[i].Ftype; t != f.Ftype && !implicitlyCoercible(t, f.Ftype) {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("column %s of recursive WITH query %s is %s in the initial query but %s in the recursive one", f.Fname, cte.name, f.Ftype, t)}
+		}
+		types[i] = f.Ftype
+	}
+	table.step = castColumns(step, types)
+	table.all = u.Type == sqlparser.UnionAllStr
+	return table, nil
+}
+
+// Returns the tables and subqueries of a reference in a FROM clause to a
+// common table expression, with the given alias, if any.
+func (b *cteBinding) reference(alias string) ([]*LogicalTableNode, []*LogicalPlan, error) {
+	if b.table != nil {
+		var file DBFile = b.table
+		return []*LogicalTableNode{{b.cte.name, alias, &file, b.scope}}, nil, nil
+	}
+	plan, err := parseStatement(b.scope, b.cte.stmt.(*sqlparser.Select))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b.cte.columns) > 0 {
+		if len(b.cte.columns) != len(plan.selects) {
+			return nil, nil, GoDBError{ParseError, fmt.Sprintf("WITH query %s has %d columns but %d names", b.cte.name, len(plan.selects), len(b.cte.columns))}
+		}
+		for i, s := range plan.selects {
+			named := *s
+			named.alias = b.cte.columns[i]
+			plan.selects[i] = &named
+		}
+	}
+	plan.alias = b.cte.name
+	if alias != "" {
+		plan.alias = alias
+	}
+	return nil, []*LogicalPlan{plan}, nil
+}
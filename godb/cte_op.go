@@ -0,0 +1,172 @@
+package godb
+
+import (
+	"fmt"
+	"sync"
+)
+
+// CommonTable holds the tuples of a common table expression, which are
+// computed by its query the first time it is read in a transaction and kept
+// in a [MemFile] that each reference to it scans.
+//
+// The tuples of a recursive common table expression are computed by
+// semi-naive iteration: those of the initial query are the first delta, and
+// the recursive query, whose reference to the common table expression reads
+// the working table, is run with the working table holding the last delta
+// until it returns no tuples that are not already in the table.  With UNION
+// ALL, every tuple it returns is new, so a query whose recursion does not end
+// only stops when it is canceled.
+type CommonTable struct {
+	name string
+	desc *TupleDesc
+	card int // the estimated number of tuples
+
+	plan Operator     // the query, or the initial query if it is recursive; nil for a working table
+	step Operator     // the recursive query, if any
+	work *CommonTable // the working table read by step
+	all  bool         // whether the duplicates returned by step are kept
+
+	mu       sync.Mutex // held while the tuples are computed
+	tid      TransactionID
+	complete bool // whether file holds the tuples of the query for tid
+	file     *MemFile
+}
+
+// Returns the table of the common table expression name computed by plan,
+// whose columns are renamed to columns if it is not empty.
+func NewCommonTable(name string, columns []string, plan *OperatorCard) (*CommonTable, error) {
+	desc := plan.Descriptor().copy()
+	if len(columns) > 0 && len(columns) != len(desc.Fields) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("WITH query %s has %d columns but %d names", name, len(desc.Fields), len(columns))}
+	}
+	for i := range desc.Fields {
+		if len(columns) > 0 {
+			desc.Fields[i].Fname = columns[i]
+		}
+		desc.Fields[i].TableQualifier = name
+	}
+	return &CommonTable{name: name, desc: desc, card: plan.Cardinality, plan: plan, file: &MemFile{desc: desc}}, nil
+}
+
+func (t *CommonTable) Descriptor() *TupleDesc {
+	return t.desc
+}
+
+func (t *CommonTable) insertTuple(tup *Tuple, tid TransactionID) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("cannot modify WITH query %s", t.name)}
+}
+
+func (t *CommonTable) deleteTuple(tup *Tuple, tid TransactionID) error {
+	return GoDBError{IllegalOperationError, fmt.Sprintf("cannot modify WITH query %s", t.name)}
+}
+
+func (t *CommonTable) readPage(pageNo int) (Page, error) {
+	return t.file.readPage(pageNo)
+}
+
+func (t *CommonTable) flushPage(page Page) error {
+	return nil
+}
+
+func (t *CommonTable) pageKey(pgNo int) any {
+	return t.file.pageKey(pgNo)
+}
+
+func (t *CommonTable) NumPages() int {
+	return t.file.NumPages()
+}
+
+func (t *CommonTable) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(t.Open(tid))
+}
+
+// Open an iterator over the tuples of the table, computing them first if
+// they have not been computed in this transaction.  A working table returns
+// the tuples it was last given.
+func (t *CommonTable) Open(tid TransactionID) (*OpIterator, error) {
+	if t.plan == nil {
+		return t.file.Open(tid)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.complete || t.tid != tid {
+		if err := t.compute(tid); err != nil {
+			return nil, err
+		}
+	}
+	return t.file.Open(tid)
+}
+
+// Compute the tuples of the table into a new file.
+func (t *CommonTable) compute(tid TransactionID) error {
+	tuples, err := t.run(t.plan, tid)
+	if err != nil {
+		return err
+	}
+	if t.step != nil {
+		seen := make(map[any]bool)
+		// Returns the tuples that are not in the table already.
+		added := func(tuples []*Tuple) []*Tuple {
+			if t.all {
+				return tuples
+			}
+			var fresh []*Tuple
+			for _, tup := range tuples {
+				if key := tup.tupleKey(); !seen[key] {
+					seen[key] = true
+					fresh = append(fresh, tup)
+				}
+			}
+			return fresh
+		}
+		delta := added(tuples)
+		tuples = delta
+		for len(delta) > 0 {
+			t.work.file = memFileOf(t.desc, delta)
+			// the operators of the recursive query that keep the tuples
+			// they read would return those of the last delta
+			resetMaterialized(t.step)
+			next, err := t.run(t.step, tid)
+			if err != nil {
+				return err
+			}
+			delta = added(next)
+			tuples = append(tuples, delta...)
+		}
+		t.work.file = &MemFile{desc: t.desc}
+	}
+	t.file, t.tid, t.complete = memFileOf(t.desc, tuples), tid, true
+	return nil
+}
+
+// Returns the tuples of op, with the descriptor of the table.
+func (t *CommonTable) run(op Operator, tid TransactionID) ([]*Tuple, error) {
+	it, err := op.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	var tuples []*Tuple
+	for {
+		if err := checkCanceled(tid); err != nil {
+			it.Close()
+			return nil, err
+		}
+		tup, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if tup == nil {
+			return tuples, nil
+		}
+		tuples = append(tuples, &Tuple{*t.desc, tup.Fields, nil})
+	}
+}
+
+// Returns a file holding tuples, whose descriptor is desc.
+func memFileOf(desc *TupleDesc, tuples []*Tuple) *MemFile {
+	file := &MemFile{desc: desc, pages: make([]*MemPage, 0, len(tuples))}
+	for _, tup := range tuples {
+		file.insertTuple(tup, 0)
+	}
+	return file
+}
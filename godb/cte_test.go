@@ -0,0 +1,120 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCommonTableExpressions(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (id int, name varchar, manager int, salary int)")
+	mustExecForTest(t, c, bp, "create table one (x int)")
+	mustExecForTest(t, c, bp, `insert into emp values (1, 'ann', 0, 300), (2, 'bob', 1, 200), (3, 'cat', 1, 250),
+		(4, 'dan', 2, 100), (5, 'eve', 4, 50), (6, 'fay', 0, 400)`)
+	mustExecForTest(t, c, bp, "insert into one values (1)")
+
+	for sql, want := range map[string]string{
+		"with rich as (select name, salary from emp where salary > 200) select name from rich order by name":                             "ann cat fay",
+		"WITH rich (n, s) AS (SELECT name, salary FROM emp WHERE salary > 200) SELECT n FROM rich WHERE s < 350 ORDER BY n":              "ann cat",
+		"with a as (select id, manager from emp), b as (select id from a where manager = 1) select id from b order by id":                "2 3",
+		"with m (mid, boss) as (select id, name from emp) select e.name, m.boss from emp e join m on e.manager = m.mid order by e.name":  "bob,ann cat,ann dan,bob eve,dan",
+		"with m as (select id, name from emp) select m1.name from m m1, m m2 where m1.id = m2.id + 5":                                    "fay",
+		"with s as (select manager, count(*) n from emp group by manager) select s.manager, s.n from s where s.n > 1 order by s.manager": "0,2 1,2",
+		// a common table expression hides a table of the same name
+		"with emp as (select x from one) select x from emp": "1",
+		// set operations in common table expressions and after them
+		"with u as (select id from emp where id < 3 union select id from emp where id > 5) select id from u order by id": "1 2 6",
+		"with u as (select id from emp) select id from u where id = 1 union all select id from u where id = 2":           "1 2",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+
+	// a common table expression read once is expanded into a subquery, and one
+	// read twice is computed once
+	plan := strings.Join(explainForTest(t, c, bp, "explain with m as (select id from emp) select id from m where id = 1"), "\n")
+	if strings.Contains(plan, "CTE Scan") {
+		t.Errorf("expected no CTE Scan in the plan:\n%s", plan)
+	}
+	plan = strings.Join(explainForTest(t, c, bp, "explain with m as (select id from emp) select m1.id from m m1, m m2 where m1.id = m2.id"), "\n")
+	if strings.Count(plan, "CTE Scan m") != 2 {
+		t.Errorf("expected two scans of m in the plan:\n%s", plan)
+	}
+
+	for _, sql := range []string{
+		"with a as (select id from emp), a as (select id from emp) select id from a",
+		"with a (x, y) as (select id from emp) select x from a",
+		"with a as (select id from emp) select id from b",
+		"with a as (delete from emp) select id from a",
+		"with a as (select id from emp) insert into one values (1)",
+	} {
+		if _, err := execForTest(t, c, bp, sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+	// common table expressions are only in scope in their query
+	if _, err := execForTest(t, c, bp, "select id from a"); err == nil {
+		t.Errorf("expected an error reading a common table expression of an earlier query")
+	}
+}
+
+func TestRecursiveCommonTableExpressions(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (id int, name varchar, manager int)")
+	mustExecForTest(t, c, bp, "create table edge (src int, dst int)")
+	mustExecForTest(t, c, bp, "create table one (x int)")
+	mustExecForTest(t, c, bp, `insert into emp values (1, 'ann', 0), (2, 'bob', 1), (3, 'cat', 1), (4, 'dan', 2), (5, 'eve', 4), (6, 'fay', 0)`)
+	mustExecForTest(t, c, bp, "insert into edge values (1, 2), (2, 3), (3, 1), (3, 4), (5, 6)")
+	mustExecForTest(t, c, bp, "insert into one values (1)")
+
+	for sql, want := range map[string]string{
+		"with recursive n (i) as (select x from one union all select i + 1 from n where i < 10) select sum(i) from n": "55",
+		// the reports of bob, directly or indirectly
+		`with recursive reports (id, name, depth) as (
+			select id, name, 0 from emp where name = 'bob'
+			union all select emp.id, emp.name, reports.depth + 1 from emp join reports on emp.manager = reports.id)
+		select name, depth from reports order by depth`: "bob,0 dan,1 eve,2",
+		// UNION removes the tuples already found, so cycles end
+		"with recursive reach (n) as (select dst from edge where src = 1 union select edge.dst from edge, reach where edge.src = reach.n) select n from reach order by n": "1 2 3 4",
+		// a recursive common table expression read twice, and one that does not refer to itself
+		`with recursive chain (id) as (select id from emp where id = 5 union select emp.manager from emp, chain where emp.id = chain.id and emp.manager > 0),
+			top as (select id from emp where manager = 0)
+		select c1.id from chain c1, chain c2, top where c1.id = c2.id and c1.id = top.id`: "1",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+
+	plan := strings.Join(explainForTest(t, c, bp, "explain with recursive n (i) as (select x from one union all select i + 1 from n where i < 3) select i from n"), "\n")
+	for _, op := range []string{"Recursive CTE Scan n, Union All", "Working Table Scan n"} {
+		if !strings.Contains(plan, op) {
+			t.Errorf("expected %s in the plan:\n%s", op, plan)
+		}
+	}
+
+	ps, err := Prepare(c, "with recursive n (i) as (select x from one union all select i + 1 from n where i < ?) select count(*) from n")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, limit := range []int{3, 7} {
+		tups := execPreparedForTest(t, bp, ps, limit)
+		if len(tups) != 1 || tups[0].Fields[0] != (IntField{int64(limit)}) {
+			t.Errorf("expected %d rows, got %v", limit, tups)
+		}
+	}
+
+	for _, sql := range []string{
+		// the recursive query refers to the table twice
+		"with recursive n (i) as (select x from one union select n1.i + 1 from n n1, n n2 where n1.i < 3) select i from n",
+		// the initial query refers to the table
+		"with recursive n (i) as (select i from n union select x from one) select i from n",
+		"with recursive n (i) as (select x from one union select name from emp, n where emp.id = n.i) select i from n",
+		"with recursive n (i) as (select x from one union select id, name from emp, n where emp.id = n.i) select i from n",
+	} {
+		if _, err := execForTest(t, c, bp, sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
@@ -0,0 +1,607 @@
+package godb
+
+// The API for embedding GoDB in a program: a [DB] opened on a directory runs
+// statements in transactions ([Tx]), and returns the tuples of queries as
+// [Rows].
+//
+//	db, err := godb.Open("data")
+//	...
+//	defer db.Close()
+//	rows, err := db.Query("select name from t where id > ?", 10)
+//	...
+//	defer rows.Close()
+//	for rows.Next() {
+//		var name string
+//		if err := rows.Scan(&name); err != nil {
+//			...
+//		}
+//	}
+//	if err := rows.Err(); err != nil {
+//		...
+//	}
+//
+// Statements run by a DB outside of a transaction commit as soon as they
+// complete, or, for queries, when their rows are closed.
+//
+// The Context variants of the methods that run statements stop them, failing
+// with the error of the context, once it is canceled or its deadline passes,
+// as do statements that run longer than the timeout set with
+// [DB.SetStatementTimeout].  A query runs until its rows are closed.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The number of pages in the buffer pool of a DB opened by [Open].
+var DBBufferPoolPages = 10000
+
+// The name of the catalog file of a DB, in its directory.
+const dbCatalogFile = "catalog.txt"
+
+var (
+	ErrTxDone     = errors.New("godb: transaction has already been committed or rolled back")
+	ErrRowsClosed = errors.New("godb: rows are closed")
+	ErrDBClosed   = errors.New("godb: database is closed")
+)
+
+// A database stored in a directory.  A DB may be used by several goroutines,
+// but runs a single statement at a time.
+type DB struct {
+	mu      sync.Mutex
+	dir     string
+	bp      *BufferPool
+	c       *Catalog
+	timeout time.Duration // the statement timeout, if not 0
+	closed  bool
+
+	// set while stale statistics are being recomputed in the background
+	refreshing bool
+}
+
+// Open the database in dir, creating the directory and an empty database if
+// they do not exist.
+func Open(dir string) (*DB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	bp, err := NewBufferPool(DBBufferPoolPages)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(dir, dbCatalogFile)); errors.Is(err, os.ErrNotExist) {
+		if err := NewCatalog(dbCatalogFile, bp, dir).SaveToFile(dbCatalogFile, dir); err != nil {
+			return nil, err
+		}
+	}
+	c, err := loadCatalog(dbCatalogFile, bp, dir, filepath.Join(dir, dbCatalogFile+".log"))
+	if err != nil {
+		return nil, err
+	}
+	return &DB{dir: dir, bp: bp, c: c}, nil
+}
+
+// Set the time statements may run for before they fail with
+// context.DeadlineExceeded.  A timeout of 0, the default, lets them run for
+// as long as they need.
+func (db *DB) SetStatementTimeout(d time.Duration) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.timeout = d
+}
+
+// Returns the context to run a statement under, which is ctx with the
+// statement timeout of the DB.  Must be called with the DB locked.
+func (db *DB) statementContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.timeout > 0 {
+		return context.WithTimeout(ctx, db.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// Returns the catalog of the database.
+func (db *DB) Catalog() *Catalog {
+	return db.c
+}
+
+// Write the pages and the catalog of the database to disk and close it.
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	db.bp.FlushAllPages()
+	return db.c.SaveToFile(dbCatalogFile, db.dir)
+}
+
+// Begin a transaction.
+func (db *DB) Begin() (*Tx, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	tid := NewTID()
+	if err := db.bp.BeginTransaction(tid); err != nil {
+		return nil, err
+	}
+	return &Tx{db, tid, false, false, nil}, nil
+}
+
+// Run a query in its own transaction, which commits when its rows are
+// closed.
+func (db *DB) Query(sql string, args ...any) (*Rows, error) {
+	return db.query(context.Background(), sql, nil, args)
+}
+
+// Run a query in its own transaction under ctx.
+func (db *DB) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	return db.query(ctx, sql, nil, args)
+}
+
+// Run a query, given by its SQL or as a prepared statement, in its own
+// transaction.
+func (db *DB) query(ctx context.Context, sql string, s *PreparedStatement, args []any) (*Rows, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	tx.autocommit = true
+	rows, err := tx.query(ctx, sql, s, args)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Run a statement that returns no rows in its own transaction.
+func (db *DB) Exec(sql string, args ...any) (Result, error) {
+	return db.exec(context.Background(), sql, nil, args)
+}
+
+// Run a statement that returns no rows in its own transaction under ctx.
+func (db *DB) ExecContext(ctx context.Context, sql string, args ...any) (Result, error) {
+	return db.exec(ctx, sql, nil, args)
+}
+
+// Run a statement, given by its SQL or as a prepared statement, in its own
+// transaction.
+func (db *DB) exec(ctx context.Context, sql string, s *PreparedStatement, args []any) (Result, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return Result{}, err
+	}
+	tx.autocommit = true
+	res, err := tx.exec(ctx, sql, s, args)
+	if err != nil {
+		tx.Rollback()
+		return Result{}, err
+	}
+	return res, tx.Commit()
+}
+
+// The result of a statement run by Exec.
+type Result struct {
+	Type         QueryType
+	RowsAffected int64 // the number of tuples inserted or deleted
+}
+
+// A transaction.  A transaction must end with a call to Commit or Rollback,
+// which close the rows of its queries that are still open.
+type Tx struct {
+	db         *DB
+	tid        TransactionID
+	autocommit bool // whether the transaction was begun by DB.Query or DB.Exec
+	done       bool
+	rows       []*Rows // the open rows of the transaction's queries
+}
+
+// Parse and plan a statement with the given parameter values, running it if
+// it is not a query, insert or delete.  The statement is prepared from sql
+// unless s is set.  The catalog is saved after statements that change it.
+// Must be called with the DB locked.
+func (tx *Tx) plan(sql string, s *PreparedStatement, args []any) (QueryType, Operator, error) {
+	if tx.done {
+		return UnknownQueryType, nil, ErrTxDone
+	}
+	if tx.db.closed {
+		return UnknownQueryType, nil, ErrDBClosed
+	}
+	if s == nil {
+		var err error
+		if s, err = Prepare(tx.db.c, sql); err != nil {
+			return UnknownQueryType, nil, err
+		}
+	}
+	qType, op, err := s.Bind(args...)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	switch qType {
+	case BeginXactionType, CommitXactionType, AbortXactionType:
+		return UnknownQueryType, nil, GoDBError{IllegalTransactionError, "use DB.Begin, Tx.Commit and Tx.Rollback to begin and end transactions"}
+	case CreateTableQueryType, CreateViewQueryType, DropTableQueryType, CreateSchemaQueryType, DropSchemaQueryType:
+		// the table of a CREATE TABLE AS statement is only added to the
+		// catalog once the statement has filled it, so the catalog is saved
+		// after it runs
+		if err := tx.db.c.SaveToFile(dbCatalogFile, tx.db.dir); err != nil {
+			return UnknownQueryType, nil, err
+		}
+	case UnknownQueryType:
+		return UnknownQueryType, nil, GoDBError{ParseError, "unknown statement type"}
+	}
+	return qType, op, nil
+}
+
+// Run a query in the transaction.  The rows must be closed, or read to the
+// end, before the transaction ends.
+func (tx *Tx) Query(sql string, args ...any) (*Rows, error) {
+	return tx.query(context.Background(), sql, nil, args)
+}
+
+// Run a query in the transaction under ctx.
+func (tx *Tx) QueryContext(ctx context.Context, sql string, args ...any) (*Rows, error) {
+	return tx.query(ctx, sql, nil, args)
+}
+
+func (tx *Tx) query(ctx context.Context, sql string, s *PreparedStatement, args []any) (*Rows, error) {
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	qType, op, err := tx.plan(sql, s, args)
+	if err != nil {
+		return nil, err
+	}
+	rows := &Rows{tx: tx, qType: qType, op: op}
+	if op == nil {
+		// a statement without tuples has no rows
+		rows.closed = true
+		rows.endTx()
+		return rows, nil
+	}
+	rows.ctx, rows.cancel = db.statementContext(ctx)
+	restore := SetStatementContext(tx.tid, rows.ctx)
+	it, err := op.Open(tx.tid)
+	restore()
+	if err != nil {
+		rows.cancel()
+		return nil, err
+	}
+	rows.desc, rows.it = op.Descriptor(), it
+	tx.rows = append(tx.rows, rows)
+	return rows, nil
+}
+
+// Run a statement in the transaction, reading any tuples it produces.
+func (tx *Tx) Exec(sql string, args ...any) (Result, error) {
+	return tx.exec(context.Background(), sql, nil, args)
+}
+
+// Run a statement in the transaction under ctx, reading any tuples it
+// produces.
+func (tx *Tx) ExecContext(ctx context.Context, sql string, args ...any) (Result, error) {
+	return tx.exec(ctx, sql, nil, args)
+}
+
+func (tx *Tx) exec(ctx context.Context, sql string, s *PreparedStatement, args []any) (Result, error) {
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	qType, op, err := tx.plan(sql, s, args)
+	if err != nil {
+		return Result{}, err
+	}
+	res := Result{qType, 0}
+	if op == nil {
+		return res, nil
+	}
+	counts := false
+	switch unwrapPlan(op).(type) {
+	case *InsertOp, *DeleteOp:
+		counts = true
+	}
+	ctx, cancel := db.statementContext(ctx)
+	defer cancel()
+	defer SetStatementContext(tx.tid, ctx)()
+	err = forEachTuple(op, tx.tid, func(t *Tuple) {
+		if counts {
+			if n, ok := t.Fields[0].(IntField); ok {
+				res.RowsAffected += n.Value
+			}
+		}
+	})
+	if err == nil && qType == CreateTableAsQueryType {
+		err = db.c.SaveToFile(dbCatalogFile, db.dir)
+	}
+	return res, err
+}
+
+// Returns the operator that computes the tuples of op, which may be the
+// execution of a prepared statement.
+func unwrapPlan(op Operator) Operator {
+	if b, ok := op.(*boundPlan); ok {
+		return b.inst.op
+	}
+	return op
+}
+
+// Commit the transaction, closing its open rows.
+func (tx *Tx) Commit() error {
+	return tx.end(true)
+}
+
+// Abort the transaction, closing its open rows.
+func (tx *Tx) Rollback() error {
+	return tx.end(false)
+}
+
+func (tx *Tx) end(commit bool) error {
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.done = true
+	for _, r := range append([]*Rows(nil), tx.rows...) {
+		r.close()
+	}
+	if commit {
+		db.commit(tx.tid)
+	} else {
+		db.bp.AbortTransaction(tx.tid)
+	}
+	return nil
+}
+
+// The tuples of a query, read one at a time with Next and Scan.
+type Rows struct {
+	tx     *Tx
+	qType  QueryType
+	op     Operator // the operator producing the rows, if any
+	desc   *TupleDesc
+	it     *OpIterator
+	ctx    context.Context // the context the query runs under
+	cancel context.CancelFunc
+	cur    *Tuple
+	err    error
+	closed bool
+}
+
+// Returns the names of the columns of the rows.
+func (r *Rows) Columns() []string {
+	if r.desc == nil {
+		return nil
+	}
+	names := make([]string, len(r.desc.Fields))
+	for i, f := range r.desc.Fields {
+		names[i] = f.Fname
+	}
+	return names
+}
+
+// Returns the descriptor of the rows, or nil if the statement produced none.
+func (r *Rows) Descriptor() *TupleDesc {
+	return r.desc
+}
+
+// Advance to the next row, returning false, and closing the rows, if there is
+// none or if reading it failed, as reported by Err.
+func (r *Rows) Next() bool {
+	db := r.tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if r.closed {
+		return false
+	}
+	restore := SetStatementContext(r.tx.tid, r.ctx)
+	t, err := r.it.Next()
+	restore()
+	if err != nil || t == nil {
+		r.err = err
+		r.close()
+		return false
+	}
+	r.cur = t
+	return true
+}
+
+// Returns the current row, as read by Next.
+func (r *Rows) Tuple() *Tuple {
+	return r.cur
+}
+
+// Returns the error, if any, that ended the rows.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Copy the fields of the current row to dest, which must have a pointer for
+// each of them.  Values may be copied to pointers to their own type (e.g.,
+// IntField), to a DBValue or any, or to a Go type: int or int64 for ints,
+// float64 for floats and decimals, string for strings, bool for booleans and
+// time.Time for dates and timestamps.  Any value may be copied to a string.
+func (r *Rows) Scan(dest ...any) error {
+	if r.closed && r.cur == nil {
+		return ErrRowsClosed
+	}
+	if r.cur == nil {
+		return GoDBError{IllegalOperationError, "Scan called without calling Next"}
+	}
+	if len(dest) != len(r.cur.Fields) {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("expected %d destinations for Scan, got %d", len(r.cur.Fields), len(dest))}
+	}
+	for i, v := range r.cur.Fields {
+		if err := scanValue(v, dest[i]); err != nil {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("column %d (%s): %s", i, r.desc.Fields[i].Fname, err.Error())}
+		}
+	}
+	return nil
+}
+
+// Copy v to the variable dest points to.
+func scanValue(v DBValue, dest any) error {
+	switch d := dest.(type) {
+	case *any:
+		*d = v
+		return nil
+	case *DBValue:
+		*d = v
+		return nil
+	case *string:
+		switch v := v.(type) {
+		case StringField:
+			*d = v.Value
+		case IntField:
+			*d = fmt.Sprint(v.Value)
+		default:
+			*d = fmt.Sprint(v)
+		}
+		return nil
+	}
+	switch v := v.(type) {
+	case IntField:
+		switch d := dest.(type) {
+		case *IntField:
+			*d = v
+			return nil
+		case *int64:
+			*d = v.Value
+			return nil
+		case *int:
+			*d = int(v.Value)
+			return nil
+		case *float64:
+			*d = float64(v.Value)
+			return nil
+		}
+	case FloatField:
+		switch d := dest.(type) {
+		case *FloatField:
+			*d = v
+			return nil
+		case *float64:
+			*d = v.Value
+			return nil
+		}
+	case DecimalField:
+		switch d := dest.(type) {
+		case *DecimalField:
+			*d = v
+			return nil
+		case *float64:
+			*d = v.float()
+			return nil
+		}
+	case StringField:
+		if d, ok := dest.(*StringField); ok {
+			*d = v
+			return nil
+		}
+	case BoolField:
+		switch d := dest.(type) {
+		case *BoolField:
+			*d = v
+			return nil
+		case *bool:
+			*d = v.Value
+			return nil
+		}
+	case DateField:
+		switch d := dest.(type) {
+		case *DateField:
+			*d = v
+			return nil
+		case *time.Time:
+			*d = v.Time()
+			return nil
+		}
+	case TimestampField:
+		switch d := dest.(type) {
+		case *TimestampField:
+			*d = v
+			return nil
+		case *time.Time:
+			*d = v.Time()
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot scan %T into %T", v, dest)
+}
+
+// Close the rows, ending the query's transaction if it was run by DB.Query.
+// Closing rows that are already closed has no effect.
+func (r *Rows) Close() error {
+	db := r.tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	r.close()
+	return nil
+}
+
+// Must be called with the DB locked.
+func (r *Rows) close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	// closing the iterator lets other executions use a cached plan
+	r.it.Close()
+	r.it, r.cur = nil, nil
+	if r.qType == CreateTableAsQueryType && r.err == nil {
+		r.err = r.tx.db.c.SaveToFile(dbCatalogFile, r.tx.db.dir)
+	}
+	if r.cancel != nil {
+		r.cancel()
+	}
+	for i, open := range r.tx.rows {
+		if open == r {
+			r.tx.rows = append(r.tx.rows[:i], r.tx.rows[i+1:]...)
+			break
+		}
+	}
+	r.endTx()
+}
+
+// End the transaction of rows of a query run by DB.Query, which commits
+// unless reading the rows failed.  Must be called with the DB locked.
+func (r *Rows) endTx() {
+	if r.tx.autocommit && !r.tx.done {
+		r.tx.done = true
+		if r.err != nil {
+			// the query failed, so its own transaction aborts
+			r.tx.db.bp.AbortTransaction(r.tx.tid)
+		} else {
+			r.tx.db.commit(r.tx.tid)
+		}
+	}
+}
+
+// Commit the transaction tid, and start recomputing the statistics that its
+// modifications made stale, if any, in the background, so that planning
+// later queries does not have to.  Must be called with the DB locked.
+func (db *DB) commit(tid TransactionID) {
+	db.bp.CommitTransaction(tid)
+	if !db.refreshing && db.c.hasStaleStats() {
+		db.refreshing = true
+		go db.refreshStats()
+	}
+}
+
+// Recompute stale statistics between statements.
+func (db *DB) refreshStats() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.refreshing = false
+	if !db.closed {
+		db.c.RefreshStaleStats()
+	}
+}
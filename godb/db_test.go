@@ -0,0 +1,150 @@
+package godb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDB(t *testing.T) {
+	dir := t.TempDir()
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("create table t (id int, name varchar, score double, at timestamp)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		res, err := db.Exec("insert into t values (?, ?, ?, ?)", i, "n"+string(rune('a'+i)), float64(i)/2, at)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if res.RowsAffected != 1 {
+			t.Errorf("expected 1 row inserted, got %d", res.RowsAffected)
+		}
+	}
+
+	rows, err := db.Query("select id, name, score, at from t where id >= ? order by id", 2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if cols := rows.Columns(); len(cols) != 4 || cols[1] != "name" {
+		t.Errorf("unexpected columns %v", cols)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		var name string
+		var score float64
+		var ts time.Time
+		if err := rows.Scan(&id, &name, &score, &ts); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if name != "n"+string(rune('a'+id)) || score != float64(id)/2 || !ts.Equal(at) {
+			t.Errorf("unexpected row %d %s %f %v", id, name, score, ts)
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil || len(ids) != 3 || ids[0] != 2 {
+		t.Errorf("expected ids 2 to 4, got %v (%v)", ids, rows.Err())
+	}
+	if err := rows.Scan(new(int)); err != ErrRowsClosed {
+		t.Errorf("expected an error scanning closed rows, got %v", err)
+	}
+
+	// errors are returned by Exec and Query, and by Scan
+	if _, err := db.Exec("select * from missing"); err == nil {
+		t.Errorf("expected an error querying a missing table")
+	}
+	if _, err := db.Exec("commit"); err == nil {
+		t.Errorf("expected an error committing outside of Tx.Commit")
+	}
+	rows, _ = db.Query("select name from t")
+	rows.Next()
+	var n int
+	if err := rows.Scan(&n); err == nil {
+		t.Errorf("expected an error scanning a string into an int")
+	}
+	rows.Close()
+
+	// a transaction closes its open rows when it ends
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if res, err := tx.Exec("delete from t where id < ?", 2); err != nil || res.RowsAffected != 2 {
+		t.Errorf("expected 2 rows deleted, got %d (%v)", res.RowsAffected, err)
+	}
+	rows, err = tx.Query("select id from t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !rows.Next() {
+		t.Fatalf("expected a row")
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if rows.Next() {
+		t.Errorf("expected the rows to be closed by the commit")
+	}
+	if _, err := tx.Query("select id from t"); err != ErrTxDone {
+		t.Errorf("expected a query after the commit to fail, got %v", err)
+	}
+	if err := db.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// the database is loaded again from its directory
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	rows, err = db.Query("select count(*) from t")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var count int64
+	if !rows.Next() || rows.Scan(&count) != nil || count != 3 {
+		t.Errorf("expected 3 rows after reopening, got %d", count)
+	}
+	rows.Close()
+}
+
+// The table of a CREATE TABLE AS statement is in the saved catalog as soon as
+// the statement has run, not only once the database is closed.
+func TestDBCreateTableAsSaved(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (id int, name varchar)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("insert into t values (1, 'a'), (2, 'b')"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("create table t2 as select id from t"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	rows, err := db.Query("create table t3 as select name from t where id = 2")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+
+	c, err := reloadCatalogForTest(t, db.c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, name := range []string{"t2", "t3"} {
+		if _, err := c.GetTable(name); err != nil {
+			t.Errorf("expected table %s in the saved catalog: %s", name, err.Error())
+		}
+	}
+}
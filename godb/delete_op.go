@@ -3,6 +3,10 @@ package godb
 type DeleteOp struct {
 	file DBFile
 	op   Operator
+
+	// catalog used to enforce foreign keys that reference file; may be nil,
+	// in which case no constraints are checked
+	catalog *Catalog
 }
 
 // Construct a delete operator. The delete operator deletes the records in the
@@ -22,35 +26,73 @@ func (i *DeleteOp) Descriptor() *TupleDesc {
 // with a "count" field indicating the number of tuples that were deleted.
 // Tuples should be deleted using the [DBFile.deleteTuple] method.
 func (dop *DeleteOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(dop.Open(tid))
+}
+
+func (dop *DeleteOp) Open(tid TransactionID) (*OpIterator, error) {
 	completed := false
+	var it *OpIterator
 
-	return func() (*Tuple, error) {
+	return newOpIterator(func() (*Tuple, error) {
+		if completed {
+			return nil, nil
+		}
 		count := int64(0)
 		if !completed {
 			// do all the insertion stuff
-			it, err := dop.op.Iterator(tid)
+			var err error
+			it, err = dop.op.Open(tid)
 			if err != nil {
 				return nil, err
 			}
+			// collect the tuples first, so that foreign keys can be checked
+			// before anything is deleted
+			var tuples []*Tuple
 			for {
-				tuple, err := it()
+				if err := checkCanceled(tid); err != nil {
+					return nil, err
+				}
+				tuple, err := it.Next()
 				if err != nil {
 					return nil, err
 				}
 				if tuple == nil {
 					break
 				}
+				tuples = append(tuples, tuple)
+			}
+			if dop.catalog != nil {
+				deleting := make(map[rowID]bool, len(tuples))
+				for _, tuple := range tuples {
+					deleting[rowID{dop.file, tuple.Rid}] = true
+				}
+				if err := dop.catalog.checkDeleteReferences(dop.file, tuples, deleting, tid); err != nil {
+					return nil, err
+				}
+			}
 
+			for _, tuple := range tuples {
 				if err := dop.file.deleteTuple(tuple, tid); err != nil {
 					return nil, err
-				} else {
-					count++
+				}
+				count++
+			}
+			if dop.catalog != nil {
+				for _, tuple := range tuples {
+					if err := dop.catalog.enforceDeleteReferences(dop.file, tuple, tid); err != nil {
+						return nil, err
+					}
 				}
 			}
 
 			completed = true
+			if dop.catalog != nil {
+				dop.catalog.noteModifications(dop.file, count)
+			}
 		}
 
 		return &Tuple{Desc: *dop.Descriptor(), Fields: []DBValue{IntField{count}}}, nil
-	}, nil
+	}, func() error {
+		return closeIterators(it)
+	}), nil
 }
@@ -0,0 +1,560 @@
+package godb
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// Statistics about the execution of an operator, collected by EXPLAIN
+// ANALYZE.  Like the estimates in [OperatorCard], they include the work done by
+// the operator's children.
+type OperatorStats struct {
+	Rows         int64         `json:"rows"`    // tuples produced, over all loops
+	Loops        int64         `json:"loops"`   // number of times the operator's iterator was created
+	Time         time.Duration `json:"time_ns"` // time spent creating and calling the iterator
+	PageRequests int64         `json:"pages"`   // pages requested from [BufferPool.GetPage]
+	PageHits     int64         `json:"hits"`    // requested pages that were already in the buffer pool
+}
+
+func (s *OperatorStats) String() string {
+	return fmt.Sprintf("actual rows:%d loops:%d time:%.3fms pages:%d hits:%d",
+		s.Rows, s.Loops, float64(s.Time.Microseconds())/1000, s.PageRequests, s.PageHits)
+}
+
+// Returns pointers to the inputs of op, so that they can be replaced.
+func planChildren(op Operator) []*Operator {
+	switch op := op.(type) {
+	case *OperatorCard:
+		return []*Operator{&op.Op}
+	case *EqualityJoin:
+		return []*Operator{op.left, op.right}
+	case *HashJoin:
+		return []*Operator{op.left, op.right}
+	case *Materialize:
+		return []*Operator{&op.child}
+	case *Project:
+		return []*Operator{&op.child}
+	case *Filter:
+		return []*Operator{&op.child}
+	case *OrderBy:
+		return []*Operator{&op.child}
+	case *LimitOp:
+		return []*Operator{&op.child}
+	case *Aggregator:
+		return []*Operator{&op.child}
+	case *InsertOp:
+		return []*Operator{&op.op}
+	case *DeleteOp:
+		return []*Operator{&op.op}
+	case *Vectorized:
+		return batchLeaves(op.plan)
+	case *Gather:
+		return operatorPointers(op.workers)
+	case *ParallelAggregator:
+		return operatorPointers(op.workers)
+	case *ParallelHashJoin:
+		return append(operatorPointers(op.builds), operatorPointers(op.probes)...)
+	case *Union:
+		return []*Operator{&op.left, &op.right}
+	case *Intersect:
+		return []*Operator{&op.left, &op.right}
+	case *Except:
+		return []*Operator{&op.left, &op.right}
+	case *Window:
+		return []*Operator{&op.child}
+	}
+	return nil
+}
+
+// Returns the inputs of op that are shown in its plan.  The workers of
+// parallel operators run the same plan over different parts of their input,
+// so only the first one is shown, with the statistics of all of them (see
+// [sumWorkerStats]).
+func shownChildren(op Operator) []*Operator {
+	switch op := op.(type) {
+	case *Gather:
+		return []*Operator{&op.workers[0]}
+	case *ParallelAggregator:
+		return []*Operator{&op.workers[0]}
+	case *ParallelHashJoin:
+		if op.buildLeft {
+			return []*Operator{&op.builds[0], &op.probes[0]}
+		}
+		return []*Operator{&op.probes[0], &op.builds[0]}
+	}
+	return planChildren(op)
+}
+
+// Returns pointers to the elements of ops.
+func operatorPointers(ops []Operator) []*Operator {
+	ptrs := make([]*Operator, len(ops))
+	for i := range ops {
+		ptrs[i] = &ops[i]
+	}
+	return ptrs
+}
+
+// Returns the name of the aggregate computed by an aggregation state, e.g.,
+// "count" for a [CountAggState].
+func aggName(agg AggState) string {
+	name := strings.TrimSuffix(strings.TrimPrefix(reflect.TypeOf(agg).String(), "*godb."), "AggState")
+	return strings.ToLower(name)
+}
+
+// Describes how an [Aggregator] finds groups or a [Project] removes
+// duplicates.
+func strategyString(sorted bool, passes int) string {
+	switch {
+	case sorted:
+		return "sorted"
+	case passes > 1:
+		return fmt.Sprintf("hashed in %d passes", passes)
+	}
+	return "hashed"
+}
+
+// Make every operator in the plan rooted at card record [OperatorStats] as it
+// runs.  Operators that have no cardinality estimate are wrapped in an
+// OperatorCard with an unknown (negative) cardinality.
+func instrumentPlan(card *OperatorCard, bp *BufferPool) {
+	card.actual = &OperatorStats{}
+	card.bufferPool = bp
+	for _, child := range planChildren(card.Op) {
+		c, ok := (*child).(*OperatorCard)
+		if !ok {
+			c = NewOperatorCard(*child, -1)
+			*child = c
+		}
+		instrumentPlan(c, bp)
+	}
+}
+
+// Add the statistics of the other workers of each parallel operator in the
+// plan rooted at op to those of its first worker, which is the one that is
+// shown.  Rows, loops, time and pages are then totals over all workers, so the
+// time of a worker can exceed that of the operator that runs them.
+func sumWorkerStats(op Operator) {
+	for _, child := range planChildren(op) {
+		sumWorkerStats(*child)
+	}
+	var groups [][]Operator
+	switch op := op.(type) {
+	case *Gather:
+		groups = [][]Operator{op.workers}
+	case *ParallelAggregator:
+		groups = [][]Operator{op.workers}
+	case *ParallelHashJoin:
+		groups = [][]Operator{op.builds, op.probes}
+	}
+	for _, workers := range groups {
+		for _, w := range workers[1:] {
+			addStats(workers[0], w)
+		}
+	}
+}
+
+// Add the statistics of each operator in the plan rooted at src to those of
+// the corresponding operator in dst, which runs the same plan.
+func addStats(dst, src Operator) {
+	d, dok := dst.(*OperatorCard)
+	s, sok := src.(*OperatorCard)
+	if dok && sok && d.actual != nil && s.actual != nil {
+		d.actual.Rows += s.actual.Rows
+		d.actual.Loops += s.actual.Loops
+		d.actual.Time += s.actual.Time
+		d.actual.PageRequests += s.actual.PageRequests
+		d.actual.PageHits += s.actual.PageHits
+	}
+	dsts, srcs := planChildren(dst), planChildren(src)
+	for i := 0; i < len(dsts) && i < len(srcs); i++ {
+		addStats(*dsts[i], *srcs[i])
+	}
+}
+
+// Returns an iterator over the tuples of o.Op that records their number, the
+// time spent producing them and the pages requested in o.actual.
+func (o *OperatorCard) instrumentedIterator(tid TransactionID) (*OpIterator, error) {
+	measure := func(f func()) {
+		requests, hits := o.bufferPool.pageStats()
+		start := time.Now()
+		f()
+		o.actual.Time += time.Since(start)
+		endRequests, endHits := o.bufferPool.pageStats()
+		o.actual.PageRequests += endRequests - requests
+		o.actual.PageHits += endHits - hits
+	}
+	var iter *OpIterator
+	var err error
+	measure(func() { iter, err = o.Op.Open(tid) })
+	if err != nil {
+		return nil, err
+	}
+	o.actual.Loops++
+	return newOpIterator(func() (*Tuple, error) {
+		var t *Tuple
+		var err error
+		measure(func() { t, err = iter.Next() })
+		if t != nil {
+			o.actual.Rows++
+		}
+		return t, err
+	}, func() error {
+		var err error
+		measure(func() { err = iter.Close() })
+		return err
+	}), nil
+}
+
+// The formats EXPLAIN can produce a plan in.
+type ExplainFormat int
+
+const (
+	ExplainText ExplainFormat = iota // the indented text of [OutputPhysicalPlan]
+	ExplainJSON ExplainFormat = iota // a tree of [ExplainNode]s as JSON
+	ExplainDOT  ExplainFormat = iota // a Graphviz digraph
+)
+
+// ExplainOp produces the plan of another operator.  In the text format, the
+// plan is produced one line per tuple; in the other formats, it is a single
+// tuple.  If analyze is set, the plan is first run to completion, and the
+// actual rows, time and pages of each operator are shown next to its estimate.
+type ExplainOp struct {
+	plan       *OperatorCard
+	analyze    bool
+	format     ExplainFormat
+	bufferPool *BufferPool
+}
+
+func NewExplainOp(plan Operator, analyze bool, format ExplainFormat, bp *BufferPool) *ExplainOp {
+	card, ok := plan.(*OperatorCard)
+	if !ok {
+		card = NewOperatorCard(plan, -1)
+	}
+	return &ExplainOp{card, analyze, format, bp}
+}
+
+func (e *ExplainOp) Descriptor() *TupleDesc {
+	return &TupleDesc{[]FieldType{{"plan", "", StringType}}}
+}
+
+func (e *ExplainOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(e.Open(tid))
+}
+
+func (e *ExplainOp) Open(tid TransactionID) (*OpIterator, error) {
+	if e.analyze {
+		instrumentPlan(e.plan, e.bufferPool)
+		if err := forEachTuple(e.plan, tid, func(*Tuple) {}); err != nil {
+			return nil, err
+		}
+		sumWorkerStats(e.plan)
+	}
+	var lines []string
+	switch e.format {
+	case ExplainJSON:
+		data, err := json.MarshalIndent(describePlan(e.plan), "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		lines = []string{string(data)}
+	case ExplainDOT:
+		lines = []string{describePlan(e.plan).dot()}
+	default:
+		var buf strings.Builder
+		OutputPhysicalPlan(func(format string, a ...any) { fmt.Fprintf(&buf, format, a...) }, e.plan, "")
+		lines = strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	}
+	desc := e.Descriptor()
+	i := 0
+	return newOpIterator(func() (*Tuple, error) {
+		if i == len(lines) {
+			return nil, nil
+		}
+		i++
+		return &Tuple{*desc, []DBValue{StringField{lines[i-1]}}, nil}, nil
+	}, nil), nil
+}
+
+var explainRe = regexp.MustCompile(`(?is)^\s*explain(\s+analyze)?(\s*\(([^)]*)\))?\s+(.*)$`)
+
+// Plan an EXPLAIN statement, which is one of
+//
+//	EXPLAIN [ANALYZE] statement
+//	EXPLAIN (option, ...) statement
+//
+// where the options are ANALYZE [true|false] and FORMAT TEXT|JSON|DOT.  The
+// explained statement must produce an operator, i.e., be a query, insert or
+// delete.  EXPLAIN ANALYZE runs it, so an insert or delete does modify the
+// table.
+func parseExplain(c *Catalog, m []string) (QueryType, Operator, error) {
+	analyze := m[1] != ""
+	format := ExplainText
+	if m[2] != "" {
+		for _, opt := range strings.Split(m[3], ",") {
+			words := strings.Fields(strings.ToLower(opt))
+			switch {
+			case len(words) == 1 && words[0] == "analyze":
+				analyze = true
+			case len(words) == 2 && words[0] == "analyze" && (words[1] == "true" || words[1] == "false"):
+				analyze = words[1] == "true"
+			case len(words) == 2 && words[0] == "format" && words[1] == "text":
+				format = ExplainText
+			case len(words) == 2 && words[0] == "format" && words[1] == "json":
+				format = ExplainJSON
+			case len(words) == 2 && words[0] == "format" && words[1] == "dot":
+				format = ExplainDOT
+			default:
+				return UnknownQueryType, nil, GoDBError{ParseError, fmt.Sprintf("unknown EXPLAIN option '%s'", strings.TrimSpace(opt))}
+			}
+		}
+	}
+	// other statements are rejected before they are planned, since planning
+	// them (e.g., a DROP TABLE) carries them out
+	notExplainable := GoDBError{ParseError, "only queries, inserts and deletes can be explained"}
+	query := rewriteWindows(rewriteCasts(m[4]))
+	if _, _, _, ok := splitCreateAs(query); ok || explainRe.MatchString(query) || analyzeRe.MatchString(query) {
+		return UnknownQueryType, nil, notExplainable
+	}
+	stmt, fks, err := parseSQL(query)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	switch stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.ParenSelect, *withSelect, *sqlparser.Insert, *sqlparser.Delete:
+	default:
+		return UnknownQueryType, nil, notExplainable
+	}
+	_, op, err := planStatement(c, stmt, fks)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	return IteratorType, NewExplainOp(op, analyze, format, c.bufferPool), nil
+}
+
+// The description of an operator in a plan exported by EXPLAIN (FORMAT JSON).
+type ExplainNode struct {
+	Operator      string         `json:"operator"`
+	Properties    map[string]any `json:"properties,omitempty"`
+	EstimatedRows *int           `json:"estimated_rows,omitempty"` // nil if there is no estimate
+	EstimatedCost *float64       `json:"estimated_cost,omitempty"` // nil if there is no estimate
+	Rejected      []PlanChoice   `json:"rejected,omitempty"`       // the alternatives the planner rejected
+	Actual        *OperatorStats `json:"actual,omitempty"`         // set by EXPLAIN ANALYZE
+	Children      []*ExplainNode `json:"children,omitempty"`
+}
+
+// Describe the plan rooted at o.
+func describePlan(o Operator) *ExplainNode {
+	n := &ExplainNode{}
+	if oc, ok := o.(*OperatorCard); ok {
+		if oc.Cardinality >= 0 {
+			card := oc.Cardinality
+			n.EstimatedRows = &card
+		}
+		if oc.Cost >= 0 {
+			cost := oc.Cost
+			n.EstimatedCost = &cost
+		}
+		n.Rejected = oc.Rejected
+		n.Actual = oc.actual
+		o = oc.Op
+	}
+	props := map[string]any{}
+	exprs := func(es []Expr) []string {
+		strs := make([]string, len(es))
+		for i, e := range es {
+			strs[i] = exprToStr(e)
+		}
+		return strs
+	}
+	switch op := o.(type) {
+	case *EqualityJoin:
+		n.Operator = "Join"
+		props["condition"] = exprToStr(op.leftField) + " = " + exprToStr(op.rightField)
+	case *HashJoin:
+		n.Operator = "Hash Join"
+		props["condition"] = exprToStr(op.leftField) + " = " + exprToStr(op.rightField)
+		props["build"] = op.buildSide()
+	case *Materialize:
+		n.Operator = "Materialize"
+	case *Project:
+		n.Operator = "Project"
+		props["expressions"] = exprs(op.selectFields)
+		props["names"] = op.outputNames
+		if op.distinct {
+			props["distinct"] = strategyString(op.sorted, op.passes)
+		}
+	case *Filter:
+		n.Operator = "Filter"
+		props["condition"] = exprToStr(op.left) + " " + strings.TrimSpace(opToStr(op.op)) + " " + exprToStr(op.right)
+	case *HeapFile:
+		n.Operator = "Heap Scan"
+		props["file"] = op.BackingFile()
+	case *OrderBy:
+		n.Operator = "Order By"
+		props["keys"] = exprs(op.orderBy)
+		props["ascending"] = op.ascending
+		if op.maxBufferSize > 0 {
+			props["buffer_bytes"] = op.maxBufferSize
+		}
+	case *LimitOp:
+		n.Operator = "Limit"
+		props["limit"] = exprToStr(op.limitTups)
+	case *Aggregator:
+		n.Operator = "Aggregate"
+		var aggs []string
+		for _, agg := range op.newAggState {
+			aggs = append(aggs, aggName(agg)+"("+agg.GetTupleDesc().HeaderString(false)+")")
+		}
+		props["aggregates"] = aggs
+		if len(op.groupByFields) > 0 {
+			props["group_by"] = exprs(op.groupByFields)
+			props["strategy"] = strategyString(op.sorted, op.passes)
+		}
+	case *InsertOp:
+		n.Operator = "Insert"
+		props["file"] = fileName(op.file)
+	case *DeleteOp:
+		n.Operator = "Delete"
+		props["file"] = fileName(op.file)
+	case *ValueOp:
+		n.Operator = "Values"
+		props["rows"] = len(op.exprs)
+	case *MemFile:
+		n.Operator = "Memory Scan"
+		props["pages"] = op.NumPages()
+	case *CommonTable:
+		n.Operator = "CTE Scan"
+		props["name"] = op.name
+		switch {
+		case op.plan == nil:
+			n.Operator = "Working Table Scan"
+		case op.step == nil:
+			n.Children = append(n.Children, describePlan(op.plan))
+		default:
+			n.Operator = "Recursive CTE Scan"
+			props["recursion"] = setOpName("Union", op.all)
+			n.Children = append(n.Children, describePlan(op.plan), describePlan(op.step))
+		}
+	case *Vectorized:
+		n.Operator = "Vectorized"
+		props["pipeline"] = batchPlanLines(op.plan, "")
+	case *HeapScanPartition:
+		n.Operator = "Heap Scan Partition"
+		props["file"] = op.file.BackingFile()
+		props["part"] = fmt.Sprintf("%d/%d", op.part+1, op.parts)
+	case *Gather:
+		n.Operator = "Gather"
+		props["workers"] = len(op.workers)
+	case *ParallelAggregator:
+		n.Operator = "Parallel Aggregate"
+		var aggs []string
+		for _, agg := range op.newAggState {
+			aggs = append(aggs, aggName(agg)+"("+agg.GetTupleDesc().HeaderString(false)+")")
+		}
+		props["aggregates"] = aggs
+		if len(op.groupByFields) > 0 {
+			props["group_by"] = exprs(op.groupByFields)
+		}
+		props["workers"] = len(op.workers)
+	case *ParallelHashJoin:
+		n.Operator = "Parallel Hash Join"
+		props["condition"] = exprToStr(op.leftField) + " = " + exprToStr(op.rightField)
+		props["build"] = op.buildSide()
+		props["workers"] = len(op.probes)
+	case *Union:
+		n.Operator = setOpName("Union", op.all)
+		if !op.all {
+			props["strategy"] = strategyString(false, op.passes)
+		}
+	case *Intersect:
+		n.Operator = setOpName("Intersect", op.all)
+		props["strategy"] = strategyString(false, op.passes)
+	case *Except:
+		n.Operator = setOpName("Except", op.all)
+		props["strategy"] = strategyString(false, op.passes)
+	case *Window:
+		n.Operator = "Window"
+		if len(op.partitionBy) > 0 {
+			props["partition_by"] = exprs(op.partitionBy)
+		}
+		if len(op.orderBy) > 0 {
+			props["order_by"] = exprs(op.orderBy)
+			props["ascending"] = op.ascending
+		}
+		var funcs []string
+		for _, f := range op.funcs {
+			funcs = append(funcs, f.String())
+		}
+		props["functions"] = funcs
+	default:
+		n.Operator = reflect.TypeOf(o).String()
+	}
+	if len(props) > 0 {
+		n.Properties = props
+	}
+	for _, child := range shownChildren(o) {
+		n.Children = append(n.Children, describePlan(*child))
+	}
+	return n
+}
+
+// Returns the plan rooted at n as a Graphviz digraph, with an edge from each
+// operator to each of its inputs.
+func (n *ExplainNode) dot() string {
+	var buf strings.Builder
+	buf.WriteString("digraph plan {\n  node [shape=box];\n")
+	next := 0
+	var visit func(n *ExplainNode) int
+	visit = func(n *ExplainNode) int {
+		id := next
+		next++
+		label := []string{n.Operator}
+		for _, k := range sortedKeys(n.Properties) {
+			label = append(label, fmt.Sprintf("%s: %v", k, n.Properties[k]))
+		}
+		card := "card: ?"
+		if n.EstimatedRows != nil {
+			card = fmt.Sprintf("card: %d", *n.EstimatedRows)
+		}
+		label = append(label, card)
+		if n.EstimatedCost != nil {
+			label = append(label, fmt.Sprintf("cost: %.0f", *n.EstimatedCost))
+		}
+		for _, r := range n.Rejected {
+			label = append(label, "rejected: "+r.String())
+		}
+		if n.Actual != nil {
+			label = append(label, n.Actual.String())
+		}
+		fmt.Fprintf(&buf, "  n%d [label=%s];\n", id, dotQuote(strings.Join(label, "\n")))
+		for _, child := range n.Children {
+			fmt.Fprintf(&buf, "  n%d -> n%d;\n", id, visit(child))
+		}
+		return id
+	}
+	visit(n)
+	buf.WriteString("}")
+	return buf.String()
+}
+
+// Quote s as a DOT string, with its lines left-justified.
+func dotQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+	return `"` + strings.ReplaceAll(s, "\n", `\l`) + `\l"`
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,162 @@
+package godb
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func explainForTest(t *testing.T, c *Catalog, bp *BufferPool, sql string) []string {
+	t.Helper()
+	tups := mustExecForTest(t, c, bp, sql)
+	lines := make([]string, len(tups))
+	for i, tup := range tups {
+		lines[i] = tup.Fields[0].(StringField).Value
+	}
+	return lines
+}
+
+var actualRe = regexp.MustCompile(`card:(\d+|\?)(?: cost:\d+)?(?: rejected:\[[^\]]*\])? \(actual rows:(\d+) loops:(\d+) time:[0-9.]+ms pages:(\d+) hits:(\d+)\)$`)
+
+func TestExplainAnalyze(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (id int, dept int, name varchar)")
+	mustExecForTest(t, c, bp, "create table dept (id int, title varchar)")
+	for i := 0; i < 50; i++ {
+		mustExecForTest(t, c, bp, fmt.Sprintf("insert into emp values (%d, %d, 'e%d')", i, i%5, i))
+	}
+	mustExecForTest(t, c, bp, "insert into dept values (0, 'a'), (1, 'b'), (2, 'c'), (3, 'd'), (4, 'e')")
+
+	// EXPLAIN does not run the query
+	lines := explainForTest(t, c, bp, "explain select emp.name from emp join dept on emp.dept = dept.id where dept.title = 'b'")
+	if len(lines) < 4 || !strings.HasPrefix(lines[0], "Project") {
+		t.Fatalf("unexpected plan %q", lines)
+	}
+	for _, l := range lines {
+		if strings.Contains(l, "actual") {
+			t.Errorf("EXPLAIN should not report actual statistics: %s", l)
+		}
+	}
+
+	lines = explainForTest(t, c, bp, "explain analyze select emp.name from emp join dept on emp.dept = dept.id where dept.title = 'b'")
+	rows := map[string]int64{}
+	for _, l := range lines {
+		m := actualRe.FindStringSubmatch(l)
+		if m == nil {
+			t.Fatalf("expected actual statistics in %q", l)
+		}
+		n, _ := strconv.ParseInt(m[2], 10, 64)
+		loops, _ := strconv.ParseInt(m[3], 10, 64)
+		pages, _ := strconv.ParseInt(m[4], 10, 64)
+		hits, _ := strconv.ParseInt(m[5], 10, 64)
+		if hits > pages {
+			t.Errorf("more buffer hits than page requests: %s", l)
+		}
+		op := strings.Fields(strings.TrimSpace(l))[0]
+		if _, ok := rows[op]; !ok {
+			rows[op] = n
+		}
+		if op == "Filter" && n != loops {
+			t.Errorf("expected 1 row per loop from the filter: %s", l)
+		}
+		if op == "Heap" && pages == 0 {
+			t.Errorf("expected a scan to request pages: %s", l)
+		}
+	}
+	if rows["Project"] != 10 || rows["Join,"] != 10 {
+		t.Errorf("expected 10 rows from the join and projection, got %v", rows)
+	}
+
+	// EXPLAIN ANALYZE of a delete deletes the tuples
+	lines = explainForTest(t, c, bp, "explain analyze delete from emp where dept = 3")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "Delete") || !actualRe.MatchString(lines[0]) {
+		t.Errorf("unexpected plan %q", lines)
+	}
+	if n := countRowsForTest(t, c, bp, "emp"); n != 40 {
+		t.Errorf("expected 40 rows after delete, got %d", n)
+	}
+
+	// other statements are rejected without being carried out
+	for _, sql := range []string{
+		"explain create table x (a int)",
+		"explain drop table dept",
+		"explain create table x as select id from emp",
+		"explain analyze create view v as select id from emp",
+		"explain create schema s",
+		"explain analyze table emp",
+	} {
+		if _, _, err := Parse(c, sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+	if _, err := c.GetTableInfo("dept"); err != nil {
+		t.Errorf("expected dept to survive EXPLAIN DROP TABLE")
+	}
+	if _, err := c.GetTableInfo("x"); err == nil || c.getView("v") != nil {
+		t.Errorf("expected EXPLAIN to create no table or view")
+	}
+	if len(c.SchemaNames()) != 1 {
+		t.Errorf("expected EXPLAIN to create no schema, got %v", c.SchemaNames())
+	}
+}
+
+func TestExplainFormats(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table t (a int, b varchar)")
+	mustExecForTest(t, c, bp, "insert into t values (1, 'x'), (2, 'y'), (3, 'z')")
+
+	lines := explainForTest(t, c, bp, "explain (format json) select b, count(a) from t where a > 1 group by b order by b limit 2")
+	if len(lines) != 1 {
+		t.Fatalf("expected the JSON plan in one tuple, got %d", len(lines))
+	}
+	var root ExplainNode
+	if err := json.Unmarshal([]byte(lines[0]), &root); err != nil {
+		t.Fatalf("invalid JSON plan: %s\n%s", err.Error(), lines[0])
+	}
+	var ops []string
+	for n := &root; n != nil; {
+		ops = append(ops, n.Operator)
+		if n.Actual != nil {
+			t.Errorf("EXPLAIN should not report actual statistics")
+		}
+		if len(n.Children) == 0 {
+			break
+		}
+		n = n.Children[0]
+	}
+	want := []string{"Limit", "Order By", "Project", "Aggregate", "Filter", "Heap Scan"}
+	if strings.Join(ops, ",") != strings.Join(want, ",") {
+		t.Errorf("expected operators %v, got %v", want, ops)
+	}
+
+	// every operator is described, including those that modify tables
+	lines = explainForTest(t, c, bp, "explain (analyze, format json) insert into t values (4, 'w'), (5, 'v')")
+	root = ExplainNode{}
+	if err := json.Unmarshal([]byte(lines[0]), &root); err != nil {
+		t.Fatalf("invalid JSON plan: %s", err.Error())
+	}
+	if root.Operator != "Insert" || len(root.Children) != 1 || root.Children[0].Operator != "Values" {
+		t.Fatalf("unexpected plan %s", lines[0])
+	}
+	if root.Children[0].Properties["rows"] != float64(2) || root.Children[0].Actual == nil || root.Children[0].Actual.Rows != 2 {
+		t.Errorf("unexpected Values node %s", lines[0])
+	}
+	if n := countRowsForTest(t, c, bp, "t"); n != 5 {
+		t.Errorf("expected EXPLAIN ANALYZE to insert 2 rows, got %d rows", n)
+	}
+
+	lines = explainForTest(t, c, bp, "explain (format dot) delete from t where b = 'x'")
+	dot := lines[0]
+	for _, s := range []string{"digraph plan {", `"Delete\l`, `"Filter\lcondition: t.b = 'x'\l`, "n0 -> n1;", "n1 -> n2;"} {
+		if !strings.Contains(dot, s) {
+			t.Errorf("expected %q in DOT plan\n%s", s, dot)
+		}
+	}
+
+	if _, _, err := Parse(c, "explain (format yaml) select * from t"); err == nil {
+		t.Errorf("expected an error for an unknown format")
+	}
+}
@@ -3,6 +3,7 @@ package godb
 import (
 	"fmt"
 	"math/rand"
+	"strings"
 	"time"
 )
 
@@ -13,7 +14,7 @@ import (
 //other values from tuples.
 
 type Expr interface {
-	EvalExpr(t *Tuple) (DBValue, error) //DBValue is either IntField or StringField
+	EvalExpr(t *Tuple) (DBValue, error) //DBValue is one of the field types, e.g., IntField or StringField
 	GetExprType() FieldType             //Return the type of the Expression
 }
 
@@ -48,15 +49,70 @@ func (c *ConstExpr) EvalExpr(_ *Tuple) (DBValue, error) {
 	return c.val, nil
 }
 
+// A parameter of a prepared statement, numbered from 1, whose value is held
+// by a constant that is set again every time the plan it is in is executed.
+// Unlike a ConstExpr, it is never folded into a constant when the query is
+// planned, since that constant would keep the value of the first execution.
+type ParamExpr struct {
+	n    int
+	slot *ConstExpr
+}
+
+func (p *ParamExpr) GetExprType() FieldType {
+	return FieldType{"const", fmt.Sprintf("$%d", p.n), p.slot.constType}
+}
+
+func (p *ParamExpr) EvalExpr(_ *Tuple) (DBValue, error) {
+	return p.slot.val, nil
+}
+
+// Converts the value of expr to another type, either for an explicit
+// CAST(expr AS type) or where the planner coerces an operand (see
+// [coerceComparison] and [bindFunc]).  scale is the scale of a cast to
+// DECIMAL(p,s), or -1 if the result keeps the scale of the value.
+type CastExpr struct {
+	expr  Expr
+	ftype DBType
+	scale int
+}
+
+func (c *CastExpr) GetExprType() FieldType {
+	ft := c.expr.GetExprType()
+	return FieldType{ft.Fname, ft.TableQualifier, c.ftype}
+}
+
+func (c *CastExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	v, err := c.expr.EvalExpr(t)
+	if err != nil {
+		return nil, err
+	}
+	return castValue(v, c.ftype, c.scale)
+}
+
+// Gives the values of expr a column of their own, e.g., the column of the
+// groups of a GROUP BY expression, which the select list reads.
+type RenamedExpr struct {
+	expr Expr
+	name string
+}
+
+func (r *RenamedExpr) GetExprType() FieldType {
+	return FieldType{r.name, "", r.expr.GetExprType().Ftype}
+}
+
+func (r *RenamedExpr) EvalExpr(t *Tuple) (DBValue, error) {
+	return r.expr.EvalExpr(t)
+}
+
 type FuncExpr struct {
 	op   string
 	args []*Expr
 }
 
 func (f *FuncExpr) GetExprType() FieldType {
-	fType, exists := funcs[f.op]
+	fType, err := resolveFunc(f.op, f.args)
 	//todo return err
-	if !exists {
+	if err != nil {
 		return FieldType{f.op, "", IntType}
 	}
 	ft := FieldType{f.op, "", IntType}
@@ -70,50 +126,126 @@ func (f *FuncExpr) GetExprType() FieldType {
 
 }
 
+// A signature of a function.  Int and string arguments are passed to f as
+// int64 and string; arguments of other types are passed as their DBValue.  f
+// returns an int64, string, float64, bool, DBValue, or an error.
 type FuncType struct {
 	argTypes []DBType
 	outType  DBType
 	f        func([]any) any
 }
 
-var funcs = map[string]FuncType{
+// Functions may have several signatures, e.g., + is defined on ints, decimals,
+// floats and dates.  Numeric signatures are listed from the narrowest to the
+// widest type, so that the narrowest one that can hold the arguments is used
+// (see [resolveFunc]).
+var funcs = map[string][]FuncType{
 	//note should all be lower case
-	"+":                     {[]DBType{IntType, IntType}, IntType, addFunc},
-	"-":                     {[]DBType{IntType, IntType}, IntType, minusFunc},
-	"*":                     {[]DBType{IntType, IntType}, IntType, timesFunc},
-	"/":                     {[]DBType{IntType, IntType}, IntType, divFunc},
-	"mod":                   {[]DBType{IntType, IntType}, IntType, modFunc},
-	"rand":                  {[]DBType{}, IntType, randIntFunc},
-	"sq":                    {[]DBType{IntType}, IntType, sqFunc},
-	"getsubstr":             {[]DBType{StringType, IntType, IntType}, StringType, subStrFunc},
-	"epoch":                 {[]DBType{}, IntType, epoch},
-	"datetimestringtoepoch": {[]DBType{StringType}, IntType, dateTimeToEpoch},
-	"datestringtoepoch":     {[]DBType{StringType}, IntType, dateToEpoch},
-	"epochtodatetimestring": {[]DBType{IntType}, StringType, dateString},
-	"imin":                  {[]DBType{IntType, IntType}, IntType, minFunc},
-	"imax":                  {[]DBType{IntType, IntType}, IntType, maxFunc},
+	"+": {
+		{[]DBType{IntType, IntType}, IntType, addFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalArith("+")},
+		{[]DBType{FloatType, FloatType}, FloatType, floatArith("+")},
+		{[]DBType{DateType, IntType}, DateType, dateAddDays},
+		{[]DBType{TimestampType, IntType}, TimestampType, timestampAddSeconds},
+	},
+	"-": {
+		{[]DBType{IntType, IntType}, IntType, minusFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalArith("-")},
+		{[]DBType{FloatType, FloatType}, FloatType, floatArith("-")},
+		{[]DBType{DateType, IntType}, DateType, dateSubDays},
+		{[]DBType{DateType, DateType}, IntType, dateDiff},
+		{[]DBType{TimestampType, TimestampType}, IntType, timestampDiff},
+	},
+	"*": {
+		{[]DBType{IntType, IntType}, IntType, timesFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalArith("*")},
+		{[]DBType{FloatType, FloatType}, FloatType, floatArith("*")},
+	},
+	"/": {
+		{[]DBType{IntType, IntType}, IntType, divFunc},
+		{[]DBType{DecimalType, DecimalType}, DecimalType, decimalArith("/")},
+		{[]DBType{FloatType, FloatType}, FloatType, floatArith("/")},
+	},
+	"mod":                   {{[]DBType{IntType, IntType}, IntType, modFunc}},
+	"rand":                  {{[]DBType{}, IntType, randIntFunc}},
+	"sq":                    {{[]DBType{IntType}, IntType, sqFunc}},
+	"getsubstr":             {{[]DBType{StringType, IntType, IntType}, StringType, subStrFunc}},
+	"epoch":                 {{[]DBType{}, IntType, epoch}},
+	"datetimestringtoepoch": {{[]DBType{StringType}, IntType, dateTimeToEpoch}},
+	"datestringtoepoch":     {{[]DBType{StringType}, IntType, dateToEpoch}},
+	"epochtodatetimestring": {{[]DBType{IntType}, StringType, dateString}},
+	"imin":                  {{[]DBType{IntType, IntType}, IntType, minFunc}},
+	"imax":                  {{[]DBType{IntType, IntType}, IntType, maxFunc}},
+	"year":                  {{[]DBType{DateType}, IntType, yearFunc}, {[]DBType{TimestampType}, IntType, yearFunc}},
+	"month":                 {{[]DBType{DateType}, IntType, monthFunc}, {[]DBType{TimestampType}, IntType, monthFunc}},
+	"day":                   {{[]DBType{DateType}, IntType, dayFunc}, {[]DBType{TimestampType}, IntType, dayFunc}},
+	"now":                   {{[]DBType{}, TimestampType, nowFunc}},
+	"current_date":          {{[]DBType{}, DateType, currentDateFunc}},
+}
+
+// Find the signature of function op to use for the given arguments.  A
+// signature matches if each argument has the type of the corresponding
+// parameter, or is a numeric type that can be widened to it.
+func resolveFunc(op string, args []*Expr) (FuncType, error) {
+	sigs, exists := funcs[op]
+	if !exists {
+		return FuncType{}, GoDBError{ParseError, fmt.Sprintf("unknown function %s", op)}
+	}
+	argTypes := make([]DBType, len(args))
+	for i, arg := range args {
+		argTypes[i] = (*arg).GetExprType().Ftype
+	}
+	// try exact matches first, then implicit widening, then also allowing
+	// constants that can be cast to the parameter type, e.g., year('2024-01-01')
+	for pass := 0; pass < 3; pass++ {
+	nextSig:
+		for _, sig := range sigs {
+			if len(sig.argTypes) != len(argTypes) {
+				continue
+			}
+			for i, t := range sig.argTypes {
+				if argTypes[i] == t {
+					continue
+				}
+				if pass >= 1 && implicitlyCoercible(argTypes[i], t) {
+					continue
+				}
+				if pass == 2 {
+					if v, ok := literalValue(*args[i]); ok {
+						if _, err := castValue(v, t, -1); err == nil {
+							continue
+						}
+					}
+				}
+				continue nextSig
+			}
+			return sig, nil
+		}
+	}
+	if len(sigs) == 1 && len(sigs[0].argTypes) != len(args) {
+		return FuncType{}, GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", op, len(sigs[0].argTypes))}
+	}
+	names := make([]string, len(argTypes))
+	for i, t := range argTypes {
+		names[i] = t.String()
+	}
+	return FuncType{}, GoDBError{TypeMismatchError, fmt.Sprintf("function %s does not accept arguments of type (%s)", op, strings.Join(names, ","))}
 }
 
 func ListOfFunctions() string {
 	fList := ""
-	for name, f := range funcs {
-		args := "("
-		argList := f.argTypes
-		hasArg := false
-		for _, a := range argList {
-			if hasArg {
-				args = args + ","
-			}
-			switch a {
-			case IntType:
-				args = args + "int"
-			case StringType:
-				args = args + "string"
+	for name, sigs := range funcs {
+		for _, f := range sigs {
+			args := "("
+			for i, a := range f.argTypes {
+				if i > 0 {
+					args = args + ","
+				}
+				args = args + a.String()
 			}
-			hasArg = true
+			args = args + ")"
+			fList = fList + "\t" + name + args + "\n"
 		}
-		args = args + ")"
-		fList = fList + "\t" + name + args + "\n"
 	}
 	return fList
 }
@@ -170,10 +302,16 @@ func randIntFunc(args []any) any {
 }
 
 func modFunc(args []any) any {
+	if args[1].(int64) == 0 {
+		return GoDBError{IllegalOperationError, "division by zero"}
+	}
 	return args[0].(int64) % args[1].(int64)
 }
 
 func divFunc(args []any) any {
+	if args[1].(int64) == 0 {
+		return GoDBError{IllegalOperationError, "division by zero"}
+	}
 	return args[0].(int64) / args[1].(int64)
 }
 
@@ -211,41 +349,30 @@ func subStrFunc(args []any) any {
 }
 
 func (f *FuncExpr) EvalExpr(t *Tuple) (DBValue, error) {
-	fType, exists := funcs[f.op]
-	if !exists {
-		return nil, GoDBError{ParseError, fmt.Sprintf("unknown function %s", f.op)}
-	}
-	if len(f.args) != len(fType.argTypes) {
-		return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected %d args", f.op, len(fType.argTypes))}
+	fType, err := resolveFunc(f.op, f.args)
+	if err != nil {
+		return nil, err
 	}
 	argvals := make([]any, len(fType.argTypes))
 	for i, argType := range fType.argTypes {
 		arg := *f.args[i]
-		if arg.GetExprType().Ftype != argType {
-			typeName := "string"
-			switch argType {
-			case IntType:
-				typeName = "int"
-			}
-			return nil, GoDBError{ParseError, fmt.Sprintf("function %s expected arg of type %s", f.op, typeName)}
-		}
 		val, err := arg.EvalExpr(t)
 		if err != nil {
 			return nil, err
 		}
+		if arg.GetExprType().Ftype != argType {
+			if val, err = castValue(val, argType, -1); err != nil {
+				return nil, err
+			}
+		}
 		switch argType {
 		case IntType:
 			argvals[i] = val.(IntField).Value
 		case StringType:
 			argvals[i] = val.(StringField).Value
+		default:
+			argvals[i] = val
 		}
 	}
-	result := fType.f(argvals)
-	switch fType.outType {
-	case IntType:
-		return IntField{result.(int64)}, nil
-	case StringType:
-		return StringField{result.(string)}, nil
-	}
-	return nil, GoDBError{ParseError, "unknown result type in function"}
+	return funcResult(fType.f(argvals))
 }
@@ -0,0 +1,162 @@
+package godb
+
+import (
+	"bufio"
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"io"
+	"os"
+)
+
+// A sorted run of tuples, written to a temporary file by an [OrderBy] whose
+// input does not fit in its buffer.  Each tuple is stored as its length
+// followed by the bytes written by [Tuple.writeTo].
+type sortRun struct {
+	file *os.File
+	r    *bufio.Reader
+	desc TupleDesc
+}
+
+// Write sorted tuples, which must not be empty, to a new run.
+func writeSortRun(tuples []Tuple) (*sortRun, error) {
+	f, err := os.CreateTemp("", "godb-sort-")
+	if err != nil {
+		return nil, err
+	}
+	run := &sortRun{f, nil, tuples[0].Desc}
+	w := bufio.NewWriter(f)
+	var buf bytes.Buffer
+	for i := range tuples {
+		buf.Reset()
+		if err := tuples[i].writeTo(&buf); err != nil {
+			run.close()
+			return nil, err
+		}
+		if err := binary.Write(w, binary.LittleEndian, uint32(buf.Len())); err != nil {
+			run.close()
+			return nil, err
+		}
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			run.close()
+			return nil, err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		run.close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		run.close()
+		return nil, err
+	}
+	run.r = bufio.NewReader(f)
+	return run, nil
+}
+
+// Returns the next tuple of the run, or nil at its end.
+func (r *sortRun) next() (*Tuple, error) {
+	var n uint32
+	if err := binary.Read(r.r, binary.LittleEndian, &n); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, err
+	}
+	return readTupleFrom(bytes.NewBuffer(data), &r.desc)
+}
+
+// Close and remove the file of the run.
+func (r *sortRun) close() {
+	r.file.Close()
+	os.Remove(r.file.Name())
+}
+
+func closeSortRuns(runs []*sortRun) {
+	for _, r := range runs {
+		if r != nil {
+			r.close()
+		}
+	}
+}
+
+// The next tuple of each run being merged, ordered by a multiSorter.  A nil
+// run stands for the tuples that were still in memory.
+type mergeHeap struct {
+	ms    *multiSorter
+	heads []*Tuple
+	runs  []*sortRun
+}
+
+func (h *mergeHeap) Len() int           { return len(h.heads) }
+func (h *mergeHeap) Less(i, j int) bool { return h.ms.less(h.heads[i], h.heads[j]) }
+func (h *mergeHeap) Swap(i, j int) {
+	h.heads[i], h.heads[j] = h.heads[j], h.heads[i]
+	h.runs[i], h.runs[j] = h.runs[j], h.runs[i]
+}
+func (h *mergeHeap) Push(x any) {}
+func (h *mergeHeap) Pop() any {
+	n := len(h.heads) - 1
+	h.heads, h.runs = h.heads[:n], h.runs[:n]
+	return nil
+}
+
+// Returns an iterator that merges the sorted runs with the sorted tuples that
+// are still in memory.  Each run is removed once it has been read, and the
+// runs that remain are removed when the iterator is closed.
+func mergeSortRuns(tid TransactionID, runs []*sortRun, inMemory []Tuple, ms *multiSorter) (*OpIterator, error) {
+	h := &mergeHeap{ms, nil, nil}
+	for _, r := range runs {
+		t, err := r.next()
+		if err != nil {
+			closeSortRuns(runs)
+			return nil, err
+		}
+		h.heads, h.runs = append(h.heads, t), append(h.runs, r)
+	}
+	i := 0
+	if len(inMemory) > 0 {
+		h.heads, h.runs = append(h.heads, &inMemory[0]), append(h.runs, nil)
+		i++
+	}
+	heap.Init(h)
+	return newOpIterator(func() (*Tuple, error) {
+		if h.Len() == 0 {
+			return nil, nil
+		}
+		if err := checkCanceled(tid); err != nil {
+			return nil, err
+		}
+		t, r := h.heads[0], h.runs[0]
+		var next *Tuple
+		if r == nil {
+			if i < len(inMemory) {
+				next = &inMemory[i]
+				i++
+			}
+		} else {
+			var err error
+			if next, err = r.next(); err != nil {
+				return nil, err
+			}
+		}
+		if next == nil {
+			if r != nil {
+				r.close()
+			}
+			heap.Pop(h)
+		} else {
+			h.heads[0] = next
+			heap.Fix(h, 0)
+		}
+		return t, nil
+	}, func() error {
+		closeSortRuns(h.runs)
+		h.heads, h.runs, inMemory = nil, nil, nil
+		return nil
+	}), nil
+}
@@ -22,25 +22,34 @@ func (f *Filter) Descriptor() *TupleDesc {
 //
 // HINT: you can use [types.evalPred] to compare two values.
 func (f *Filter) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
-	childItr, err := f.child.Iterator(tid)
+	return iteratorOf(f.Open(tid))
+}
+
+func (f *Filter) Open(tid TransactionID) (*OpIterator, error) {
+	child, err := f.child.Open(tid)
 	if err != nil {
 		return nil, err
 	}
-	return func() (*Tuple, error) {
+	desc := f.child.Descriptor()
+	left, right := compileExpr(f.left, desc), compileExpr(f.right, desc)
+	return newOpIterator(func() (*Tuple, error) {
 		for {
-			tuple, err := childItr()
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			tuple, err := child.Next()
 			if err != nil {
 				return nil, err
 			} else if tuple == nil {
 				return nil, nil
 			}
 
-			leftVal, err := f.left.EvalExpr(tuple)
+			leftVal, err := left(tuple)
 			if err != nil {
 				return nil, err
 			}
 
-			rightVal, err := f.right.EvalExpr(tuple)
+			rightVal, err := right(tuple)
 			if err != nil {
 				return nil, err
 			}
@@ -49,5 +58,5 @@ func (f *Filter) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 				return tuple, nil
 			}
 		}
-	}, nil
+	}, child.Close), nil
 }
@@ -0,0 +1,467 @@
+package godb
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FOREIGN KEY constraints.
+//
+// A foreign key on a child table column names a column in a parent table.
+// InsertOp refuses to insert a child tuple whose value has no matching parent
+// tuple, and DeleteOp either refuses to delete a parent tuple that is still
+// referenced (RESTRICT, the default) or deletes the referencing child tuples
+// along with it (CASCADE).
+//
+// sqlparser does not understand REFERENCES clauses, so they are stripped out
+// of CREATE TABLE statements by [extractForeignKeys] before the statement is
+// handed to sqlparser.
+
+type FKAction int
+
+const (
+	FKRestrict FKAction = iota
+	FKCascade  FKAction = iota
+)
+
+func (a FKAction) String() string {
+	switch a {
+	case FKCascade:
+		return "cascade"
+	}
+	return "restrict"
+}
+
+type ForeignKey struct {
+	Column    string
+	RefTable  string
+	RefColumn string
+	OnDelete  FKAction
+}
+
+// Returns the REFERENCES clause for this key, in the form accepted by
+// [parseReferences].
+func (fk *ForeignKey) String() string {
+	return fmt.Sprintf("references %s(%s) on delete %s", fk.RefTable, fk.RefColumn, fk.OnDelete)
+}
+
+// Parse a clause of the form
+//
+//	references parent(col) [on delete cascade|restrict|no action]
+//
+// into a ForeignKey on the column named col.
+func parseReferences(col string, clause string) (*ForeignKey, error) {
+	clause = strings.TrimSpace(strings.ToLower(clause))
+	if !strings.HasPrefix(clause, "references") {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected REFERENCES clause, got '%s'", clause)}
+	}
+	rest := strings.TrimSpace(clause[len("references"):])
+	open := strings.Index(rest, "(")
+	close := strings.Index(rest, ")")
+	if open <= 0 || close < open {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected parent table and column in '%s'", clause)}
+	}
+	fk := &ForeignKey{
+		Column:    strings.TrimSpace(col),
+		RefTable:  strings.TrimSpace(rest[:open]),
+		RefColumn: strings.TrimSpace(rest[open+1 : close]),
+		OnDelete:  FKRestrict,
+	}
+	if fk.RefTable == "" || fk.RefColumn == "" || strings.Contains(fk.RefColumn, ",") {
+		return nil, GoDBError{ParseError, fmt.Sprintf("foreign keys must reference a single column of a parent table, got '%s'", clause)}
+	}
+
+	action := strings.Join(strings.Fields(rest[close+1:]), " ")
+	switch action {
+	case "", "on delete restrict", "on delete no action":
+	case "on delete cascade":
+		fk.OnDelete = FKCascade
+	default:
+		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported foreign key action '%s'", action)}
+	}
+	return fk, nil
+}
+
+// Split s on commas that are not nested inside parentheses or quotes.
+func splitTopLevel(s string, sep byte) []string {
+	var parts []string
+	depth := 0
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case ch == sep && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// Remove column-level REFERENCES clauses and table-level FOREIGN KEY
+// constraints from a CREATE TABLE statement, returning the remaining
+// statement (which sqlparser can parse) and the extracted keys. Statements
+// other than CREATE TABLE are returned unchanged.
+func extractForeignKeys(query string) (string, []*ForeignKey, error) {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) < 2 || words[0] != "create" || words[1] != "table" {
+		return query, nil, nil
+	}
+	open := strings.Index(query, "(")
+	close := strings.LastIndex(query, ")")
+	if open == -1 || close < open {
+		return query, nil, nil
+	}
+
+	var fks []*ForeignKey
+	var kept []string
+	for _, def := range splitTopLevel(query[open+1:close], ',') {
+		fields := strings.Fields(strings.ToLower(def))
+		if len(fields) >= 2 && fields[0] == "constraint" {
+			fields = fields[2:]
+		}
+		lower := strings.Join(fields, " ")
+		if strings.HasPrefix(lower, "foreign key") {
+			rest := strings.TrimSpace(lower[len("foreign key"):])
+			colOpen := strings.Index(rest, "(")
+			colClose := strings.Index(rest, ")")
+			if colOpen != 0 || colClose < colOpen {
+				return "", nil, GoDBError{ParseError, fmt.Sprintf("malformed foreign key constraint '%s'", def)}
+			}
+			fk, err := parseReferences(rest[1:colClose], rest[colClose+1:])
+			if err != nil {
+				return "", nil, err
+			}
+			fks = append(fks, fk)
+			continue
+		}
+		refPos := -1
+		for i, w := range fields {
+			if w == "references" {
+				refPos = i
+				break
+			}
+		}
+		if refPos == -1 {
+			kept = append(kept, strings.TrimSpace(def))
+			continue
+		}
+		if refPos < 2 {
+			return "", nil, GoDBError{ParseError, fmt.Sprintf("malformed column definition '%s'", def)}
+		}
+		fk, err := parseReferences(fields[0], strings.Join(fields[refPos:], " "))
+		if err != nil {
+			return "", nil, err
+		}
+		fks = append(fks, fk)
+		kept = append(kept, strings.Join(fields[:refPos], " "))
+	}
+	return query[:open+1] + strings.Join(kept, ", ") + query[close:], fks, nil
+}
+
+// Check that the columns of the foreign keys of a new table exist, and that
+// they reference existing columns of the same type.
+func (c *Catalog) validateForeignKeys(tableName string, desc *TupleDesc, fks []*ForeignKey) error {
+	for _, fk := range fks {
+		col, err := findFieldInTd(FieldType{fk.Column, "", UnknownType}, desc)
+		if err != nil {
+			return GoDBError{ParseError, fmt.Sprintf("foreign key column %s is not a column of %s", fk.Column, tableName)}
+		}
+		var parentDesc *TupleDesc
+		if fk.RefTable == tableName {
+			parentDesc = desc
+		} else {
+			parent, err := c.GetTableInfo(fk.RefTable)
+			if err != nil {
+				return err
+			}
+			parentDesc = &parent.desc
+		}
+		refCol, err := findFieldInTd(FieldType{fk.RefColumn, "", UnknownType}, parentDesc)
+		if err != nil {
+			return GoDBError{ParseError, fmt.Sprintf("referenced column %s.%s does not exist", fk.RefTable, fk.RefColumn)}
+		}
+		if desc.Fields[col].Ftype != parentDesc.Fields[refCol].Ftype {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("foreign key %s.%s has a different type than %s.%s", tableName, fk.Column, fk.RefTable, fk.RefColumn)}
+		}
+	}
+	return nil
+}
+
+// Return the tuples of f whose field'th column equals v.
+func findMatchingTuples(f DBFile, field int, v DBValue, tid TransactionID) ([]*Tuple, error) {
+	iter, err := f.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var matches []*Tuple
+	for {
+		t, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return matches, nil
+		}
+		if t.Fields[field].EvalPred(v, OpEq) {
+			matches = append(matches, t)
+		}
+	}
+}
+
+// A resolved foreign key: the column positions of a key in the child and
+// parent tables.
+type fkLink struct {
+	fk               *ForeignKey
+	child, parent    *Table
+	childCol, refCol int
+}
+
+func (c *Catalog) resolveForeignKey(child *Table, fk *ForeignKey) (*fkLink, error) {
+	parent, err := c.GetTableInfo(fk.RefTable)
+	if err != nil {
+		return nil, err
+	}
+	childCol, err := findFieldInTd(FieldType{fk.Column, "", UnknownType}, &child.desc)
+	if err != nil {
+		return nil, err
+	}
+	refCol, err := findFieldInTd(FieldType{fk.RefColumn, "", UnknownType}, &parent.desc)
+	if err != nil {
+		return nil, err
+	}
+	return &fkLink{fk, child, parent, childCol, refCol}, nil
+}
+
+// Return the foreign keys of other tables (or of t itself) that reference t.
+func (c *Catalog) referencingKeys(t *Table) ([]*fkLink, error) {
+	var links []*fkLink
+	for _, child := range c.tableMap {
+		for _, fk := range child.foreignKeys {
+			if fk.RefTable != t.name {
+				continue
+			}
+			link, err := c.resolveForeignKey(child, fk)
+			if err != nil {
+				return nil, err
+			}
+			links = append(links, link)
+		}
+	}
+	return links, nil
+}
+
+// fkChecker verifies the foreign keys of tuples inserted into a table. The
+// set of parent key values is read once per parent and cached for the
+// lifetime of the checker, so a multi-row insert scans each parent only
+// once.
+type fkChecker struct {
+	links []*fkLink
+	keys  []map[any]bool
+}
+
+func (c *Catalog) newFKChecker(file DBFile) (*fkChecker, error) {
+	t, err := c.GetTableInfoDBFile(file)
+	if err != nil {
+		// not a catalog table (e.g., a temporary file), so no constraints
+		return &fkChecker{}, nil
+	}
+	checker := &fkChecker{}
+	for _, fk := range t.foreignKeys {
+		link, err := c.resolveForeignKey(t, fk)
+		if err != nil {
+			return nil, err
+		}
+		checker.links = append(checker.links, link)
+		checker.keys = append(checker.keys, nil)
+	}
+	return checker, nil
+}
+
+// Returns an error if t references a parent tuple that does not exist.
+func (fc *fkChecker) check(t *Tuple, tid TransactionID) error {
+	for i, link := range fc.links {
+		v := t.Fields[link.childCol]
+		if link.parent == link.child && t.Fields[link.refCol].EvalPred(v, OpEq) {
+			// a row of a self-referencing table may reference itself
+			continue
+		}
+		if fc.keys[i] == nil {
+			keys, err := columnKeySet(link.parent.file, link.refCol, tid)
+			if err != nil {
+				return err
+			}
+			fc.keys[i] = keys
+		}
+		if !fc.keys[i][keyValue(v)] {
+			return fc.violation(link, v)
+		}
+	}
+	return nil
+}
+
+// Record that t was inserted, so that rows of a self-referencing table may
+// reference rows inserted earlier by the same statement.
+func (fc *fkChecker) inserted(t *Tuple) {
+	for i, link := range fc.links {
+		if fc.keys[i] != nil && link.parent == link.child {
+			fc.keys[i][keyValue(t.Fields[link.refCol])] = true
+		}
+	}
+}
+
+func (fc *fkChecker) violation(link *fkLink, v DBValue) error {
+	return GoDBError{ConstraintViolationError, fmt.Sprintf("insert into %s violates foreign key %s: no row in %s with %s = %s",
+		link.child.name, link.fk.Column, link.parent.name, link.fk.RefColumn, valueString(v))}
+}
+
+func columnKeySet(f DBFile, field int, tid TransactionID) (map[any]bool, error) {
+	iter, err := f.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	keys := make(map[any]bool)
+	for {
+		t, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return keys, nil
+		}
+		keys[keyValue(t.Fields[field])] = true
+	}
+}
+
+// Return the key of v in a set of key values. Decimals are normalized and
+// integers converted to decimals, so that numerically equal values (e.g., 1.5
+// and 1.50, or 3 and 3.00) have the same key.  The values of an inserted
+// tuple are checked before they are converted to the types of their columns.
+func keyValue(v DBValue) any {
+	switch x := v.(type) {
+	case DecimalField:
+		return x.normalize()
+	case IntField:
+		return DecimalField{x.Value, 0}
+	}
+	return v
+}
+
+// A row of a table, identified by the file it is stored in and its record
+// id.
+type rowID struct {
+	file DBFile
+	rid  recordID
+}
+
+// Check that deleting tuples from the table stored in file violates no
+// RESTRICT foreign key, before any of them is deleted, following CASCADE keys
+// to the tuples they would delete.  deleting holds the rows that the statement
+// deletes; a parent tuple is only considered referenced if a tuple with the
+// same key value remains in the parent table, and referencing tuples that are
+// themselves deleted do not count.
+func (c *Catalog) checkDeleteReferences(file DBFile, tuples []*Tuple, deleting map[rowID]bool, tid TransactionID) error {
+	parent, err := c.GetTableInfoDBFile(file)
+	if err != nil {
+		return nil
+	}
+	links, err := c.referencingKeys(parent)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		checked := make(map[any]bool)
+		for _, t := range tuples {
+			v := t.Fields[link.refCol]
+			if checked[keyValue(v)] {
+				continue
+			}
+			checked[keyValue(v)] = true
+			matches, err := findMatchingTuples(parent.file, link.refCol, v, tid)
+			if err != nil {
+				return err
+			}
+			if slices.ContainsFunc(matches, func(m *Tuple) bool { return !deleting[rowID{parent.file, m.Rid}] }) {
+				continue
+			}
+			children, err := findMatchingTuples(link.child.file, link.childCol, v, tid)
+			if err != nil {
+				return err
+			}
+			var referencing []*Tuple
+			for _, child := range children {
+				if !deleting[rowID{link.child.file, child.Rid}] {
+					referencing = append(referencing, child)
+				}
+			}
+			if len(referencing) == 0 {
+				continue
+			}
+			if link.fk.OnDelete == FKRestrict {
+				return GoDBError{ConstraintViolationError, fmt.Sprintf("delete from %s violates foreign key %s.%s: %d referencing rows with %s = %s",
+					parent.name, link.child.name, link.fk.Column, len(referencing), link.fk.Column, valueString(v))}
+			}
+			for _, child := range referencing {
+				deleting[rowID{link.child.file, child.Rid}] = true
+			}
+			if err := c.checkDeleteReferences(link.child.file, referencing, deleting, tid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Delete the tuples that reference t by CASCADE foreign keys, recursively,
+// given that t has just been deleted from the table stored in file.  RESTRICT
+// keys have been checked by [Catalog.checkDeleteReferences] before the
+// statement deleted anything.  A parent tuple is only considered referenced
+// if no other tuple with the same key value remains in the parent table.
+func (c *Catalog) enforceDeleteReferences(file DBFile, t *Tuple, tid TransactionID) error {
+	parent, err := c.GetTableInfoDBFile(file)
+	if err != nil {
+		return nil
+	}
+	links, err := c.referencingKeys(parent)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.fk.OnDelete == FKRestrict {
+			continue
+		}
+		v := t.Fields[link.refCol]
+		remaining, err := findMatchingTuples(parent.file, link.refCol, v, tid)
+		if err != nil {
+			return err
+		}
+		if len(remaining) > 0 {
+			continue
+		}
+		children, err := findMatchingTuples(link.child.file, link.childCol, v, tid)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := link.child.file.deleteTuple(child, tid); err != nil {
+				return err
+			}
+			if err := c.enforceDeleteReferences(link.child.file, child, tid); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+package godb
+
+import (
+	"testing"
+)
+
+func TestExtractForeignKeys(t *testing.T) {
+	sql, fks, err := extractForeignKeys("create table c (id int, pid int references p(id) on delete cascade, name varchar, foreign key (name) references q(name))")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if sql != "create table c (id int, pid int, name varchar)" {
+		t.Errorf("unexpected stripped statement '%s'", sql)
+	}
+	expected := []ForeignKey{
+		{"pid", "p", "id", FKCascade},
+		{"name", "q", "name", FKRestrict},
+	}
+	if len(fks) != len(expected) {
+		t.Fatalf("expected %d foreign keys, got %d", len(expected), len(fks))
+	}
+	for i, fk := range fks {
+		if *fk != expected[i] {
+			t.Errorf("expected foreign key %v, got %v", expected[i], *fk)
+		}
+	}
+
+	if _, _, err := extractForeignKeys("create table c (pid int references p(id) on update cascade)"); err == nil {
+		t.Errorf("expected error for unsupported action")
+	}
+}
+
+func TestForeignKeyCreate(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table p (id int, name varchar)")
+	if _, err := execForTest(t, c, bp, "create table c (id int, pid int references nosuch(id))"); err == nil {
+		t.Errorf("expected error referencing missing table")
+	}
+	if _, err := execForTest(t, c, bp, "create table c (id int, pid varchar references p(id))"); err == nil {
+		t.Errorf("expected error referencing column of another type")
+	}
+	mustExecForTest(t, c, bp, "create table c (id int, pid int references p(id))")
+
+	info, err := c.GetTableInfo("c")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(info.foreignKeys) != 1 || info.foreignKeys[0].RefTable != "p" {
+		t.Fatalf("foreign key not recorded in catalog")
+	}
+	if err := c.dropTable("p"); err == nil {
+		t.Errorf("expected error dropping referenced table")
+	}
+
+	// keys survive a round trip through the catalog file
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2 := NewCatalog(c.filePath, bp, c.rootPath)
+	if err := c2.parseCatalogFile(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	info2, err := c2.GetTableInfo("c")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if len(info2.foreignKeys) != 1 || *info2.foreignKeys[0] != *info.foreignKeys[0] {
+		t.Errorf("foreign key not restored from catalog file, got %v", info2.foreignKeys)
+	}
+}
+
+func TestForeignKeyInsert(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table p (id int, name varchar)")
+	mustExecForTest(t, c, bp, "create table c (id int, pid int references p(id))")
+	mustExecForTest(t, c, bp, "insert into p values (1, 'a'), (2, 'b')")
+
+	mustExecForTest(t, c, bp, "insert into c values (10, 1), (11, 2), (12, 1)")
+	if _, err := execForTest(t, c, bp, "insert into c values (13, 3)"); err == nil {
+		t.Errorf("expected foreign key violation")
+	} else if gerr, ok := err.(GoDBError); !ok || gerr.code != ConstraintViolationError {
+		t.Errorf("expected ConstraintViolationError, got %s", err.Error())
+	}
+	if n := countRowsForTest(t, c, bp, "c"); n != 3 {
+		t.Errorf("expected 3 rows in c, got %d", n)
+	}
+
+	// a self-referencing table may reference rows inserted by the same statement
+	mustExecForTest(t, c, bp, "create table emp (id int, boss int references emp(id))")
+	mustExecForTest(t, c, bp, "insert into emp values (1, 1), (2, 1), (3, 2)")
+	if _, err := execForTest(t, c, bp, "insert into emp values (4, 5)"); err == nil {
+		t.Errorf("expected foreign key violation on self-referencing table")
+	}
+}
+
+func TestForeignKeyDelete(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table p (id int, name varchar)")
+	mustExecForTest(t, c, bp, "create table r (id int, pid int references p(id))")
+	mustExecForTest(t, c, bp, "create table k (id int, pid int, foreign key (pid) references p(id) on delete cascade)")
+	mustExecForTest(t, c, bp, "create table g (id int, kid int references k(id) on delete cascade)")
+	mustExecForTest(t, c, bp, "insert into p values (1, 'a'), (2, 'b'), (3, 'c')")
+	mustExecForTest(t, c, bp, "insert into r values (1, 1)")
+	mustExecForTest(t, c, bp, "insert into k values (20, 2), (21, 2), (30, 3)")
+	mustExecForTest(t, c, bp, "insert into g values (1, 20), (2, 30)")
+
+	// restrict: p.id = 1 is still referenced by r
+	if _, err := execForTest(t, c, bp, "delete from p where id = 1"); err == nil {
+		t.Errorf("expected restrict violation")
+	}
+	if n := countRowsForTest(t, c, bp, "p"); n != 3 {
+		t.Errorf("expected the restricted delete to leave 3 rows in p, got %d", n)
+	}
+
+	// cascade: deleting p.id = 2 removes k rows 20 and 21, and g row 1
+	mustExecForTest(t, c, bp, "delete from p where id = 2")
+	if n := countRowsForTest(t, c, bp, "k"); n != 1 {
+		t.Errorf("expected 1 row left in k, got %d", n)
+	}
+	if n := countRowsForTest(t, c, bp, "g"); n != 1 {
+		t.Errorf("expected 1 row left in g, got %d", n)
+	}
+
+	// deleting the referencing row first makes the parent deletable
+	mustExecForTest(t, c, bp, "delete from r where pid = 1")
+	mustExecForTest(t, c, bp, "delete from p where id = 1")
+	if n := countRowsForTest(t, c, bp, "p"); n != 1 {
+		t.Errorf("expected 1 row left in p, got %d", n)
+	}
+
+	// a restrict violation further down a cascade deletes nothing
+	mustExecForTest(t, c, bp, "create table q (id int, gid int references g(id))")
+	mustExecForTest(t, c, bp, "insert into q values (1, 2)")
+	if _, err := execForTest(t, c, bp, "delete from p where id = 3"); err == nil {
+		t.Errorf("expected restrict violation through cascade")
+	}
+	if n := countRowsForTest(t, c, bp, "k"); n != 1 {
+		t.Errorf("expected k to keep its row, got %d", n)
+	}
+
+	// referencing rows deleted by the same statement do not restrict it
+	mustExecForTest(t, c, bp, "create table emp (id int, boss int references emp(id))")
+	mustExecForTest(t, c, bp, "insert into emp values (1, 1), (2, 1), (3, 2)")
+	if _, err := execForTest(t, c, bp, "delete from emp where id = 2"); err == nil {
+		t.Errorf("expected restrict violation on self-referencing table")
+	}
+	mustExecForTest(t, c, bp, "delete from emp")
+	if n := countRowsForTest(t, c, bp, "emp"); n != 0 {
+		t.Errorf("expected emp to be empty, got %d", n)
+	}
+}
+
+func TestForeignKeyDecimalScale(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table p (id decimal(6,2))")
+	mustExecForTest(t, c, bp, "create table c (id int, pid decimal(6,1) references p(id))")
+	mustExecForTest(t, c, bp, "create table k (id int, pid decimal(6,1), foreign key (pid) references p(id) on delete cascade)")
+	mustExecForTest(t, c, bp, "insert into p values (1.50), (2.50), (3.00)")
+
+	// 1.5 and 1.50 are the same key
+	mustExecForTest(t, c, bp, "insert into c values (1, 1.5), (2, 3)")
+	if _, err := execForTest(t, c, bp, "insert into c values (3, 1.2)"); err == nil {
+		t.Errorf("expected foreign key violation")
+	}
+	mustExecForTest(t, c, bp, "insert into k values (1, 2.5), (2, 2.5)")
+
+	// restrict: p.id = 1.50 is referenced by c.pid = 1.5
+	if _, err := execForTest(t, c, bp, "delete from p where id = 1.5"); err == nil {
+		t.Errorf("expected restrict violation")
+	}
+	if n := countRowsForTest(t, c, bp, "p"); n != 3 {
+		t.Errorf("expected the restricted delete to leave 3 rows in p, got %d", n)
+	}
+
+	// cascade: deleting p.id = 2.50 removes the k rows with pid = 2.5
+	mustExecForTest(t, c, bp, "delete from p where id = 2.5")
+	if n := countRowsForTest(t, c, bp, "k"); n != 0 {
+		t.Errorf("expected k to be empty, got %d", n)
+	}
+}
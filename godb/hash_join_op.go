@@ -0,0 +1,162 @@
+package godb
+
+// HashJoin is an equality join that builds a hash table over one of its inputs,
+// the build side, and probes it with each tuple of the other one.  The hash
+// table holds at most maxBufferSize bytes of tuples: if the build side does
+// not fit, it is read in chunks that do, and the probe side is read once per
+// chunk.  Whichever side is built, the joined tuples are those of the left
+// input followed by those of the right one, as for [EqualityJoin].
+type HashJoin struct {
+	leftField, rightField Expr
+
+	left, right *Operator
+
+	buildLeft     bool // whether the hash table is built over the left input
+	maxBufferSize int  // in bytes, as estimated by [tupleMemory]
+}
+
+func NewHashJoin(left Operator, leftField Expr, right Operator, rightField Expr, buildLeft bool, maxBufferSize int) *HashJoin {
+	return &HashJoin{leftField, rightField, &left, &right, buildLeft, maxBufferSize}
+}
+
+func (hj *HashJoin) Descriptor() *TupleDesc {
+	return (*hj.left).Descriptor().merge((*hj.right).Descriptor())
+}
+
+// Returns the input the hash table is built over, "left" or "right".
+func (hj *HashJoin) buildSide() string {
+	if hj.buildLeft {
+		return "left"
+	}
+	return "right"
+}
+
+// A tuple of the build side of a hash join, along with its join value.
+type hashEntry struct {
+	val DBValue
+	tup *Tuple
+}
+
+// Returns the key a value is stored under in a hash table.  Numbers of
+// different types that compare equal have the same key; since keys of
+// different values may also be equal, e.g., for large integers, the values of
+// matching entries must still be compared.
+func hashKey(v DBValue) any {
+	switch v := v.(type) {
+	case IntField:
+		return float64(v.Value)
+	case DecimalField:
+		return v.float()
+	case FloatField:
+		return v.Value
+	}
+	return v
+}
+
+func (hj *HashJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(hj.Open(tid))
+}
+
+// Open an iterator over the joined tuples.  Closing it closes the iterators
+// of the build and probe sides and drops the hash table.
+func (hj *HashJoin) Open(tid TransactionID) (*OpIterator, error) {
+	build, buildField, probe, probeField := hj.right, hj.rightField, hj.left, hj.leftField
+	if hj.buildLeft {
+		build, buildField, probe, probeField = hj.left, hj.leftField, hj.right, hj.rightField
+	}
+	buildIter, err := (*build).Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	buildExpr := compileExpr(buildField, (*build).Descriptor())
+	probeExpr := compileExpr(probeField, (*probe).Descriptor())
+
+	var table map[any][]hashEntry
+	var pending *Tuple // the first build tuple that did not fit in the last chunk
+	buildDone := false
+
+	// Fill the hash table with the next chunk of the build side.  Returns
+	// false once the build side is exhausted.
+	nextChunk := func() (bool, error) {
+		table = make(map[any][]hashEntry)
+		size, n := 0, 0
+		for !buildDone {
+			if err := checkCanceled(tid); err != nil {
+				return false, err
+			}
+			t := pending
+			pending = nil
+			if t == nil {
+				var err error
+				if t, err = buildIter.Next(); err != nil {
+					return false, err
+				}
+				if t == nil {
+					buildDone = true
+					break
+				}
+			}
+			// a chunk holds at least one tuple, however large
+			if n > 0 && size+tupleMemory(t) > hj.maxBufferSize {
+				pending = t
+				break
+			}
+			v, err := buildExpr(t)
+			if err != nil {
+				return false, err
+			}
+			key := hashKey(v)
+			table[key] = append(table[key], hashEntry{v, t})
+			size += tupleMemory(t)
+			n++
+		}
+		return n > 0, nil
+	}
+
+	var probeIter *OpIterator
+	var probeTuple *Tuple
+	var probeVal DBValue
+	var matches []hashEntry
+	return newOpIterator(func() (*Tuple, error) {
+		for {
+			for len(matches) > 0 {
+				m := matches[0]
+				matches = matches[1:]
+				if !probeVal.EvalPred(m.val, OpEq) {
+					continue
+				}
+				if hj.buildLeft {
+					return joinTuples(m.tup, probeTuple), nil
+				}
+				return joinTuples(probeTuple, m.tup), nil
+			}
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			if probeIter == nil {
+				ok, err := nextChunk()
+				if err != nil || !ok {
+					return nil, err
+				}
+				if probeIter, err = (*probe).Open(tid); err != nil {
+					return nil, err
+				}
+			}
+			probeTuple, err = probeIter.Next()
+			if err != nil {
+				return nil, err
+			}
+			if probeTuple == nil {
+				probeIter = nil
+				continue
+			}
+			if probeVal, err = probeExpr(probeTuple); err != nil {
+				return nil, err
+			}
+			matches = table[hashKey(probeVal)]
+		}
+	}, func() error {
+		table, pending, matches = nil, nil, nil
+		return closeIterators(buildIter, probeIter)
+	}), nil
+}
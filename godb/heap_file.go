@@ -5,7 +5,6 @@ import (
 	"bytes"
 	"fmt"
 	"os"
-	"strconv"
 	"strings"
 	"sync"
 )
@@ -22,6 +21,9 @@ type HeapFile struct {
 	// HeapFile should include the fields below;  you may want to add
 	// additional fields
 	bufPool *BufferPool
+	// declared length, precision and scale of each field; nil if no field
+	// has any
+	columnSpecs []columnSpec
 	sync.Mutex
 }
 
@@ -50,10 +52,21 @@ func NewHeapFile(fromFile string, td *TupleDesc, bp *BufferPool) (*HeapFile, err
 		return nil, err
 	}
 	numPages := fi.Size() / int64(PageSize)
-	return &HeapFile{td, int(numPages), fromFile, -1, bp, sync.Mutex{}}, nil
+	return &HeapFile{td, int(numPages), fromFile, -1, bp, nil, sync.Mutex{}}, nil
 
 }
 
+// Remove all pages of the file, on disk and in the buffer pool.
+func (f *HeapFile) truncate() error {
+	f.bufPool.discardPages(f)
+	if err := os.WriteFile(f.backingFile, nil, 0644); err != nil {
+		return err
+	}
+	f.numPages = 0
+	f.lastEmptyPage = -1
+	return nil
+}
+
 // Return the name of the backing file
 func (f *HeapFile) BackingFile() string {
 	// TODO: some code goes here
@@ -108,25 +121,12 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 		var newFields []DBValue // 用于存储处理后的字段值
 		// 遍历当前行的每个字段，并根据字段类型进行处理
 		for fno, field := range fields {
-			switch f.Descriptor().Fields[fno].Ftype {
-			case IntType:
-				// 对于整数类型字段，先移除空白字符
-				field = strings.TrimSpace(field)
-				// 将字段转换为浮点数，然后再转换为整数
-				floatVal, err := strconv.ParseFloat(field, 64)
-				if err != nil {
-					// 如果转换失败，返回类型不匹配错误
-					return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: couldn't convert value %s to int, tuple %d", field, cnt)}
-				}
-				intValue := int(floatVal)                                // 将浮点数转为整数
-				newFields = append(newFields, IntField{int64(intValue)}) // 将转换后的整数添加到字段列表中
-			case StringType:
-				// 对于字符串类型字段，截取最大允许长度的字符串
-				if len(field) > StringLength {
-					field = field[0:StringLength]
-				}
-				newFields = append(newFields, StringField{field}) // 将字符串字段添加到字段列表中
+			// 按字段类型解析值；超过 VARCHAR(n) 长度的字符串由 insertTuple 报错
+			value, err := parseValue(field, desc.Fields[fno].Ftype)
+			if err != nil {
+				return GoDBError{TypeMismatchError, fmt.Sprintf("LoadFromCSV: %s, tuple %d", err.Error(), cnt)}
 			}
+			newFields = append(newFields, value)
 		}
 
 		// 创建一个新的元组
@@ -139,7 +139,9 @@ func (f *HeapFile) LoadFromCSV(file *os.File, hasHeader bool, sep string, skipLa
 		bp.BeginTransaction(tid)
 
 		// 将元组插入到 HeapFile 中
-		f.insertTuple(&newT, tid)
+		if err := f.insertTuple(&newT, tid); err != nil {
+			return GoDBError{MalformedDataError, fmt.Sprintf("LoadFromCSV: line %d: %s", cnt, err.Error())}
+		}
 
 		// 将脏页（dirty pages）强制写入磁盘，可能是由于事务提交还未实现，所以手动调用
 		bp.FlushAllPages()
@@ -183,13 +185,18 @@ func (f *HeapFile) readPage(pageNo int) (Page, error) {
 }
 
 // Add the tuple to the HeapFile. This method should search through pages in the
-// heap file, looking for empty slots and adding the tuple in the first empty
-// slot if finds.
+// heap file, looking for a page with enough free space for the tuple and
+// adding the tuple to the first such page it finds.
 //
 // If none are found, it should create a new [heapPage] and insert the tuple
 // there, and write the heapPage to the end of the HeapFile (e.g., using the
 // [flushPage] method.)
 //
+// Strings are stored with their actual length.  If the tuple is too large to
+// fit on a page, its longest strings are moved to chains of overflow pages,
+// appended to the file through the buffer pool, until the rest of the record
+// fits.
+//
 // To iterate through pages, it should use the [BufferPool.GetPage method]
 // rather than directly reading pages itself. For lab 1, you do not need to
 // worry about concurrent transactions modifying the Page or HeapFile. We will
@@ -197,7 +204,16 @@ func (f *HeapFile) readPage(pageNo int) (Page, error) {
 //
 // The page the tuple is inserted into should be marked as dirty.
 func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
-	// TODO: some code goes here
+	conformed, err := f.conformTuple(t)
+	if err != nil {
+		return err
+	}
+	stored, err := f.moveToOverflow(conformed, tid)
+	if err != nil {
+		return err
+	}
+	size := stored.recordSize()
+
 	var start int
 
 	if f.lastEmptyPage == -1 {
@@ -212,7 +228,7 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 		if err != nil {
 			return err
 		}
-		if pg.(*heapPage).getNumEmptySlots() == 0 {
+		if !pg.(*heapPage).hasRoomFor(size) {
 			continue
 		}
 
@@ -221,43 +237,227 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 			return err
 		}
 		heapp := pg.(*heapPage)
-		_, err = heapp.insertTuple(t)
+		_, err = heapp.insertTuple(stored)
 		if err != nil && err != ErrPageFull {
 			return err
 		}
 		if err == nil {
 			heapp.setDirty(tid, true)
+			t.Rid = stored.Rid
 			f.lastEmptyPage = p // this is fine because lastEmptyPage is a hint, not forcing
 			return nil
 		}
 	}
 
-	//no free slots, create new page
+	//no free space, create new page
 	heapp, err := newHeapPage(f.td, f.numPages, f)
-	err = f.flushPage(heapp) // flush an empty page to later add to buffer pool, helps maintain dirtiness
 	if err != nil {
 		return err
 	}
-	f.lastEmptyPage = f.numPages
-	p := f.numPages
-	f.numPages++
-
-	pg, err := f.bufPool.GetPage(f, p, tid, WritePerm)
+	heapp, err = f.appendPage(heapp, tid)
 	if err != nil {
 		return err
 	}
-	heapp = pg.(*heapPage)
-	_, err = heapp.insertTuple(t)
+	_, err = heapp.insertTuple(stored)
 	if err != nil {
 		return err
 	}
 	heapp.setDirty(tid, true)
+	t.Rid = stored.Rid
 
-	f.lastEmptyPage = p
+	f.lastEmptyPage = heapp.pageNo
 
 	return nil
 }
 
+// Write an empty page to the end of the file, and return the page as read
+// through the buffer pool.
+func (f *HeapFile) appendPage(heapp *heapPage, tid TransactionID) (*heapPage, error) {
+	err := f.flushPage(heapp) // flush an empty page to later add to buffer pool, helps maintain dirtiness
+	if err != nil {
+		return nil, err
+	}
+	p := f.numPages
+	f.numPages++
+
+	pg, err := f.bufPool.GetPage(f, p, tid, WritePerm)
+	if err != nil {
+		return nil, err
+	}
+	return pg.(*heapPage), nil
+}
+
+// The declared size of a column: the n of VARCHAR(n), or the p and s of
+// DECIMAL(p,s).  Zero values mean there is no limit.
+type columnSpec struct {
+	length    int
+	precision int
+	scale     int
+}
+
+func (f *HeapFile) columnSpec(i int) columnSpec {
+	if i >= len(f.columnSpecs) {
+		return columnSpec{}
+	}
+	return f.columnSpecs[i]
+}
+
+// Returns t with its values converted to the types of the file's fields, and
+// decimals rounded to the scale of their column.  Returns an error if a value
+// cannot be converted, or is too long or has too many digits for its column.
+// t itself is not modified; if no value needs converting t is returned.
+func (f *HeapFile) conformTuple(t *Tuple) (*Tuple, error) {
+	if len(t.Fields) != len(f.td.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("expected %d fields, got %d", len(f.td.Fields), len(t.Fields))}
+	}
+	var fields []DBValue // copy of t.Fields, made when a value is converted
+	for i, v := range t.Fields {
+		field := f.td.Fields[i]
+		spec := f.columnSpec(i)
+		conv, err := convertValue(v, field.Ftype)
+		if err != nil {
+			return nil, err
+		}
+		switch x := conv.(type) {
+		case StringField:
+			if spec.length > 0 && len(x.Value) > spec.length {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("value too long for %s varchar(%d) (%d bytes)", field.Fname, spec.length, len(x.Value))}
+			}
+		case DecimalField:
+			if spec.precision > 0 {
+				if conv, err = x.rescale(spec.scale); err != nil {
+					return nil, err
+				}
+				if p := conv.(DecimalField).precision(); p > spec.precision {
+					return nil, GoDBError{MalformedDataError, fmt.Sprintf("value %s has too many digits for %s decimal(%d,%d)", x, field.Fname, spec.precision, spec.scale)}
+				}
+			}
+		}
+		if conv != v {
+			if fields == nil {
+				fields = append([]DBValue(nil), t.Fields...)
+			}
+			fields[i] = conv
+		}
+	}
+	if fields == nil {
+		return t, nil
+	}
+	return &Tuple{t.Desc, fields, t.Rid}, nil
+}
+
+// A placeholder for a string stored on overflow pages.  It appears in tuples
+// on heap pages, and is replaced by the actual [StringField] when tuples are
+// read through [HeapFile.Iterator].
+type overflowField struct {
+	firstPage int
+	length    int
+}
+
+func (v overflowField) EvalPred(v2 DBValue, op BoolOp) bool {
+	return false
+}
+
+// Return the tuple to store on a heap page for t.  If t does not fit on a
+// page, its longest strings are written to overflow pages and replaced by
+// references to them until it does.
+func (f *HeapFile) moveToOverflow(t *Tuple, tid TransactionID) (*Tuple, error) {
+	if t.recordSize() <= maxRecordSize {
+		return t, nil
+	}
+	stored := &Tuple{t.Desc, append([]DBValue(nil), t.Fields...), nil}
+	for stored.recordSize() > maxRecordSize {
+		longest := -1
+		for i, v := range stored.Fields {
+			if s, ok := v.(StringField); ok && (longest == -1 || len(s.Value) > len(stored.Fields[longest].(StringField).Value)) {
+				longest = i
+			}
+		}
+		if longest == -1 {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("tuple of %d bytes is too large for a page", stored.recordSize())}
+		}
+		value := stored.Fields[longest].(StringField).Value
+		first, err := f.writeOverflow([]byte(value), tid)
+		if err != nil {
+			return nil, err
+		}
+		stored.Fields[longest] = overflowField{first, len(value)}
+	}
+	return stored, nil
+}
+
+// Write data to a new chain of overflow pages at the end of the file and
+// return the number of the first page of the chain.
+//
+// Like any other modified page, the pages of the chain are dirty pages in the
+// buffer pool until the inserting transaction commits, so a value needs a
+// buffer pool page for each of its chunks.
+func (f *HeapFile) writeOverflow(data []byte, tid TransactionID) (int, error) {
+	numChunks := (len(data) + overflowChunkSize - 1) / overflowChunkSize
+	first := f.numPages
+	for i := 0; i < numChunks; i++ {
+		chunk := data[i*overflowChunkSize : min((i+1)*overflowChunkSize, len(data))]
+		heapp, err := newHeapPage(f.td, f.numPages, f)
+		if err != nil {
+			return 0, err
+		}
+		heapp, err = f.appendPage(heapp, tid)
+		if err != nil {
+			return 0, err
+		}
+		next := -1
+		if i < numChunks-1 {
+			next = heapp.pageNo + 1
+		}
+		heapp.setOverflow(chunk, next)
+		heapp.setDirty(tid, true)
+	}
+	return first, nil
+}
+
+// Free the overflow pages of a deleted value.  They become empty pages, which
+// later inserts fill with tuples.
+func (f *HeapFile) freeOverflow(ref overflowField, tid TransactionID) error {
+	for pgNo := ref.firstPage; pgNo != -1; {
+		pg, err := f.bufPool.GetPage(f, pgNo, tid, WritePerm)
+		if err != nil {
+			return err
+		}
+		hp := pg.(*heapPage)
+		if !hp.overflow {
+			return GoDBError{MalformedDataError, fmt.Sprintf("page %d is not an overflow page", pgNo)}
+		}
+		pgNo = hp.next
+		hp.clearOverflow()
+		hp.setDirty(tid, true)
+		if hp.pageNo < f.lastEmptyPage {
+			f.lastEmptyPage = hp.pageNo
+		}
+	}
+	return nil
+}
+
+// Read a value stored on overflow pages.
+func (f *HeapFile) readOverflow(ref overflowField, tid TransactionID) (StringField, error) {
+	data := make([]byte, 0, ref.length)
+	for pgNo := ref.firstPage; pgNo != -1 && len(data) < ref.length; {
+		pg, err := f.bufPool.GetPage(f, pgNo, tid, ReadPerm)
+		if err != nil {
+			return StringField{}, err
+		}
+		hp := pg.(*heapPage)
+		if !hp.overflow {
+			return StringField{}, GoDBError{MalformedDataError, fmt.Sprintf("page %d is not an overflow page", pgNo)}
+		}
+		data = append(data, hp.data...)
+		pgNo = hp.next
+	}
+	if len(data) != ref.length {
+		return StringField{}, GoDBError{MalformedDataError, fmt.Sprintf("overflow chain at page %d is truncated", ref.firstPage)}
+	}
+	return StringField{string(data)}, nil
+}
+
 // Remove the provided tuple from the HeapFile.
 //
 // This method should use the [Tuple.Rid] field of t to determine which tuple to
@@ -266,7 +466,8 @@ func (f *HeapFile) insertTuple(t *Tuple, tid TransactionID) error {
 // empty interface, so you can supply any object you wish. You will likely want
 // to identify the heap page and slot within the page that the tuple came from.
 //
-// The page the tuple is deleted from should be marked as dirty.
+// The page the tuple is deleted from should be marked as dirty.  Overflow pages
+// holding the tuple's large values are freed.
 func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
 	// TODO: some code goes here
 	if t.Rid == nil {
@@ -290,11 +491,22 @@ func (f *HeapFile) deleteTuple(t *Tuple, tid TransactionID) error {
 	if !ok {
 		return GoDBError{IncompatibleTypesError, "buffer pool returned non-heap page when heap page expected"}
 	}
+	var stored *Tuple
+	if rid.slotNo >= 0 && rid.slotNo < len(hp.tuples) {
+		stored = hp.tuples[rid.slotNo]
+	}
 	hp.setDirty(tid, true)
 	err = hp.deleteTuple(rid)
 	if err != nil {
 		return err
 	}
+	for _, v := range stored.Fields {
+		if ref, ok := v.(overflowField); ok {
+			if err := f.freeOverflow(ref, tid); err != nil {
+				return err
+			}
+		}
+	}
 
 	if rid.pageNo < f.lastEmptyPage {
 		f.lastEmptyPage = rid.pageNo
@@ -342,16 +554,28 @@ func (f *HeapFile) Descriptor() *TupleDesc {
 // You should esnure that Tuples returned by this method have their Rid object
 // set appropriate so that [deleteTuple] will work (see additional comments there).
 func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
-	// TODO: some code goes here
-	nPages := f.NumPages()
-	pgNo := 0
+	return iteratorOf(f.Open(tid))
+}
+
+// Open an iterator over the records in the heap file.  Closing it stops the
+// scan, so no more pages are requested from the BufferPool.
+func (f *HeapFile) Open(tid TransactionID) (*OpIterator, error) {
+	return f.openPages(tid, 0, f.NumPages())
+}
+
+// Open an iterator over the records on the pages from pgNo up to, but not
+// including, nPages.
+func (f *HeapFile) openPages(tid TransactionID, pgNo, nPages int) (*OpIterator, error) {
 	var pgIter func() (*Tuple, error)
-	return func() (*Tuple, error) {
+	return newOpIterator(func() (*Tuple, error) {
 		for {
 			if pgIter == nil {
 				if pgNo == nPages {
 					return nil, nil
 				}
+				if err := checkCanceled(tid); err != nil {
+					return nil, err
+				}
 				p, err := f.bufPool.GetPage(f, pgNo, tid, ReadPerm)
 				if err != nil {
 					return nil, err
@@ -366,10 +590,27 @@ func (f *HeapFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 			if next == nil {
 				pgIter = nil
 			} else {
-				return &Tuple{*f.td, next.Fields, next.Rid}, nil
+				fields := next.Fields
+				copied := false
+				for i, v := range next.Fields {
+					if ref, ok := v.(overflowField); ok {
+						// don't modify the tuple on the page
+						if !copied {
+							fields = append([]DBValue(nil), next.Fields...)
+							copied = true
+						}
+						if fields[i], err = f.readOverflow(ref, tid); err != nil {
+							return nil, err
+						}
+					}
+				}
+				return &Tuple{*f.td, fields, next.Rid}, nil
 			}
 		}
-	}, nil
+	}, func() error {
+		pgNo, pgIter = nPages, nil
+		return nil
+	}), nil
 }
 
 // internal strucuture to use as key for a heap page
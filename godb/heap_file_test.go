@@ -2,6 +2,7 @@ package godb
 
 import (
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -263,9 +264,10 @@ func TestHeapFileSetDirty(t *testing.T) {
 	}
 
 	_, t1, _, hf, bp, tid := makeTestVars(t)
-	for i := 0; i < 308; i++ {
+	// the buffer pool holds 3 pages
+	for i := 0; i < 3*samTuplesPerPage+2; i++ {
 		err := hf.insertTuple(&t1, tid)
-		if err != nil && (i == 306 || i == 307) {
+		if err != nil && (i == 3*samTuplesPerPage || i == 3*samTuplesPerPage+1) {
 			return
 		} else if err != nil {
 			t.Fatalf("%v", err)
@@ -315,3 +317,136 @@ func TestHeapFileIteratorExtra(t *testing.T) {
 		t.Fatalf("Iterator returned error at end, expected nil, nil, got nil, %s", err.Error())
 	}
 }
+
+func TestHeapFileLongStrings(t *testing.T) {
+	td, _, _, hf, bp, tid := makeTestVars(t)
+	// one value that needs a page of its own, one that spans two overflow
+	// pages, and short values around them
+	values := []string{"sam", strings.Repeat("a", PageSize-100), "bob", strings.Repeat("0123456789", 3*PageSize/20), "ann"}
+	for i, v := range values {
+		tup := Tuple{Desc: td, Fields: []DBValue{StringField{v}, IntField{int64(i)}}}
+		if err := hf.insertTuple(&tup, tid); err != nil {
+			t.Fatalf(err.Error())
+		}
+		// the buffer pool only holds 3 pages
+		bp.FlushAllPages()
+	}
+	bp.CommitTransaction(tid)
+
+	// read back from disk through a new buffer pool
+	bp2, err := NewBufferPool(10)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	hf2, err := NewHeapFile(hf.BackingFile(), &td, bp2)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	tid = NewTID()
+	iter, err := hf2.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	seen := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		i := tup.Fields[1].(IntField).Value
+		if got := tup.Fields[0].(StringField).Value; got != values[i] {
+			t.Errorf("value %d has length %d after reading back, expected %d", i, len(got), len(values[i]))
+		}
+		seen++
+	}
+	if seen != len(values) {
+		t.Errorf("expected %d tuples, got %d", len(values), seen)
+	}
+}
+
+func TestHeapFileOverflowDelete(t *testing.T) {
+	td, _, _, hf, bp, tid := makeTestVars(t)
+	long := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("x", PageSize+100)}, IntField{1}}}
+	if err := hf.insertTuple(&long, tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// the overflow pages are written through the buffer pool
+	dirty := 0
+	for _, pg := range bp.pages {
+		if pg.isDirty() {
+			dirty++
+		}
+	}
+	if dirty != hf.NumPages() {
+		t.Errorf("expected all %d pages to be dirty in the buffer pool, got %d", hf.NumPages(), dirty)
+	}
+	bp.FlushAllPages()
+
+	// deleting the tuple frees its overflow pages, which later tuples reuse
+	numPages := hf.NumPages()
+	if err := hf.deleteTuple(&long, tid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	bp.FlushAllPages()
+	for i := 0; i < 90; i++ {
+		tup := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("y", 100)}, IntField{int64(i)}}}
+		if err := hf.insertTuple(&tup, tid); err != nil {
+			t.Fatalf(err.Error())
+		}
+		bp.FlushAllPages()
+	}
+	if hf.NumPages() != numPages {
+		t.Errorf("expected the file to keep %d pages, got %d", numPages, hf.NumPages())
+	}
+	iter, err := hf.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	n := 0
+	for {
+		tup, err := iter()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if tup == nil {
+			break
+		}
+		n++
+	}
+	if n != 90 {
+		t.Errorf("expected 90 tuples, got %d", n)
+	}
+}
+
+func TestHeapFileVarcharLength(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table urls (id int, url varchar(20), descr text)")
+	long := strings.Repeat("x", 500)
+	mustExecForTest(t, c, bp, "insert into urls values (1, 'http://example.com', '"+long+"')")
+	if _, err := execForTest(t, c, bp, "insert into urls values (2, 'http://example.com/a/long/path', 'b')"); err == nil {
+		t.Errorf("expected error inserting a value longer than varchar(20)")
+	}
+	tups := mustExecForTest(t, c, bp, "select descr from urls")
+	if len(tups) != 1 || tups[0].Fields[0].(StringField).Value != long {
+		t.Errorf("text value was not stored in full")
+	}
+
+	// lengths survive a round trip through the catalog file
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2 := NewCatalog(c.filePath, bp, c.rootPath)
+	if err := c2.parseCatalogFile(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	info, err := c2.GetTableInfo("urls")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if info.columnSpec(1).length != 20 || info.columnSpec(2).length != 0 {
+		t.Errorf("unexpected lengths after reload: %d, %d", info.columnSpec(1).length, info.columnSpec(2).length)
+	}
+}
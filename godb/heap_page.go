@@ -3,6 +3,8 @@ package godb
 import (
 	"bytes"
 	"encoding/binary"
+	"fmt"
+	"math"
 	"sync"
 )
 
@@ -13,195 +15,275 @@ interface requires.  You will want to use an interface like what we provide to
 implement the methods of [HeapFile] that insert, delete, and iterate through
 tuples.
 
-In GoDB all tuples are fixed length, which means that given a TupleDesc it is
-possible to figure out how many tuple "slots" fit on a given page.
+Tuples in GoDB are variable length, because strings are stored with their
+actual length rather than padded to a fixed width.  Heap pages therefore use a
+slotted layout.  All pages are PageSize bytes.  They begin with a header with a
+32 bit integer with the number of slots, and a second 32 bit integer with the
+number of used slots.  The header is followed by the slot directory, which has
+one entry per slot holding the 16 bit offset and 16 bit length of the slot's
+record; free slots have an offset of 0.  Records are packed at the end of the
+page, growing towards the slot directory:
 
-In addition, all pages are PageSize bytes.  They begin with a header with a 32
-bit integer with the number of slots (tuples), and a second 32 bit integer with
-the number of used slots.
+	| numSlots | numUsed | slot 0 | slot 1 | ... free space ... | rec 1 | rec 0 |
 
-Each tuple occupies the same number of bytes.  You can use the go function
-unsafe.Sizeof() to determine the size in bytes of an object.  So, a GoDB integer
-(represented as an int64) requires unsafe.Sizeof(int64(0)) bytes.  For strings,
-we encode them as byte arrays of StringLength, so they are size
-((int)(unsafe.Sizeof(byte('a')))) * StringLength bytes.  The size in bytes  of a
-tuple is just the sum of the size in bytes of its fields.
+A tuple keeps its slot number for as long as it is on the page, so record ids
+remain valid when the page is written out and read back.  Space freed by
+deleted records is reclaimed when the page is next serialized, since records
+are written back to back in [heapPage.toBuffer].
 
-Once you have figured out how big a record is, you can determine the number of
-slots on on the page as:
+Values too large to fit on a page are stored on overflow pages (see
+[HeapFile.insertTuple]).  An overflow page is a heapPage whose numSlots header
+field is -1, followed by the page number of the next overflow page in the
+chain (or -1) and the number of bytes of the value stored on this page.
+Overflow pages hold no tuples.
 
-remPageSize = PageSize - 8 // bytes after header
-numSlots = remPageSize / bytesPerTuple //integer division will round down
-
-To serialize a page to a buffer, you can then:
-
-write the number of slots as an int32
-write the number of used slots as an int32
-write the tuples themselves to the buffer
+*/
 
-You will follow the inverse process to read pages from a buffer.
+const (
+	heapPageHeaderSize = 8 // numSlots and numUsed
+	slotEntrySize      = 4 // offset and length of a record
+	overflowHeaderSize = 12
 
-Note that to process deletions you will likely delete tuples at a specific
-position (slot) in the heap page.  This means that after a page is read from
-disk, tuples should retain the same slot number. Because GoDB will never evict a
-dirty page, it's OK if tuples are renumbered when they are written back to disk.
+	// the largest record that fits on an otherwise empty page
+	maxRecordSize = PageSize - heapPageHeaderSize - slotEntrySize
 
-*/
+	// number of bytes of a large value stored on each overflow page
+	overflowChunkSize = PageSize - overflowHeaderSize
+)
 
 type heapPage struct {
-	// TODO: some code goes here
 	desc     TupleDesc
-	numSlots int32
 	numUsed  int32
 	dirty    bool
-	tuples   []*Tuple
+	tuples   []*Tuple // indexed by slot number, nil for free slots
+	recBytes int      // bytes of record data for the tuples on the page
 	pageNo   int
 	file     *HeapFile
+
+	// set for overflow pages, which hold part of a large value instead of
+	// tuples
+	overflow bool
+	next     int
+	data     []byte
 	sync.Mutex
 }
 
 // Construct a new heap page
 func newHeapPage(desc *TupleDesc, pageNo int, f *HeapFile) (*heapPage, error) {
-	// TODO: some code goes here
 	var pg heapPage
 	pg.desc = *desc
-	pg.numSlots = int32((PageSize - 8) / desc.bytesPerTuple())
 	pg.numUsed = 0
 	pg.dirty = false
-	pg.tuples = make([]*Tuple, pg.numSlots)
+	pg.tuples = make([]*Tuple, 0)
 	pg.pageNo = pageNo
 	pg.file = f
 	//pg.SetBeforeImage()
 	return &pg, nil
 }
 
-// Hint: heapfile/insertTuple needs function there:  func (h *heapPage) getNumEmptySlots() int
-func (h *heapPage) getNumEmptySlots() int {
-	return int(h.numSlots - h.numUsed)
+// Turn the (empty) page into an overflow page holding data, which is followed
+// by the overflow page next (or -1 if data is the last part of the value).
+func (h *heapPage) setOverflow(data []byte, next int) {
+	h.overflow = true
+	h.data = data
+	h.next = next
+}
+
+// Turn an overflow page whose value has been deleted back into an empty page
+// that can hold tuples.
+func (h *heapPage) clearOverflow() {
+	h.overflow = false
+	h.data = nil
+	h.next = 0
 }
 
 func (h *heapPage) getNumSlots() int {
-	// TODO: some code goes here
-	return int(h.numSlots)
+	return len(h.tuples)
+}
 
+// Return the number of unused bytes on the page.
+func (h *heapPage) getFreeSpace() int {
+	if h.overflow {
+		return 0
+	}
+	return PageSize - heapPageHeaderSize - slotEntrySize*len(h.tuples) - h.recBytes
+}
+
+// Return the slot a new tuple should be stored in, which is either a free
+// slot or a new slot at the end of the directory.
+func (h *heapPage) nextFreeSlot() int {
+	if int(h.numUsed) < len(h.tuples) {
+		for i, t := range h.tuples {
+			if t == nil {
+				return i
+			}
+		}
+	}
+	return len(h.tuples)
+}
+
+// Returns true if a record of the given size can be inserted into the page.
+func (h *heapPage) hasRoomFor(size int) bool {
+	need := size
+	if h.nextFreeSlot() == len(h.tuples) {
+		need += slotEntrySize
+	}
+	return need <= h.getFreeSpace()
 }
 
 var ErrPageFull = GoDBError{PageFullError, "page is full"}
 
-// Insert the tuple into a free slot on the page, or return an error if there are
-// no free slots.  Set the tuples rid and return it.
+// Insert the tuple into a free slot on the page, or return an error if there is
+// not enough space left on the page.  Set the tuples rid and return it.
 func (h *heapPage) insertTuple(t *Tuple) (recordID, error) {
-	// TODO: some code goes here
-	for i := 0; i < int(h.numSlots); i++ {
-		if h.tuples[i] == nil {
-			h.tuples[i] = t
-			h.numUsed++
-			t.Rid = heapFileRid{h.pageNo, i}
-			return t.Rid, nil
-		}
+	size := t.recordSize()
+	if h.overflow || !h.hasRoomFor(size) {
+		return 0, ErrPageFull
 	}
-	return 0, ErrPageFull
+	slot := h.nextFreeSlot()
+	if slot == len(h.tuples) {
+		h.tuples = append(h.tuples, nil)
+	}
+	h.tuples[slot] = t
+	h.numUsed++
+	h.recBytes += size
+	t.Rid = heapFileRid{h.pageNo, slot}
+	return t.Rid, nil
 }
 
 // Delete the tuple at the specified record ID, or return an error if the ID is
 // invalid.
 func (h *heapPage) deleteTuple(rid recordID) error {
-	// TODO: some code goes here
 	heapRid, ok := rid.(heapFileRid)
 	if !ok {
 		return GoDBError{TupleNotFoundError, "supplied rid is not a heapFileRid"}
 	}
 	slot := heapRid.slotNo
-	if slot < 0 || slot >= int(h.numSlots) {
+	if slot < 0 || slot >= len(h.tuples) {
 		return GoDBError{TupleNotFoundError, "slot does not exist on delete"}
 	}
 	if h.tuples[slot] == nil {
 		return GoDBError{TupleNotFoundError, "element already deleted"}
 	}
 	h.numUsed--
+	h.recBytes -= h.tuples[slot].recordSize()
 	h.tuples[slot] = nil
+	// free slots at the end of the directory can be dropped, since no record
+	// id refers to them any more
+	for len(h.tuples) > 0 && h.tuples[len(h.tuples)-1] == nil {
+		h.tuples = h.tuples[:len(h.tuples)-1]
+	}
 	return nil
 }
 
 // Page method - return whether or not the page is dirty
 func (h *heapPage) isDirty() bool {
-	// TODO: some code goes here
 	return h.dirty
 }
 
 // Page method - mark the page as dirty
 func (h *heapPage) setDirty(tid TransactionID, dirty bool) {
-	// TODO: some code goes here
 	h.dirty = dirty
-
 }
 
 // Page method - return the corresponding HeapFile
 // for this page.
 func (p *heapPage) getFile() DBFile {
-	// TODO: some code goes here
 	return p.file
-
 }
 
 // Allocate a new bytes.Buffer and write the heap page to it. Returns an error
 // if the write to the the buffer fails. You will likely want to call this from
-// your [HeapFile.flushPage] method.  You should write the page header, using
-// the binary.Write method in LittleEndian order, followed by the tuples of the
-// page, written using the Tuple.writeTo method.
+// your [HeapFile.flushPage] method.  The page header and slot directory are
+// written using the binary.Write method in LittleEndian order, and the
+// records of the page, written using the Tuple.writeTo method, are packed at
+// the end of the page.
 func (h *heapPage) toBuffer() (*bytes.Buffer, error) {
-	// TODO: some code goes here
-	b := new(bytes.Buffer)
-
-	err := binary.Write(b, binary.LittleEndian, (int32)(h.numSlots))
-	if err != nil {
-		return nil, err
-	}
-	err = binary.Write(b, binary.LittleEndian, (int32)(h.numUsed))
-	if err != nil {
-		return nil, err
+	page := make([]byte, PageSize)
+	if h.overflow {
+		binary.LittleEndian.PutUint32(page[0:], math.MaxUint32) // -1
+		binary.LittleEndian.PutUint32(page[4:], uint32(int32(h.next)))
+		binary.LittleEndian.PutUint32(page[8:], uint32(len(h.data)))
+		copy(page[overflowHeaderSize:], h.data)
+		return bytes.NewBuffer(page), nil
 	}
 
-	for i := 0; i < len(h.tuples); i++ {
-		t := h.tuples[i]
-		if t != nil {
-			err = t.writeTo(b)
-			if err != nil {
-				return nil, err
-			}
+	binary.LittleEndian.PutUint32(page[0:], uint32(len(h.tuples)))
+	binary.LittleEndian.PutUint32(page[4:], uint32(h.numUsed))
+	end := PageSize
+	var rec bytes.Buffer
+	for i, t := range h.tuples {
+		if t == nil {
+			continue
 		}
+		rec.Reset()
+		if err := t.writeTo(&rec); err != nil {
+			return nil, err
+		}
+		start := end - rec.Len()
+		dirEnd := heapPageHeaderSize + slotEntrySize*len(h.tuples)
+		if start < dirEnd {
+			return nil, GoDBError{MalformedDataError, "buffer is greater than page size"}
+		}
+		copy(page[start:end], rec.Bytes())
+		slot := page[heapPageHeaderSize+slotEntrySize*i:]
+		binary.LittleEndian.PutUint16(slot[0:], uint16(start))
+		binary.LittleEndian.PutUint16(slot[2:], uint16(rec.Len()))
+		end = start
 	}
-	if b.Len() > PageSize {
-		return nil, GoDBError{MalformedDataError, "buffer is greater than page size"}
-	}
-	b.Write(make([]byte, PageSize-b.Len())) // pad to page size
 
-	return b, nil
+	return bytes.NewBuffer(page), nil
 }
 
 // Read the contents of the HeapPage from the supplied buffer.
 func (h *heapPage) initFromBuffer(buf *bytes.Buffer) error {
-	// TODO: some code goes here
-	var numSlotsHeader, numUsedHeader int32
-	err := binary.Read(buf, binary.LittleEndian, &numSlotsHeader)
-	if err != nil {
-		return err
-	}
-	err = binary.Read(buf, binary.LittleEndian, &numUsedHeader)
-	if err != nil {
-		return err
-	}
-	tups := make([]*Tuple, numSlotsHeader)
-	for i := 0; i < int(numUsedHeader); i++ {
-		t, err := readTupleFrom(buf, &h.desc)
-		t.Rid = heapFileRid{h.pageNo, i}
+	page := buf.Bytes()
+	if len(page) < PageSize {
+		return GoDBError{MalformedDataError, "not enough bytes for a heap page"}
+	}
+	numSlots := int32(binary.LittleEndian.Uint32(page[0:]))
+	if numSlots == -1 {
+		length := int(binary.LittleEndian.Uint32(page[8:]))
+		if length > overflowChunkSize {
+			return GoDBError{MalformedDataError, "overflow page length exceeds page size"}
+		}
+		h.overflow = true
+		h.next = int(int32(binary.LittleEndian.Uint32(page[4:])))
+		h.data = append([]byte(nil), page[overflowHeaderSize:overflowHeaderSize+length]...)
+		h.tuples = make([]*Tuple, 0)
+		h.numUsed = 0
+		h.recBytes = 0
+		h.dirty = false
+		return nil
+	}
+	if numSlots < 0 || heapPageHeaderSize+slotEntrySize*int(numSlots) > PageSize {
+		return GoDBError{MalformedDataError, fmt.Sprintf("invalid slot count %d", numSlots)}
+	}
+
+	tups := make([]*Tuple, numSlots)
+	var numUsed int32
+	recBytes := 0
+	for i := 0; i < int(numSlots); i++ {
+		slot := page[heapPageHeaderSize+slotEntrySize*i:]
+		offset := int(binary.LittleEndian.Uint16(slot[0:]))
+		length := int(binary.LittleEndian.Uint16(slot[2:]))
+		if offset == 0 {
+			continue
+		}
+		if offset+length > PageSize {
+			return GoDBError{MalformedDataError, fmt.Sprintf("slot %d extends past the end of the page", i)}
+		}
+		t, err := readTupleFrom(bytes.NewBuffer(page[offset:offset+length]), &h.desc)
 		if err != nil {
 			return err
 		}
+		t.Rid = heapFileRid{h.pageNo, i}
 		tups[i] = t
+		numUsed++
+		recBytes += length
 	}
-	h.numSlots = numSlotsHeader
-	h.numUsed = numUsedHeader
+	h.numUsed = numUsed
+	h.recBytes = recBytes
 	h.dirty = false
 	h.tuples = tups
 	//h.SetBeforeImage()
@@ -212,7 +294,6 @@ func (h *heapPage) initFromBuffer(buf *bytes.Buffer) error {
 // to set the rid of the tuple to the rid struct of your choosing beforing
 // return it. Return nil, nil when the last tuple is reached.
 func (p *heapPage) tupleIter() func() (*Tuple, error) {
-	// TODO: some code goes here
 	i := 0
 	return func() (*Tuple, error) {
 		for {
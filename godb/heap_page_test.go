@@ -1,19 +1,23 @@
 package godb
 
 import (
+	"strings"
 	"testing"
 	"unsafe"
 )
 
+// Number of ("sam", int) tuples that fit on a page: each takes a slot
+// directory entry, a string length and the string, and an int.
+var samTuplesPerPage = (PageSize - 8) / (4 + 4 + len("sam") + int(unsafe.Sizeof(int64(0))))
+
 func TestHeapPageInsert(t *testing.T) {
 	td, t1, t2, hf, _, _ := makeTestVars(t)
 	pg, err := newHeapPage(&td, 0, hf)
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	var expectedSlots = (PageSize - 8) / (StringLength + int(unsafe.Sizeof(int64(0))))
-	if pg.getNumSlots() != expectedSlots {
-		t.Fatalf("Incorrect number of slots, expected %d, got %d", expectedSlots, pg.getNumSlots())
+	if pg.getNumSlots() != 0 {
+		t.Fatalf("Incorrect number of slots on new page, expected 0, got %d", pg.getNumSlots())
 	}
 
 	_, err = pg.insertTuple(&t1)
@@ -25,6 +29,9 @@ func TestHeapPageInsert(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
+	if pg.getNumSlots() != 2 {
+		t.Fatalf("Incorrect number of slots, expected 2, got %d", pg.getNumSlots())
+	}
 
 	iter := pg.tupleIter()
 	if iter == nil {
@@ -85,7 +92,7 @@ func TestHeapPageInsertTuple(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	free := page.getNumSlots()
+	free := samTuplesPerPage
 
 	for i := 0; i < free; i++ {
 		var addition = Tuple{
@@ -134,7 +141,7 @@ func TestHeapPageDeleteTuple(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	free := page.getNumSlots()
+	free := samTuplesPerPage
 
 	list := make([]recordID, free)
 	for i := 0; i < free; i++ {
@@ -200,7 +207,7 @@ func TestHeapPageSerialization(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	free := page.getNumSlots()
+	free := samTuplesPerPage
 
 	for i := 0; i < free-1; i++ {
 		var addition = Tuple{
@@ -258,7 +265,7 @@ func TestHeapPageBufferLen(t *testing.T) {
 	if err != nil {
 		t.Fatalf(err.Error())
 	}
-	free := page.getNumSlots()
+	free := samTuplesPerPage
 
 	for i := 0; i < free-1; i++ {
 		var addition = Tuple{
@@ -277,3 +284,51 @@ func TestHeapPageBufferLen(t *testing.T) {
 		t.Fatalf("HeapPage.toBuffer returns buffer of unexpected size;  NOTE:  This error may be OK, but many implementations that don't write full pages break.")
 	}
 }
+
+// Slot numbers survive a round trip through a buffer, and the space of
+// deleted tuples is reused.
+func TestHeapPageSlotsAfterDelete(t *testing.T) {
+	td, _, _, hf, _, _ := makeTestVars(t)
+	page, err := newHeapPage(&td, 0, hf)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	long := Tuple{Desc: td, Fields: []DBValue{StringField{strings.Repeat("x", 1000)}, IntField{1}}}
+	short := Tuple{Desc: td, Fields: []DBValue{StringField{"sam"}, IntField{2}}}
+	long2 := long
+	if _, err := page.insertTuple(&long); err != nil {
+		t.Fatalf(err.Error())
+	}
+	rid, err := page.insertTuple(&short)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for {
+		l := long2
+		if _, err := page.insertTuple(&l); err != nil {
+			break
+		}
+	}
+	if err := page.deleteTuple(long.Rid); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := page.insertTuple(&long2); err != nil {
+		t.Fatalf("expected space of deleted tuple to be reused: %s", err.Error())
+	}
+	if long2.Rid != long.Rid {
+		t.Errorf("expected free slot %v to be reused, got %v", long.Rid, long2.Rid)
+	}
+
+	buf, err := page.toBuffer()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	page2, _ := newHeapPage(&td, 0, hf)
+	if err := page2.initFromBuffer(buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	slot := rid.(heapFileRid).slotNo
+	if page2.getNumSlots() != page.getNumSlots() || !page2.tuples[slot].equals(&short) || page2.tuples[slot].Rid != rid {
+		t.Errorf("tuple did not keep its slot after serialization")
+	}
+}
@@ -0,0 +1,104 @@
+package godb
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Create an empty catalog whose tables are stored in a temporary directory.
+func makeEmptyTestCatalog(t *testing.T) (*BufferPool, *Catalog) {
+	t.Helper()
+	bp, err := NewBufferPool(1000)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	return bp, NewCatalog("catalog.txt", bp, t.TempDir())
+}
+
+// Create a catalog whose tables are stored in a temporary directory, and run
+// the given statements in it.
+func makeTestCatalogWith(t *testing.T, stmts ...string) (*BufferPool, *Catalog) {
+	t.Helper()
+	bp, c := makeEmptyTestCatalog(t)
+	for _, sql := range stmts {
+		mustExecForTest(t, c, bp, sql)
+	}
+	return bp, c
+}
+
+// Returns an insert statement that adds n rows to a table, with the values of
+// row i given by row.
+func insertForTest(table string, n int, row func(i int) string) string {
+	rows := make([]string, n)
+	for i := range rows {
+		rows[i] = row(i)
+	}
+	return "insert into " + table + " values " + strings.Join(rows, ", ")
+}
+
+// Parse and run a statement in its own transaction, returning the tuples it
+// produced.
+func execForTest(t *testing.T, c *Catalog, bp *BufferPool, sql string) ([]*Tuple, error) {
+	t.Helper()
+	qType, op, err := Parse(c, sql)
+	if err != nil {
+		return nil, err
+	}
+	if qType != IteratorType && qType != CreateTableAsQueryType {
+		return nil, nil
+	}
+	tid := BeginTransactionForTest(t, bp)
+	defer bp.CommitTransaction(tid)
+	iter, err := op.Iterator(tid)
+	if err != nil {
+		return nil, err
+	}
+	var tups []*Tuple
+	for {
+		tup, err := iter()
+		if err != nil {
+			return nil, err
+		}
+		if tup == nil {
+			return tups, nil
+		}
+		tups = append(tups, tup)
+	}
+}
+
+// Like execForTest, but fails the test if the statement fails.
+func mustExecForTest(t *testing.T, c *Catalog, bp *BufferPool, sql string) []*Tuple {
+	t.Helper()
+	tups, err := execForTest(t, c, bp, sql)
+	if err != nil {
+		t.Fatalf("%s: %s", sql, err.Error())
+	}
+	return tups
+}
+
+// Returns the number of rows of a table.
+func countRowsForTest(t *testing.T, c *Catalog, bp *BufferPool, table string) int64 {
+	t.Helper()
+	tups := mustExecForTest(t, c, bp, "select count(*) from "+table)
+	return tups[0].Fields[0].(IntField).Value
+}
+
+// Returns the rows of a query as a string, with the values of a row
+// separated by commas and the rows separated by spaces.  If sorted is set,
+// the rows are sorted, for queries whose order is not defined.
+func rowsForTest(t *testing.T, c *Catalog, bp *BufferPool, sql string, sorted bool) string {
+	t.Helper()
+	var rows []string
+	for _, tup := range mustExecForTest(t, c, bp, sql) {
+		vals := make([]string, len(tup.Fields))
+		for i, v := range tup.Fields {
+			vals[i] = valueString(v)
+		}
+		rows = append(rows, strings.Join(vals, ","))
+	}
+	if sorted {
+		sort.Strings(rows)
+	}
+	return strings.Join(rows, " ")
+}
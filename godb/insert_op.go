@@ -3,6 +3,14 @@ package godb
 type InsertOp struct {
 	file DBFile
 	op   Operator
+
+	// catalog used to enforce the foreign keys of file; may be nil, in which
+	// case no constraints are checked
+	catalog *Catalog
+
+	// table of a CREATE TABLE AS statement, which file stores and which is
+	// added to the catalog once all tuples are inserted; nil for an INSERT
+	create *pendingTable
 }
 
 // Construct an insert operator that inserts the records in the child Operator
@@ -22,35 +30,78 @@ func (i *InsertOp) Descriptor() *TupleDesc {
 // were inserted.  Tuples should be inserted using the [DBFile.insertTuple]
 // method.
 func (iop *InsertOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(iop.Open(tid))
+}
+
+func (iop *InsertOp) Open(tid TransactionID) (*OpIterator, error) {
 	completed := false
+	var it *OpIterator
 
-	return func() (*Tuple, error) {
-		count := int64(0)
-		if !completed {
-			// do all the insertion stuff
-			it, err := iop.op.Iterator(tid)
-			if err != nil {
+	return newOpIterator(func() (*Tuple, error) {
+		if completed {
+			return nil, nil
+		}
+		if iop.create != nil {
+			if err := iop.create.create(iop.file.(*HeapFile)); err != nil {
 				return nil, err
 			}
-			for {
-				tuple, err := it()
-				if err != nil {
-					return nil, err
-				}
-				if tuple == nil {
-					break
-				}
-
-				if err := iop.file.insertTuple(tuple, tid); err != nil {
-					return nil, err
-				} else {
-					count++
-				}
+		}
+		var err error
+		it, err = iop.op.Open(tid)
+		count := int64(0)
+		if err == nil {
+			count, err = iop.insertAll(it, tid)
+		}
+		if err == nil && iop.create != nil {
+			err = iop.create.register(iop.file.(*HeapFile))
+		}
+		if err != nil {
+			if iop.create != nil {
+				iop.create.discard(iop.file.(*HeapFile))
 			}
-
-			completed = true
+			return nil, err
 		}
 
+		completed = true
+		if iop.catalog != nil {
+			iop.catalog.noteModifications(iop.file, count)
+		}
 		return &Tuple{Desc: *iop.Descriptor(), Fields: []DBValue{IntField{count}}}, nil
-	}, nil
+	}, func() error {
+		return closeIterators(it)
+	}), nil
+}
+
+// Insert the tuples of it into the file, returning how many were inserted.
+func (iop *InsertOp) insertAll(it *OpIterator, tid TransactionID) (int64, error) {
+	fks := &fkChecker{}
+	if iop.catalog != nil {
+		var err error
+		fks, err = iop.catalog.newFKChecker(iop.file)
+		if err != nil {
+			return 0, err
+		}
+	}
+	count := int64(0)
+	for {
+		if err := checkCanceled(tid); err != nil {
+			return 0, err
+		}
+		tuple, err := it.Next()
+		if err != nil {
+			return 0, err
+		}
+		if tuple == nil {
+			return count, nil
+		}
+
+		if err := fks.check(tuple, tid); err != nil {
+			return 0, err
+		}
+		if err := iop.file.insertTuple(tuple, tid); err != nil {
+			return 0, err
+		}
+		fks.inserted(tuple)
+		count++
+	}
 }
@@ -4,7 +4,14 @@ import (
 	"fmt"
 )
 
+// A fixed-width histogram over int64 values.  The range [vMin, vMax] is split
+// into bins of equal width, and each bin counts the values that fall in it.
 type IntHistogram struct {
+	vMin  int64
+	vMax  int64
+	width float64 // width of each bin; at least 1
+	bins  []int64
+	count int64
 }
 
 // NewIntHistogram creates a new IntHistogram with the specified number of bins.
@@ -12,11 +19,33 @@ type IntHistogram struct {
 // Min and max specify the range of values that the histogram will cover
 // (inclusive).
 func NewIntHistogram(nBins int64, vMin int64, vMax int64) (*IntHistogram, error) {
-	return nil, fmt.Errorf("NewIntHistogram unimplemented")
+	if nBins <= 0 || vMax < vMin {
+		return nil, fmt.Errorf("invalid histogram of %d bins over [%d, %d]", nBins, vMin, vMax)
+	}
+	// computed in floating point, since the span may not fit in an int64
+	span := float64(vMax) - float64(vMin) + 1
+	if float64(nBins) > span {
+		nBins = int64(span)
+	}
+	return &IntHistogram{vMin, vMax, span / float64(nBins), make([]int64, nBins), 0}, nil
 }
 
-// Add a value v to the histogram.
+// Returns the bin that v falls in, which must be in the range of h.
+func (h *IntHistogram) bin(v int64) int {
+	b := int((float64(v) - float64(h.vMin)) / h.width)
+	return min(max(b, 0), len(h.bins)-1)
+}
+
+// Returns the lower bound of bin b.
+func (h *IntHistogram) binStart(b int) float64 {
+	return float64(h.vMin) + float64(b)*h.width
+}
+
+// Add a value v to the histogram.  Values outside the range of the histogram
+// are counted in the first or last bin.
 func (h *IntHistogram) AddValue(v int64) {
+	h.bins[h.bin(v)]++
+	h.count++
 }
 
 // Estimate the selectivity of a predicate and operand on the values represented
@@ -25,5 +54,50 @@ func (h *IntHistogram) AddValue(v int64) {
 // For example, if op is OpLt and v is 10, return the fraction of values that
 // are less than 10.
 func (h *IntHistogram) EstimateSelectivity(op BoolOp, v int64) float64 {
-	return 0.0
+	if h.count == 0 {
+		return 0.0
+	}
+	switch op {
+	case OpEq:
+		if v < h.vMin || v > h.vMax {
+			return 0.0
+		}
+		return float64(h.bins[h.bin(v)]) / h.width / float64(h.count)
+	case OpNeq:
+		return 1.0 - h.EstimateSelectivity(OpEq, v)
+	case OpGt:
+		if v < h.vMin {
+			return 1.0
+		}
+		if v >= h.vMax {
+			return 0.0
+		}
+		b := h.bin(v)
+		// the part of v's bin that is above v, plus the bins after it
+		frac := (h.binStart(b+1) - float64(v) - 1) / h.width
+		sum := float64(h.bins[b]) * min(max(frac, 0), 1)
+		for _, n := range h.bins[b+1:] {
+			sum += float64(n)
+		}
+		return sum / float64(h.count)
+	case OpGe:
+		return h.EstimateSelectivity(OpGt, v) + h.EstimateSelectivity(OpEq, v)
+	case OpLt:
+		if v <= h.vMin {
+			return 0.0
+		}
+		if v > h.vMax {
+			return 1.0
+		}
+		b := h.bin(v)
+		frac := (float64(v) - h.binStart(b)) / h.width
+		sum := float64(h.bins[b]) * min(max(frac, 0), 1)
+		for _, n := range h.bins[:b] {
+			sum += float64(n)
+		}
+		return sum / float64(h.count)
+	case OpLe:
+		return h.EstimateSelectivity(OpLt, v) + h.EstimateSelectivity(OpEq, v)
+	}
+	return 1.0
 }
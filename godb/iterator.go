@@ -0,0 +1,87 @@
+package godb
+
+// The lifecycle of the iterators of operators.
+//
+// An operator is opened with [Operator.Open], which returns an [OpIterator]
+// whose Next method returns its tuples, and whose Close method releases what
+// it holds: the iterators of its children, temporary files such as the runs
+// of an external sort, and in-memory state such as hash tables.  An iterator
+// closes itself once it has returned its last tuple or an error, so a plan
+// read to the end needs no call to Close, but one that is abandoned early,
+// e.g., by a limit, on an error or when a query is canceled, must be closed.
+//
+// [Operator.Iterator] returns the Next method of an opened iterator, for the
+// callers that always read their iterators to the end.
+
+import "sync/atomic"
+
+// An open iterator over the tuples of an operator.
+type OpIterator struct {
+	next  func() (*Tuple, error)
+	close func() error // releases the resources of the iterator, if set
+	done  bool
+}
+
+// The number of iterators that have been opened and not closed, to check
+// that operators close the iterators of their children.
+var openIterators atomic.Int64
+
+// Returns an iterator whose tuples are returned by next, and whose resources
+// are released by close, which may be nil.
+func newOpIterator(next func() (*Tuple, error), close func() error) *OpIterator {
+	openIterators.Add(1)
+	return &OpIterator{next: next, close: close}
+}
+
+// Returns the next tuple, or nil once there are no more.  The iterator is
+// closed when it returns nil or an error.
+func (it *OpIterator) Next() (*Tuple, error) {
+	if it.done {
+		return nil, nil
+	}
+	t, err := it.next()
+	if t == nil || err != nil {
+		if cerr := it.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return t, err
+}
+
+// Release the resources of the iterator.  Closing a closed iterator has no
+// effect.
+func (it *OpIterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.done = true
+	openIterators.Add(-1)
+	if it.close != nil {
+		return it.close()
+	}
+	return nil
+}
+
+// Returns the Next method of an opened iterator, as the iterator function of
+// [Operator.Iterator].
+func iteratorOf(it *OpIterator, err error) (func() (*Tuple, error), error) {
+	if err != nil {
+		return nil, err
+	}
+	return it.Next, nil
+}
+
+// Close the iterators, returning the first error.  Nil iterators are
+// skipped.
+func closeIterators(its ...*OpIterator) error {
+	var first error
+	for _, it := range its {
+		if it == nil {
+			continue
+		}
+		if err := it.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
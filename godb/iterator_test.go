@@ -0,0 +1,92 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHeapFileIteratorClose(t *testing.T) {
+	_, t1, t2, hf, _, tid := makeTestVars(t)
+	for i := 0; i < 10; i++ {
+		hf.insertTuple(&t1, tid)
+		hf.insertTuple(&t2, tid)
+	}
+	open := openIterators.Load()
+	it, err := hf.Open(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup, err := it.Next(); tup == nil || err != nil {
+		t.Fatalf("expected a tuple, got %v (%v)", tup, err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup, err := it.Next(); tup != nil || err != nil {
+		t.Errorf("expected no tuples after closing, got %v (%v)", tup, err)
+	}
+	if err := it.Close(); err != nil {
+		t.Errorf("expected closing twice to have no effect, got %v", err)
+	}
+	if n := openIterators.Load(); n != open {
+		t.Errorf("expected %d open iterators, got %d", open, n)
+	}
+}
+
+// Queries that are abandoned after their first row close every iterator of
+// their plans, and remove the runs of their sorts.
+func TestAbandonedQueriesReleaseResources(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (id int, grp int, name varchar)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	values := make([]string, 2000)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, %d, 'name %d')", i, i%10, (i*7919)%2000)
+	}
+	if _, err := db.Exec("insert into t values " + strings.Join(values, ", ")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	defer func(budget int, enabled bool) {
+		MemoryBudget, EnablePhysicalOptimization = budget, enabled
+	}(MemoryBudget, EnablePhysicalOptimization)
+	MemoryBudget = 5000
+	queries := []string{
+		"select a.id, b.id from t a join t b on a.grp = b.grp",
+		"select a.id, b.id from t a join t b on a.grp = b.grp limit 3",
+		"select id, name from t order by name",
+		"select id, name from t order by name limit 2",
+		"select grp, count(*) from t group by grp",
+		"select distinct name from t limit 1",
+		"select name from t where grp = 3 limit 1",
+	}
+	for _, physical := range []bool{true, false} {
+		EnablePhysicalOptimization = physical
+		for _, sql := range queries {
+			open := openIterators.Load()
+			rows, err := db.Query(sql)
+			if err != nil {
+				t.Fatalf("%s: %s", sql, err.Error())
+			}
+			if !rows.Next() {
+				t.Errorf("%s: expected a row, got none (%v)", sql, rows.Err())
+			}
+			rows.Close()
+			if n := openIterators.Load(); n != open {
+				t.Errorf("%s: expected %d open iterators, got %d", sql, open, n)
+			}
+			if runs, _ := filepath.Glob(filepath.Join(os.TempDir(), "godb-sort-*")); len(runs) != 0 {
+				t.Errorf("%s: expected the sort runs to be removed, found %d", sql, len(runs))
+			}
+		}
+	}
+}
@@ -45,38 +45,52 @@ func (hj *EqualityJoin) Descriptor() *TupleDesc {
 // out. To pass this test, you will need to use something other than a nested
 // loops join.
 func (joinOp *EqualityJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
-	leftIter, err := (*joinOp.left).Iterator(tid)
+	return iteratorOf(joinOp.Open(tid))
+}
+
+// Open an iterator over the joined tuples, which rescans the right child for
+// each tuple of the left one.  Closing it closes the iterators of both.
+func (joinOp *EqualityJoin) Open(tid TransactionID) (*OpIterator, error) {
+	leftIter, err := (*joinOp.left).Open(tid)
 	if err != nil {
 		return nil, err
 	}
 
-	rightIter, err := (*joinOp.right).Iterator(tid)
+	rightIter, err := (*joinOp.right).Open(tid)
 	if err != nil {
+		leftIter.Close()
 		return nil, err
 	}
 
-	leftTuple, err := leftIter()
+	leftTuple, err := leftIter.Next()
 	if err != nil {
+		rightIter.Close()
 		return nil, err
 	}
-	if leftTuple == nil {
-		return nil, nil
-	}
+	leftField := compileExpr(joinOp.leftField, (*joinOp.left).Descriptor())
+	rightField := compileExpr(joinOp.rightField, (*joinOp.right).Descriptor())
 
-	return func() (*Tuple, error) {
+	return newOpIterator(func() (*Tuple, error) {
 		for {
-			leftVal, err := joinOp.leftField.EvalExpr(leftTuple)
+			// the left input is exhausted, keep reporting the end of the join
+			if leftTuple == nil {
+				return nil, nil
+			}
+			leftVal, err := leftField(leftTuple)
 			if err != nil {
 				return nil, err
 			}
 
 			for {
-				rightTuple, err := rightIter()
+				if err := checkCanceled(tid); err != nil {
+					return nil, err
+				}
+				rightTuple, err := rightIter.Next()
 				if err != nil {
 					return nil, err
 				}
 				if rightTuple == nil {
-					leftTuple, err = leftIter()
+					leftTuple, err = leftIter.Next()
 					if err != nil {
 						return nil, err
 					}
@@ -84,7 +98,7 @@ func (joinOp *EqualityJoin) Iterator(tid TransactionID) (func() (*Tuple, error),
 						return nil, nil
 					}
 
-					rightIter, err = (*joinOp.right).Iterator(tid)
+					rightIter, err = (*joinOp.right).Open(tid)
 					if err != nil {
 						return nil, err
 					}
@@ -92,7 +106,7 @@ func (joinOp *EqualityJoin) Iterator(tid TransactionID) (func() (*Tuple, error),
 					break
 				}
 
-				rightVal, err := joinOp.rightField.EvalExpr(rightTuple)
+				rightVal, err := rightField(rightTuple)
 				if err != nil {
 					return nil, err
 				}
@@ -102,5 +116,8 @@ func (joinOp *EqualityJoin) Iterator(tid TransactionID) (func() (*Tuple, error),
 				}
 			}
 		}
-	}, nil
+	}, func() error {
+		leftTuple = nil
+		return closeIterators(leftIter, rightIter)
+	}), nil
 }
@@ -22,28 +22,34 @@ func (l *LimitOp) Descriptor() *TupleDesc {
 // of the child iterator, and limit the result set to the first [lim] tuples it
 // sees (where lim is specified in the constructor).
 func (l *LimitOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(l.Open(tid))
+}
+
+// Open an iterator over the first tuples of the child.  The child's iterator
+// is closed as soon as the limit is reached.
+func (l *LimitOp) Open(tid TransactionID) (*OpIterator, error) {
 	cnt := int64(0)
 	limit, err := l.limitTups.EvalExpr(nil)
 	if err != nil {
 		return nil, err
 	}
 
-	it, err := l.child.Iterator(tid)
+	it, err := l.child.Open(tid)
 	if err != nil {
 		return nil, err
 	}
 
-	return func() (*Tuple, error) {
-		tup, err := it()
-		if err != nil {
-			return nil, err
-		}
-		if tup == nil || limit.EvalPred(IntField{cnt}, OpEq) {
+	return newOpIterator(func() (*Tuple, error) {
+		if limit.EvalPred(IntField{cnt}, OpEq) {
 			return nil, nil
 		}
+		tup, err := it.Next()
+		if err != nil || tup == nil {
+			return nil, err
+		}
 
 		cnt++
 		return tup, nil
 
-	}, nil
+	}, it.Close), nil
 }
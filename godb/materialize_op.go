@@ -0,0 +1,72 @@
+package godb
+
+// Materialize keeps the tuples of its child in memory the first time they are
+// read in a transaction, and returns them from memory when it is iterated over
+// again in that transaction.  The planner puts it on the inner input of a
+// nested loops join, which is read once per tuple of the outer input.
+type Materialize struct {
+	child Operator
+
+	tid      TransactionID // the transaction tuples were stored for
+	tuples   []*Tuple
+	complete bool // whether tuples holds all the tuples of child
+}
+
+func NewMaterialize(child Operator) *Materialize {
+	return &Materialize{child: child}
+}
+
+func (m *Materialize) Descriptor() *TupleDesc {
+	return m.child.Descriptor()
+}
+
+// Returns an iterator over the stored tuples if every tuple of the child was
+// stored for this transaction, and otherwise an iterator over the child that
+// stores its tuples as they are read.  The tuples of an iterator over the
+// child that is closed before its end are not kept.
+func (m *Materialize) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(m.Open(tid))
+}
+
+func (m *Materialize) Open(tid TransactionID) (*OpIterator, error) {
+	if m.complete && m.tid == tid {
+		tuples := m.tuples
+		i := 0
+		return newOpIterator(func() (*Tuple, error) {
+			if i == len(tuples) {
+				return nil, nil
+			}
+			i++
+			return tuples[i-1], nil
+		}, nil), nil
+	}
+
+	it, err := m.child.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	m.tid, m.tuples, m.complete = tid, nil, false
+	var tuples []*Tuple
+	done := false
+	return newOpIterator(func() (*Tuple, error) {
+		if done {
+			return nil, nil
+		}
+		t, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			done = true
+			if m.tid == tid {
+				m.tuples, m.complete = tuples, true
+			}
+			return nil, nil
+		}
+		tuples = append(tuples, t)
+		return t, nil
+	}, func() error {
+		tuples = nil
+		return it.Close()
+	}), nil
+}
@@ -65,12 +65,19 @@ func (mf *MemFile) Descriptor() *TupleDesc {
 }
 
 func (mf *MemFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(mf.Open(tid))
+}
+
+func (mf *MemFile) Open(tid TransactionID) (*OpIterator, error) {
 	i := 0
-	return func() (*Tuple, error) {
+	return newOpIterator(func() (*Tuple, error) {
 		for {
 			if i >= len(mf.pages) {
 				return nil, nil
 			}
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
 			page := mf.pages[i]
 			if page == nil {
 				i++
@@ -80,7 +87,7 @@ func (mf *MemFile) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 			i++
 			return &page.tuple, nil
 		}
-	}, nil
+	}, nil), nil
 }
 
 func CreateMemFileFromTuples(tuples []Tuple) *MemFile {
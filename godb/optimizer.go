@@ -0,0 +1,1320 @@
+package godb
+
+// The logical optimizer.
+//
+// Before the FROM and WHERE clauses of a query block ([LogicalPlan]) are
+// planned physically, they are turned into a tree of logical operators: the
+// tables and FROM subqueries the block reads ([logicalScan] and
+// [logicalSubquery]), a join of all of them whose order is left to the
+// physical planner ([logicalJoin]), and filters and projections applied to
+// them ([logicalFilter] and [logicalProject]).  Initially, the WHERE clause is
+// a single filter above the join.  The rules in [logicalRules] then rewrite
+// the tree:
+//
+//   - constant predicates are evaluated, and predicates are put in the form
+//     column op constant where possible;
+//   - predicates are pushed down through the join to the relation they refer
+//     to, and into FROM subqueries where that does not change the result of
+//     the subquery; a predicate on a join column is also applied to the column
+//     it is joined with;
+//   - duplicate predicates, and bounds implied by tighter ones, are removed,
+//     and contradictory predicates are replaced by a false one;
+//   - the inputs of a join are projected onto the columns needed above it.
+//
+// Constants in expressions are folded as the expressions are generated (see
+// [foldConstants]).  The subqueries of a block are optimized when they are
+// planned, after the predicates of the block have been pushed into them.
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Set to false to plan the logical tree of each query block as it was parsed,
+// e.g., to compare the results of optimized and unoptimized plans.
+var EnableLogicalOptimization = true
+
+// An operator of the logical tree of a query block.
+type logicalOp interface {
+	children() []logicalOp
+	String() string
+}
+
+// A table read by the query block.
+type logicalScan struct {
+	name  string // the name the table is referred to by in the block
+	table *LogicalTableNode
+}
+
+// A subquery or view in the FROM clause.
+type logicalSubquery struct {
+	name string
+	plan *LogicalPlan
+}
+
+// The conjunction of preds applied to input.
+type logicalFilter struct {
+	preds []*LogicalFilterNode
+	input logicalOp
+}
+
+// Keeps the columns of input that have one of the given names.
+type logicalProject struct {
+	columns []string
+	input   logicalOp
+}
+
+// The inner join of two or more relations on a conjunction of equalities.
+// The order the relations are joined in is chosen by the physical planner
+// (see [OrderJoins]).
+type logicalJoin struct {
+	conds []*LogicalJoinNode
+	rels  []logicalOp
+}
+
+func (s *logicalScan) children() []logicalOp     { return nil }
+func (s *logicalSubquery) children() []logicalOp { return nil }
+func (f *logicalFilter) children() []logicalOp   { return []logicalOp{f.input} }
+func (p *logicalProject) children() []logicalOp  { return []logicalOp{p.input} }
+func (j *logicalJoin) children() []logicalOp     { return j.rels }
+
+func (s *logicalScan) String() string {
+	if s.name != s.table.tableName {
+		return fmt.Sprintf("Scan %s AS %s", s.table.tableName, s.name)
+	}
+	return "Scan " + s.name
+}
+
+func (s *logicalSubquery) String() string {
+	return "Subquery " + s.name
+}
+
+func (f *logicalFilter) String() string {
+	preds := make([]string, len(f.preds))
+	for i, p := range f.preds {
+		preds[i] = predString(p)
+	}
+	return "Filter " + strings.Join(preds, " AND ")
+}
+
+func (p *logicalProject) String() string {
+	return "Project " + strings.Join(p.columns, ", ")
+}
+
+func (j *logicalJoin) String() string {
+	conds := make([]string, len(j.conds))
+	for i, cond := range j.conds {
+		conds[i] = exprKey(cond.left) + " = " + exprKey(cond.right)
+	}
+	return "Join " + strings.Join(conds, " AND ")
+}
+
+// Returns the tree rooted at op, one operator per line, with the inputs of
+// each operator indented below it.
+func logicalTreeString(op logicalOp) string {
+	var lines []string
+	var visit func(op logicalOp, indent string)
+	visit = func(op logicalOp, indent string) {
+		lines = append(lines, indent+op.String())
+		for _, child := range op.children() {
+			visit(child, indent+"  ")
+		}
+	}
+	visit(op, "")
+	return strings.Join(lines, "\n")
+}
+
+// Returns a canonical string for an expression, ignoring its alias, so that
+// equivalent expressions can be recognized.
+func exprKey(e *LogicalSelectNode) string {
+	switch e.exprType {
+	case ExprField:
+		if e.table != "" {
+			return e.table + "." + e.field
+		}
+		return e.field
+	case ExprConst:
+		if e.constType == StringType {
+			return "'" + e.value + "'"
+		}
+		return e.value
+	case ExprStar:
+		if e.table != "" {
+			return e.table + ".*"
+		}
+		return "*"
+	case ExprParam:
+		return e.value
+	}
+	args := make([]string, len(e.args))
+	for i, arg := range e.args {
+		args[i] = exprKey(arg)
+	}
+	return *e.funcOp + "(" + strings.Join(args, ", ") + ")"
+}
+
+func predString(p *LogicalFilterNode) string {
+	return exprKey(&p.fieldExpr) + " " + strings.TrimSpace(p.predOp.String()) + " " + exprKey(&p.constExpr)
+}
+
+// The state of planning a query block: the relations it reads and, once its
+// logical tree has been planned physically, their operators.
+type queryBlock struct {
+	c         *Catalog
+	plan      *LogicalPlan
+	relations map[string]logicalOp // the scans and subqueries of the block, by name
+	names     []string             // the names of the relations, in the order they are planned
+
+	tableMap   map[string]*PlanNode // mapping from relation names to operators
+	tableStats map[string]Stats     // mapping from relation names to table stats
+	sel        map[string]float64   // mapping from relation names to selectivities
+}
+
+func newQueryBlock(c *Catalog, plan *LogicalPlan) (*queryBlock, error) {
+	b := &queryBlock{c, plan, make(map[string]logicalOp), nil,
+		make(map[string]*PlanNode), make(map[string]Stats), make(map[string]float64)}
+	add := func(name string, rel logicalOp) error {
+		if _, ok := b.relations[name]; ok {
+			return GoDBError{AmbiguousNameError, fmt.Sprintf("table name %s specified more than once; use an alias", name)}
+		}
+		b.relations[name] = rel
+		b.names = append(b.names, name)
+		return nil
+	}
+	for _, p := range plan.subqueries {
+		if err := add(p.alias, &logicalSubquery{p.alias, p}); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range plan.tables {
+		name := t.tableName
+		if t.alias != "" {
+			name = t.alias
+		}
+		if err := add(name, &logicalScan{name, t}); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// Returns the logical tree of the block as it was parsed: its WHERE clause
+// applied to the join of its relations.
+func (b *queryBlock) logicalTree() logicalOp {
+	var root logicalOp
+	if len(b.names) == 1 && len(b.plan.joins) == 0 {
+		root = b.relations[b.names[0]]
+	} else {
+		rels := make([]logicalOp, len(b.names))
+		for i, name := range b.names {
+			rels[i] = b.relations[name]
+		}
+		root = &logicalJoin{b.plan.joins, rels}
+	}
+	if len(b.plan.filters) > 0 {
+		root = &logicalFilter{b.plan.filters, root}
+	}
+	return root
+}
+
+// A rewrite rule returns the tree it rewrites op into.  Rules do not modify
+// the operators or predicates of the tree they are given.
+type rewriteRule func(b *queryBlock, op logicalOp) (logicalOp, error)
+
+// The rules applied by [queryBlock.optimize], in order.
+var logicalRules = []rewriteRule{
+	(*queryBlock).normalizePredicates,
+	(*queryBlock).pushDownPredicates,
+	(*queryBlock).eliminateRedundantPredicates,
+	(*queryBlock).pruneColumns,
+}
+
+// Rewrite the logical tree rooted at root with each of the [logicalRules].
+func (b *queryBlock) optimize(root logicalOp) (logicalOp, error) {
+	for _, rule := range logicalRules {
+		var err error
+		if root, err = rule(b, root); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// Returns op with f applied to each of its operators, inputs first.
+func transformUp(op logicalOp, f func(logicalOp) (logicalOp, error)) (logicalOp, error) {
+	var err error
+	switch n := op.(type) {
+	case *logicalFilter:
+		var input logicalOp
+		if input, err = transformUp(n.input, f); err != nil {
+			return nil, err
+		}
+		op = &logicalFilter{n.preds, input}
+	case *logicalProject:
+		var input logicalOp
+		if input, err = transformUp(n.input, f); err != nil {
+			return nil, err
+		}
+		op = &logicalProject{n.columns, input}
+	case *logicalJoin:
+		rels := make([]logicalOp, len(n.rels))
+		for i, rel := range n.rels {
+			if rels[i], err = transformUp(rel, f); err != nil {
+				return nil, err
+			}
+		}
+		op = &logicalJoin{n.conds, rels}
+	}
+	return f(op)
+}
+
+// Returns the name of the scan or subquery at the bottom of op, which must not
+// contain a join.
+func relationName(op logicalOp) string {
+	switch n := op.(type) {
+	case *logicalScan:
+		return n.name
+	case *logicalSubquery:
+		return n.name
+	}
+	return relationName(op.children()[0])
+}
+
+// Returns the name of the relation table refers to, which is either its name
+// in the block or, if it has an alias, the name of the table itself.
+func (b *queryBlock) resolveRelation(table string) (string, bool) {
+	if _, ok := b.relations[table]; ok {
+		return table, true
+	}
+	found := ""
+	for _, name := range b.names {
+		if s, ok := b.relations[name].(*logicalScan); ok && s.table.tableName == table {
+			if found != "" {
+				return "", false
+			}
+			found = name
+		}
+	}
+	return found, found != ""
+}
+
+// Returns the name of the relation a (possibly unqualified) column refers to.
+// Returns false if there is no such relation, or more than one.
+func (b *queryBlock) resolve(table string, field string) (string, bool) {
+	if table != "" {
+		return b.resolveRelation(table)
+	}
+	found := ""
+	for _, name := range b.names {
+		if b.hasColumn(name, field) {
+			if found != "" {
+				return "", false
+			}
+			found = name
+		}
+	}
+	return found, found != ""
+}
+
+// Returns true if the named relation has a column called field.
+func (b *queryBlock) hasColumn(name string, field string) bool {
+	switch rel := b.relations[name].(type) {
+	case *logicalScan:
+		_, err := findFieldInTd(FieldType{field, "", UnknownType}, (*rel.table.file).Descriptor())
+		return err == nil
+	case *logicalSubquery:
+		for i, f := range rel.plan.getSubplanFields(b.c) {
+			if f.Fname == field || rel.plan.selects[i].alias == field {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the type of a column of the named relation, if it is known before
+// the relation is planned, i.e., if the relation is a table.
+func (b *queryBlock) columnType(name string, field string) (DBType, bool) {
+	rel, ok := b.relations[name].(*logicalScan)
+	if !ok {
+		return UnknownType, false
+	}
+	desc := (*rel.table.file).Descriptor()
+	i, err := findFieldInTd(FieldType{field, "", UnknownType}, desc)
+	if err != nil {
+		return UnknownType, false
+	}
+	return desc.Fields[i].Ftype, true
+}
+
+// Add the columns e refers to to cols, a map from relation names to column
+// names, in which "*" stands for all of a relation's columns.  Returns false if
+// some column cannot be resolved to a relation.
+func (b *queryBlock) columnsUsed(e *LogicalSelectNode, cols map[string]map[string]bool) bool {
+	use := func(rel string, field string) {
+		if cols[rel] == nil {
+			cols[rel] = make(map[string]bool)
+		}
+		cols[rel][field] = true
+	}
+	switch e.exprType {
+	case ExprStar:
+		if e.table == "" {
+			for _, name := range b.names {
+				use(name, "*")
+			}
+			return true
+		}
+		rel, ok := b.resolveRelation(e.table)
+		if ok {
+			use(rel, "*")
+		}
+		return ok
+	case ExprField:
+		if e.field == "*" { // the argument of count(*)
+			return true
+		}
+		rel, ok := b.resolve(e.table, e.field)
+		if ok {
+			use(rel, e.field)
+		}
+		return ok
+	}
+	for _, arg := range e.args {
+		if !b.columnsUsed(arg, cols) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the names of the relations e refers to, in sorted order, or false if
+// some column of e cannot be resolved.
+func (b *queryBlock) relationsOf(e ...*LogicalSelectNode) ([]string, bool) {
+	cols := make(map[string]map[string]bool)
+	for _, e := range e {
+		if !b.columnsUsed(e, cols) {
+			return nil, false
+		}
+	}
+	names := make([]string, 0, len(cols))
+	for name := range cols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, true
+}
+
+// Returns true if e has the same value wherever it is evaluated, i.e., it
+// refers to no columns and calls no functions without arguments, such as
+// rand() and now().  Parameters are not constant, since a plan may be run
+// with different values for them.
+func isConstant(e *LogicalSelectNode) bool {
+	switch e.exprType {
+	case ExprConst:
+		return true
+	case ExprFunc:
+		for _, arg := range e.args {
+			if !isConstant(arg) {
+				return false
+			}
+		}
+		return len(e.args) > 0
+	}
+	return false
+}
+
+func containsAggregate(e *LogicalSelectNode) bool {
+	if e.exprType == ExprAggr {
+		return true
+	}
+	for _, arg := range e.args {
+		if containsAggregate(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsWindow(e *LogicalSelectNode) bool {
+	if e.exprType == ExprWindow {
+		return true
+	}
+	for _, arg := range e.args {
+		if containsWindow(arg) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the value of a constant expression.
+func (b *queryBlock) constValue(e *LogicalSelectNode) (DBValue, bool) {
+	if !isConstant(e) {
+		return nil, false
+	}
+	expr, _, err := e.generateExpr(b.c, nil, nil)
+	if err != nil {
+		return nil, false
+	}
+	ce, ok := expr.(*ConstExpr)
+	if !ok {
+		return nil, false
+	}
+	return ce.val, true
+}
+
+// Evaluate a predicate whose sides are both constant.  Returns false if it
+// cannot be evaluated before the query runs, e.g., because its sides cannot be
+// compared; it is then left for the Filter to report.
+func (b *queryBlock) evalConstPredicate(p *LogicalFilterNode) (result bool, ok bool) {
+	if !isConstant(&p.fieldExpr) || !isConstant(&p.constExpr) {
+		return false, false
+	}
+	left, _, err := p.fieldExpr.generateExpr(b.c, nil, nil)
+	if err != nil {
+		return false, false
+	}
+	right, _, err := p.constExpr.generateExpr(b.c, nil, nil)
+	if err != nil {
+		return false, false
+	}
+	if left, right, err = coerceComparison(left, right); err != nil {
+		return false, false
+	}
+	lc, lok := left.(*ConstExpr)
+	rc, rok := right.(*ConstExpr)
+	if !lok || !rok {
+		return false, false
+	}
+	return lc.val.EvalPred(rc.val, p.predOp), true
+}
+
+// A predicate that is never true, which replaces contradictory predicates.
+func falsePredicate() *LogicalFilterNode {
+	return &LogicalFilterNode{NewTypedConstSelectNode("1", IntType, ""), NewTypedConstSelectNode("0", IntType, ""), OpEq}
+}
+
+// Returns the comparison op with its operands swapped, e.g., > for <.
+func flipOp(op BoolOp) BoolOp {
+	switch op {
+	case OpGt:
+		return OpLt
+	case OpGe:
+		return OpLe
+	case OpLt:
+		return OpGt
+	case OpLe:
+		return OpGe
+	}
+	return op
+}
+
+// Returns a copy of e in which every column is qualified by the name of the
+// relation it refers to, if it can be resolved.
+func (b *queryBlock) qualify(e *LogicalSelectNode) *LogicalSelectNode {
+	q := *e
+	switch e.exprType {
+	case ExprField:
+		if e.field != "*" {
+			if rel, ok := b.resolve(e.table, e.field); ok {
+				q.table = rel
+			}
+		}
+	case ExprFunc, ExprAggr, ExprWindow:
+		q.args = make([]*LogicalSelectNode, len(e.args))
+		for i, arg := range e.args {
+			q.args[i] = b.qualify(arg)
+		}
+	}
+	return &q
+}
+
+// Qualify the columns of every predicate and join condition, evaluate
+// constant predicates, dropping those that are true, and put constants on the
+// right side of comparisons.
+func (b *queryBlock) normalizePredicates(op logicalOp) (logicalOp, error) {
+	return transformUp(op, func(op logicalOp) (logicalOp, error) {
+		switch n := op.(type) {
+		case *logicalFilter:
+			var preds []*LogicalFilterNode
+			for _, p := range n.preds {
+				q := &LogicalFilterNode{*b.qualify(&p.fieldExpr), *b.qualify(&p.constExpr), p.predOp}
+				if isConstant(&q.fieldExpr) && !isConstant(&q.constExpr) && q.predOp != OpLike {
+					q.fieldExpr, q.constExpr, q.predOp = q.constExpr, q.fieldExpr, flipOp(q.predOp)
+				}
+				if result, ok := b.evalConstPredicate(q); ok && result {
+					continue
+				}
+				preds = append(preds, q)
+			}
+			if len(preds) == 0 {
+				return n.input, nil
+			}
+			return &logicalFilter{preds, n.input}, nil
+		case *logicalJoin:
+			conds := make([]*LogicalJoinNode, len(n.conds))
+			for i, cond := range n.conds {
+				conds[i] = &LogicalJoinNode{b.qualify(cond.left), b.qualify(cond.right), cond.predOp}
+			}
+			return &logicalJoin{conds, n.rels}, nil
+		}
+		return op, nil
+	})
+}
+
+// Move every filter as far down the tree as it can go.
+func (b *queryBlock) pushDownPredicates(op logicalOp) (logicalOp, error) {
+	switch n := op.(type) {
+	case *logicalFilter:
+		return b.pushFilter(n.preds, n.input)
+	case *logicalProject:
+		input, err := b.pushDownPredicates(n.input)
+		if err != nil {
+			return nil, err
+		}
+		return &logicalProject{n.columns, input}, nil
+	case *logicalJoin:
+		rels := make([]logicalOp, len(n.rels))
+		for i, rel := range n.rels {
+			var err error
+			if rels[i], err = b.pushDownPredicates(rel); err != nil {
+				return nil, err
+			}
+		}
+		return &logicalJoin{n.conds, rels}, nil
+	}
+	return op, nil
+}
+
+// Returns input with preds applied to it, as far down as they can go.
+func (b *queryBlock) pushFilter(preds []*LogicalFilterNode, input logicalOp) (logicalOp, error) {
+	switch n := input.(type) {
+	case *logicalFilter:
+		return b.pushFilter(append(slices.Clip(n.preds), preds...), n.input)
+	case *logicalProject:
+		// the predicates refer only to columns the projection keeps
+		pushed, err := b.pushFilter(preds, n.input)
+		if err != nil {
+			return nil, err
+		}
+		return &logicalProject{n.columns, pushed}, nil
+	case *logicalJoin:
+		preds = b.inferPredicates(preds, n.conds)
+		index := make(map[string]int)
+		for i, rel := range n.rels {
+			index[relationName(rel)] = i
+		}
+		pushed := make([][]*LogicalFilterNode, len(n.rels))
+		var remaining []*LogicalFilterNode
+		for _, p := range preds {
+			rels, ok := b.relationsOf(&p.fieldExpr, &p.constExpr)
+			switch {
+			case ok && len(rels) == 1:
+				i := index[rels[0]]
+				pushed[i] = append(pushed[i], p)
+			case ok && len(rels) == 0 && isConstant(&p.fieldExpr) && isConstant(&p.constExpr):
+				// a constant predicate that is not true holds for no
+				// tuple of any relation
+				for i := range pushed {
+					pushed[i] = append(pushed[i], p)
+				}
+			default:
+				remaining = append(remaining, p)
+			}
+		}
+		rels := make([]logicalOp, len(n.rels))
+		for i, rel := range n.rels {
+			var err error
+			if len(pushed[i]) > 0 {
+				rels[i], err = b.pushFilter(pushed[i], rel)
+			} else {
+				rels[i], err = b.pushDownPredicates(rel)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		var op logicalOp = &logicalJoin{n.conds, rels}
+		if len(remaining) > 0 {
+			op = &logicalFilter{remaining, op}
+		}
+		return op, nil
+	case *logicalSubquery:
+		sub, remaining := b.pushIntoSubquery(n, preds)
+		if len(remaining) == 0 {
+			return sub, nil
+		}
+		return &logicalFilter{remaining, sub}, nil
+	}
+	return &logicalFilter{preds, input}, nil
+}
+
+// Returns preds along with, for each predicate comparing a column with a
+// constant, the same comparison of each column the column is joined with by
+// conds, directly or through other join conditions.  For example, a.x = b.y
+// and a.x > 5 imply b.y > 5.  Predicates are only inferred for columns of the
+// same type, since the comparison of columns of different types may convert
+// their values.
+func (b *queryBlock) inferPredicates(preds []*LogicalFilterNode, conds []*LogicalJoinNode) []*LogicalFilterNode {
+	seen := make(map[string]bool)
+	for _, p := range preds {
+		seen[predString(p)] = true
+	}
+	sameColumn := func(e1 *LogicalSelectNode, e2 *LogicalSelectNode) bool {
+		return e1.exprType == ExprField && e2.exprType == ExprField && e1.table != "" && e1.table == e2.table && e1.field == e2.field
+	}
+	for i := 0; i < len(preds); i++ {
+		p := preds[i]
+		if p.fieldExpr.exprType != ExprField || !isConstant(&p.constExpr) {
+			continue
+		}
+		for _, cond := range conds {
+			for _, sides := range [][2]*LogicalSelectNode{{cond.left, cond.right}, {cond.right, cond.left}} {
+				from, to := sides[0], sides[1]
+				if !sameColumn(from, &p.fieldExpr) || to.exprType != ExprField || to.table == "" {
+					continue
+				}
+				fromType, ok1 := b.columnType(from.table, from.field)
+				toType, ok2 := b.columnType(to.table, to.field)
+				if !ok1 || !ok2 || fromType != toType {
+					continue
+				}
+				q := &LogicalFilterNode{*to, p.constExpr, p.predOp}
+				q.fieldExpr.alias = ""
+				if !seen[predString(q)] {
+					seen[predString(q)] = true
+					preds = append(preds, q)
+				}
+			}
+		}
+	}
+	return preds
+}
+
+// Move the predicates that can be evaluated inside the subquery sub into its
+// WHERE clause, returning the new subquery and the predicates that could not
+// be moved.  A predicate can be moved if every column it refers to is the
+// result of an expression of the subquery's select list that has no
+// aggregate, and, if the subquery is grouped, is one of its GROUP BY
+// expressions.  Nothing is moved into a subquery with a LIMIT, since that
+// would change the rows it returns.
+func (b *queryBlock) pushIntoSubquery(sub *logicalSubquery, preds []*LogicalFilterNode) (logicalOp, []*LogicalFilterNode) {
+	if sub.plan.limit != nil {
+		return sub, preds
+	}
+	inner, err := newQueryBlock(b.c, sub.plan)
+	if err != nil {
+		return sub, preds
+	}
+	var pushed, remaining []*LogicalFilterNode
+	for _, p := range preds {
+		field, ok1 := inner.substitute(&p.fieldExpr)
+		constExpr, ok2 := inner.substitute(&p.constExpr)
+		if ok1 && ok2 {
+			pushed = append(pushed, &LogicalFilterNode{*field, *constExpr, p.predOp})
+		} else {
+			remaining = append(remaining, p)
+		}
+	}
+	if len(pushed) == 0 {
+		return sub, preds
+	}
+	plan := *sub.plan
+	plan.filters = append(slices.Clip(plan.filters), pushed...)
+	return &logicalSubquery{sub.name, &plan}, remaining
+}
+
+// Returns e with each column replaced by the expression of the block's select
+// list it names, if it can be evaluated in the block's WHERE clause.
+func (b *queryBlock) substitute(e *LogicalSelectNode) (*LogicalSelectNode, bool) {
+	switch e.exprType {
+	case ExprConst, ExprParam:
+		return e, true
+	case ExprField:
+		return b.selectedColumn(e.field)
+	case ExprFunc:
+		q := *e
+		q.args = make([]*LogicalSelectNode, len(e.args))
+		for i, arg := range e.args {
+			var ok bool
+			if q.args[i], ok = b.substitute(arg); !ok {
+				return nil, false
+			}
+		}
+		return &q, true
+	}
+	return nil, false
+}
+
+// Returns the expression of the block's select list whose result is the named
+// column, if there is exactly one and it can be evaluated before the block is
+// grouped.  None can if the block computes window functions, whose windows
+// would change if tuples were filtered out before them.
+func (b *queryBlock) selectedColumn(col string) (*LogicalSelectNode, bool) {
+	var found *LogicalSelectNode
+	n := 0
+	for _, s := range b.plan.selects {
+		if containsWindow(s) {
+			return nil, false
+		}
+		switch {
+		case s.exprType == ExprStar:
+			rel, ok := b.resolve(s.table, col)
+			if ok && b.hasColumn(rel, col) {
+				f := NewFieldSelectNode(rel, col, "")
+				found = &f
+				n++
+			}
+		case s.alias == col || (s.alias == "" && s.exprType == ExprField && s.field == col):
+			found = s
+			n++
+		}
+	}
+	if n != 1 || containsAggregate(found) {
+		return nil, false
+	}
+	if len(b.plan.aggs) > 0 && !b.isGroupBy(found) {
+		return nil, false
+	}
+	e := *found
+	e.alias = ""
+	return &e, true
+}
+
+// Returns true if e is one of the block's GROUP BY expressions.
+func (b *queryBlock) isGroupBy(e *LogicalSelectNode) bool {
+	for _, gby := range b.plan.groupByFields {
+		g := gby.expr
+		if exprKey(g) == exprKey(e) {
+			return true
+		}
+		if g.exprType == ExprField && e.exprType == ExprField && g.field == e.field &&
+			(g.table == "" || e.table == "" || g.table == e.table) {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove predicates that are implied by the others in the same filter, and
+// join conditions that repeat another.  Predicates that contradict each other
+// are replaced by a single false predicate.
+func (b *queryBlock) eliminateRedundantPredicates(op logicalOp) (logicalOp, error) {
+	return transformUp(op, func(op logicalOp) (logicalOp, error) {
+		switch n := op.(type) {
+		case *logicalFilter:
+			return &logicalFilter{b.simplifyPredicates(n.preds), n.input}, nil
+		case *logicalJoin:
+			seen := make(map[string]bool)
+			var conds []*LogicalJoinNode
+			for _, cond := range n.conds {
+				l, r := exprKey(cond.left), exprKey(cond.right)
+				if seen[l+" = "+r] || seen[r+" = "+l] {
+					continue
+				}
+				seen[l+" = "+r] = true
+				conds = append(conds, cond)
+			}
+			return &logicalJoin{conds, n.rels}, nil
+		}
+		return op, nil
+	})
+}
+
+// A bound on the value of a numeric column given by a predicate.
+type predicateBound struct {
+	pred *LogicalFilterNode
+	val  DBValue
+}
+
+// Returns the column a predicate compares with a numeric constant and the
+// value of the constant, if the column is a numeric column of a table.
+func (b *queryBlock) numericBound(p *LogicalFilterNode) (string, *predicateBound, bool) {
+	f := &p.fieldExpr
+	if f.exprType != ExprField || f.table == "" {
+		return "", nil, false
+	}
+	if t, ok := b.columnType(f.table, f.field); !ok || !isNumericType(t) {
+		return "", nil, false
+	}
+	v, ok := b.constValue(&p.constExpr)
+	if !ok || !isNumericType(valueType(v)) {
+		return "", nil, false
+	}
+	return exprKey(f), &predicateBound{p, v}, true
+}
+
+// Returns the predicates of a conjunction without those that are implied by
+// the others: duplicates, and bounds on numeric columns that are implied by
+// tighter bounds or equalities.  If the predicates cannot all be true, e.g.,
+// a > 5 and a < 3, a single false predicate is returned.
+func (b *queryBlock) simplifyPredicates(preds []*LogicalFilterNode) []*LogicalFilterNode {
+	contradiction := []*LogicalFilterNode{falsePredicate()}
+	seen := make(map[string]bool)
+	var distinct []*LogicalFilterNode
+	for _, p := range preds {
+		if seen[predString(p)] {
+			continue
+		}
+		seen[predString(p)] = true
+		if result, ok := b.evalConstPredicate(p); ok && !result {
+			return contradiction
+		}
+		distinct = append(distinct, p)
+	}
+
+	var columns []string
+	bounds := make(map[string][]*predicateBound)
+	for _, p := range distinct {
+		if col, bound, ok := b.numericBound(p); ok {
+			if bounds[col] == nil {
+				columns = append(columns, col)
+			}
+			bounds[col] = append(bounds[col], bound)
+		}
+	}
+	cmp := func(v1 DBValue, v2 DBValue) int {
+		c, _ := compareValues(v1, v2)
+		return c
+	}
+	drop := make(map[*LogicalFilterNode]bool)
+	for _, col := range columns {
+		var eq, lower, upper *predicateBound
+		for _, bound := range bounds[col] {
+			switch op := bound.pred.predOp; op {
+			case OpEq:
+				if eq != nil {
+					if cmp(eq.val, bound.val) != 0 {
+						return contradiction
+					}
+					drop[bound.pred] = true
+					continue
+				}
+				eq = bound
+			case OpGt, OpGe:
+				if lower == nil {
+					lower = bound
+				} else if c := cmp(bound.val, lower.val); c > 0 || c == 0 && op == OpGt {
+					drop[lower.pred] = true
+					lower = bound
+				} else {
+					drop[bound.pred] = true
+				}
+			case OpLt, OpLe:
+				if upper == nil {
+					upper = bound
+				} else if c := cmp(bound.val, upper.val); c < 0 || c == 0 && op == OpLt {
+					drop[upper.pred] = true
+					upper = bound
+				} else {
+					drop[bound.pred] = true
+				}
+			}
+		}
+		if eq != nil {
+			for _, bound := range []*predicateBound{lower, upper} {
+				if bound == nil {
+					continue
+				}
+				if !evalComparison(cmp(eq.val, bound.val), bound.pred.predOp) {
+					return contradiction
+				}
+				drop[bound.pred] = true
+			}
+		} else if lower != nil && upper != nil {
+			c := cmp(lower.val, upper.val)
+			if c > 0 || c == 0 && (lower.pred.predOp == OpGt || upper.pred.predOp == OpLt) {
+				return contradiction
+			}
+		}
+	}
+
+	var simplified []*LogicalFilterNode
+	for _, p := range distinct {
+		if !drop[p] {
+			simplified = append(simplified, p)
+		}
+	}
+	return simplified
+}
+
+// Project each input of the block's join onto the columns that are needed
+// above the join: those of its join conditions, of the predicates that could
+// not be pushed below it, and of the block's select list, GROUP BY and ORDER
+// BY clauses.  Nothing is pruned if some column cannot be resolved.
+func (b *queryBlock) pruneColumns(op logicalOp) (logicalOp, error) {
+	var above []*LogicalFilterNode
+	join, ok := op.(*logicalJoin)
+	if f, isFilter := op.(*logicalFilter); isFilter {
+		above = f.preds
+		join, ok = f.input.(*logicalJoin)
+	}
+	if !ok {
+		return op, nil
+	}
+
+	aliases := make(map[string]bool)
+	for _, s := range b.plan.selects {
+		if s.alias != "" {
+			aliases[s.alias] = true
+		}
+	}
+	var exprs []*LogicalSelectNode
+	exprs = append(exprs, b.plan.selects...)
+	for _, cond := range join.conds {
+		exprs = append(exprs, cond.left, cond.right)
+	}
+	for _, p := range above {
+		exprs = append(exprs, &p.fieldExpr, &p.constExpr)
+	}
+	for _, gby := range b.plan.groupByFields {
+		exprs = append(exprs, gby.expr)
+	}
+	for _, oby := range b.plan.orderByFields {
+		exprs = append(exprs, oby.expr)
+	}
+	needed := make(map[string]map[string]bool)
+	for _, e := range exprs {
+		if e.exprType == ExprField && e.table == "" && aliases[e.field] {
+			continue // refers to the select list
+		}
+		if !b.columnsUsed(e, needed) {
+			return op, nil
+		}
+	}
+
+	rels := make([]logicalOp, len(join.rels))
+	for i, rel := range join.rels {
+		rels[i] = rel
+		name := relationName(rel)
+		cols := needed[name]
+		if cols["*"] {
+			continue
+		}
+		var keep []string
+		if scan, ok := b.relations[name].(*logicalScan); ok {
+			all := (*scan.table.file).Descriptor().Fields
+			for _, f := range all {
+				if cols[f.Fname] {
+					keep = append(keep, f.Fname)
+				}
+			}
+			if len(keep) == len(all) {
+				continue
+			}
+		} else {
+			for col := range cols {
+				keep = append(keep, col)
+			}
+			sort.Strings(keep)
+		}
+		rels[i] = &logicalProject{keep, rel}
+	}
+	var pruned logicalOp = &logicalJoin{join.conds, rels}
+	if len(above) > 0 {
+		pruned = &logicalFilter{above, pruned}
+	}
+	return pruned, nil
+}
+
+// Plan the operators of the logical tree rooted at op, recording them in
+// b.tableMap.  Returns the name of one of the relations of the tree, all of
+// which map to its root operator once they are joined.
+func (b *queryBlock) planRelations(op logicalOp) (string, error) {
+	switch n := op.(type) {
+	case *logicalScan:
+		t := n.table
+		if ct, ok := (*t.file).(*CommonTable); ok {
+			// the tuples of a common table expression are scanned in memory
+			td := ct.Descriptor().copy()
+			td.setTableAlias(n.name)
+			b.tableMap[n.name] = &PlanNode{costedCard(ct, ct.card, float64(ct.card)*costPerTuple, nil), td}
+			b.tableStats[n.name] = &DummyStats{}
+			b.sel[n.name] = 1.0
+			return n.name, nil
+		}
+		var stats Stats = &DummyStats{}
+		if ts := t.schema.GetTableStats(t.tableName); ts != nil {
+			stats = ts
+		}
+		b.tableStats[n.name] = stats
+
+		td := (*t.file).Descriptor()
+		td.setTableAlias(n.name)
+		b.tableMap[n.name] = &PlanNode{costedCard(*t.file, stats.EstimateCardinality(1.0), stats.EstimateScanCost(), nil), td}
+		b.sel[n.name] = 1.0
+		return n.name, nil
+	case *logicalSubquery:
+		subPhysP, err := makePhysicalPlan(b.c, n.plan)
+		if err != nil {
+			return "", err
+		}
+		td := subPhysP.Descriptor()
+		td.setTableAlias(n.name)
+		b.tableMap[n.name] = &PlanNode{subPhysP, td}
+		b.tableStats[n.name] = &DummyStats{}
+		b.sel[n.name] = 1.0
+		return n.name, nil
+	case *logicalFilter:
+		name, err := b.planRelations(n.input)
+		if err != nil {
+			return "", err
+		}
+		for _, p := range n.preds {
+			if err := b.applyFilter(name, p); err != nil {
+				return "", err
+			}
+		}
+		return name, nil
+	case *logicalProject:
+		name, err := b.planRelations(n.input)
+		if err != nil {
+			return "", err
+		}
+		return name, b.applyProject(name, n.columns)
+	case *logicalJoin:
+		if len(n.rels) == 0 {
+			return "", GoDBError{ParseError, "query reads no tables"}
+		}
+		var first string
+		for i, rel := range n.rels {
+			name, err := b.planRelations(rel)
+			if err != nil {
+				return "", err
+			}
+			if i == 0 {
+				first = name
+			}
+		}
+		return first, b.applyJoins(n.conds)
+	}
+	return "", GoDBError{ParseError, fmt.Sprintf("unexpected logical operator %s", op)}
+}
+
+// Replace the operator old of one or more relations by new.
+func (b *queryBlock) replaceNode(old *PlanNode, new *PlanNode) {
+	for name, node := range b.tableMap {
+		if node == old {
+			b.tableMap[name] = new
+		}
+	}
+}
+
+// Apply a predicate to the operator of the named relation.
+func (b *queryBlock) applyFilter(name string, f *LogicalFilterNode) error {
+	node := b.tableMap[name]
+	leftExpr, _, err := f.fieldExpr.generateExpr(b.c, node.desc, b.tableMap)
+	if err != nil {
+		return err
+	}
+	rightExpr, _, err := f.constExpr.generateExpr(b.c, node.desc, b.tableMap)
+	if err != nil {
+		return err
+	}
+
+	fieldType := leftExpr.GetExprType()
+	table_stats := b.tableStats[fieldType.TableQualifier]
+
+	filterSel := 1.0
+	_, rightConst := rightExpr.(*ConstExpr)
+	// a parameter is estimated with the value the plan is made for
+	value, ok := literalValue(rightExpr)
+	if _, leftConst := leftExpr.(*ConstExpr); leftConst && rightConst {
+		// only false constant predicates are left in optimized plans
+		if result, ok := b.evalConstPredicate(f); ok && !result {
+			filterSel = 0
+		}
+	} else if ok && table_stats != nil {
+		filterSel, err = table_stats.EstimateSelectivity(fieldType.Fname, f.predOp, value)
+		if err != nil {
+			return err
+		}
+	}
+	b.sel[name] *= filterSel
+
+	leftExpr, rightExpr, err = coerceComparison(leftExpr, rightExpr)
+	if err != nil {
+		return err
+	}
+	newOp, err := NewFilter(rightExpr, f.predOp, leftExpr, node.op)
+	if err != nil {
+		return err
+	}
+	cost := estimatedCost(node.op) + estimatedRows(node.op)*costPerTuple
+	b.replaceNode(node, &PlanNode{costedCard(newOp, int(float64(node.op.Cardinality)*filterSel), cost, nil), node.desc})
+	return nil
+}
+
+// Project the operator of the named relation onto the columns with the given
+// names.  Nothing is done if that would keep all of its columns, or if two of
+// its columns have the same name, since they could not be told apart.
+func (b *queryBlock) applyProject(name string, columns []string) error {
+	node := b.tableMap[name]
+	keep := make(map[string]bool)
+	for _, col := range columns {
+		keep[col] = true
+	}
+	var exprs []Expr
+	var names []string
+	seen := make(map[string]bool)
+	for _, f := range node.desc.Fields {
+		if seen[f.Fname] {
+			return nil
+		}
+		seen[f.Fname] = true
+		if keep[f.Fname] {
+			exprs = append(exprs, &FieldExpr{f})
+			names = append(names, f.Fname)
+		}
+	}
+	if len(exprs) == len(node.desc.Fields) {
+		return nil
+	}
+	if len(exprs) == 0 {
+		// e.g., only count(*) is computed over the join
+		f := node.desc.Fields[0]
+		exprs, names = []Expr{&FieldExpr{f}}, []string{f.Fname}
+	}
+	projOp, err := NewProjectOp(exprs, names, false, node.op)
+	if err != nil {
+		return err
+	}
+	desc := projOp.Descriptor()
+	desc.setTableAlias(name)
+	cost := estimatedCost(node.op) + estimatedRows(node.op)*costPerTuple
+	b.replaceNode(node, &PlanNode{costedCard(projOp, node.op.Cardinality, cost, nil), desc})
+	return nil
+}
+
+// Returns the relation and field a side of a join condition refers to.
+func (b *queryBlock) joinSide(e *LogicalSelectNode) (string, string, error) {
+	tabName, fieldName, err := e.getTableField(b.c, b.plan.subqueries, b.plan.tables)
+	if err != nil {
+		return "", "", err
+	}
+	if rels, ok := b.relationsOf(e); ok && len(rels) == 1 {
+		tabName = rels[0]
+	}
+	return tabName, fieldName, nil
+}
+
+// Join the operators of the relations in b.tableMap on conds, in the order
+// chosen by [OrderJoins], with the algorithms chosen by [planJoin].  A condition between relations that are already
+// joined, e.g., the last of a cycle of conditions, is applied as a filter.
+func (b *queryBlock) applyJoins(conds []*LogicalJoinNode) error {
+	selects := make(map[TableAndField]*LogicalSelectNode)
+	join_order := make([]*JoinNode, len(conds))
+	for i, j := range conds {
+		leftName, leftField, err := b.joinSide(j.left)
+		if err != nil {
+			return err
+		}
+		rightName, rightField, err := b.joinSide(j.right)
+		if err != nil {
+			return err
+		}
+
+		leftStats := b.tableStats[leftName]
+		if leftStats == nil {
+			return GoDBError{ParseError, fmt.Sprintf("no stats for lhs table %s, join %v, tables %v", leftName, j.left, b.tableMap)}
+		}
+		rightStats := b.tableStats[rightName]
+		if rightStats == nil {
+			return GoDBError{ParseError, fmt.Sprintf("no stats for rhs table %s, join %v, tables %v", rightName, j, b.tableMap)}
+		}
+
+		join_order[i] = &JoinNode{
+			leftTable:  TableInfo{leftName, leftStats, b.sel[leftName]},
+			leftField:  leftField,
+			rightTable: TableInfo{rightName, rightStats, b.sel[rightName]},
+			rightField: rightField,
+		}
+		selects[TableAndField{leftName, leftField}] = j.left
+		selects[TableAndField{rightName, rightField}] = j.right
+	}
+
+	if EnableJoinOptimization {
+		var err error
+		join_order, err = OrderJoins(join_order)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, j := range join_order {
+		left := selects[TableAndField{j.leftTable.name, j.leftField}]
+		right := selects[TableAndField{j.rightTable.name, j.rightField}]
+
+		node1, err := fieldToOp(j.leftTable.name, j.leftField, b.tableMap)
+		if err != nil {
+			return err
+		}
+		node2, err := fieldToOp(j.rightTable.name, j.rightField, b.tableMap)
+		if err != nil {
+			return err
+		}
+		leftExpr, _, err := left.generateExpr(b.c, node1.desc, b.tableMap)
+		if err != nil {
+			return err
+		}
+		rightExpr, _, err := right.generateExpr(b.c, node2.desc, b.tableMap)
+		if err != nil {
+			return err
+		}
+		leftExpr, rightExpr, err = coerceComparison(leftExpr, rightExpr)
+		if err != nil {
+			return err
+		}
+
+		if node1 == node2 {
+			filter, err := NewFilter(rightExpr, OpEq, leftExpr, node1.op)
+			if err != nil {
+				return err
+			}
+			cost := estimatedCost(node1.op) + estimatedRows(node1.op)*costPerTuple
+			b.replaceNode(node1, &PlanNode{costedCard(filter, node1.op.Cardinality, cost, nil), node1.desc})
+			continue
+		}
+		joinOp, err := planJoin(node1.op, leftExpr, node2.op, rightExpr)
+		if err != nil {
+			return err
+		}
+		newNode := &PlanNode{joinOp, joinOp.Descriptor()}
+		b.replaceNode(node1, newNode)
+		b.replaceNode(node2, newNode)
+	}
+	return nil
+}
+
+// Returns the value of a call of a function whose arguments are all constant
+// as a constant, so that it is computed once when the query is planned rather
+// than for every tuple.  Parameters ([ParamExpr]) are not constant.  Functions without arguments, such as rand() and
+// now(), are not folded, and neither are calls that fail, so that their error
+// is reported when the query runs.
+func foldConstants(f Expr) Expr {
+	var args []Expr
+	switch f := f.(type) {
+	case *FuncExpr:
+		for _, arg := range f.args {
+			args = append(args, *arg)
+		}
+	case lazyExpr:
+		args = f.operands()
+	}
+	if len(args) == 0 {
+		return f
+	}
+	for _, arg := range args {
+		if _, ok := arg.(*ConstExpr); !ok {
+			return f
+		}
+	}
+	v, err := f.EvalExpr(&Tuple{})
+	if err != nil {
+		return f
+	}
+	return &ConstExpr{v, f.GetExprType().Ftype}
+}
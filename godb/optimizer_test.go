@@ -0,0 +1,223 @@
+package godb
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var optimizerTestTables = []string{
+	"create table emp (id int, dept int, name varchar, salary int)",
+	"create table dept (id int, title varchar)",
+	"insert into dept values (0, 'a'), (1, 'b'), (2, 'c'), (3, 'd'), (4, 'e')",
+	insertForTest("emp", 40, func(i int) string { return fmt.Sprintf("(%d, %d, 'e%d', %d)", i, i%5, i, 10*i) }),
+}
+
+// Returns the optimized logical tree of a select statement.
+func optimizedTreeForTest(t *testing.T, c *Catalog, sql string) string {
+	t.Helper()
+	stmt, err := parseSelectStatement(sql)
+	if err != nil {
+		t.Fatalf("%s: %s", sql, err.Error())
+	}
+	plan, err := parseStatement(c, stmt)
+	if err != nil {
+		t.Fatalf("%s: %s", sql, err.Error())
+	}
+	b, err := newQueryBlock(c, plan)
+	if err != nil {
+		t.Fatalf("%s: %s", sql, err.Error())
+	}
+	root, err := b.optimize(b.logicalTree())
+	if err != nil {
+		t.Fatalf("%s: %s", sql, err.Error())
+	}
+	return logicalTreeString(root)
+}
+
+func TestOptimizerRewrites(t *testing.T) {
+	_, c := makeTestCatalogWith(t, optimizerTestTables...)
+	tests := []struct {
+		sql  string
+		tree []string
+	}{
+		// predicates are pushed through the join, also to the column a
+		// filtered column is joined with, and the inputs of the join carry
+		// only the columns needed above it
+		{"select emp.name from emp join dept on emp.dept = dept.id where dept.id < 3 and title <> 'b'", []string{
+			"Join emp.dept = dept.id",
+			"  Project dept, name",
+			"    Filter emp.dept < 3",
+			"      Scan emp",
+			"  Project id",
+			"    Filter dept.id < 3 AND dept.title <> 'b'",
+			"      Scan dept",
+		}},
+		// constants are moved to the right, and redundant bounds removed
+		{"select * from emp where 100 < salary and salary > 50 and salary >= 100 and 1 = 1", []string{
+			"Filter emp.salary > 100",
+			"  Scan emp",
+		}},
+		{"select * from emp where id = 3 and id < 10 and id = 3", []string{
+			"Filter emp.id = 3",
+			"  Scan emp",
+		}},
+		// contradictions, and false constant predicates, empty every input
+		{"select * from emp where salary > 10 and salary < 5", []string{
+			"Filter 1 = 0",
+			"  Scan emp",
+		}},
+		{"select emp.name from emp join dept on emp.dept = dept.id where 1 = 2", []string{
+			"Join emp.dept = dept.id",
+			"  Project dept, name",
+			"    Filter 1 = 0",
+			"      Scan emp",
+			"  Project id",
+			"    Filter 1 = 0",
+			"      Scan dept",
+		}},
+		// predicates are pushed into subqueries, below their grouping if
+		// they are on a grouping column
+		{"select n from (select name n, salary s from emp) x where x.s > 20", []string{
+			"Subquery x",
+		}},
+		{"select d, c from (select dept d, count(*) c from emp group by dept) x where d = 2 and c > 1", []string{
+			"Filter x.c > 1",
+			"  Subquery x",
+		}},
+		// but not past a LIMIT
+		{"select n from (select name n from emp limit 3) x where n = 'e1'", []string{
+			"Filter x.n = 'e1'",
+			"  Subquery x",
+		}},
+		// a predicate on several relations stays above the join, and a
+		// repeated join condition is removed
+		{"select emp.name from emp join dept on emp.dept = dept.id where emp.id + dept.id > 3 and dept.id = emp.dept", []string{
+			"Filter +(emp.id, dept.id) > 3",
+			"  Join emp.dept = dept.id",
+			"    Project id, dept, name",
+			"      Scan emp",
+			"    Project id",
+			"      Scan dept",
+		}},
+	}
+	for _, tt := range tests {
+		if tree := optimizedTreeForTest(t, c, tt.sql); tree != strings.Join(tt.tree, "\n") {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.sql, strings.Join(tt.tree, "\n"), tree)
+		}
+	}
+
+	// the pushed predicates are in the WHERE clause of the subquery
+	stmt, _ := parseSelectStatement("select d from (select dept d, count(*) c from emp group by dept) x where d = 2")
+	plan, _ := parseStatement(c, stmt)
+	b, _ := newQueryBlock(c, plan)
+	root, err := b.optimize(b.logicalTree())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sub := root.(*logicalSubquery)
+	inner, _ := newQueryBlock(c, sub.plan)
+	innerRoot, _ := inner.optimize(inner.logicalTree())
+	if tree := logicalTreeString(innerRoot); tree != "Filter emp.dept = 2\n  Scan emp" {
+		t.Errorf("expected the predicate to be pushed into the subquery, got\n%s", tree)
+	}
+	if len(plan.subqueries[0].filters) != 0 {
+		t.Errorf("optimizing a plan should not modify it")
+	}
+}
+
+func TestOptimizerJoinCarriesNeededColumns(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, optimizerTestTables...)
+	_, op, err := Parse(c, "select emp.name, dept.title from emp join dept on emp.dept = dept.id")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var join *EqualityJoin
+	var find func(op Operator)
+	find = func(op Operator) {
+		if j, ok := op.(*EqualityJoin); ok {
+			join = j
+		}
+		for _, child := range planChildren(op) {
+			find(*child)
+		}
+	}
+	find(op)
+	if join == nil {
+		t.Fatalf("expected a join in the plan")
+	}
+	var names []string
+	for _, f := range join.Descriptor().Fields {
+		names = append(names, f.TableQualifier+"."+f.Fname)
+	}
+	if strings.Join(names, ",") != "emp.dept,emp.name,dept.id,dept.title" {
+		t.Errorf("unexpected join columns %v", names)
+	}
+
+	// the columns of the two sides of a self-join are told apart
+	tups := mustExecForTest(t, c, bp, "select e.name, f.name from emp e join emp f on e.id = f.dept where f.salary > 380")
+	if len(tups) != 1 || tups[0].Fields[0].(StringField).Value != "e4" || tups[0].Fields[1].(StringField).Value != "e39" {
+		t.Errorf("unexpected result of self-join %v", tups)
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, optimizerTestTables...)
+	lines := explainForTest(t, c, bp, "explain select salary + 2 * 3 from emp where id > 10 - sq(2)")
+	plan := strings.Join(lines, "\n")
+	for _, s := range []string{"+(emp.salary,6,)", "emp.id > 6"} {
+		if !strings.Contains(plan, s) {
+			t.Errorf("expected %s in plan\n%s", s, plan)
+		}
+	}
+	// functions without arguments are evaluated for every tuple
+	lines = explainForTest(t, c, bp, "explain select id from emp where id < rand()")
+	if !strings.Contains(strings.Join(lines, "\n"), "rand()") {
+		t.Errorf("expected rand() not to be folded\n%s", strings.Join(lines, "\n"))
+	}
+	// an error is reported when the query runs, not when it is planned
+	if _, _, err := Parse(c, "select id / 0 from emp where 1 / 0 = 1"); err != nil {
+		t.Errorf("expected 1 / 0 not to be folded: %s", err.Error())
+	}
+}
+
+func TestOptimizerPreservesResults(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, optimizerTestTables...)
+	mustExecForTest(t, c, bp, "create view rich as select name, dept, salary from emp where salary > 100")
+	queries := []string{
+		"select emp.name, dept.title from emp join dept on emp.dept = dept.id where dept.id < 3 and title <> 'b'",
+		"select emp.name from emp join dept on emp.dept = dept.id where emp.dept > 1 and 3 >= dept.id",
+		"select emp.name from emp join dept on emp.dept = dept.id where emp.id + dept.id > 30",
+		"select * from emp join dept on emp.dept = dept.id where salary > 100 and salary <= 250 and salary > 50",
+		"select count(*) from emp join dept on emp.dept = dept.id",
+		"select a.name from emp a, emp b, emp c where a.id = b.id and b.id = c.id and c.id = a.id and a.salary < 100",
+		"select n from (select name n, salary s from emp) x where x.s > 200",
+		"select d, c from (select dept d, count(*) c from emp group by dept) x where d = 2 and c > 1",
+		"select n from (select name n from emp limit 3) x where n = 'e1'",
+		"select name from (select * from emp) x where salary > 300",
+		"select rich.name, dept.title from rich join dept on rich.dept = dept.id where rich.salary < 200",
+		"select name from emp where id = 3 and id > 5",
+		"select name from emp where 2 < 1",
+		"select title, sum(salary) from emp join dept on emp.dept = dept.id where salary > 50 group by title order by title",
+	}
+	run := func(sql string) []string {
+		tups := mustExecForTest(t, c, bp, sql)
+		rows := make([]string, len(tups))
+		for i, tup := range tups {
+			rows[i] = fmt.Sprint(tup.Fields)
+		}
+		sort.Strings(rows)
+		return rows
+	}
+	defer func() { EnableLogicalOptimization = true }()
+	for _, sql := range queries {
+		EnableLogicalOptimization = false
+		expected := run(sql)
+		EnableLogicalOptimization = true
+		got := run(sql)
+		if strings.Join(expected, "\n") != strings.Join(got, "\n") {
+			t.Errorf("%s: expected %d rows\n%v\ngot %d rows\n%v", sql, len(expected), expected, len(got), got)
+		}
+	}
+}
@@ -8,8 +8,11 @@ type OrderBy struct {
 	orderBy   []Expr // OrderBy should include these two fields (used by parser)
 	child     Operator
 	ascending []bool
-	// TODO: some code goes here
-	// add additional fields here
+
+	// The number of bytes of tuples, as estimated by [tupleMemory], that are
+	// sorted in memory; larger inputs are sorted in runs that are written to
+	// temporary files and then merged.  Zero if there is no limit.
+	maxBufferSize int
 }
 
 // Construct an order by operator. Saves the list of field, child, and ascending
@@ -59,7 +62,11 @@ func (ms *multiSorter) Len() int {
 // -1, 0, 1 and reduce the number of calls for greater efficiency: an
 // exercise for the reader.
 func (ms *multiSorter) Less(i, j int) bool {
-	p, q := &ms.data[i], &ms.data[j]
+	return ms.less(&ms.data[i], &ms.data[j])
+}
+
+// Reports whether p sorts before q.
+func (ms *multiSorter) less(p, q *Tuple) bool {
 	// Try all but the last comparison.
 	var k int
 	for k = 0; k < len(ms.orderBy)-1; k++ {
@@ -122,32 +129,61 @@ func OrderedBy(orderBy []Expr, ascending []bool) *multiSorter {
 // example, example of SortMultiKeys, and documentation at:
 // https://pkg.go.dev/sort
 func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(o.Open(tid))
+}
+
+// Open an iterator over the sorted tuples of the child, which is read to the
+// end first.  Closing it removes the sorted runs written to disk, if any.
+func (o *OrderBy) Open(tid TransactionID) (*OpIterator, error) {
 	// make the sorted stuff here
 	sorted := []Tuple{}
+	var runs []*sortRun
+	size := 0
 
-	it, err := o.child.Iterator(tid)
+	it, err := o.child.Open(tid)
 	if err != nil {
 		return nil, err
 	}
 
 	for {
-		tuple, err := it()
+		if err := checkCanceled(tid); err != nil {
+			it.Close()
+			closeSortRuns(runs)
+			return nil, err
+		}
+		tuple, err := it.Next()
 		if err != nil {
+			closeSortRuns(runs)
 			return nil, err
 		}
 		if tuple == nil {
 			break
 		}
 		sorted = append(sorted, *tuple)
+		size += tupleMemory(tuple)
+		if o.maxBufferSize > 0 && size > o.maxBufferSize {
+			OrderedBy(o.orderBy, o.ascending).Sort(sorted)
+			run, err := writeSortRun(sorted)
+			if err != nil {
+				it.Close()
+				closeSortRuns(runs)
+				return nil, err
+			}
+			runs = append(runs, run)
+			sorted, size = []Tuple{}, 0
+		}
 	}
 
 	// now do the sorting
 
 	OrderedBy(o.orderBy, o.ascending).Sort(sorted)
+	if len(runs) > 0 {
+		return mergeSortRuns(tid, runs, sorted, OrderedBy(o.orderBy, o.ascending))
+	}
 
 	i := 0
 
-	return func() (*Tuple, error) {
+	return newOpIterator(func() (*Tuple, error) {
 		if i >= len(sorted) {
 			return nil, nil
 		}
@@ -155,5 +191,8 @@ func (o *OrderBy) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 		retVal := sorted[i]
 		i++
 		return &retVal, nil
-	}, nil
+	}, func() error {
+		sorted = nil
+		return nil
+	}), nil
 }
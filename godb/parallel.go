@@ -0,0 +1,652 @@
+package godb
+
+// Intra-query parallelism.
+//
+// When [Parallelism] is more than one, the planner splits the scans of heap
+// files in SELECT plans among that many workers, each of which scans a range
+// of the pages of the file in its own goroutine, on behalf of the transaction
+// of the query:
+//
+//   - a pipeline of filters and projections over a scan runs in each worker,
+//     and a [Gather] returns the tuples of all of them;
+//   - a hash aggregate over such a pipeline is computed by a
+//     [ParallelAggregator], whose workers aggregate their tuples into partial
+//     aggregation states, which are then merged into the final ones;
+//   - a hash join whose probe side is such a pipeline, and whose build side
+//     fits in memory, is computed by a [ParallelHashJoin], whose workers
+//     build the partitions of its hash table in parallel, then probe it with
+//     their parts of the probe side.
+//
+// The workers check for the cancellation of the query as the operators they
+// run do, and closing the iterator of a parallel operator stops its workers
+// and waits for them to close their iterators.
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// The number of workers the planner splits the scans of a query among; 1
+// disables parallel execution.
+var Parallelism = 1
+
+// HeapScanPartition scans one of parts ranges of consecutive pages of a heap
+// file, which together cover the file.
+type HeapScanPartition struct {
+	file        *HeapFile
+	part, parts int
+}
+
+func NewHeapScanPartition(file *HeapFile, part, parts int) *HeapScanPartition {
+	return &HeapScanPartition{file, part, parts}
+}
+
+func (s *HeapScanPartition) Descriptor() *TupleDesc {
+	return s.file.Descriptor()
+}
+
+func (s *HeapScanPartition) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(s.Open(tid))
+}
+
+func (s *HeapScanPartition) Open(tid TransactionID) (*OpIterator, error) {
+	nPages := s.file.NumPages()
+	return s.file.openPages(tid, s.part*nPages/s.parts, (s.part+1)*nPages/s.parts)
+}
+
+// Gather is the exchange operator that returns the tuples of its workers,
+// each of which it runs in its own goroutine.  The tuples of different
+// workers are returned in no particular order.
+type Gather struct {
+	workers []Operator
+}
+
+func NewGather(workers []Operator) *Gather {
+	return &Gather{workers}
+}
+
+func (g *Gather) Descriptor() *TupleDesc {
+	return g.workers[0].Descriptor()
+}
+
+func (g *Gather) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(g.Open(tid))
+}
+
+// The number of tuples the workers of a [Gather] send at once.
+const gatherBatchSize = 256
+
+// The tuples sent by a worker of a [Gather], or the error it stopped on.
+type gatherMsg struct {
+	tups []*Tuple
+	err  error
+}
+
+// Open an iterator over the tuples of the workers, which starts them.
+// Closing it stops the workers and waits for them to close their iterators.
+func (g *Gather) Open(tid TransactionID) (*OpIterator, error) {
+	out := make(chan gatherMsg, len(g.workers))
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for _, w := range g.workers {
+		wg.Add(1)
+		go func(w Operator) {
+			defer wg.Done()
+			gatherWorker(tid, w, out, done)
+		}(w)
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+
+	var tups []*Tuple
+	stopped := false
+	return newOpIterator(func() (*Tuple, error) {
+		for len(tups) == 0 {
+			m, ok := <-out
+			if !ok {
+				return nil, nil
+			}
+			if m.err != nil {
+				return nil, m.err
+			}
+			tups = m.tups
+		}
+		t := tups[0]
+		tups = tups[1:]
+		return t, nil
+	}, func() error {
+		tups = nil
+		if !stopped {
+			stopped = true
+			close(done)
+			for range out {
+			}
+		}
+		return nil
+	}), nil
+}
+
+// Send the tuples of w to out, until there are no more or done is closed.
+func gatherWorker(tid TransactionID, w Operator, out chan<- gatherMsg, done <-chan struct{}) {
+	send := func(m gatherMsg) bool {
+		select {
+		case out <- m:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	it, err := w.Open(tid)
+	if err != nil {
+		send(gatherMsg{err: err})
+		return
+	}
+	defer it.Close()
+	tups := make([]*Tuple, 0, gatherBatchSize)
+	for {
+		t, err := it.Next()
+		if err != nil {
+			send(gatherMsg{err: err})
+			return
+		}
+		if t == nil {
+			if len(tups) > 0 {
+				send(gatherMsg{tups: tups})
+			}
+			return
+		}
+		tups = append(tups, t)
+		if len(tups) == gatherBatchSize {
+			if !send(gatherMsg{tups: tups}) {
+				return
+			}
+			tups = make([]*Tuple, 0, gatherBatchSize)
+		}
+	}
+}
+
+// Run f for each of n workers in its own goroutine, and return the first
+// error.  Once a worker has failed, the stop function passed to the others
+// returns true.
+func runWorkers(n int, f func(i int, stop func() bool) error) error {
+	var failed atomic.Bool
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if errs[i] = f(i, failed.Load); errs[i] != nil {
+				failed.Store(true)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParallelAggregator computes the same aggregates as a hashed [Aggregator],
+// over the union of the tuples of its workers: each worker aggregates its
+// tuples into partial aggregation states, which are then merged.  The
+// aggregation states must implement [mergeableAggState].
+type ParallelAggregator struct {
+	groupByFields []Expr
+	newAggState   []AggState
+	workers       []Operator
+}
+
+func NewParallelAggregator(emptyAggState []AggState, groupByFields []Expr, workers []Operator) *ParallelAggregator {
+	return &ParallelAggregator{groupByFields, emptyAggState, workers}
+}
+
+func (a *ParallelAggregator) Descriptor() *TupleDesc {
+	return (&Aggregator{groupByFields: a.groupByFields, newAggState: a.newAggState}).Descriptor()
+}
+
+func (a *ParallelAggregator) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(a.Open(tid))
+}
+
+// The aggregation states of the groups of an aggregate, in the order in which
+// they were found.
+type aggGroups struct {
+	index  map[any]int
+	keys   []*Tuple // the group by key tuple of each group; nil without a group by
+	states [][]AggState
+}
+
+// Returns the index of the group with the given key tuple, adding it if it
+// is new.
+func (g *aggGroups) group(key *Tuple, newAggState []AggState) int {
+	var k any = DefaultGroup
+	if key != nil {
+		k = key.tupleKey()
+	}
+	i, ok := g.index[k]
+	if !ok {
+		states := make([]AggState, len(newAggState))
+		for j, as := range newAggState {
+			states[j] = as.Copy()
+		}
+		i = len(g.keys)
+		g.index[k] = i
+		g.keys = append(g.keys, key)
+		g.states = append(g.states, states)
+	}
+	return i
+}
+
+// Aggregate the tuples of w into partial aggregation states, until there are
+// no more or stop returns true.
+func (a *ParallelAggregator) partialAggregate(tid TransactionID, w Operator, stop func() bool) (*aggGroups, error) {
+	groups := &aggGroups{index: make(map[any]int)}
+	if a.groupByFields == nil {
+		groups.group(nil, a.newAggState)
+	}
+	it, err := w.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+	keyOf := &Aggregator{groupByFields: a.groupByFields}
+	for !stop() {
+		t, err := it.Next()
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			break
+		}
+		g := 0
+		if a.groupByFields != nil {
+			key, err := extractGroupByKeyTuple(keyOf, t)
+			if err != nil {
+				return nil, err
+			}
+			g = groups.group(key, a.newAggState)
+		}
+		for _, as := range groups.states[g] {
+			as.AddTuple(t)
+		}
+	}
+	return groups, nil
+}
+
+// Open an iterator over the results of the aggregate, which runs the workers
+// to completion before returning the first one.
+func (a *ParallelAggregator) Open(tid TransactionID) (*OpIterator, error) {
+	var groups *aggGroups
+	next := 0 // the next group to return
+	return newOpIterator(func() (*Tuple, error) {
+		if groups == nil {
+			partials := make([]*aggGroups, len(a.workers))
+			err := runWorkers(len(a.workers), func(i int, stop func() bool) error {
+				var err error
+				partials[i], err = a.partialAggregate(tid, a.workers[i], stop)
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+			groups = partials[0]
+			for _, p := range partials[1:] {
+				for i, key := range p.keys {
+					g := groups.group(key, a.newAggState)
+					for j, as := range groups.states[g] {
+						as.(mergeableAggState).merge(p.states[i][j])
+					}
+				}
+			}
+		}
+		if next == len(groups.keys) {
+			return nil, nil
+		}
+		var tup *Tuple
+		for _, as := range groups.states[next] {
+			tup = joinTuples(tup, as.Finalize())
+		}
+		tup = joinTuples(groups.keys[next], tup)
+		next++
+		return tup, nil
+	}, func() error {
+		groups = nil
+		return nil
+	}), nil
+}
+
+// Returns true if all of the aggregation states can be merged.
+func mergeableAggStates(aggs []AggState) bool {
+	for _, as := range aggs {
+		if _, ok := as.(mergeableAggState); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ParallelHashJoin computes the same tuples as a [HashJoin] whose build side
+// fits in memory.  Its build workers, which together produce the build side,
+// each split their tuples into one partition of the hash table per probe
+// worker, by the hash of their join value, and the partitions built by
+// different workers are then merged in parallel.  Each probe worker then
+// probes the hash table with its part of the probe side, and a [Gather]
+// returns the joined tuples.
+type ParallelHashJoin struct {
+	leftField, rightField Expr
+
+	builds, probes []Operator
+
+	buildLeft bool
+}
+
+func NewParallelHashJoin(leftField, rightField Expr, builds, probes []Operator, buildLeft bool) *ParallelHashJoin {
+	return &ParallelHashJoin{leftField, rightField, builds, probes, buildLeft}
+}
+
+func (hj *ParallelHashJoin) Descriptor() *TupleDesc {
+	if hj.buildLeft {
+		return hj.builds[0].Descriptor().merge(hj.probes[0].Descriptor())
+	}
+	return hj.probes[0].Descriptor().merge(hj.builds[0].Descriptor())
+}
+
+// Returns the input the hash table is built over, "left" or "right".
+func (hj *ParallelHashJoin) buildSide() string {
+	if hj.buildLeft {
+		return "left"
+	}
+	return "right"
+}
+
+func (hj *ParallelHashJoin) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(hj.Open(tid))
+}
+
+// Returns the partition, between 0 and n - 1, of a key returned by
+// [hashKey].  Equal keys are in the same partition.
+func hashPartition(key any, n int) int {
+	var h uint64
+	switch k := key.(type) {
+	case float64:
+		if k == 0 {
+			k = 0 // -0 == 0
+		}
+		h = math.Float64bits(k)
+		h ^= h >> 29
+		h *= 0xbf58476d1ce4e5b9
+		h ^= h >> 32
+	default:
+		f := fnv.New64a()
+		if s, ok := k.(StringField); ok {
+			f.Write([]byte(s.Value))
+		} else {
+			fmt.Fprint(f, k)
+		}
+		h = f.Sum64()
+	}
+	return int(h % uint64(n))
+}
+
+// Build the partitions of the hash table over the tuples of the build
+// workers.
+func (hj *ParallelHashJoin) buildTable(tid TransactionID) ([]map[any][]hashEntry, error) {
+	buildField := hj.rightField
+	if hj.buildLeft {
+		buildField = hj.leftField
+	}
+	n := len(hj.probes)
+	parts := make([][]map[any][]hashEntry, len(hj.builds)) // by worker, then partition
+	err := runWorkers(len(hj.builds), func(i int, stop func() bool) error {
+		local := make([]map[any][]hashEntry, n)
+		for p := range local {
+			local[p] = make(map[any][]hashEntry)
+		}
+		parts[i] = local
+		it, err := hj.builds[i].Open(tid)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		// compiled expressions are not shared between workers
+		buildExpr := compileExpr(buildField, hj.builds[i].Descriptor())
+		for !stop() {
+			t, err := it.Next()
+			if err != nil {
+				return err
+			}
+			if t == nil {
+				break
+			}
+			v, err := buildExpr(t)
+			if err != nil {
+				return err
+			}
+			key := hashKey(v)
+			p := local[hashPartition(key, n)]
+			p[key] = append(p[key], hashEntry{v, t})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	table := make([]map[any][]hashEntry, n)
+	runWorkers(n, func(p int, _ func() bool) error {
+		table[p] = parts[0][p]
+		for _, local := range parts[1:] {
+			for key, entries := range local[p] {
+				table[p][key] = append(table[p][key], entries...)
+			}
+		}
+		return nil
+	})
+	return table, nil
+}
+
+// Open an iterator over the joined tuples, which builds the hash table before
+// returning the first one.  Closing it stops the probe workers.
+func (hj *ParallelHashJoin) Open(tid TransactionID) (*OpIterator, error) {
+	var probe *OpIterator
+	return newOpIterator(func() (*Tuple, error) {
+		if probe == nil {
+			table, err := hj.buildTable(tid)
+			if err != nil {
+				return nil, err
+			}
+			probes := make([]Operator, len(hj.probes))
+			for i, p := range hj.probes {
+				probes[i] = &hashProbe{hj, table, p}
+			}
+			if probe, err = NewGather(probes).Open(tid); err != nil {
+				return nil, err
+			}
+		}
+		return probe.Next()
+	}, func() error {
+		return closeIterators(probe)
+	}), nil
+}
+
+// A probe worker of a [ParallelHashJoin], which joins the tuples of its part
+// of the probe side with the matching tuples of the hash table.
+type hashProbe struct {
+	hj    *ParallelHashJoin
+	table []map[any][]hashEntry
+	probe Operator
+}
+
+func (p *hashProbe) Descriptor() *TupleDesc {
+	return p.hj.Descriptor()
+}
+
+func (p *hashProbe) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(p.Open(tid))
+}
+
+func (p *hashProbe) Open(tid TransactionID) (*OpIterator, error) {
+	probeField := p.hj.leftField
+	if p.hj.buildLeft {
+		probeField = p.hj.rightField
+	}
+	it, err := p.probe.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	probeExpr := compileExpr(probeField, p.probe.Descriptor())
+	var probeTuple *Tuple
+	var probeVal DBValue
+	var matches []hashEntry
+	return newOpIterator(func() (*Tuple, error) {
+		for {
+			for len(matches) > 0 {
+				m := matches[0]
+				matches = matches[1:]
+				if !probeVal.EvalPred(m.val, OpEq) {
+					continue
+				}
+				if p.hj.buildLeft {
+					return joinTuples(m.tup, probeTuple), nil
+				}
+				return joinTuples(probeTuple, m.tup), nil
+			}
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			if probeTuple, err = it.Next(); probeTuple == nil || err != nil {
+				return nil, err
+			}
+			if probeVal, err = probeExpr(probeTuple); err != nil {
+				return nil, err
+			}
+			key := hashKey(probeVal)
+			matches = p.table[hashPartition(key, len(p.table))][key]
+		}
+	}, func() error {
+		matches = nil
+		return it.Close()
+	}), nil
+}
+
+// Split the scans of heap files in the plan rooted at op among
+// [Parallelism] workers, where the parallel operators can compute its
+// results.
+func parallelizePlan(op Operator) Operator {
+	inner := op
+	oc, isCard := op.(*OperatorCard)
+	if isCard {
+		inner = oc.Op
+	}
+	var par Operator
+	switch o := inner.(type) {
+	case *Filter, *Project:
+		if workers, ok := partitionPlan(op, Parallelism); ok {
+			par = NewGather(workers)
+		}
+	case *Aggregator:
+		if !o.sorted && o.passes <= 1 && mergeableAggStates(o.newAggState) {
+			if workers, ok := partitionPlan(o.child, Parallelism); ok {
+				par = NewParallelAggregator(o.newAggState, o.groupByFields, workers)
+			}
+		}
+	case *HashJoin:
+		build, probe := *o.right, *o.left
+		if o.buildLeft {
+			build, probe = *o.left, *o.right
+		}
+		if fitsInMemory(build, o.maxBufferSize) {
+			if probes, ok := partitionPlan(probe, Parallelism); ok {
+				builds, ok := partitionPlan(build, Parallelism)
+				if !ok {
+					builds = []Operator{parallelizePlan(build)}
+				}
+				par = NewParallelHashJoin(o.leftField, o.rightField, builds, probes, o.buildLeft)
+			}
+		}
+	case *EqualityJoin:
+		// the inner input is read once per outer tuple, which would start
+		// its workers again each time
+		*o.left = parallelizePlan(*o.left)
+		return op
+	}
+	if par == nil {
+		for _, child := range planChildren(inner) {
+			*child = parallelizePlan(*child)
+		}
+		return op
+	}
+	if isCard {
+		pc := *oc
+		pc.Op = par
+		return &pc
+	}
+	return par
+}
+
+// Returns true if the estimated size of the tuples of op is at most size
+// bytes.
+func fitsInMemory(op Operator, size int) bool {
+	oc, ok := op.(*OperatorCard)
+	return ok && oc.Cardinality >= 0 && oc.Cardinality*estimatedTupleMemory(op.Descriptor()) <= size
+}
+
+// Returns n operators that each compute part of the tuples of op, by scanning
+// part of the pages of the heap file it reads, if op is a pipeline of filters
+// and projections without DISTINCT over a heap file.
+func partitionPlan(op Operator, n int) ([]Operator, bool) {
+	inner := op
+	oc, isCard := op.(*OperatorCard)
+	if isCard {
+		inner = oc.Op
+	}
+	workers := make([]Operator, n)
+	switch o := inner.(type) {
+	case *HeapFile:
+		for i := range workers {
+			workers[i] = NewHeapScanPartition(o, i, n)
+		}
+	case *Filter:
+		children, ok := partitionPlan(o.child, n)
+		if !ok {
+			return nil, false
+		}
+		for i, child := range children {
+			workers[i] = &Filter{o.op, o.left, o.right, child}
+		}
+	case *Project:
+		if o.distinct {
+			return nil, false
+		}
+		children, ok := partitionPlan(o.child, n)
+		if !ok {
+			return nil, false
+		}
+		for i, child := range children {
+			workers[i] = &Project{selectFields: o.selectFields, outputNames: o.outputNames, child: child}
+		}
+	default:
+		return nil, false
+	}
+	if isCard {
+		for i, w := range workers {
+			card, cost := oc.Cardinality, oc.Cost
+			if card > 0 {
+				card = (card + n - 1) / n
+			}
+			if cost > 0 {
+				cost /= float64(n)
+			}
+			workers[i] = costedCard(w, card, cost, nil)
+		}
+	}
+	return workers, true
+}
@@ -0,0 +1,127 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParallelQueries(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (id int, dept int, name varchar, salary float)")
+	mustExecForTest(t, c, bp, "create table dept (id int, title varchar)")
+	values := make([]string, 3000)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, %d, 'employee %d', %d.25)", i, i%7, i%13, i*3)
+	}
+	mustExecForTest(t, c, bp, "insert into emp values "+strings.Join(values, ", "))
+	mustExecForTest(t, c, bp, "insert into dept values (0, 'a'), (1, 'b'), (2, 'c'), (3, 'd'), (5, 'f'), (5, 'g')")
+	if err := c.Analyze(""); err != nil {
+		t.Fatalf(err.Error())
+	}
+	emp, err := c.GetTable("emp")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := emp.(*HeapFile).NumPages(); n < 8 {
+		t.Fatalf("expected emp to span at least 8 pages, got %d", n)
+	}
+
+	queries := []string{
+		"select id, name from emp where dept = 3",
+		"select id + 1, name from emp where salary > 100",
+		"select count(*), sum(salary), avg(id), min(name), max(id) from emp",
+		"select count(*), avg(salary) from emp where id < 0",
+		"select dept, count(*), sum(id), avg(salary), min(id) from emp group by dept",
+		"select dept, name, count(*) from emp group by dept, name",
+		"select emp.id, dept.title from emp join dept on emp.dept = dept.id",
+		"select dept.title, count(*) from emp join dept on emp.dept = dept.id where emp.id > 100 group by dept.title",
+		"select a.id, b.id from emp a join emp b on a.id = b.dept where b.id < 50",
+		"select distinct name from emp where dept = 1",
+		"select name, id from emp where dept = 2 order by id limit 5",
+	}
+	defer func(enabled bool) { EnableVectorizedExecution = enabled }(EnableVectorizedExecution)
+	defer func(n int) { Parallelism = n }(Parallelism)
+	for _, vectorized := range []bool{false, true} {
+		EnableVectorizedExecution = vectorized
+		for _, sql := range queries {
+			sorted := !strings.Contains(sql, "order by")
+			Parallelism = 1
+			want := rowsForTest(t, c, bp, sql, sorted)
+			Parallelism = 4
+			if got := rowsForTest(t, c, bp, sql, sorted); got != want {
+				t.Errorf("%s (vectorized: %v): parallel execution returned\n%s\nexpected\n%s", sql, vectorized, got, want)
+			}
+		}
+	}
+	EnableVectorizedExecution = false
+
+	Parallelism = 4
+	for sql, ops := range map[string][]string{
+		"explain select id from emp where dept = 3":                                  {"Gather, 4 workers", "part 1/4"},
+		"explain select dept, count(*) from emp group by dept":                       {"Parallel Aggregate", "part 1/4"},
+		"explain select emp.id, dept.title from emp join dept on emp.dept = dept.id": {"Parallel Hash Join", "part 1/4"},
+	} {
+		plan := strings.Join(explainForTest(t, c, bp, sql), "\n")
+		for _, op := range ops {
+			if !strings.Contains(plan, op) {
+				t.Errorf("expected %s in the plan:\n%s", op, plan)
+			}
+		}
+	}
+
+	// EXPLAIN ANALYZE shows the rows of all workers, not just the first one
+	lines := explainForTest(t, c, bp, "explain analyze select id from emp where dept = 3")
+	rowsRe := regexp.MustCompile(`actual rows:(\d+) loops:(\d+)`)
+	var rows, loops []string
+	for _, line := range lines {
+		if m := rowsRe.FindStringSubmatch(line); m != nil {
+			rows, loops = append(rows, m[1]), append(loops, m[2])
+		}
+	}
+	if len(rows) < 2 || rows[0] != rows[1] || loops[1] != "4" {
+		t.Errorf("expected the workers to produce the rows of the gather in 4 loops:\n%s", strings.Join(lines, "\n"))
+	}
+}
+
+// Parallel queries that are abandoned after their first row stop their
+// workers, which close their iterators.
+func TestAbandonedParallelQueries(t *testing.T) {
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (id int, grp int, name varchar)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	values := make([]string, 3000)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, %d, 'name %d')", i, i%10, i)
+	}
+	if _, err := db.Exec("insert into t values " + strings.Join(values, ", ")); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	defer func(n int) { Parallelism = n }(Parallelism)
+	Parallelism = 3
+	for _, sql := range []string{
+		"select id, name from t where grp = 3",
+		"select grp, count(*) from t group by grp",
+		"select a.id, b.name from t a join t b on a.id = b.grp",
+	} {
+		open := openIterators.Load()
+		rows, err := db.Query(sql)
+		if err != nil {
+			t.Fatalf("%s: %s", sql, err.Error())
+		}
+		if !rows.Next() {
+			t.Errorf("%s: expected a row, got none (%v)", sql, rows.Err())
+		}
+		rows.Close()
+		if n := openIterators.Load(); n != open {
+			t.Errorf("%s: expected %d open iterators, got %d", sql, open, n)
+		}
+	}
+}
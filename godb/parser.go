@@ -3,6 +3,7 @@ package godb
 import (
 	"fmt"
 	"reflect"
+	"regexp"
 	"strconv"
 	"strings"
 	"unsafe"
@@ -24,11 +25,13 @@ type LogicalJoinNode struct {
 type SelectExprType int
 
 const (
-	ExprField SelectExprType = iota
-	ExprConst SelectExprType = iota
-	ExprFunc  SelectExprType = iota
-	ExprStar  SelectExprType = iota
-	ExprAggr  SelectExprType = iota
+	ExprField  SelectExprType = iota
+	ExprConst  SelectExprType = iota
+	ExprFunc   SelectExprType = iota
+	ExprStar   SelectExprType = iota
+	ExprAggr   SelectExprType = iota
+	ExprParam  SelectExprType = iota // a parameter of a prepared statement
+	ExprWindow SelectExprType = iota // a window function, computed by a [Window]
 )
 
 type LogicalSelectNode struct {
@@ -38,8 +41,11 @@ type LogicalSelectNode struct {
 	funcOp      *string //may be nil, if no aggregate
 	alias       string
 	value       string
+	constType   DBType               // type of a constant; UnknownType if it should be inferred from value
 	args        []*LogicalSelectNode //for functions other than aggregates
 	cachedField *FieldType
+	param       int         // the number of a parameter, from 1
+	window      *windowSpec // the window of a window function
 }
 
 func NewFieldSelectNode(table string, field string, alias string) LogicalSelectNode {
@@ -55,10 +61,34 @@ func NewConstSelectNode(value string, alias string) LogicalSelectNode {
 	lsn := LogicalSelectNode{}
 	lsn.exprType = ExprConst
 	lsn.value = value
+	lsn.constType = UnknownType
 	lsn.alias = alias
 	return lsn
 }
 
+func NewTypedConstSelectNode(value string, constType DBType, alias string) LogicalSelectNode {
+	lsn := NewConstSelectNode(value, alias)
+	lsn.constType = constType
+	return lsn
+}
+
+// A parameter of a prepared statement, whose value is bound when the statement
+// is planned (see [PreparedStatement]).
+func NewParamSelectNode(param int, alias string) LogicalSelectNode {
+	lsn := LogicalSelectNode{}
+	lsn.exprType = ExprParam
+	lsn.param = param
+	lsn.value = fmt.Sprintf("$%d", param)
+	lsn.alias = alias
+	return lsn
+}
+
+// A cast of arg is a function whose name is castFunc, cast_as_<type> (see
+// [rewriteCasts]).
+func NewCastSelectNode(arg *LogicalSelectNode, castFunc string, alias string) LogicalSelectNode {
+	return NewFuncSelectNode(castFunc, []*LogicalSelectNode{arg}, alias)
+}
+
 func NewStarSelectNode(table string) LogicalSelectNode {
 	lsn := LogicalSelectNode{}
 	lsn.exprType = ExprStar
@@ -77,6 +107,15 @@ func NewAggrSelectNode(op string, arg *LogicalSelectNode, alias string) LogicalS
 	return lsn
 }
 
+// A call of window function op with the given arguments over a window, whose
+// partition and order by expressions follow the arguments in args.
+func NewWindowSelectNode(op string, args []*LogicalSelectNode, window *windowSpec, alias string) LogicalSelectNode {
+	lsn := NewFuncSelectNode(op, args, alias)
+	lsn.exprType = ExprWindow
+	lsn.window = window
+	return lsn
+}
+
 func NewFuncSelectNode(op string, args []*LogicalSelectNode, alias string) LogicalSelectNode {
 	lsn := LogicalSelectNode{}
 	lsn.exprType = ExprFunc
@@ -98,6 +137,10 @@ func (t SelectExprType) String() string {
 		return "ExprStar"
 	case ExprAggr:
 		return "ExprAggr"
+	case ExprParam:
+		return "ExprParam"
+	case ExprWindow:
+		return "ExprWindow"
 	default:
 		return "Unknown"
 	}
@@ -162,15 +205,12 @@ func checkNameInTablesOrSubqueries(table string, field string, c *Catalog, subqu
 		}
 	}
 	if table == "" && c != nil && ts != nil {
-		catTs := c.findTablesWithColumn(field)
-		for _, t := range catTs {
-			for _, t2 := range ts {
-				if t.name == t2.tableName {
-					if table != "" {
-						return "", GoDBError{AmbiguousNameError, fmt.Sprintf("multiple possible table names for field %s in select expression", field)}
-					}
-					table = t.name
+		for _, t := range ts {
+			if _, err := findFieldInTd(FieldType{field, "", UnknownType}, (*t.file).Descriptor()); err == nil {
+				if table != "" {
+					return "", GoDBError{AmbiguousNameError, fmt.Sprintf("multiple possible table names for field %s in select expression", field)}
 				}
+				table = t.tableName
 			}
 		}
 	}
@@ -182,10 +222,10 @@ func checkNameInTablesOrSubqueries(table string, field string, c *Catalog, subqu
 // If catalog is non null, will try to resolve table name from catalog
 // otherwise, will not.
 func (lsn *LogicalSelectNode) getTableField(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode) (string, string, error) {
-	if lsn.exprType == ExprConst {
+	if lsn.exprType == ExprConst || lsn.exprType == ExprParam {
 		return "", "", nil
 	}
-	if lsn.exprType == ExprFunc || lsn.exprType == ExprAggr {
+	if lsn.exprType == ExprFunc || lsn.exprType == ExprAggr || lsn.exprType == ExprWindow {
 		tabName := ""
 		fieldName := ""
 		for _, subLsn := range lsn.args {
@@ -225,6 +265,7 @@ type LogicalTableNode struct {
 	tableName string
 	alias     string
 	file      *DBFile
+	schema    *Catalog // the schema the table is in
 }
 
 type GroupBy struct {
@@ -298,6 +339,14 @@ func parseWhere(c *Catalog, subqueries []*LogicalPlan, ts []*LogicalTableNode, e
 			return []*LogicalFilterNode{{*left, *right, op}}, nil, nil
 		}
 
+	case *sqlparser.CaseExpr:
+		// a CASE whose results are conditions holds if its value is true
+		cond, err := parseCase(c, expr, "")
+		if err != nil {
+			return nil, nil, err
+		}
+		return []*LogicalFilterNode{{*cond, NewTypedConstSelectNode("true", BoolType, ""), OpEq}}, nil, nil
+
 	default:
 		return nil, nil, GoDBError{ParseError, "where expression with non value or column on RHS (disjunctions and nested where expressions are not supported)"}
 	}
@@ -318,17 +367,39 @@ func parseFrom(c *Catalog, t sqlparser.TableExpr) ([]*LogicalTableNode, []*Logic
 				}
 				subplan.alias = strings.ToLower(sqlparser.String(tableEx.As))
 				return nil, []*LogicalPlan{subplan}, nil, nil
+			case *sqlparser.Union, *sqlparser.ParenSelect:
+				tables, err := subqueryTable(c, strings.ToLower(sqlparser.String(tableEx.As)), stmt)
+				return tables, nil, nil, err
+			}
+		case sqlparser.TableName:
+			if tn := tableEx.Expr.(sqlparser.TableName); tn.Qualifier.IsEmpty() {
+				if cte := c.ctes[strings.ToLower(tn.Name.CompliantName())]; cte != nil {
+					tables, subplans, err := cte.reference(strings.ToLower(sqlparser.String(tableEx.As)))
+					return tables, subplans, nil, err
+				}
+			}
+			schema, tableName, err := c.resolveTableName(tableEx.Expr.(sqlparser.TableName))
+			if err != nil {
+				return nil, nil, nil, err
 			}
-		case sqlparser.SimpleTableExpr:
-			tableName := strings.ToLower(sqlparser.GetTableName(tableEx.Expr).CompliantName())
 			//fmt.Printf("got simple table, name %s\n", tableName)
-			dbFile, err := c.GetTable(tableName)
+			if view := schema.getView(tableName); view != nil {
+				// the view's statement is resolved in the view's schema
+				alias := view.name
+				if !tableEx.As.IsEmpty() {
+					alias = strings.ToLower(sqlparser.String(tableEx.As))
+				}
+				tables, subplans, err := view.reference(schema, alias)
+				return tables, subplans, nil, err
+			}
+			dbFile, err := schema.GetTable(tableName)
 			if err != nil {
 				return nil, nil, nil, err
 			}
 			table := LogicalTableNode{tableName,
 				strings.ToLower(sqlparser.String(tableEx.As)),
-				&dbFile}
+				&dbFile,
+				schema}
 			table.alias = strings.ToLower(sqlparser.String(tableEx.As))
 			return []*LogicalTableNode{&table}, nil, nil, nil
 		}
@@ -381,6 +452,9 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 	switch expr := expr.(type) {
 	case *sqlparser.FuncExpr:
 		funName := strings.ToLower(sqlparser.String(expr.Name))
+		if funName == windowFuncName {
+			return parseWindow(c, expr, alias)
+		}
 		if isAgg(funName) {
 			if len(expr.Exprs) != 1 {
 				return nil, GoDBError{ParseError, fmt.Sprintf("expected one argument to aggregate %s in select list", sqlparser.String(expr.Name))}
@@ -402,6 +476,9 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 			return &outer, nil
 		} else {
 			funName := strings.ToLower(sqlparser.String(expr.Name))
+			if expr.Name.Lowered() == "if" {
+				return parseIf(c, expr, alias)
+			}
 			exprList := make([]*LogicalSelectNode, len(expr.Exprs))
 			for i, subExpr := range expr.Exprs {
 				e, err := parseSelect(c, subExpr)
@@ -413,6 +490,16 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 			if funName[0] == '\'' || funName[0] == '`' {
 				funName = funName[1 : len(funName)-1]
 			}
+			if strings.HasPrefix(funName, castFuncPrefix) {
+				if len(exprList) != 1 {
+					return nil, GoDBError{ParseError, "expected one argument to cast"}
+				}
+				if _, _, err := castFuncType(funName); err != nil {
+					return nil, err
+				}
+				outer := NewCastSelectNode(exprList[0], funName, alias)
+				return &outer, nil
+			}
 			outer := NewFuncSelectNode(funName, exprList, alias)
 			return &outer, nil
 		}
@@ -433,8 +520,28 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 		return &outer, nil
 	case *sqlparser.ParenExpr:
 		return parseExpr(c, expr.Expr, alias)
+	case *sqlparser.CaseExpr:
+		return parseCase(c, expr, alias)
+	case *sqlparser.ConvertExpr:
+		arg, err := parseExpr(c, expr.Expr, "")
+		if err != nil {
+			return nil, err
+		}
+		castFunc := castFuncPrefix + strings.ToLower(expr.Type.Type)
+		if expr.Type.Length != nil {
+			castFunc += "_" + string(expr.Type.Length.Val)
+			if expr.Type.Scale != nil {
+				castFunc += "_" + string(expr.Type.Scale.Val)
+			}
+		}
+		if _, _, err := castFuncType(castFunc); err != nil {
+			return nil, err
+		}
+		outer := NewCastSelectNode(arg, castFunc, alias)
+		return &outer, nil
 	case *sqlparser.ColName:
-		field := NewFieldSelectNode(strings.ToLower(sqlparser.String(expr.Qualifier)), strings.ToLower(sqlparser.String(expr.Name)), alias)
+		// a column of schema.table is qualified by the table name alone
+		field := NewFieldSelectNode(strings.ToLower(sqlparser.String(expr.Qualifier.Name)), strings.ToLower(sqlparser.String(expr.Name)), alias)
 		if len(field.table) > 1 && (field.table[0] == '\'' || field.table[0] == '`') {
 			field.table = field.table[1 : len(field.table)-1]
 		}
@@ -444,13 +551,52 @@ func parseExpr(c *Catalog, expr sqlparser.Expr, alias string) (*LogicalSelectNod
 
 		return &field, nil
 	case *sqlparser.SQLVal:
+		if expr.Type == sqlparser.ValArg {
+			// ? and $n are parsed as :v1, :v2, ...
+			n, err := strconv.Atoi(strings.TrimPrefix(string(expr.Val), ":v"))
+			if err != nil || n < 1 {
+				return nil, GoDBError{ParseError, fmt.Sprintf("unsupported parameter %s", expr.Val)}
+			}
+			field := NewParamSelectNode(n, alias)
+			return &field, nil
+		}
 		str := sqlparser.String(expr)
 		if str[0] == '\'' {
 			str = str[1 : len(str)-1]
 			//str = str[-1]
 		}
 		field := NewConstSelectNode(str, alias)
+		switch expr.Type {
+		case sqlparser.StrVal:
+			field.constType = StringType
+		case sqlparser.IntVal:
+			field.constType = IntType
+		case sqlparser.FloatVal:
+			// literals with a decimal point are exact, as in standard SQL
+			field.constType = DecimalType
+			if strings.ContainsAny(str, "eE") {
+				field.constType = FloatType
+			}
+		}
 		return &field, nil
+	case sqlparser.BoolVal:
+		field := NewTypedConstSelectNode(strconv.FormatBool(bool(expr)), BoolType, alias)
+		return &field, nil
+	case *sqlparser.UnaryExpr:
+		if expr.Operator != sqlparser.UMinusStr {
+			return nil, GoDBError{ParseError, fmt.Sprintf("unsupported operator %s", expr.Operator)}
+		}
+		arg, err := parseExpr(c, expr.Expr, alias)
+		if err != nil {
+			return nil, err
+		}
+		if arg.exprType == ExprConst && arg.value != "" && arg.value[0] != '-' {
+			arg.value = "-" + arg.value
+			return arg, nil
+		}
+		zero := NewConstSelectNode("0", "")
+		outer := NewFuncSelectNode("-", []*LogicalSelectNode{&zero, arg}, alias)
+		return &outer, nil
 	default:
 		return nil, GoDBError{ParseError, fmt.Sprintf("unsupported expression type %s in select list", reflect.TypeOf(expr))}
 	}
@@ -477,7 +623,7 @@ func extractAggs(s *LogicalSelectNode) []*LogicalSelectNode {
 	switch s.exprType {
 	case ExprAggr:
 		return []*LogicalSelectNode{s}
-	case ExprFunc:
+	case ExprFunc, ExprWindow:
 		var aggs []*LogicalSelectNode
 		for _, subs := range s.args {
 			aggs = append(aggs, extractAggs(subs)...)
@@ -545,28 +691,36 @@ func parseStatement(c *Catalog, s *sqlparser.Select) (*LogicalPlan, error) {
 		groupBys[i] = &GroupBy{expr}
 	}
 
-	var orderBys = make([]*OrderByNode, len(s.OrderBy))
-	for i, oby := range s.OrderBy {
+	orderBys, limExpr, err := parseOrderAndLimit(c, s.OrderBy, s.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", ""}
+
+	return &p, nil
+}
+
+// Parse the ORDER BY and LIMIT clauses of a query.
+func parseOrderAndLimit(c *Catalog, orderBy sqlparser.OrderBy, lim *sqlparser.Limit) ([]*OrderByNode, *LogicalSelectNode, error) {
+	var orderBys = make([]*OrderByNode, len(orderBy))
+	for i, oby := range orderBy {
 		expr, err := parseExpr(c, oby.Expr, "")
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		orderBys[i] = &OrderByNode{expr, oby.Direction == sqlparser.AscScr}
 	}
 
-	lim := s.Limit
 	var limExpr *LogicalSelectNode
 	if lim != nil {
 		var err error
 		limExpr, err = parseExpr(c, lim.Rowcount, "")
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 	}
-
-	p := LogicalPlan{filters, joins, selects, aggs, tables, subplans, groupBys, orderBys, limExpr, s.Distinct != "", ""}
-
-	return &p, nil
+	return orderBys, limExpr, nil
 }
 
 // Given a table name tab, a field name, and a map between table names and operators, do one of the following:
@@ -629,7 +783,7 @@ type PlanNode struct {
 
 func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, tableMap map[string]*PlanNode) (Expr, string, error) {
 	switch s.exprType {
-	case ExprAggr:
+	case ExprWindow, ExprAggr:
 		fallthrough
 	case ExprField:
 		var field FieldType
@@ -683,13 +837,16 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		return &e, fieldName, nil
 	case ExprConst:
 		var fval DBValue
-		constType := StringType
-		intFval, e := strconv.Atoi(s.value)
-		if e == nil {
-			constType = IntType
-			fval = IntField{int64(intFval)}
-		} else {
-			fval = StringField{s.value}
+		constType := s.constType
+		if constType == UnknownType {
+			constType = StringType
+			if _, e := strconv.Atoi(s.value); e == nil {
+				constType = IntType
+			}
+		}
+		fval, err := parseValue(s.value, constType)
+		if err != nil {
+			return nil, "", err
 		}
 		fieldName := s.value
 		if s.alias != "" {
@@ -697,6 +854,16 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 		}
 		ce := ConstExpr{fval, constType}
 		return &ce, fieldName, nil
+	case ExprParam:
+		ce, err := c.schemas.binding.bind(s.param)
+		if err != nil {
+			return nil, "", err
+		}
+		fieldName := s.value
+		if s.alias != "" {
+			fieldName = s.alias
+		}
+		return ce, fieldName, nil
 	case ExprFunc:
 		fieldName := *s.funcOp
 		if s.alias != "" {
@@ -711,8 +878,43 @@ func (s *LogicalSelectNode) generateExpr(c *Catalog, inputDesc *TupleDesc, table
 			exprs[i] = &newExpr
 		}
 
-		fe := FuncExpr{*s.funcOp, exprs}
-		return &fe, fieldName, nil
+		if strings.HasPrefix(*s.funcOp, castFuncPrefix) {
+			t, scale, err := castFuncType(*s.funcOp)
+			if err != nil {
+				return nil, "", err
+			}
+			if s.alias == "" {
+				fieldName = "cast"
+			}
+			arg := *exprs[0]
+			// parameters are cast when the query runs (see [castExpr])
+			if ce, ok := arg.(*ConstExpr); ok {
+				v, err := castValue(ce.val, t, scale)
+				if err != nil {
+					return nil, "", err
+				}
+				return &ConstExpr{v, t}, fieldName, nil
+			}
+			if from := arg.GetExprType().Ftype; !castable(from, t) {
+				return nil, "", GoDBError{TypeMismatchError, fmt.Sprintf("cannot cast %s %s to %s", exprToStr(arg), from, t)}
+			}
+			return &CastExpr{arg, t, scale}, fieldName, nil
+		}
+		var fe Expr
+		var err error
+		if bind, ok := conditionalFuncs[*s.funcOp]; ok {
+			args := make([]Expr, len(exprs))
+			for i, e := range exprs {
+				args[i] = *e
+			}
+			fe, err = bind(*s.funcOp, args)
+		} else {
+			fe, err = bindFunc(*s.funcOp, exprs)
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		return foldConstants(fe), fieldName, nil
 	}
 	return nil, "", GoDBError{ParseError, "unhandled expression type in select list"}
 
@@ -729,13 +931,50 @@ func exprToStr(e Expr) string {
 		}
 		return fmt.Sprintf("%s%s", tbl, ex.selectField.Fname)
 	case *ConstExpr:
-		return fmt.Sprintf("%v", ex.val)
+		if sv, ok := ex.val.(StringField); ok {
+			return "'" + sv.Value + "'"
+		}
+		return valueString(ex.val)
+	case *ParamExpr:
+		return fmt.Sprintf("$%d", ex.n)
+	case *CastExpr:
+		return fmt.Sprintf("cast(%s as %s)", exprToStr(ex.expr), ex.ftype)
 	case *FuncExpr:
 		argStr := ""
 		for _, arg := range ex.args {
 			argStr += fmt.Sprintf("%s,", exprToStr(*arg))
 		}
 		return fmt.Sprintf("%s(%s)", ex.op, argStr)
+	case *RenamedExpr:
+		return exprToStr(ex.expr)
+	case *CompareExpr:
+		return fmt.Sprintf("%s %s %s", exprToStr(ex.left), strings.TrimSpace(opToStr(ex.op)), exprToStr(ex.right))
+	case *LogicExpr:
+		if ex.op == "not" {
+			return fmt.Sprintf("not (%s)", exprToStr(ex.args[0]))
+		}
+		return fmt.Sprintf("(%s %s %s)", exprToStr(ex.args[0]), ex.op, exprToStr(ex.args[1]))
+	case *CaseExpr:
+		if ex.name == "if" {
+			return fmt.Sprintf("if(%s, %s, %s)", exprToStr(ex.args[0]), exprToStr(ex.args[1]), exprToStr(ex.args[2]))
+		}
+		var b strings.Builder
+		b.WriteString("case")
+		i := 0
+		for ; i+1 < len(ex.args); i += 2 {
+			fmt.Fprintf(&b, " when %s then %s", exprToStr(ex.args[i]), exprToStr(ex.args[i+1]))
+		}
+		if i < len(ex.args) {
+			fmt.Fprintf(&b, " else %s", exprToStr(ex.args[i]))
+		}
+		b.WriteString(" end")
+		return b.String()
+	case *ChoiceExpr:
+		args := make([]string, len(ex.args))
+		for i, arg := range ex.args {
+			args[i] = exprToStr(arg)
+		}
+		return fmt.Sprintf("%s(%s)", ex.op, strings.Join(args, ", "))
 	default:
 		return fmt.Sprintf("%+v, ", e)
 	}
@@ -768,30 +1007,67 @@ func GetUnexportedField(field reflect.Value) interface{} {
 	return reflect.NewAt(field.Type(), unsafe.Pointer(field.UnsafeAddr())).Elem().Interface()
 }
 
+// Returns the estimated cardinality of an operator and, if it was run by
+// EXPLAIN ANALYZE, its actual statistics.
+func cardString(oc *OperatorCard) string {
+	card := "card:?"
+	if oc.Cardinality >= 0 {
+		card = fmt.Sprintf("card:%d", oc.Cardinality)
+	}
+	if oc.Cost >= 0 {
+		card += fmt.Sprintf(" cost:%.0f", oc.Cost)
+	}
+	if len(oc.Rejected) > 0 {
+		rejected := make([]string, len(oc.Rejected))
+		for i, r := range oc.Rejected {
+			rejected[i] = r.String()
+		}
+		card += " rejected:[" + strings.Join(rejected, ", ") + "]"
+	}
+	if oc.actual != nil {
+		card += " (" + oc.actual.String() + ")"
+	}
+	return card
+}
+
 func OutputPhysicalPlan(printf func(format string, a ...any), o Operator, indent string) {
-	oc := o.(*OperatorCard)
+	oc, ok := o.(*OperatorCard)
+	if !ok {
+		oc = NewOperatorCard(o, -1)
+	}
 	switch op := oc.Op.(type) {
 	case *EqualityJoin:
-		printf("%sJoin, %+v == %+v, card:%d\n", indent, exprToStr(op.leftField), exprToStr(op.rightField), oc.Cardinality)
+		printf("%sJoin, %+v == %+v, %s\n", indent, exprToStr(op.leftField), exprToStr(op.rightField), cardString(oc))
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, *op.left, indent)
 		OutputPhysicalPlan(printf, *op.right, indent)
+	case *HashJoin:
+		printf("%sHash Join, %+v == %+v, build %s, %s\n", indent, exprToStr(op.leftField), exprToStr(op.rightField), op.buildSide(), cardString(oc))
+		indent = indent + "\t"
+		OutputPhysicalPlan(printf, *op.left, indent)
+		OutputPhysicalPlan(printf, *op.right, indent)
+	case *Materialize:
+		printf("%sMaterialize, %s\n", indent, cardString(oc))
+		OutputPhysicalPlan(printf, op.child, indent+"\t")
 	case *Project:
 		selectStr := ""
 		for _, ex := range op.selectFields {
 			selectStr += exprToStr(ex) + ","
 		}
-		printf("%sProject %+v -> %+v, card:%d\n", indent, selectStr, op.outputNames, oc.Cardinality)
+		if op.distinct {
+			selectStr = "distinct " + strategyString(op.sorted, op.passes) + " " + selectStr
+		}
+		printf("%sProject %+v -> %+v, %s\n", indent, selectStr, op.outputNames, cardString(oc))
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, op.child, indent)
 
 	case *Filter:
-		printf("%sFilter %s %s %s, card:%d", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right), oc.Cardinality)
+		printf("%sFilter %s %s %s, %s\n", indent, exprToStr(op.left), opToStr(op.op), exprToStr(op.right), cardString(oc))
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, op.child, indent)
 
 	case *HeapFile:
-		printf("%sHeap Scan %s, card:%d\n", indent, op.BackingFile(), oc.Cardinality)
+		printf("%sHeap Scan %s, %s\n", indent, op.BackingFile(), cardString(oc))
 
 	case *OrderBy:
 		orderStr := ""
@@ -801,12 +1077,12 @@ func OutputPhysicalPlan(printf func(format string, a ...any), o Operator, indent
 				orderStr += ", " + exprToStr(op.orderBy[i])
 			}
 		}
-		printf("%sOrder By %s, card:%d\n", indent, orderStr, oc.Cardinality)
+		printf("%sOrder By %s, %s\n", indent, orderStr, cardString(oc))
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, op.child, indent)
 
 	case *LimitOp:
-		printf("%sLimit %s, card:%d\n", indent, exprToStr(op.limitTups), oc.Cardinality)
+		printf("%sLimit %s, %s\n", indent, exprToStr(op.limitTups), cardString(oc))
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, op.child, indent)
 
@@ -818,29 +1094,158 @@ func OutputPhysicalPlan(printf func(format string, a ...any), o Operator, indent
 		for _, ex := range op.groupByFields {
 			gbyStr += exprToStr(ex) + ","
 		}
+		if len(op.groupByFields) > 0 {
+			gbyStr += " " + strategyString(op.sorted, op.passes)
+		}
 
 		aggStr := ""
 		for _, ex := range op.newAggState {
 			aggStr += fmt.Sprintf("%s(%s),", reflect.TypeOf(ex), ex.GetTupleDesc().HeaderString(false))
 		}
 
-		printf("%sAggregate, %s %s, card:%d\n", indent, aggStr, gbyStr, oc.Cardinality)
+		printf("%sAggregate, %s %s, %s\n", indent, aggStr, gbyStr, cardString(oc))
 		indent = indent + "\t"
 		OutputPhysicalPlan(printf, op.child, indent)
 
+	case *InsertOp:
+		printf("%sInsert into %s, %s\n", indent, fileName(op.file), cardString(oc))
+		OutputPhysicalPlan(printf, op.op, indent+"\t")
+
+	case *DeleteOp:
+		printf("%sDelete from %s, %s\n", indent, fileName(op.file), cardString(oc))
+		OutputPhysicalPlan(printf, op.op, indent+"\t")
+
+	case *ValueOp:
+		printf("%sValues, %d rows, %s\n", indent, len(op.exprs), cardString(oc))
+
+	case *MemFile:
+		printf("%sMemory Scan, %d pages, %s\n", indent, op.NumPages(), cardString(oc))
+
+	// the queries of a common table expression are shown under each
+	// reference to it
+	case *CommonTable:
+		switch {
+		case op.plan == nil:
+			printf("%sWorking Table Scan %s, %s\n", indent, op.name, cardString(oc))
+		case op.step == nil:
+			printf("%sCTE Scan %s, %s\n", indent, op.name, cardString(oc))
+			OutputPhysicalPlan(printf, op.plan, indent+"\t")
+		default:
+			printf("%sRecursive CTE Scan %s, %s, %s\n", indent, op.name, setOpName("Union", op.all), cardString(oc))
+			OutputPhysicalPlan(printf, op.plan, indent+"\t")
+			OutputPhysicalPlan(printf, op.step, indent+"\t")
+		}
+
+	case *Vectorized:
+		printf("%sVectorized, %s\n", indent, cardString(oc))
+		outputBatchPlan(printf, op.plan, indent+"\t")
+
+	case *Union:
+		strategy := ""
+		if !op.all {
+			strategy = " " + strategyString(false, op.passes)
+		}
+		printf("%s%s%s, %s\n", indent, setOpName("Union", op.all), strategy, cardString(oc))
+		OutputPhysicalPlan(printf, op.left, indent+"\t")
+		OutputPhysicalPlan(printf, op.right, indent+"\t")
+
+	case *Intersect:
+		printf("%s%s %s, %s\n", indent, setOpName("Intersect", op.all), strategyString(false, op.passes), cardString(oc))
+		OutputPhysicalPlan(printf, op.left, indent+"\t")
+		OutputPhysicalPlan(printf, op.right, indent+"\t")
+
+	case *Except:
+		printf("%s%s %s, %s\n", indent, setOpName("Except", op.all), strategyString(false, op.passes), cardString(oc))
+		OutputPhysicalPlan(printf, op.left, indent+"\t")
+		OutputPhysicalPlan(printf, op.right, indent+"\t")
+
+	case *Window:
+		exprs := func(es []Expr) string {
+			strs := make([]string, len(es))
+			for i, e := range es {
+				strs[i] = exprToStr(e)
+			}
+			return strings.Join(strs, ", ")
+		}
+		var parts []string
+		if len(op.partitionBy) > 0 {
+			parts = append(parts, "partition by "+exprs(op.partitionBy))
+		}
+		if len(op.orderBy) > 0 {
+			parts = append(parts, "order by "+exprs(op.orderBy))
+		}
+		var funcs []string
+		for _, f := range op.funcs {
+			funcs = append(funcs, f.String())
+		}
+		parts = append(parts, strings.Join(funcs, ", "))
+		printf("%sWindow %s, %s\n", indent, strings.Join(parts, ", "), cardString(oc))
+		OutputPhysicalPlan(printf, op.child, indent+"\t")
+
+	case *HeapScanPartition:
+		printf("%sHeap Scan %s, part %d/%d, %s\n", indent, op.file.BackingFile(), op.part+1, op.parts, cardString(oc))
+
+	// the workers of parallel operators run the same plan over different
+	// parts of their input, so only the first one is shown (see
+	// shownChildren), with the actual statistics of all of them
+	case *Gather:
+		printf("%sGather, %d workers, %s\n", indent, len(op.workers), cardString(oc))
+		OutputPhysicalPlan(printf, op.workers[0], indent+"\t")
+
+	case *ParallelAggregator:
+		gbyStr := ""
+		if len(op.groupByFields) > 0 {
+			gbyStr = "Group By "
+		}
+		for _, ex := range op.groupByFields {
+			gbyStr += exprToStr(ex) + ","
+		}
+		aggStr := ""
+		for _, ex := range op.newAggState {
+			aggStr += fmt.Sprintf("%s(%s),", reflect.TypeOf(ex), ex.GetTupleDesc().HeaderString(false))
+		}
+		printf("%sParallel Aggregate, %s %s %d workers, %s\n", indent, aggStr, gbyStr, len(op.workers), cardString(oc))
+		OutputPhysicalPlan(printf, op.workers[0], indent+"\t")
+
+	case *ParallelHashJoin:
+		printf("%sParallel Hash Join, %+v == %+v, build %s, %d workers, %s\n", indent, exprToStr(op.leftField), exprToStr(op.rightField), op.buildSide(), len(op.probes), cardString(oc))
+		left, right := op.probes[0], op.builds[0]
+		if op.buildLeft {
+			left, right = right, left
+		}
+		OutputPhysicalPlan(printf, left, indent+"\t")
+		OutputPhysicalPlan(printf, right, indent+"\t")
+
 	default:
 		printf("%sUnknown op, %s\n", indent, reflect.TypeOf(op))
 	}
 }
 
+// Returns the name of the file backing f, if it has one.
+func fileName(f DBFile) string {
+	if hf, ok := f.(*HeapFile); ok {
+		return hf.BackingFile()
+	}
+	return reflect.TypeOf(f).String()
+}
+
 func PrintPhysicalPlan(o Operator, indent string) {
 	OutputPhysicalPlan(func(s string, a ...any) { fmt.Printf(s, a...) }, o, indent)
 }
 
-// Wraps an operator with a cardinality estimate.
+// Wraps an operator with a cardinality estimate, and the estimated cost of
+// the operator chosen by the physical planner along with the alternatives it
+// rejected (see [makePhysicalPlan]).
 type OperatorCard struct {
 	Cardinality int
 	Op          Operator
+	Cost        float64      // negative if there is no estimate
+	Rejected    []PlanChoice // the operators that could have been used instead, if any
+
+	// statistics collected by EXPLAIN ANALYZE, and the buffer pool they
+	// count the pages of; nil unless the plan is being analyzed
+	actual     *OperatorStats
+	bufferPool *BufferPool
 }
 
 func (o *OperatorCard) Descriptor() *TupleDesc {
@@ -848,7 +1253,14 @@ func (o *OperatorCard) Descriptor() *TupleDesc {
 }
 
 func (o *OperatorCard) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
-	return o.Op.Iterator(tid)
+	return iteratorOf(o.Open(tid))
+}
+
+func (o *OperatorCard) Open(tid TransactionID) (*OpIterator, error) {
+	if o.actual != nil {
+		return o.instrumentedIterator(tid)
+	}
+	return o.Op.Open(tid)
 }
 
 func NewOperatorCard(op Operator, card int) *OperatorCard {
@@ -856,7 +1268,7 @@ func NewOperatorCard(op Operator, card int) *OperatorCard {
 	if ok {
 		panic("cannot wrap an operator card in another operator card")
 	}
-	return &OperatorCard{card, op}
+	return &OperatorCard{card, op, -1, nil, nil, nil}
 }
 
 var EnableJoinOptimization = true
@@ -881,218 +1293,42 @@ type TableAndField struct {
 	field string
 }
 
+// Plan the operators of a query block.  The logical tree of its FROM and WHERE
+// clauses is rewritten by the logical optimizer (see [queryBlock.optimize])
+// and planned first; its grouping, select list, ORDER BY and LIMIT are then
+// applied to the result.  The physical planner chooses how each operator is
+// computed (see physical_planner.go).
 func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
-	tableMap := make(map[string]*PlanNode) // mapping from table aliases to operators
-	tableStats := make(map[string]Stats)   // mapping from table aliases to table stats
-	sel := make(map[string]float64)        // mapping from table aliases to selectivities
-
-	for _, p := range plan.subqueries {
-		subPhysP, err := makePhysicalPlan(c, p)
-		if err != nil {
-			return nil, err
-		}
-		td := subPhysP.Descriptor()
-		td.setTableAlias(p.alias)
-		tableMap[p.alias] = &PlanNode{subPhysP, td}
-		tableStats[p.alias] = &DummyStats{}
-		sel[p.alias] = 1.0
-	}
-
-	for _, t := range plan.tables {
-		var stats Stats
-		stats = c.GetTableStats(t.tableName)
-		if stats == nil {
-			stats = &DummyStats{}
-		}
-
-		name := t.tableName
-		if t.alias != "" {
-			name = t.alias
-		}
-		tableStats[name] = stats
-
-		td := (*t.file).Descriptor()
-		td.setTableAlias(name)
-
-		card := 0
-		if stats != nil {
-			card = stats.EstimateCardinality(1.0)
-		}
-		tableMap[name] = &PlanNode{NewOperatorCard(*t.file, card), td}
-		sel[name] = 1.0
-	}
-
-	//now apply each filter to appropriate table
-	for _, f := range plan.filters {
-		tabName, fieldName, err := f.fieldExpr.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
-		}
-		node, err := fieldToOp(tabName, fieldName, tableMap)
-		if err != nil {
-			return nil, err
-		}
-		leftExpr, _, err := f.fieldExpr.generateExpr(c, node.desc, tableMap)
-		if err != nil {
-			return nil, err
-		}
-		rightExpr, _, err := f.constExpr.generateExpr(c, node.desc, tableMap)
-		if err != nil {
-			return nil, err
-		}
-
-		op := node.op
-		desc := *op.Descriptor()
-		desc.setTableAlias(tabName)
-
-		fieldType := leftExpr.GetExprType()
-		table := fieldType.TableQualifier
-		field := fieldType.Fname
-		table_stats := tableStats[table]
-
-		filterSel := 1.0
-		constExpr, ok := rightExpr.(*ConstExpr)
-		if ok && table_stats != nil {
-			filterSel, err = table_stats.EstimateSelectivity(field, f.predOp, constExpr.val)
-		}
-		if err != nil {
-			return nil, err
-		}
-		sel[table] *= filterSel
-
-		newOp, err := NewFilter(rightExpr, f.predOp, leftExpr, op)
-		if err != nil {
-			return nil, err
-		}
-
-		tableMap[table] = &PlanNode{NewOperatorCard(newOp, int(float64(op.Cardinality)*filterSel)), &desc}
-	}
-
-	selects := make(map[TableAndField]*LogicalSelectNode)
-	join_order := make([]*JoinNode, len(plan.joins))
-	for i, j := range plan.joins {
-		leftName, leftField, err := j.left.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
-		}
-
-		rightName, rightField, err := j.right.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
-		}
-
-		leftStats := tableStats[leftName]
-		if leftStats == nil {
-			return nil, GoDBError{ParseError, fmt.Sprintf("no stats for lhs table %s, join %v, tables %v", leftName, j.left, tableMap)}
-		}
-
-		rightStats := tableStats[rightName]
-		if rightStats == nil {
-			return nil, GoDBError{ParseError, fmt.Sprintf("no stats for rhs table %s, join %v, tables %v", rightName, j, tableMap)}
-		}
-
-		join_order[i] = &JoinNode{
-			leftTable:  TableInfo{leftName, leftStats, sel[leftName]},
-			leftField:  leftField,
-			rightTable: TableInfo{rightName, rightStats, sel[rightName]},
-			rightField: rightField,
-		}
-		selects[TableAndField{leftName, leftField}] = j.left
-		selects[TableAndField{rightName, rightField}] = j.right
+	b, err := newQueryBlock(c, plan)
+	if err != nil {
+		return nil, err
 	}
-
-	if EnableJoinOptimization {
-		var err error
-		join_order, err = OrderJoins(join_order)
-		if err != nil {
+	root := b.logicalTree()
+	if EnableLogicalOptimization {
+		if root, err = b.optimize(root); err != nil {
 			return nil, err
 		}
 	}
-
-	//finally apply joins
-	for _, j := range join_order {
-		left := selects[TableAndField{j.leftTable.name, j.leftField}]
-		right := selects[TableAndField{j.rightTable.name, j.rightField}]
-
-		lTabName, lFieldName, err := left.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
-		}
-
-		node1, err := fieldToOp(lTabName, lFieldName, tableMap)
-		if err != nil {
-			return nil, err
-		}
-
-		rTabName, rFieldName, err := right.getTableField(c, plan.subqueries, plan.tables)
-		if err != nil {
-			return nil, err
-		}
-
-		node2, err := fieldToOp(rTabName, rFieldName, tableMap)
-		if err != nil {
-			return nil, err
-		}
-
-		/*desc1 := *op1.Descriptor()
-		desc1.setTableAlias(j.t1)
-		desc2 := *op2.Descriptor()
-		desc2.setTableAlias(j.t2)
-		*/
-		op1 := node1.op
-		op2 := node2.op
-
-		/*
-			leftField, _ := fieldNameToField(j.t1, j.f1, node1)
-			rightField, _ := fieldNameToField(j.t2, j.f2, node2)
-		*/
-		leftExpr, _, err := left.generateExpr(c, node1.desc, tableMap)
-		if err != nil {
-			return nil, err
-		}
-		rightExpr, _, err := right.generateExpr(c, node2.desc, tableMap)
-		if err != nil {
-			return nil, err
-		}
-
-		newOp, err := NewJoin(op1, leftExpr, op2, rightExpr, JoinBufferSize)
-		if err != nil {
-			return nil, err
-		}
-
-		newNode := &PlanNode{NewOperatorCard(newOp, EstimateJoinCardinality(node1.op.Cardinality, node2.op.Cardinality)), newOp.Descriptor()}
-		for key, node := range tableMap {
-			if node.op == op1 {
-				tableMap[key] = newNode
-			}
-			if node.op == op2 {
-				tableMap[key] = newNode
-			}
-		}
-		tableMap[lTabName] = newNode
-		tableMap[rTabName] = newNode
+	name, err := b.planRelations(root)
+	if err != nil {
+		return nil, err
 	}
+	tableMap := b.tableMap
 
 	//check that all tables have the same op (all tables are joined)
-	first := true
-	var curOp *OperatorCard
 	for _, node := range tableMap {
-		if first {
-			curOp = node.op
-			first = false
-		} else {
-			if curOp != node.op {
-				return nil, GoDBError{ParseError, "not all tables are joined, cross products are not supported in GoDB"}
-			}
+		if node.op != tableMap[name].op {
+			return nil, GoDBError{ParseError, "not all tables are joined, cross products are not supported in GoDB"}
 		}
 	}
 
-	topOp := curOp
+	topOp := tableMap[name].op
 
 	//var fieldList []FieldType
 	var fieldNames []string
 	hasAgg := len(plan.aggs) > 0
 	selectAll := false
+	selects := plan.selects
 
 	/*
 		for _, s := range plan.selects {
@@ -1121,8 +1357,6 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 			*/
 
 			if s.exprType == ExprAggr {
-				var as AggState
-
 				tabName, fieldName, err := s.args[0].getTableField(c, plan.subqueries, plan.tables)
 				if err != nil {
 					return nil, err
@@ -1136,29 +1370,13 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 					return nil, err
 				}
 
-				switch *s.funcOp {
-				case "max":
-					as = &MaxAggState{}
-
-				case "min":
-					as = &MinAggState{}
-				case "avg":
-					as = &AvgAggState{}
-				case "sum":
-					as = &SumAggState{}
-				case "count":
-					as = &CountAggState{}
-				default:
-					return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", *s.funcOp)}
-				}
-
 				//make sure name has unique id
 				name := fmt.Sprintf("%s(%s.%s)%d", *s.funcOp, tabName, fieldName, aggCnt)
 				aggCnt++
 				if s.alias != "" {
 					name = s.alias
 				}
-				err = as.Init(name, aggExpr)
+				as, err := newAggState(*s.funcOp, name, aggExpr)
 				if err != nil {
 					return nil, err
 				}
@@ -1172,23 +1390,46 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 			}
 		}
 
+		// the select list reads the values of computed GROUP BY expressions
+		// from their columns, rather than computing them again from columns
+		// that the aggregate does not return
+		groupCols := make(map[string]*FieldType)
 		for _, gby := range plan.groupByFields {
 			expr, _, err := gby.expr.generateExpr(c, topOp.Descriptor(), tableMap)
 			if err != nil {
 				return nil, err
 			}
+			if gby.expr.exprType == ExprFunc {
+				key := exprKey(gby.expr)
+				if groupCols[key] != nil {
+					continue
+				}
+				expr = &RenamedExpr{expr, key}
+				field := expr.GetExprType()
+				groupCols[key] = &field
+			}
 			gbys = append(gbys, expr)
 		}
 
-		if len(gbys) == 0 {
-			topOp = NewOperatorCard(NewAggregator(aggs, topOp), 1)
-		} else {
-			topOp = NewOperatorCard(NewGroupedAggregator(aggs, gbys, topOp), 0)
+		if topOp, err = b.planAggregate(aggs, gbys, topOp); err != nil {
+			return nil, err
+		}
+		if len(groupCols) > 0 {
+			selects = make([]*LogicalSelectNode, len(plan.selects))
+			for i, s := range plan.selects {
+				selects[i] = readGroupColumns(s, groupCols)
+			}
+		}
+	}
+
+	if windows := extractWindows(selects); len(windows) > 0 {
+		if topOp, err = planWindows(c, windows, tableMap, topOp); err != nil {
+			return nil, err
 		}
 	}
 
-	exprList := make([]Expr, len(plan.selects))
-	for i, s := range plan.selects {
+	exprList := make([]Expr, len(selects))
+	for i, s := range selects {
 		switch s.exprType {
 		case ExprStar:
 			if s.field == "*" && s.funcOp == nil {
@@ -1204,18 +1445,77 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		}
 	}
 	if !selectAll {
-		projOp, err := NewProjectOp(exprList, fieldNames, plan.distinct, topOp)
-		if err != nil {
+		if topOp, err = b.planProject(exprList, fieldNames, plan.distinct, topOp); err != nil {
 			return nil, err
 		}
-		topOp = NewOperatorCard(projOp, topOp.Cardinality)
 	}
 
-	if len(plan.orderByFields) > 0 {
+	return planOrderAndLimit(c, plan.orderByFields, plan.limit, tableMap, topOp)
+}
+
+// Returns e with each of its subexpressions that is a computed GROUP BY
+// expression replaced by the column of its values, which cols maps
+// [exprKey] of the expression to.
+func readGroupColumns(e *LogicalSelectNode, cols map[string]*FieldType) *LogicalSelectNode {
+	if e.exprType != ExprFunc && e.exprType != ExprWindow {
+		return e
+	}
+	if field, ok := cols[exprKey(e)]; ok && e.exprType == ExprFunc {
+		// the column keeps the name the expression would have had
+		alias := e.alias
+		if alias == "" {
+			alias = *e.funcOp
+			if strings.HasPrefix(alias, castFuncPrefix) {
+				alias = "cast"
+			}
+		}
+		col := NewFieldSelectNode("", field.Fname, alias)
+		col.cachedField = field
+		return &col
+	}
+	q := *e
+	q.args = make([]*LogicalSelectNode, len(e.args))
+	for i, arg := range e.args {
+		q.args[i] = readGroupColumns(arg, cols)
+	}
+	return &q
+}
+
+// Returns the state of aggregate op over the values of expr, whose result is
+// named name.
+func newAggState(op string, name string, expr Expr) (AggState, error) {
+	if t := expr.GetExprType().Ftype; (op == "sum" || op == "avg") && !isNumericType(t) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot compute %s of %s values", op, t)}
+	}
+	var as AggState
+	switch op {
+	case "max":
+		as = &MaxAggState{}
+	case "min":
+		as = &MinAggState{}
+	case "avg":
+		as = &AvgAggState{}
+	case "sum":
+		as = &SumAggState{}
+	case "count":
+		as = &CountAggState{}
+	default:
+		return nil, GoDBError{IllegalOperationError, fmt.Sprintf("unknown aggregate function %s", op)}
+	}
+	if err := as.Init(name, expr); err != nil {
+		return nil, err
+	}
+	return as, nil
+}
+
+// Plan the ORDER BY and LIMIT clauses of a query over the tuples of topOp.
+func planOrderAndLimit(c *Catalog, orderByFields []*OrderByNode, limit *LogicalSelectNode, tableMap map[string]*PlanNode, topOp *OperatorCard) (*OperatorCard, error) {
+	var err error
+	if len(orderByFields) > 0 {
 		var ascs []bool
 
-		exprs := make([]Expr, len(plan.orderByFields))
-		for i, oby := range plan.orderByFields {
+		exprs := make([]Expr, len(orderByFields))
+		for i, oby := range orderByFields {
 			expr, _, err := oby.expr.generateExpr(c, topOp.Descriptor(), tableMap)
 			if err != nil {
 				return nil, err
@@ -1224,15 +1524,13 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 			ascs = append(ascs, oby.ascending)
 
 		}
-		orderOp, err := NewOrderBy(exprs, topOp, ascs)
-		if err != nil {
+		if topOp, err = planOrderBy(exprs, ascs, topOp); err != nil {
 			return nil, err
 		}
-		topOp = NewOperatorCard(orderOp, topOp.Cardinality)
 	}
 
-	if plan.limit != nil {
-		expr, _, err := plan.limit.generateExpr(c, topOp.Descriptor(), tableMap)
+	if limit != nil {
+		expr, _, err := limit.generateExpr(c, topOp.Descriptor(), tableMap)
 		if err != nil {
 			return nil, err
 		}
@@ -1240,18 +1538,47 @@ func makePhysicalPlan(c *Catalog, plan *LogicalPlan) (*OperatorCard, error) {
 		if err != nil {
 			return nil, err
 		}
-		numTups := numTupsExpr.(IntField).Value
-		topOp = NewOperatorCard(NewLimitOp(expr, topOp), min(int(numTups), topOp.Cardinality))
+		limit, ok := numTupsExpr.(IntField)
+		if !ok {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("LIMIT must be an integer, got %s", valueString(numTupsExpr))}
+		}
+		numTups := limit.Value
+		topOp = costedCard(NewLimitOp(expr, topOp), min(int(numTups), topOp.Cardinality), topOp.Cost, nil)
 	}
 	return topOp, nil
 }
 
+// Check that the values produced by exprs can be stored in a table with the
+// given descriptor.  Constants are converted to the types of their columns, so
+// that a malformed constant is reported before any tuple is inserted.
+func checkInsertTypes(desc *TupleDesc, exprs []Expr) error {
+	if len(exprs) != len(desc.Fields) {
+		return GoDBError{TypeMismatchError, fmt.Sprintf("expected %d values to insert, got %d", len(desc.Fields), len(exprs))}
+	}
+	for i, e := range exprs {
+		field := desc.Fields[i]
+		if v, ok := literalValue(e); ok {
+			if _, err := convertValue(v, field.Ftype); err != nil {
+				return GoDBError{TypeMismatchError, fmt.Sprintf("cannot insert %s into column %s: %s", valueString(v), field.Fname, err.Error())}
+			}
+			continue
+		}
+		if t := e.GetExprType().Ftype; !storableAs(t, field.Ftype) {
+			return GoDBError{TypeMismatchError, fmt.Sprintf("cannot insert %s value into %s column %s", t, field.Ftype, field.Fname)}
+		}
+	}
+	return nil
+}
+
 func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 	if insStmt.Columns != nil {
 		return nil, GoDBError{ParseError, "GoDB doesn't support inserts of incomplete tuples"}
 	}
-	tab := insStmt.Table.Name
-	file, err := c.GetTable(sqlparser.String(tab))
+	schema, tab, err := c.resolveTableName(insStmt.Table)
+	if err != nil {
+		return nil, err
+	}
+	file, err := schema.GetTable(tab)
 	if err != nil {
 		return nil, err
 	}
@@ -1272,23 +1599,31 @@ func parseInsert(c *Catalog, insStmt *sqlparser.Insert) (Operator, error) {
 				}
 				tupAr = append(tupAr, exprOp)
 			}
+			if err := checkInsertTypes(file.Descriptor(), tupAr); err != nil {
+				return nil, err
+			}
 			exprAr = append(exprAr, tupAr)
 		}
 		iterOp := NewValueOp(exprAr)
 		insertOp := NewInsertOp(file, iterOp)
+		insertOp.catalog = schema
 		return insertOp, nil
 
-	case *sqlparser.Select:
-		plan, err := parseStatement(c, stmt)
+	case sqlparser.SelectStatement:
+		op, err := planSelectStatement(c, stmt)
 		if err != nil {
 			return nil, err
 		}
-		op, err := makePhysicalPlan(c, plan)
-		if err != nil {
+		var exprs []Expr
+		for _, f := range op.Descriptor().Fields {
+			exprs = append(exprs, &FieldExpr{f})
+		}
+		if err := checkInsertTypes(file.Descriptor(), exprs); err != nil {
 			return nil, err
 		}
 
 		insertOp := NewInsertOp(file, op)
+		insertOp.catalog = schema
 		return insertOp, nil
 	}
 	return nil, nil
@@ -1310,7 +1645,7 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 	}
 
 	tableMap := make(map[string]*PlanNode)
-	tableMap[tables[0].tableName] = &PlanNode{&OperatorCard{Op: *tables[0].file, Cardinality: 0}, (*tables[0].file).Descriptor()}
+	tableMap[tables[0].tableName] = &PlanNode{&OperatorCard{Op: *tables[0].file, Cardinality: 0, Cost: -1}, (*tables[0].file).Descriptor()}
 
 	var filters []*LogicalFilterNode = make([]*LogicalFilterNode, 0)
 	if delStmt.Where != nil {
@@ -1346,91 +1681,220 @@ func parseDelete(c *Catalog, delStmt *sqlparser.Delete) (Operator, error) {
 		//dbField, _ := fieldNameToField(f.table, f.field, &PlanNode{op, &desc})
 
 		//newInt, _ := strconv.Atoi(f.constVal)
+		leftExpr, rightExpr, err = coerceComparison(leftExpr, rightExpr)
+		if err != nil {
+			return nil, err
+		}
 		newOp, err = NewFilter(rightExpr, f.predOp, leftExpr, newOp)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	return NewDeleteOp(*tables[0].file, newOp), nil
+	deleteOp := NewDeleteOp(*tables[0].file, newOp)
+	deleteOp.catalog = tables[0].schema
+	return deleteOp, nil
 }
 
 type QueryType int
 
 const (
-	IteratorType         QueryType = iota
-	BeginXactionType     QueryType = iota
-	CommitXactionType    QueryType = iota
-	AbortXactionType     QueryType = iota
-	CreateTableQueryType QueryType = iota
-	DropTableQueryType   QueryType = iota
-	UnknownQueryType     QueryType = iota
+	IteratorType           QueryType = iota
+	BeginXactionType       QueryType = iota
+	CommitXactionType      QueryType = iota
+	AbortXactionType       QueryType = iota
+	CreateTableQueryType   QueryType = iota
+	DropTableQueryType     QueryType = iota
+	CreateTableAsQueryType QueryType = iota
+	CreateViewQueryType    QueryType = iota
+	AnalyzeQueryType       QueryType = iota
+	CreateSchemaQueryType  QueryType = iota
+	DropSchemaQueryType    QueryType = iota
+	UseSchemaQueryType     QueryType = iota
+	UnknownQueryType       QueryType = iota
 )
 
-func processDDL(c *Catalog, ddl *sqlparser.DDL) (QueryType, error) {
+func processDDL(c *Catalog, ddl *sqlparser.DDL, fks []*ForeignKey) (QueryType, error) {
 	switch ddl.Action {
 	case "create":
+		if ddl.TableSpec == nil {
+			return UnknownQueryType, GoDBError{ParseError, "could not parse table definition"}
+		}
 		fields := make([]FieldType, len(ddl.TableSpec.Columns))
-		tabName := sqlparser.String(ddl.NewName.Name)
+		specs := make([]columnSpec, len(ddl.TableSpec.Columns))
+		c, tabName, err := c.resolveTableName(ddl.NewName)
+		if err != nil {
+			return UnknownQueryType, err
+		}
 		t, _ := c.GetTable(tabName)
 		if t != nil {
 			return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("table %s already exists", tabName)}
 		}
+		if c.getView(tabName) != nil {
+			return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("view %s already exists", tabName)}
+		}
 		for i, col := range ddl.TableSpec.Columns {
 			var colType DBType
 			colName := sqlparser.String(col.Name)
-			switch col.Type.Type {
-			case "int":
-				colType = IntType
-			case "string":
-				fallthrough
-			case "text":
-				fallthrough
-			case "varchar":
-				colType = StringType
-			default:
+			colType, ok := typeFromName(col.Type.Type)
+			if !ok {
 				return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported column type %s", col.Type.Type)}
-
+			}
+			switch colType {
+			case StringType:
+				if col.Type.Length != nil {
+					n, err := strconv.Atoi(string(col.Type.Length.Val))
+					if err != nil || n <= 0 {
+						return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("invalid length for varchar column %s", colName)}
+					}
+					specs[i].length = n
+				}
+			case DecimalType:
+				if col.Type.Length != nil {
+					spec := columnSpec{}
+					var err error
+					spec.precision, err = strconv.Atoi(string(col.Type.Length.Val))
+					if err == nil && col.Type.Scale != nil {
+						spec.scale, err = strconv.Atoi(string(col.Type.Scale.Val))
+					}
+					if err != nil {
+						return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("invalid precision for decimal column %s", colName)}
+					}
+					if err := checkDecimalSpec(spec, sqlparser.String(&col.Type)); err != nil {
+						return UnknownQueryType, err
+					}
+					specs[i] = spec
+				}
 			}
 			fields[i] = FieldType{colName, "", colType}
 		}
+		if err := c.validateForeignKeys(tabName, &TupleDesc{fields}, fks); err != nil {
+			return UnknownQueryType, err
+		}
 
-		_, err := c.addTable(tabName, TupleDesc{fields})
-		if err != nil {
+		if _, err := c.addTable(tabName, TupleDesc{fields}); err != nil {
 			return UnknownQueryType, err
 		}
+		c.tableMap[tabName].foreignKeys = fks
+		c.tableMap[tabName].stats = emptyTableStats(&TupleDesc{fields})
+		c.setColumnSpecs(tabName, specs)
 		return CreateTableQueryType, nil
 
 	case "drop":
-		tabName := sqlparser.String(ddl.Table.Name)
-		err := c.dropTable(tabName)
+		c, tabName, err := c.resolveTableName(ddl.Table)
 		if err != nil {
 			return UnknownQueryType, err
 		}
+		// DROP VIEW parses to the same statement as DROP TABLE
+		if c.getView(tabName) != nil {
+			if err := c.dropView(tabName); err != nil {
+				return UnknownQueryType, err
+			}
+			return DropTableQueryType, nil
+		}
+		if err := c.dropTable(tabName); err != nil {
+			return UnknownQueryType, err
+		}
 		return DropTableQueryType, nil
 	default:
 		return UnknownQueryType, GoDBError{ParseError, fmt.Sprintf("unsupported ddl statement %s", ddl.Action)}
 	}
 }
 
+// sqlparser does not know the BOOL and BOOLEAN column types, so they are
+// rewritten to BIT, which it does know and which is also stored as a bool.
+var booleanColumnRe = regexp.MustCompile(`(?i)([(,]\s*\w+\s+)bool(ean)?\b`)
+
+func rewriteBooleanColumns(query string) string {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) < 2 || words[0] != "create" || words[1] != "table" {
+		return query
+	}
+	return booleanColumnRe.ReplaceAllString(query, "${1}bit")
+}
+
+// sqlparser does not know ANALYZE, so it is recognized here.
+var analyzeRe = regexp.MustCompile(`(?is)^\s*analyze(\s+table)?(\s+([a-z_][a-z0-9_.]*))?\s*;?\s*$`)
+
+// Parse a statement.  Unqualified table names are resolved in the current
+// schema of the default session of c, which USE changes; see [Session.Parse].
 func Parse(c *Catalog, query string) (QueryType, Operator, error) {
-	stmt, err := sqlparser.Parse(query)
+	return c.schemas.session.Parse(query)
+}
+
+// Parse a statement in the session.  Unqualified table names are resolved in
+// the session's current schema, which USE changes.
+func (s *Session) Parse(query string) (QueryType, Operator, error) {
+	c := s.schema
+	if m := explainRe.FindStringSubmatch(query); m != nil {
+		return parseExplain(c, m)
+	}
+	if m := analyzeRe.FindStringSubmatch(query); m != nil {
+		schema, name, err := c.resolveDottedName(strings.ToLower(m[3]))
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		if err := schema.Analyze(name); err != nil {
+			return UnknownQueryType, nil, err
+		}
+		return AnalyzeQueryType, nil, nil
+	}
+	query = rewriteWindows(rewriteCasts(query))
+	if kind, name, sel, ok := splitCreateAs(query); ok {
+		return parseCreateAs(c, kind, name, sel)
+	}
+	stmt, fks, err := parseSQL(query)
 	if err != nil {
 		return UnknownQueryType, nil, err
 	}
-	switch stmt := stmt.(type) {
-	case *sqlparser.Select:
-		plan, err := parseStatement(c, stmt)
-		if err != nil {
-			//fmt.Printf("Err: %s\n", err.Error())
+	if use, ok := stmt.(*sqlparser.Use); ok {
+		if err := s.use(use.DBName.String()); err != nil {
 			return UnknownQueryType, nil, err
 		}
-		op, err := makePhysicalPlan(c, plan)
+		return UseSchemaQueryType, nil, nil
+	}
+	return planStatement(c, stmt, fks)
+}
+
+// Parse a statement that sqlparser may not support as is, returning the
+// foreign keys of a CREATE TABLE statement separately.
+func parseSQL(query string) (sqlparser.Statement, []*ForeignKey, error) {
+	if withRe.MatchString(query) {
+		stmt, err := parseWith(query)
+		return stmt, nil, err
+	}
+	query, fks, err := extractForeignKeys(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	query = rewriteBooleanColumns(query)
+	query, setOps := rewriteSetOps(query)
+	stmt, err := sqlparser.Parse(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := restoreSetOps(stmt, setOps); err != nil {
+		return nil, nil, err
+	}
+	return stmt, fks, nil
+}
+
+// Plan or execute a parsed statement.
+func planStatement(c *Catalog, stmt sqlparser.Statement, fks []*ForeignKey) (QueryType, Operator, error) {
+	switch stmt := stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.ParenSelect, *withSelect:
+		op, err := planSelectStatement(c, stmt.(sqlparser.SelectStatement))
 		if err != nil {
-			//fmt.Printf("Err: %s\n", err.Error())
 			return UnknownQueryType, nil, err
 		}
-		return IteratorType, op, nil
+		var root Operator = op
+		if Parallelism > 1 {
+			root = parallelizePlan(root)
+		}
+		if EnableVectorizedExecution {
+			root = vectorizePlan(root)
+		}
+		return IteratorType, root, nil
 	case *sqlparser.Insert:
 		op, err := parseInsert(c, stmt)
 		if err != nil {
@@ -1450,12 +1914,26 @@ func Parse(c *Catalog, query string) (QueryType, Operator, error) {
 	case *sqlparser.Rollback:
 		return AbortXactionType, nil, nil
 	case *sqlparser.DDL:
-		qtype, err := processDDL(c, stmt)
+		qtype, err := processDDL(c, stmt, fks)
 		if err != nil {
 			return UnknownQueryType, nil, err
 		} else {
 			return qtype, nil, nil
 		}
+	case *sqlparser.DBDDL:
+		// CREATE/DROP DATABASE and CREATE/DROP SCHEMA are the same statement
+		switch stmt.Action {
+		case sqlparser.CreateStr:
+			if err := c.createSchema(stmt.DBName); err != nil {
+				return UnknownQueryType, nil, err
+			}
+			return CreateSchemaQueryType, nil, nil
+		case sqlparser.DropStr:
+			if err := c.dropSchema(stmt.DBName); err != nil {
+				return UnknownQueryType, nil, err
+			}
+			return DropSchemaQueryType, nil, nil
+		}
 	}
 
 	return UnknownQueryType, nil, GoDBError{ParseError, "invalid query"}
@@ -0,0 +1,1262 @@
+package godb
+
+// A server for version 3 of the PostgreSQL frontend/backend protocol, so that
+// psql and PostgreSQL client libraries can query a [DB] over the network.
+//
+// Both the simple query protocol (Query) and the extended one (Parse, Bind,
+// Describe, Execute, Close, Sync and Flush) are supported.  Each connection has
+// its own transaction context: statements run outside of a BEGIN ... COMMIT
+// block commit when they complete, as in PostgreSQL, and an error in a block
+// fails it until it is rolled back.  Values are sent in text or binary format,
+// as the client asks; parameters whose types the client does not give are
+// inferred from their text.  There is no authentication, and SSL is declined.
+//
+// A running statement is canceled by a CancelRequest with the key sent to its
+// connection at startup, or once it has run for longer than the connection's
+// statement_timeout, which is set with SET statement_timeout as in
+// PostgreSQL.
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The type OIDs of PostgreSQL.
+const (
+	pgOidUnknownParam = 0
+	pgOidBool         = 16
+	pgOidInt8         = 20
+	pgOidInt2         = 21
+	pgOidInt4         = 23
+	pgOidText         = 25
+	pgOidFloat4       = 700
+	pgOidFloat8       = 701
+	pgOidUnknown      = 705
+	pgOidVarchar      = 1043
+	pgOidDate         = 1082
+	pgOidTimestamp    = 1114
+	pgOidTimestampTZ  = 1184
+	pgOidNumeric      = 1700
+)
+
+// Codes of the startup packets that are not startup messages.
+const (
+	pgProtocolVersion = 196608 // 3.0
+	pgCancelRequest   = 80877102
+	pgSSLRequest      = 80877103
+	pgGSSENCRequest   = 80877104
+)
+
+// Dates and timestamps are sent in binary as days and microseconds since
+// 2000-01-01.
+const (
+	pgEpochDays   = 10957
+	pgEpochMicros = pgEpochDays * 24 * 60 * 60 * 1000000
+)
+
+// A server of a DB, speaking the PostgreSQL protocol.
+type Server struct {
+	db *DB
+
+	mu     sync.Mutex
+	ln     net.Listener
+	conns  map[*pgConn]bool
+	nextID int32
+	closed bool
+	wg     sync.WaitGroup
+}
+
+func NewServer(db *DB) *Server {
+	return &Server{db: db, conns: make(map[*pgConn]bool)}
+}
+
+// Listen on the TCP address addr, e.g., ":5432", and serve connections until
+// the server is closed.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve the connections accepted by ln until the server is closed, which
+// returns nil.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		ln.Close()
+		return nil
+	}
+	s.ln = ln
+	s.mu.Unlock()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return nil
+		}
+		s.nextID++
+		c := &pgConn{s: s, conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn), id: s.nextID,
+			secret: rand.Int31(), stmts: make(map[string]*pgStatement), portals: make(map[string]*pgPortal), session: s.db.c.NewSession()}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+		}()
+	}
+}
+
+// Cancel the running statement of the connection with the given id and
+// secret, if there is one.
+func (s *Server) cancel(id, secret int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		if c.id == id && c.secret == secret {
+			c.cancelStatement()
+		}
+	}
+}
+
+// Returns the address the server listens on, or nil if it is not serving.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ln == nil {
+		return nil
+	}
+	return s.ln.Addr()
+}
+
+// Stop listening and close every connection, rolling back their
+// transactions.  The DB is not closed.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	var err error
+	if s.ln != nil {
+		err = s.ln.Close()
+	}
+	for c := range s.conns {
+		c.conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// A statement prepared by a client, by a Parse message or for a simple query.
+type pgStatement struct {
+	sql       string
+	ps        *PreparedStatement // nil for the statements handled by the connection
+	control   string             // "BEGIN", "COMMIT", "ROLLBACK" or "SET" for those
+	paramOIDs []uint32           // the types of the parameters, 0 if not given
+}
+
+// A statement bound to parameter values, and the state of its execution.
+type pgPortal struct {
+	stmt    *pgStatement
+	args    []any
+	guessed map[int]string // the text of the parameters whose types were inferred
+	formats []int16        // the formats of the result columns
+	rows    *Rows          // the rows of a query being read, if any
+	started bool
+	tag     string // the command tag of a statement that has completed
+	count   int    // the number of rows sent
+}
+
+// A client connection.
+type pgConn struct {
+	s      *Server
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	id     int32
+	secret int32
+
+	tx      *Tx
+	failed  bool // a statement failed in the transaction, which must end
+	stmts   map[string]*pgStatement
+	portals map[string]*pgPortal
+
+	skipToSync bool // an extended query message failed
+
+	session  *Session      // the current schema of the connection
+	timeout  time.Duration // the statement timeout, if not 0
+	cancelMu sync.Mutex
+	cancel   context.CancelFunc // cancels the statement last started
+}
+
+// An error to report to the client, with its SQLSTATE code.
+type pgError struct {
+	code string
+	msg  string
+}
+
+func (e pgError) Error() string {
+	return e.msg
+}
+
+// Returns the SQLSTATE code of an error.
+func pgErrorCode(err error) string {
+	var pe pgError
+	if errors.As(err, &pe) {
+		return pe.code
+	}
+	if IsCanceled(err) {
+		return "57014" // query_canceled
+	}
+	var ge GoDBError
+	if errors.As(err, &ge) {
+		switch ge.code {
+		case ParseError:
+			return "42601" // syntax_error
+		case NoSuchTableError:
+			return "42P01" // undefined_table
+		case DuplicateTableError:
+			return "42P07" // duplicate_table
+		case AmbiguousNameError:
+			return "42702" // ambiguous_column
+		case TypeMismatchError, IncompatibleTypesError:
+			return "42804" // datatype_mismatch
+		case ConstraintViolationError:
+			return "23000" // integrity_constraint_violation
+		case IllegalTransactionError:
+			return "25000" // invalid_transaction_state
+		case DeadlockError:
+			return "40P01" // deadlock_detected
+		case IllegalOperationError:
+			return "0A000" // feature_not_supported
+		}
+	}
+	return "XX000" // internal_error
+}
+
+// A message to send to the client.
+type pgMessage struct {
+	typ byte
+	buf bytes.Buffer
+}
+
+func (m *pgMessage) int16(v int16) *pgMessage {
+	binary.Write(&m.buf, binary.BigEndian, v)
+	return m
+}
+
+func (m *pgMessage) int32(v int32) *pgMessage {
+	binary.Write(&m.buf, binary.BigEndian, v)
+	return m
+}
+
+func (m *pgMessage) string(s string) *pgMessage {
+	m.buf.WriteString(s)
+	m.buf.WriteByte(0)
+	return m
+}
+
+func (m *pgMessage) bytes(b []byte) *pgMessage {
+	m.buf.Write(b)
+	return m
+}
+
+// Buffer a message to the client; messages are sent when the connection is
+// ready for a query, or flushed.
+func (c *pgConn) send(m *pgMessage) error {
+	if err := c.w.WriteByte(m.typ); err != nil {
+		return err
+	}
+	if err := binary.Write(c.w, binary.BigEndian, int32(m.buf.Len()+4)); err != nil {
+		return err
+	}
+	_, err := c.w.Write(m.buf.Bytes())
+	return err
+}
+
+func (c *pgConn) sendError(err error) error {
+	m := &pgMessage{typ: 'E'}
+	m.bytes([]byte{'S'}).string("ERROR")
+	m.bytes([]byte{'V'}).string("ERROR")
+	m.bytes([]byte{'C'}).string(pgErrorCode(err))
+	msg := err.Error()
+	var ge GoDBError
+	if errors.As(err, &ge) {
+		msg = ge.errString
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		msg = "canceling statement due to statement timeout"
+	} else if errors.Is(err, context.Canceled) {
+		msg = "canceling statement due to user request"
+	}
+	m.bytes([]byte{'M'}).string(msg)
+	m.bytes([]byte{0})
+	return c.send(m)
+}
+
+func (c *pgConn) readyForQuery() error {
+	status := byte('I')
+	if c.failed {
+		status = 'E'
+	} else if c.tx != nil {
+		status = 'T'
+	}
+	if err := c.send((&pgMessage{typ: 'Z'}).bytes([]byte{status})); err != nil {
+		return err
+	}
+	return c.w.Flush()
+}
+
+// The body of a message from the client.
+type pgReader struct {
+	data []byte
+	err  error
+}
+
+func (r *pgReader) next(n int) []byte {
+	if r.err != nil || n < 0 || len(r.data) < n {
+		r.err = pgError{"08P01", "malformed message"}
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *pgReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *pgReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *pgReader) string() string {
+	i := bytes.IndexByte(r.data, 0)
+	if r.err != nil || i < 0 {
+		r.err = pgError{"08P01", "malformed message"}
+		return ""
+	}
+	s := string(r.data[:i])
+	r.data = r.data[i+1:]
+	return s
+}
+
+// Read a message of the given length, including the length itself.
+func (c *pgConn) readBody(length int32) ([]byte, error) {
+	if length < 4 || length > 1<<30 {
+		return nil, pgError{"08P01", "invalid message length"}
+	}
+	body := make([]byte, length-4)
+	_, err := io.ReadFull(c.r, body)
+	return body, err
+}
+
+func (c *pgConn) readMessage() (byte, *pgReader, error) {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	var length int32
+	if err := binary.Read(c.r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	body, err := c.readBody(length)
+	if err != nil {
+		return 0, nil, err
+	}
+	return typ, &pgReader{body, nil}, nil
+}
+
+// Read the startup message, declining SSL and encryption, and greet the
+// client.  Returns false if the connection should close.
+func (c *pgConn) startup() (bool, error) {
+	for {
+		var length int32
+		if err := binary.Read(c.r, binary.BigEndian, &length); err != nil {
+			return false, err
+		}
+		body, err := c.readBody(length)
+		if err != nil {
+			return false, err
+		}
+		r := &pgReader{body, nil}
+		switch code := r.int32(); code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if err := c.w.WriteByte('N'); err != nil {
+				return false, err
+			}
+			if err := c.w.Flush(); err != nil {
+				return false, err
+			}
+			continue
+		case pgCancelRequest:
+			id, secret := r.int32(), r.int32()
+			if r.err == nil {
+				c.s.cancel(id, secret)
+			}
+			return false, nil
+		case pgProtocolVersion:
+		default:
+			c.sendError(pgError{"08P01", fmt.Sprintf("unsupported protocol version %d.%d", code>>16, code&0xffff)})
+			return false, c.w.Flush()
+		}
+		break
+	}
+	c.send((&pgMessage{typ: 'R'}).int32(0)) // AuthenticationOk
+	for _, p := range [][2]string{
+		{"server_version", "14.0 (GoDB)"},
+		{"server_encoding", "UTF8"},
+		{"client_encoding", "UTF8"},
+		{"DateStyle", "ISO, MDY"},
+		{"TimeZone", "UTC"},
+		{"integer_datetimes", "on"},
+		{"standard_conforming_strings", "on"},
+	} {
+		c.send((&pgMessage{typ: 'S'}).string(p[0]).string(p[1]))
+	}
+	c.send((&pgMessage{typ: 'K'}).int32(c.id).int32(c.secret))
+	return true, c.readyForQuery()
+}
+
+func (c *pgConn) serve() {
+	defer c.close()
+	if ok, err := c.startup(); !ok || err != nil {
+		return
+	}
+	for {
+		typ, r, err := c.readMessage()
+		if err != nil {
+			return
+		}
+		if c.skipToSync && typ != 'S' && typ != 'X' {
+			continue
+		}
+		switch typ {
+		case 'Q':
+			err = c.handleQuery(r.string())
+		case 'P':
+			err = c.handleParse(r)
+		case 'B':
+			err = c.handleBind(r)
+		case 'D':
+			err = c.handleDescribe(r)
+		case 'E':
+			err = c.handleExecute(r)
+		case 'C':
+			err = c.handleClose(r)
+		case 'S':
+			c.skipToSync = false
+			if c.tx == nil {
+				c.closePortals()
+			}
+			err = c.readyForQuery()
+		case 'H':
+			err = c.w.Flush()
+		case 'X':
+			return
+		default:
+			err = c.extendedError(pgError{"08P01", fmt.Sprintf("unsupported message type '%c'", typ)})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Returns the context to start a statement under, which has the
+// connection's statement timeout, and is canceled by a CancelRequest until
+// the next statement starts.
+func (c *pgConn) statementContext() context.Context {
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if c.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), c.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.cancel = cancel
+	return ctx
+}
+
+func (c *pgConn) cancelStatement() {
+	c.cancelMu.Lock()
+	defer c.cancelMu.Unlock()
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+// Roll back the transaction of a closed connection.
+func (c *pgConn) close() {
+	c.closePortals()
+	c.cancelStatement()
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	c.conn.Close()
+	c.s.mu.Lock()
+	delete(c.s.conns, c)
+	c.s.mu.Unlock()
+}
+
+func (c *pgConn) closePortal(name string) {
+	if p, ok := c.portals[name]; ok {
+		if p.rows != nil {
+			p.rows.Close()
+		}
+		delete(c.portals, name)
+	}
+}
+
+func (c *pgConn) closePortals() {
+	for name := range c.portals {
+		c.closePortal(name)
+	}
+}
+
+// Report an error of an extended query message, and ignore the messages that
+// follow until the next Sync.
+func (c *pgConn) extendedError(err error) error {
+	c.skipToSync = true
+	c.noteFailure()
+	return c.sendError(err)
+}
+
+// Fail the transaction, if there is one.
+func (c *pgConn) noteFailure() {
+	if c.tx != nil {
+		c.failed = true
+	}
+}
+
+var (
+	pgBeginRe    = regexp.MustCompile(`(?is)^\s*(begin|start\s+transaction)(\s+(work|transaction))?\s*$`)
+	pgCommitRe   = regexp.MustCompile(`(?is)^\s*(commit|end)(\s+(work|transaction))?\s*$`)
+	pgRollbackRe = regexp.MustCompile(`(?is)^\s*(rollback|abort)(\s+(work|transaction))?\s*$`)
+	pgSetRe      = regexp.MustCompile(`(?is)^\s*set\s`)
+	pgTimeoutRe  = regexp.MustCompile(`(?is)^\s*set\s+(session\s+)?statement_timeout\s*(=|to)\s*(default|'?\s*(\d+)\s*(ms|s|min)?\s*'?)\s*$`)
+)
+
+// Returns the statement timeout set by a SET statement_timeout statement,
+// whose value is in milliseconds unless it has a unit, and 0 means none.
+func pgStatementTimeout(sql string) (time.Duration, bool) {
+	m := pgTimeoutRe.FindStringSubmatch(sql)
+	if m == nil {
+		return 0, false
+	}
+	if strings.EqualFold(m[3], "default") {
+		return 0, true
+	}
+	n, err := strconv.ParseInt(m[4], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	unit := time.Millisecond
+	switch strings.ToLower(m[5]) {
+	case "s":
+		unit = time.Second
+	case "min":
+		unit = time.Minute
+	}
+	return time.Duration(n) * unit, true
+}
+
+// Prepare a statement.  Transaction control statements and SET, which the
+// connection handles itself, are not prepared.
+func (c *pgConn) prepare(sql string, paramOIDs []uint32) (*pgStatement, error) {
+	stmt := &pgStatement{sql, nil, "", paramOIDs}
+	switch {
+	case pgBeginRe.MatchString(sql):
+		stmt.control = "BEGIN"
+	case pgCommitRe.MatchString(sql):
+		stmt.control = "COMMIT"
+	case pgRollbackRe.MatchString(sql):
+		stmt.control = "ROLLBACK"
+	case pgSetRe.MatchString(sql):
+		// session settings other than statement_timeout are accepted, and
+		// ignored
+		stmt.control = "SET"
+	default:
+		db := c.s.db
+		db.mu.Lock()
+		defer db.mu.Unlock()
+		ps, err := c.session.Prepare(sql)
+		if err != nil {
+			return nil, err
+		}
+		stmt.ps = ps
+	}
+	return stmt, nil
+}
+
+// Split a query into its statements, at the semicolons outside of string
+// literals.  Empty statements are dropped.
+func splitStatements(query string) []string {
+	var stmts []string
+	var quote byte
+	start := 0
+	for i := 0; i <= len(query); i++ {
+		if i < len(query) {
+			switch b := query[i]; {
+			case quote == 0 && (b == '\'' || b == '"'):
+				quote = b
+				continue
+			case quote != 0 && b == quote:
+				quote = 0
+				continue
+			case quote != 0 || b != ';':
+				continue
+			}
+		}
+		if s := strings.TrimSpace(query[start:i]); s != "" {
+			stmts = append(stmts, s)
+		}
+		start = i + 1
+	}
+	return stmts
+}
+
+// Run the statements of a simple query, stopping at the first error.
+func (c *pgConn) handleQuery(query string) error {
+	delete(c.stmts, "")
+	c.closePortal("")
+	stmts := splitStatements(query)
+	if len(stmts) == 0 {
+		c.send(&pgMessage{typ: 'I'}) // EmptyQueryResponse
+		return c.readyForQuery()
+	}
+	for _, sql := range stmts {
+		stmt, err := c.prepare(sql, nil)
+		if err != nil {
+			c.noteFailure()
+			c.sendError(err)
+			break
+		}
+		p := &pgPortal{stmt: stmt}
+		if err := c.start(p); err != nil {
+			c.noteFailure()
+			c.sendError(err)
+			break
+		}
+		if p.rows != nil {
+			c.send(c.rowDescription(p.rows.desc, nil))
+		}
+		if err := c.execute(p, 0); err != nil {
+			c.noteFailure()
+			c.sendError(err)
+			break
+		}
+	}
+	return c.readyForQuery()
+}
+
+func (c *pgConn) handleParse(r *pgReader) error {
+	name, sql := r.string(), r.string()
+	oids := make([]uint32, r.int16())
+	for i := range oids {
+		oids[i] = uint32(r.int32())
+	}
+	if r.err != nil {
+		return c.extendedError(r.err)
+	}
+	stmt, err := c.prepare(sql, oids)
+	if err != nil {
+		return c.extendedError(err)
+	}
+	c.stmts[name] = stmt
+	return c.send(&pgMessage{typ: '1'}) // ParseComplete
+}
+
+// Returns the format of column or parameter i given the format codes of a
+// Bind message: none for text, a single one for all, or one for each.
+func pgFormat(formats []int16, i int) int16 {
+	switch len(formats) {
+	case 0:
+		return 0
+	case 1:
+		return formats[0]
+	}
+	if i < len(formats) {
+		return formats[i]
+	}
+	return 0
+}
+
+func (c *pgConn) handleBind(r *pgReader) error {
+	portal, name := r.string(), r.string()
+	paramFormats := make([]int16, r.int16())
+	for i := range paramFormats {
+		paramFormats[i] = r.int16()
+	}
+	params := make([][]byte, r.int16())
+	for i := range params {
+		if n := r.int32(); n >= 0 {
+			params[i] = r.next(int(n))
+		} else if r.err == nil {
+			return c.extendedError(pgError{"22004", "NULL parameter values are not supported"})
+		}
+	}
+	resultFormats := make([]int16, r.int16())
+	for i := range resultFormats {
+		resultFormats[i] = r.int16()
+	}
+	if r.err != nil {
+		return c.extendedError(r.err)
+	}
+	stmt, ok := c.stmts[name]
+	if !ok {
+		return c.extendedError(pgError{"26000", fmt.Sprintf("prepared statement \"%s\" does not exist", name)})
+	}
+	args := make([]any, len(params))
+	guessed := make(map[int]string)
+	for i, data := range params {
+		var oid uint32
+		if i < len(stmt.paramOIDs) {
+			oid = stmt.paramOIDs[i]
+		}
+		format := pgFormat(paramFormats, i)
+		v, err := decodePgParam(data, format, oid)
+		if err != nil {
+			return c.extendedError(err)
+		}
+		if format == 0 && (oid == pgOidUnknownParam || oid == pgOidUnknown) && valueType(v) != StringType {
+			guessed[i] = string(data)
+		}
+		args[i] = v
+	}
+	c.closePortal(portal)
+	c.portals[portal] = &pgPortal{stmt: stmt, args: args, guessed: guessed, formats: resultFormats}
+	return c.send(&pgMessage{typ: '2'}) // BindComplete
+}
+
+func (c *pgConn) handleDescribe(r *pgReader) error {
+	kind, name := r.next(1), r.string()
+	if r.err != nil {
+		return c.extendedError(r.err)
+	}
+	if kind[0] == 'S' {
+		stmt, ok := c.stmts[name]
+		if !ok {
+			return c.extendedError(pgError{"26000", fmt.Sprintf("prepared statement \"%s\" does not exist", name)})
+		}
+		oids, desc := c.describeStatement(stmt)
+		m := (&pgMessage{typ: 't'}).int16(int16(len(oids)))
+		for _, oid := range oids {
+			m.int32(int32(oid))
+		}
+		c.send(m)
+		if desc == nil {
+			return c.send(&pgMessage{typ: 'n'}) // NoData
+		}
+		return c.send(c.rowDescription(desc, nil))
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return c.extendedError(pgError{"34000", fmt.Sprintf("portal \"%s\" does not exist", name)})
+	}
+	if err := c.start(p); err != nil {
+		return c.extendedError(err)
+	}
+	if p.rows == nil {
+		return c.send(&pgMessage{typ: 'n'})
+	}
+	return c.send(c.rowDescription(p.rows.desc, p.formats))
+}
+
+// Returns the types of the parameters of a statement and the descriptor of
+// its rows, or nil if it has none.  Parameters whose types were not given are
+// taken to be ints if the statement can be planned with int parameters, and
+// text otherwise.
+func (c *pgConn) describeStatement(stmt *pgStatement) ([]uint32, *TupleDesc) {
+	if stmt.ps == nil {
+		return nil, nil
+	}
+	oids := make([]uint32, stmt.ps.NumParams())
+	copy(oids, stmt.paramOIDs)
+	if !stmt.ps.cached {
+		for i := range oids {
+			if oids[i] == pgOidUnknownParam {
+				oids[i] = pgOidText
+			}
+		}
+		return oids, nil
+	}
+	db := c.s.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// plan the statement with placeholder values of the parameters, which
+	// leaves the plan in the cache for its executions
+	var desc *TupleDesc
+	for _, guess := range []DBValue{IntField{0}, StringField{""}} {
+		args := make([]any, len(oids))
+		for i, oid := range oids {
+			args[i] = pgPlaceholder(oid, guess)
+		}
+		_, op, err := stmt.ps.Bind(args...)
+		if err != nil {
+			continue
+		}
+		op.(*boundPlan).release()
+		for i, oid := range oids {
+			if oid == pgOidUnknownParam {
+				oids[i] = pgTypeOid(valueType(guess))
+			}
+		}
+		switch unwrapPlan(op).(type) {
+		case *InsertOp, *DeleteOp:
+		default:
+			desc = op.Descriptor()
+		}
+		return oids, desc
+	}
+	for i := range oids {
+		if oids[i] == pgOidUnknownParam {
+			oids[i] = pgOidText
+		}
+	}
+	return oids, nil
+}
+
+// Returns a value of the type with the given OID, or guess if the type is not
+// known.
+func pgPlaceholder(oid uint32, guess DBValue) DBValue {
+	switch oid {
+	case pgOidBool:
+		return BoolField{false}
+	case pgOidInt2, pgOidInt4, pgOidInt8:
+		return IntField{0}
+	case pgOidFloat4, pgOidFloat8:
+		return FloatField{0}
+	case pgOidText, pgOidVarchar:
+		return StringField{""}
+	case pgOidDate:
+		return DateField{0}
+	case pgOidTimestamp, pgOidTimestampTZ:
+		return TimestampField{0}
+	case pgOidNumeric:
+		return DecimalField{0, 0}
+	}
+	return guess
+}
+
+func (c *pgConn) handleExecute(r *pgReader) error {
+	name, maxRows := r.string(), r.int32()
+	if r.err != nil {
+		return c.extendedError(r.err)
+	}
+	p, ok := c.portals[name]
+	if !ok {
+		return c.extendedError(pgError{"34000", fmt.Sprintf("portal \"%s\" does not exist", name)})
+	}
+	if err := c.start(p); err != nil {
+		return c.extendedError(err)
+	}
+	if err := c.execute(p, int(maxRows)); err != nil {
+		return c.extendedError(err)
+	}
+	return nil
+}
+
+func (c *pgConn) handleClose(r *pgReader) error {
+	kind, name := r.next(1), r.string()
+	if r.err != nil {
+		return c.extendedError(r.err)
+	}
+	if kind[0] == 'S' {
+		delete(c.stmts, name)
+	} else {
+		c.closePortal(name)
+	}
+	return c.send(&pgMessage{typ: '3'}) // CloseComplete
+}
+
+// Start the statement of a portal, unless it has been started.  Statements
+// that produce no rows run to completion, and set the portal's tag.
+func (c *pgConn) start(p *pgPortal) error {
+	if p.started {
+		return nil
+	}
+	p.started = true
+	stmt := p.stmt
+	if stmt.control != "" {
+		return c.control(p)
+	}
+	if c.failed {
+		return pgError{"25P02", "current transaction is aborted, commands ignored until end of transaction block"}
+	}
+	var rows *Rows
+	var err error
+	ctx := c.statementContext()
+	if c.tx != nil {
+		rows, err = c.tx.query(ctx, stmt.sql, stmt.ps, p.args)
+	} else {
+		rows, err = c.s.db.query(ctx, stmt.sql, stmt.ps, p.args)
+	}
+	if err != nil {
+		// parameters whose types were inferred from their text may be
+		// strings that look like numbers
+		if len(p.guessed) > 0 && !IsCanceled(err) {
+			for i, text := range p.guessed {
+				p.args[i] = StringField{text}
+			}
+			p.guessed = nil
+			p.started = false
+			return c.start(p)
+		}
+		return err
+	}
+	if rows.op == nil {
+		p.tag = pgCommandTag(rows.qType, 0)
+		return nil
+	}
+	switch unwrapPlan(rows.op).(type) {
+	case *InsertOp, *DeleteOp:
+		// modifications run to completion, and report their count
+		var n int64
+		for rows.Next() {
+			if v, ok := rows.Tuple().Fields[0].(IntField); ok {
+				n += v.Value
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		tag := "DELETE %d"
+		if _, ok := unwrapPlan(rows.op).(*InsertOp); ok {
+			tag = "INSERT 0 %d"
+		}
+		if rows.qType == CreateTableAsQueryType {
+			tag = "SELECT %d"
+		}
+		p.tag = fmt.Sprintf(tag, n)
+		return nil
+	}
+	p.rows = rows
+	return nil
+}
+
+// Run a transaction control statement.
+func (c *pgConn) control(p *pgPortal) error {
+	p.tag = p.stmt.control
+	switch p.stmt.control {
+	case "SET":
+		if d, ok := pgStatementTimeout(p.stmt.sql); ok {
+			c.timeout = d
+		}
+	case "BEGIN":
+		if c.tx != nil {
+			// PostgreSQL warns, and stays in the transaction
+			return nil
+		}
+		tx, err := c.s.db.Begin()
+		if err != nil {
+			return err
+		}
+		c.tx = tx
+	case "COMMIT", "ROLLBACK":
+		if c.tx == nil {
+			return nil
+		}
+		if c.failed || p.stmt.control == "ROLLBACK" {
+			c.tx.Rollback()
+			p.tag = "ROLLBACK"
+		} else {
+			c.tx.Commit()
+		}
+		c.tx, c.failed = nil, false
+		for name, q := range c.portals {
+			if q != p {
+				c.closePortal(name)
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the command tag of a statement that produces no rows.
+func pgCommandTag(qType QueryType, n int) string {
+	switch qType {
+	case CreateTableQueryType:
+		return "CREATE TABLE"
+	case DropTableQueryType:
+		return "DROP TABLE"
+	case CreateViewQueryType:
+		return "CREATE VIEW"
+	case CreateSchemaQueryType:
+		return "CREATE SCHEMA"
+	case DropSchemaQueryType:
+		return "DROP SCHEMA"
+	case AnalyzeQueryType:
+		return "ANALYZE"
+	case UseSchemaQueryType:
+		return "SET"
+	}
+	return fmt.Sprintf("SELECT %d", n)
+}
+
+// Send up to maxRows rows of a started portal, or all of them if maxRows is
+// 0, followed by PortalSuspended if there are more, or by CommandComplete.
+func (c *pgConn) execute(p *pgPortal, maxRows int) error {
+	if p.rows == nil {
+		if p.tag == "" {
+			// the rows have all been sent
+			p.tag = fmt.Sprintf("SELECT %d", p.count)
+		}
+		return c.send((&pgMessage{typ: 'C'}).string(p.tag))
+	}
+	sent := 0
+	for maxRows <= 0 || sent < maxRows {
+		if !p.rows.Next() {
+			rows := p.rows
+			p.rows = nil
+			if err := rows.Err(); err != nil {
+				return err
+			}
+			return c.send((&pgMessage{typ: 'C'}).string(fmt.Sprintf("SELECT %d", p.count)))
+		}
+		m := (&pgMessage{typ: 'D'}).int16(int16(len(p.rows.desc.Fields)))
+		for i, v := range p.rows.Tuple().Fields {
+			var data []byte
+			if pgFormat(p.formats, i) == 1 {
+				data = encodePgBinary(v)
+			} else {
+				data = []byte(encodePgText(v))
+			}
+			m.int32(int32(len(data))).bytes(data)
+		}
+		if err := c.send(m); err != nil {
+			return err
+		}
+		p.count++
+		sent++
+	}
+	return c.send(&pgMessage{typ: 's'}) // PortalSuspended
+}
+
+// Returns the OID of the PostgreSQL type values of type t are sent as.
+func pgTypeOid(t DBType) uint32 {
+	switch t {
+	case IntType:
+		return pgOidInt8
+	case StringType:
+		return pgOidText
+	case FloatType:
+		return pgOidFloat8
+	case BoolType:
+		return pgOidBool
+	case DateType:
+		return pgOidDate
+	case TimestampType:
+		return pgOidTimestamp
+	case DecimalType:
+		return pgOidNumeric
+	}
+	return pgOidText
+}
+
+// Returns the size of the PostgreSQL type values of type t are sent as, or
+// -1 if their size varies.
+func pgTypeSize(t DBType) int16 {
+	switch t {
+	case IntType, FloatType, TimestampType:
+		return 8
+	case BoolType:
+		return 1
+	case DateType:
+		return 4
+	}
+	return -1
+}
+
+func (c *pgConn) rowDescription(desc *TupleDesc, formats []int16) *pgMessage {
+	m := (&pgMessage{typ: 'T'}).int16(int16(len(desc.Fields)))
+	for i, f := range desc.Fields {
+		m.string(f.Fname).int32(0).int16(0)
+		m.int32(int32(pgTypeOid(f.Ftype))).int16(pgTypeSize(f.Ftype)).int32(-1)
+		m.int16(pgFormat(formats, i))
+	}
+	return m
+}
+
+// Returns the text format of a value.
+func encodePgText(v DBValue) string {
+	if b, ok := v.(BoolField); ok {
+		if b.Value {
+			return "t"
+		}
+		return "f"
+	}
+	return valueString(v)
+}
+
+// Returns the binary format of a value.
+func encodePgBinary(v DBValue) []byte {
+	switch v := v.(type) {
+	case IntField:
+		return binary.BigEndian.AppendUint64(nil, uint64(v.Value))
+	case FloatField:
+		return binary.BigEndian.AppendUint64(nil, math.Float64bits(v.Value))
+	case BoolField:
+		if v.Value {
+			return []byte{1}
+		}
+		return []byte{0}
+	case DateField:
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(v.Value-pgEpochDays)))
+	case TimestampField:
+		return binary.BigEndian.AppendUint64(nil, uint64(v.Value-pgEpochMicros))
+	case DecimalField:
+		return encodePgNumeric(v)
+	}
+	return []byte(valueString(v))
+}
+
+// Returns the binary format of a numeric: the number of base 10000 digits,
+// the weight of the first one, the sign and the scale, followed by the
+// digits.
+func encodePgNumeric(d DecimalField) []byte {
+	s := strconv.FormatInt(d.Value, 10)
+	sign := uint16(0)
+	if strings.HasPrefix(s, "-") {
+		sign, s = 0x4000, s[1:]
+	}
+	scale := max(d.Scale, 0)
+	if len(s) <= scale {
+		s = strings.Repeat("0", scale-len(s)+1) + s
+	}
+	intPart, frac := s[:len(s)-scale], s[len(s)-scale:]
+	intPart = strings.Repeat("0", (4-len(intPart)%4)%4) + intPart
+	frac += strings.Repeat("0", (4-len(frac)%4)%4)
+	var digits []uint16
+	for i := 0; i < len(intPart); i += 4 {
+		n, _ := strconv.Atoi(intPart[i : i+4])
+		digits = append(digits, uint16(n))
+	}
+	weight := len(digits) - 1
+	for i := 0; i < len(frac); i += 4 {
+		n, _ := strconv.Atoi(frac[i : i+4])
+		digits = append(digits, uint16(n))
+	}
+	for len(digits) > 0 && digits[0] == 0 {
+		digits = digits[1:]
+		weight--
+	}
+	for len(digits) > 0 && digits[len(digits)-1] == 0 {
+		digits = digits[:len(digits)-1]
+	}
+	if len(digits) == 0 {
+		weight, sign = 0, 0
+	}
+	b := binary.BigEndian.AppendUint16(nil, uint16(len(digits)))
+	b = binary.BigEndian.AppendUint16(b, uint16(int16(weight)))
+	b = binary.BigEndian.AppendUint16(b, sign)
+	b = binary.BigEndian.AppendUint16(b, uint16(scale))
+	for _, digit := range digits {
+		b = binary.BigEndian.AppendUint16(b, digit)
+	}
+	return b
+}
+
+// Decode a parameter value of the type with the given OID.  The types of
+// text values of unknown type are inferred: numbers are taken to be numbers,
+// as in SQL literals, and anything else a string.
+func decodePgParam(data []byte, format int16, oid uint32) (DBValue, error) {
+	if format == 1 {
+		return decodePgBinary(data, oid)
+	}
+	text := string(data)
+	var t DBType
+	switch oid {
+	case pgOidBool:
+		t = BoolType
+	case pgOidInt2, pgOidInt4, pgOidInt8:
+		t = IntType
+	case pgOidFloat4, pgOidFloat8:
+		t = FloatType
+	case pgOidDate:
+		t = DateType
+	case pgOidTimestamp, pgOidTimestampTZ:
+		t = TimestampType
+	case pgOidNumeric:
+		t = DecimalType
+	case pgOidUnknownParam, pgOidUnknown:
+		if i, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return IntField{i}, nil
+		}
+		if f, err := strconv.ParseFloat(text, 64); err == nil && !strings.ContainsAny(text, "nNiIxX") {
+			return FloatField{f}, nil
+		}
+		return StringField{text}, nil
+	default:
+		return StringField{text}, nil
+	}
+	return parseValue(text, t)
+}
+
+func decodePgBinary(data []byte, oid uint32) (DBValue, error) {
+	size := map[uint32]int{pgOidBool: 1, pgOidInt2: 2, pgOidInt4: 4, pgOidInt8: 8, pgOidFloat4: 4,
+		pgOidFloat8: 8, pgOidDate: 4, pgOidTimestamp: 8, pgOidTimestampTZ: 8}
+	if n, ok := size[oid]; ok && len(data) != n {
+		return nil, pgError{"22P03", fmt.Sprintf("invalid binary value of type %d", oid)}
+	}
+	switch oid {
+	case pgOidBool:
+		return BoolField{data[0] != 0}, nil
+	case pgOidInt2:
+		return IntField{int64(int16(binary.BigEndian.Uint16(data)))}, nil
+	case pgOidInt4:
+		return IntField{int64(int32(binary.BigEndian.Uint32(data)))}, nil
+	case pgOidInt8:
+		return IntField{int64(binary.BigEndian.Uint64(data))}, nil
+	case pgOidFloat4:
+		return FloatField{float64(math.Float32frombits(binary.BigEndian.Uint32(data)))}, nil
+	case pgOidFloat8:
+		return FloatField{math.Float64frombits(binary.BigEndian.Uint64(data))}, nil
+	case pgOidDate:
+		return DateField{int64(int32(binary.BigEndian.Uint32(data))) + pgEpochDays}, nil
+	case pgOidTimestamp, pgOidTimestampTZ:
+		return TimestampField{int64(binary.BigEndian.Uint64(data)) + pgEpochMicros}, nil
+	case pgOidText, pgOidVarchar, pgOidUnknownParam, pgOidUnknown:
+		return StringField{string(data)}, nil
+	}
+	return nil, pgError{"0A000", fmt.Sprintf("binary parameters of type %d are not supported", oid)}
+}
@@ -0,0 +1,295 @@
+package godb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minimal client of the PostgreSQL protocol.
+type pgTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// A message from the server.
+type pgTestMessage struct {
+	typ  byte
+	body []byte
+}
+
+// Returns the null-terminated strings of a message body.
+func (m pgTestMessage) strings() []string {
+	return strings.Split(strings.TrimRight(string(m.body), "\x00"), "\x00")
+}
+
+// Start a server of a new database and connect to it.
+func startPgTestServer(t *testing.T) *pgTestClient {
+	t.Helper()
+	db, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	s := NewServer(db)
+	go s.Serve(ln)
+	t.Cleanup(func() {
+		s.Close()
+		db.Close()
+	})
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	c := &pgTestClient{t, conn, bufio.NewReader(conn)}
+
+	// decline SSL, then start up
+	c.write(0, binary.BigEndian.AppendUint32(nil, pgSSLRequest))
+	if b, _ := c.r.ReadByte(); b != 'N' {
+		t.Fatalf("expected SSL to be declined, got %c", b)
+	}
+	startup := binary.BigEndian.AppendUint32(nil, pgProtocolVersion)
+	startup = append(startup, "user\x00test\x00database\x00test\x00\x00"...)
+	c.write(0, startup)
+	msgs := c.readUntilReady()
+	if msgs[0].typ != 'R' || msgs[len(msgs)-1].body[0] != 'I' {
+		t.Fatalf("unexpected startup response %v", msgs)
+	}
+	return c
+}
+
+// Send a message; a type of 0 sends a startup packet.
+func (c *pgTestClient) write(typ byte, body []byte) {
+	var msg []byte
+	if typ != 0 {
+		msg = append(msg, typ)
+	}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(len(body)+4))
+	if _, err := c.conn.Write(append(msg, body...)); err != nil {
+		c.t.Fatalf(err.Error())
+	}
+}
+
+func (c *pgTestClient) read() pgTestMessage {
+	typ, err := c.r.ReadByte()
+	if err != nil {
+		c.t.Fatalf(err.Error())
+	}
+	var n uint32
+	binary.Read(c.r, binary.BigEndian, &n)
+	body := make([]byte, n-4)
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		c.t.Fatalf(err.Error())
+	}
+	return pgTestMessage{typ, body}
+}
+
+// Read messages up to and including ReadyForQuery.
+func (c *pgTestClient) readUntilReady() []pgTestMessage {
+	var msgs []pgTestMessage
+	for {
+		m := c.read()
+		msgs = append(msgs, m)
+		if m.typ == 'Z' {
+			return msgs
+		}
+	}
+}
+
+// Run a simple query, returning the responses, summarized as the command tags,
+// the text of the data rows and the SQLSTATE codes of errors, and the
+// transaction status.
+func (c *pgTestClient) query(sql string) ([]string, byte) {
+	c.write('Q', append([]byte(sql), 0))
+	return summarizePgMessages(c.readUntilReady())
+}
+
+func summarizePgMessages(msgs []pgTestMessage) ([]string, byte) {
+	var out []string
+	var status byte
+	for _, m := range msgs {
+		switch m.typ {
+		case 'C':
+			out = append(out, m.strings()[0])
+		case 'D':
+			n := int(binary.BigEndian.Uint16(m.body))
+			body := m.body[2:]
+			var fields []string
+			for i := 0; i < n; i++ {
+				l := int(int32(binary.BigEndian.Uint32(body)))
+				fields = append(fields, string(body[4:4+l]))
+				body = body[4+l:]
+			}
+			out = append(out, "row "+strings.Join(fields, ","))
+		case 'E':
+			for _, f := range m.strings() {
+				if strings.HasPrefix(f, "C") {
+					out = append(out, "error "+f[1:])
+				}
+			}
+		case 's':
+			out = append(out, "suspended")
+		case 'n':
+			out = append(out, "no data")
+		case 'Z':
+			status = m.body[0]
+		}
+	}
+	return out, status
+}
+
+func pgString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+// Parse, bind and run a statement with text parameters with the extended
+// protocol, returning the responses as query does.
+func (c *pgTestClient) extended(sql string, maxRows uint32, resultFormat uint16, params ...string) ([]string, byte) {
+	parse := append(pgString(""), pgString(sql)...)
+	c.write('P', binary.BigEndian.AppendUint16(parse, 0))
+	bind := append(pgString(""), pgString("")...)
+	bind = binary.BigEndian.AppendUint16(bind, 0)
+	bind = binary.BigEndian.AppendUint16(bind, uint16(len(params)))
+	for _, p := range params {
+		bind = binary.BigEndian.AppendUint32(bind, uint32(len(p)))
+		bind = append(bind, p...)
+	}
+	bind = binary.BigEndian.AppendUint16(bind, 1)
+	bind = binary.BigEndian.AppendUint16(bind, resultFormat)
+	c.write('B', bind)
+	c.write('D', append([]byte{'P'}, pgString("")...))
+	c.write('E', binary.BigEndian.AppendUint32(pgString(""), maxRows))
+	if maxRows > 0 {
+		c.write('E', binary.BigEndian.AppendUint32(pgString(""), 0))
+	}
+	c.write('S', nil)
+	return summarizePgMessages(c.readUntilReady())
+}
+
+func TestPgServerSimpleQuery(t *testing.T) {
+	c := startPgTestServer(t)
+	got, status := c.query("create table t (id int, name varchar, ok bool); insert into t values (1, 'a;b', true), (2, 'c', false); select id, name, ok from t order by id")
+	expected := []string{"CREATE TABLE", "INSERT 0 2", "row 1,a;b,t", "row 2,c,f", "SELECT 2"}
+	if strings.Join(got, "|") != strings.Join(expected, "|") || status != 'I' {
+		t.Errorf("expected %v, got %v (%c)", expected, got, status)
+	}
+	if got, _ := c.query("select * from missing; select 1"); len(got) != 1 || got[0] != "error 42P01" {
+		t.Errorf("expected the query to stop at an undefined table, got %v", got)
+	}
+
+	// an error fails the transaction until it is rolled back
+	for _, tt := range []struct {
+		sql    string
+		result string
+		status byte
+	}{
+		{"begin", "BEGIN", 'T'},
+		{"select count(*) from t", "row 2", 'T'},
+		{"select count(*) from nope", "error 42P01", 'E'},
+		{"select count(*) from t", "error 25P02", 'E'},
+		{"commit", "ROLLBACK", 'I'},
+		{"set extra_float_digits = 3", "SET", 'I'},
+		{"select count(*) from t", "row 2", 'I'},
+	} {
+		got, status := c.query(tt.sql)
+		if len(got) == 0 || got[0] != tt.result || status != tt.status {
+			t.Errorf("%s: expected %s (%c), got %v (%c)", tt.sql, tt.result, tt.status, got, status)
+		}
+	}
+}
+
+func TestPgServerExtendedQuery(t *testing.T) {
+	c := startPgTestServer(t)
+	c.query("create table t (id int, name varchar); insert into t values (1, 'a'), (2, 'b'), (3, '123')")
+
+	// the statement is described before it is bound; its parameter is
+	// taken to be an int
+	c.write('P', binary.BigEndian.AppendUint16(append(pgString("s1"), pgString("select name from t where id >= $1")...), 0))
+	c.write('D', append([]byte{'S'}, pgString("s1")...))
+	c.write('S', nil)
+	msgs := c.readUntilReady()
+	if len(msgs) != 4 || msgs[0].typ != '1' || msgs[1].typ != 't' || msgs[2].typ != 'T' {
+		t.Fatalf("unexpected describe response %v", msgs)
+	}
+	if oid := binary.BigEndian.Uint32(msgs[1].body[2:]); oid != pgOidInt8 {
+		t.Errorf("expected an int8 parameter, got %d", oid)
+	}
+
+	for _, tt := range []struct {
+		sql      string
+		maxRows  uint32
+		format   uint16
+		params   []string
+		expected string
+	}{
+		{"select id, name from t where id >= $1 order by id", 0, 0, []string{"2"}, "row 2,b|row 3,123|SELECT 2"},
+		{"select id, name from t where id >= $1 order by id", 1, 0, []string{"1"}, "row 1,a|suspended|row 2,b|row 3,123|SELECT 3"},
+		// '123' is taken to be a number, and then a string
+		{"select id from t where name = $1", 0, 0, []string{"123"}, "row 3|SELECT 1"},
+		{"select id from t where name = $1", 0, 1, []string{"b"}, "row \x00\x00\x00\x00\x00\x00\x00\x02|SELECT 1"},
+		{"insert into t values ($1, $2)", 0, 0, []string{"4", "d"}, "no data|INSERT 0 1"},
+		{"select nope from t", 0, 0, nil, "error 42601"},
+	} {
+		got, status := c.extended(tt.sql, tt.maxRows, tt.format, tt.params...)
+		if strings.Join(got, "|") != tt.expected || status != 'I' {
+			t.Errorf("%s %v: expected %q, got %q (%c)", tt.sql, tt.params, tt.expected, strings.Join(got, "|"), status)
+		}
+	}
+	if got, _ := c.query("select count(*) from t"); got[0] != "row 4" {
+		t.Errorf("expected 4 rows, got %v", got)
+	}
+}
+
+func TestPgNumericEncoding(t *testing.T) {
+	for _, tt := range []struct {
+		d        DecimalField
+		expected []uint16
+	}{
+		{DecimalField{123456, 2}, []uint16{2, 0, 0, 2, 1234, 5600}},
+		{DecimalField{-5, 3}, []uint16{1, 0xffff, 0x4000, 3, 50}},
+		{DecimalField{0, 2}, []uint16{0, 0, 0, 2}},
+		{DecimalField{100000000, 0}, []uint16{1, 2, 0, 0, 1}},
+	} {
+		b := encodePgNumeric(tt.d)
+		var got []uint16
+		for i := 0; i < len(b); i += 2 {
+			got = append(got, binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(got) != len(tt.expected) {
+			t.Errorf("%s: expected %v, got %v", tt.d, tt.expected, got)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.expected[i] {
+				t.Errorf("%s: expected %v, got %v", tt.d, tt.expected, got)
+				break
+			}
+		}
+	}
+}
+
+func TestPgServerStatementTimeout(t *testing.T) {
+	c := startPgTestServer(t)
+	values := strings.TrimSuffix(strings.Repeat("(1), ", 1000), ", ")
+	c.query("create table t (x int); insert into t values " + values)
+	if got, _ := c.query("set statement_timeout = 50"); len(got) != 1 || got[0] != "SET" {
+		t.Fatalf("unexpected response %v", got)
+	}
+	got, status := c.query("select count(*) from t a, t b, t c where a.x = b.x and b.x = c.x")
+	if len(got) != 1 || got[0] != "error 57014" || status != 'I' {
+		t.Errorf("expected the query to be canceled, got %v (%c)", got, status)
+	}
+	c.query("set statement_timeout to default")
+	if got, _ := c.query("select count(*) from t"); len(got) == 0 || got[0] != "row 1000" {
+		t.Errorf("expected 1000 rows, got %v", got)
+	}
+}
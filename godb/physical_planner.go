@@ -0,0 +1,316 @@
+package godb
+
+// The physical planner.
+//
+// The logical optimizer decides what the plan of a query block computes; the
+// physical planner decides how.  For each join it chooses between a nested
+// loops join, one whose inner input is kept in memory ([Materialize]), and a
+// [HashJoin] built over either input; for grouping and DISTINCT, between
+// hashing, possibly in several passes over the input, and sorting the input
+// so that equal keys are adjacent.  Each time, the alternative with the lowest
+// estimated cost is chosen, given the statistics of the tables and the memory
+// an operator may use, [MemoryBudget].  The card of the chosen operator
+// records its cost and the costs of the alternatives it was chosen over, which
+// EXPLAIN shows.
+//
+// Costs are in the units of [Stats.EstimateScanCost]: reading or writing a
+// page costs CostPerPage, and processing a tuple, e.g., applying a predicate
+// to it, costs costPerTuple.  The cost of an operator includes the cost of its
+// inputs, once for each time they are read.
+
+import (
+	"fmt"
+	"math"
+)
+
+// The bytes of memory, as estimated by [tupleMemory], that each hash table or
+// sort buffer of a query may use.  Larger inputs are hashed in several passes
+// or sorted in runs that are written to temporary files.
+var MemoryBudget = 64 << 20
+
+// If false, the operators chosen are those used before there was a physical
+// planner: nested loops joins, and hashing in a single pass and in-memory
+// sorts, however large their inputs.
+var EnablePhysicalOptimization = true
+
+const (
+	costPerTuple = 1.0 // the cost of processing a tuple
+	costPerHash  = 2.0 // the cost of adding a tuple to a hash table, or looking it up
+
+	// estimated memory of a tuple besides its values, and of each of its
+	// fields, of the state of an aggregate, and the average length of a
+	// string
+	tupleOverhead       = 64
+	fieldOverhead       = 16
+	aggStateMemory      = 48
+	averageStringLength = 16
+)
+
+// An operator the physical planner could have chosen, and its estimated cost.
+type PlanChoice struct {
+	Operator string  `json:"operator"`
+	Cost     float64 `json:"cost"`
+}
+
+func (c PlanChoice) String() string {
+	return fmt.Sprintf("%s cost:%.0f", c.Operator, c.Cost)
+}
+
+// The bytes of memory taken by a tuple, as counted against the buffers of
+// operators.
+func tupleMemory(t *Tuple) int {
+	return tupleOverhead + len(t.Fields)*fieldOverhead + t.recordSize()
+}
+
+// The estimated [tupleMemory] of the tuples with the given descriptor.
+func estimatedTupleMemory(desc *TupleDesc) int {
+	size := tupleOverhead
+	for _, f := range desc.Fields {
+		size += fieldOverhead
+		switch f.Ftype {
+		case StringType:
+			size += 4 + averageStringLength
+		case BoolType:
+			size += 1
+		case DateType:
+			size += 4
+		case DecimalType:
+			size += 9
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// Returns the number of passes needed to hash the given number of bytes
+// within the memory budget.
+func hashPasses(bytes float64) int {
+	if !EnablePhysicalOptimization {
+		return 1
+	}
+	return max(1, int(math.Ceil(bytes/float64(MemoryBudget))))
+}
+
+// Returns the buffer size of sorts.
+func sortBufferSize() int {
+	if !EnablePhysicalOptimization {
+		return 0
+	}
+	return MemoryBudget
+}
+
+// Returns the estimated number of tuples of op, or 1 if it is not known.
+func estimatedRows(op *OperatorCard) float64 {
+	return float64(max(op.Cardinality, 1))
+}
+
+// Returns the estimated cost of op, or 0 if it is not known.
+func estimatedCost(op *OperatorCard) float64 {
+	return max(op.Cost, 0)
+}
+
+// Returns the card of an operator chosen by the planner.
+func costedCard(op Operator, card int, cost float64, rejected []PlanChoice) *OperatorCard {
+	oc := NewOperatorCard(op, card)
+	oc.Cost, oc.Rejected = cost, rejected
+	return oc
+}
+
+// The estimated cost of sorting n tuples of the given width produced by an
+// input of the given cost: buffering each tuple and about log2(n) comparisons
+// per tuple, and, if they do not fit in the sort buffer, writing and reading
+// them back once.
+func sortCost(inputCost float64, n float64, width int) float64 {
+	cost := inputCost + n*(1+math.Log2(max(n, 2)))*costPerTuple
+	if buf := sortBufferSize(); buf > 0 && n*float64(width) > float64(buf) {
+		cost += 2 * math.Ceil(n*float64(width)/float64(PageSize)) * CostPerPage
+	}
+	return cost
+}
+
+// Sort the tuples of input on exprs, in ascending order.
+func sortInput(input *OperatorCard, exprs []Expr) (*OperatorCard, error) {
+	ascs := make([]bool, len(exprs))
+	for i := range ascs {
+		ascs[i] = true
+	}
+	return planOrderBy(exprs, ascs, input)
+}
+
+// An operator the physical planner may choose.
+type physicalAlternative struct {
+	name string
+	cost float64
+	plan func() (Operator, error)
+}
+
+// Returns the cheapest of alts, and the others as rejected choices.  The
+// first alternative is the default one, which is chosen if physical planning
+// is disabled, and on ties.
+func cheapest(alts []physicalAlternative) (physicalAlternative, []PlanChoice) {
+	if !EnablePhysicalOptimization {
+		return alts[0], nil
+	}
+	best := 0
+	for i, alt := range alts {
+		if alt.cost < alts[best].cost {
+			best = i
+		}
+	}
+	var rejected []PlanChoice
+	for i, alt := range alts {
+		if i != best {
+			rejected = append(rejected, PlanChoice{alt.name, alt.cost})
+		}
+	}
+	return alts[best], rejected
+}
+
+// Returns the estimated cardinality of a join, by [EstimateJoinCardinality]
+// or, if that gives no estimate, assuming that the join is on a key of the
+// smaller input, so that each tuple of the larger one joins a single tuple.
+func joinCardinality(leftCard int, rightCard int) int {
+	if card := EstimateJoinCardinality(leftCard, rightCard); card >= 0 {
+		return card
+	}
+	if leftCard < 0 || rightCard < 0 {
+		return -1
+	}
+	return max(leftCard, rightCard)
+}
+
+// Plan the join of left and right on leftExpr = rightExpr.
+func planJoin(left *OperatorCard, leftExpr Expr, right *OperatorCard, rightExpr Expr) (*OperatorCard, error) {
+	l, r := estimatedRows(left), estimatedRows(right)
+	lCost, rCost := estimatedCost(left), estimatedCost(right)
+	card := joinCardinality(left.Cardinality, right.Cardinality)
+	output := float64(max(card, 0)) * costPerTuple
+
+	// a hash join reads its build side once, and its probe side once for
+	// each chunk of the build side that fits in memory
+	hashJoin := func(buildLeft bool) physicalAlternative {
+		b, bCost, p, pCost, desc := r, rCost, l, lCost, right.Descriptor()
+		name := "Hash Join build right"
+		if buildLeft {
+			b, bCost, p, pCost, desc = l, lCost, r, rCost, left.Descriptor()
+			name = "Hash Join build left"
+		}
+		chunks := float64(hashPasses(b * float64(estimatedTupleMemory(desc))))
+		cost := bCost + b*costPerHash + chunks*(pCost+p*costPerHash) + output
+		return physicalAlternative{name, cost, func() (Operator, error) {
+			return NewHashJoin(left, leftExpr, right, rightExpr, buildLeft, MemoryBudget), nil
+		}}
+	}
+	alts := []physicalAlternative{
+		// the inner input is read once for each tuple of the outer one
+		{"Nested Loops Join", lCost + l*rCost + l*r*costPerTuple + output, func() (Operator, error) {
+			return NewJoin(left, leftExpr, right, rightExpr, JoinBufferSize)
+		}},
+		hashJoin(false),
+		hashJoin(true),
+	}
+	if r*float64(estimatedTupleMemory(right.Descriptor())) <= float64(MemoryBudget) {
+		inner := costedCard(NewMaterialize(right), right.Cardinality, rCost+r*costPerTuple, nil)
+		alts = append(alts, physicalAlternative{"Materialized Nested Loops Join", lCost + inner.Cost + l*r*costPerTuple + output, func() (Operator, error) {
+			return NewJoin(left, leftExpr, inner, rightExpr, JoinBufferSize)
+		}})
+	}
+	best, rejected := cheapest(alts)
+	op, err := best.plan()
+	if err != nil {
+		return nil, err
+	}
+	return costedCard(op, card, best.cost, rejected), nil
+}
+
+// Returns the estimated number of distinct values of exprs over n tuples of
+// the block: the product of the numbers of distinct values of the columns
+// among exprs, or n if that is not known for one of them.
+func (b *queryBlock) estimateGroups(exprs []Expr, n float64) float64 {
+	groups := 1.0
+	for _, e := range exprs {
+		distinct := n
+		if fe, ok := e.(*FieldExpr); ok {
+			if ts, ok := b.tableStats[fe.selectField.TableQualifier].(*TableStats); ok {
+				if col := ts.column(fe.selectField.Fname); col != nil && col.distinct > 0 {
+					distinct = float64(col.distinct)
+				}
+			}
+		}
+		groups *= distinct
+	}
+	return max(1, min(groups, n))
+}
+
+// Plan the aggregation of the tuples of input by aggs, grouped by gbys.
+func (b *queryBlock) planAggregate(aggs []AggState, gbys []Expr, input *OperatorCard) (*OperatorCard, error) {
+	n, inputCost := estimatedRows(input), estimatedCost(input)
+	if len(gbys) == 0 {
+		return costedCard(NewAggregator(aggs, input), 1, inputCost+n*costPerTuple, nil), nil
+	}
+	groups := b.estimateGroups(gbys, n)
+	agg := NewGroupedAggregator(aggs, gbys, input)
+	groupMemory := estimatedTupleMemory(agg.Descriptor()) + len(aggs)*aggStateMemory
+	passes := hashPasses(groups * float64(groupMemory))
+	sorted, err := sortInput(input, gbys)
+	if err != nil {
+		return nil, err
+	}
+	alts := []physicalAlternative{
+		{"Hash Aggregate", float64(passes)*(inputCost+n*costPerHash) + groups*costPerTuple, func() (Operator, error) {
+			agg.passes = passes
+			return agg, nil
+		}},
+		{"Sort Aggregate", sorted.Cost + n*costPerTuple, func() (Operator, error) {
+			sortAgg := NewGroupedAggregator(aggs, gbys, sorted)
+			sortAgg.sorted = true
+			return sortAgg, nil
+		}},
+	}
+	best, rejected := cheapest(alts)
+	op, _ := best.plan()
+	return costedCard(op, int(groups), best.cost, rejected), nil
+}
+
+// Plan the projection of the tuples of input onto exprs, removing duplicates
+// if distinct is set.
+func (b *queryBlock) planProject(exprs []Expr, names []string, distinct bool, input *OperatorCard) (*OperatorCard, error) {
+	n, inputCost := estimatedRows(input), estimatedCost(input)
+	projOp, err := NewProjectOp(exprs, names, distinct, input)
+	if err != nil {
+		return nil, err
+	}
+	if !distinct {
+		return costedCard(projOp, input.Cardinality, inputCost+n*costPerTuple, nil), nil
+	}
+	groups := b.estimateGroups(exprs, n)
+	passes := hashPasses(groups * float64(estimatedTupleMemory(projOp.Descriptor())))
+	sorted, err := sortInput(input, exprs)
+	if err != nil {
+		return nil, err
+	}
+	alts := []physicalAlternative{
+		{"Hash Distinct", float64(passes) * (inputCost + n*(costPerTuple+costPerHash)), func() (Operator, error) {
+			projOp.(*Project).passes = passes
+			return projOp, nil
+		}},
+		{"Sort Distinct", sorted.Cost + n*costPerTuple, func() (Operator, error) {
+			return &Project{selectFields: exprs, outputNames: names, child: sorted, distinct: true, sorted: true}, nil
+		}},
+	}
+	best, rejected := cheapest(alts)
+	op, _ := best.plan()
+	return costedCard(op, int(groups), best.cost, rejected), nil
+}
+
+// Plan the sort of the tuples of input on exprs.
+func planOrderBy(exprs []Expr, ascending []bool, input *OperatorCard) (*OperatorCard, error) {
+	orderOp, err := NewOrderBy(exprs, input, ascending)
+	if err != nil {
+		return nil, err
+	}
+	orderOp.maxBufferSize = sortBufferSize()
+	cost := sortCost(estimatedCost(input), estimatedRows(input), estimatedTupleMemory(input.Descriptor()))
+	return costedCard(orderOp, input.Cardinality, cost, nil), nil
+}
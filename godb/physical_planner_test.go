@@ -0,0 +1,159 @@
+package godb
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Creates an analyzed table big(id, grp, name) with 1000 rows, and small(id,
+// label) with 10.
+var physicalPlanTestTables = []string{
+	"create table big (id int, grp int, name varchar)",
+	"create table small (id int, label varchar)",
+	insertForTest("big", 1000, func(i int) string { return fmt.Sprintf("(%d, %d, 'n%d')", i, i%10, i%300) }),
+	insertForTest("small", 10, func(i int) string { return fmt.Sprintf("(%d, 'l%d')", i, i) }),
+	"analyze",
+}
+
+func TestPhysicalPlanJoinChoice(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, physicalPlanTestTables...)
+
+	// the hash table is built over the smaller input, whichever side it is on
+	for _, tt := range []struct {
+		sql, build string
+	}{
+		{"explain select big.name from big join small on big.grp = small.id", "build right"},
+		{"explain select big.name from small join big on small.id = big.grp", "build left"},
+	} {
+		plan := strings.Join(explainForTest(t, c, bp, tt.sql), "\n")
+		if !strings.Contains(plan, "Hash Join") || !strings.Contains(plan, tt.build) {
+			t.Errorf("%s: expected a hash join, %s\n%s", tt.sql, tt.build, plan)
+		}
+		if !strings.Contains(plan, "rejected:[") || !strings.Contains(plan, "Nested Loops Join cost:") {
+			t.Errorf("%s: expected the rejected nested loops join in the plan\n%s", tt.sql, plan)
+		}
+	}
+
+	// a single outer tuple is cheaper to join by scanning the inner input
+	plan := strings.Join(explainForTest(t, c, bp, "explain select big.name from big join small on big.grp = small.id where big.id = 7"), "\n")
+	if !strings.HasPrefix(strings.Split(plan, "\n")[1], "\tJoin,") {
+		t.Errorf("expected a nested loops join\n%s", plan)
+	}
+
+	// the estimated costs and the alternatives are in the JSON plan
+	lines := explainForTest(t, c, bp, "explain (format json) select big.name from big join small on big.grp = small.id")
+	var root ExplainNode
+	if err := json.Unmarshal([]byte(lines[0]), &root); err != nil {
+		t.Fatalf("invalid JSON plan: %s", err.Error())
+	}
+	join := root.Children[0]
+	if join.Operator != "Hash Join" || join.EstimatedCost == nil || len(join.Rejected) != 3 {
+		t.Fatalf("unexpected join node %s", lines[0])
+	}
+	for _, r := range join.Rejected {
+		if r.Cost < *join.EstimatedCost {
+			t.Errorf("rejected %s is cheaper than the chosen join", r)
+		}
+	}
+}
+
+func TestPhysicalPlanMemoryBudget(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, physicalPlanTestTables...)
+	defer func(budget int) {
+		MemoryBudget = budget
+		EnablePhysicalOptimization = true
+	}(MemoryBudget)
+
+	// with a small budget, grouping and removing duplicates on many distinct
+	// values sorts rather than hashing in many passes
+	MemoryBudget = 2000
+	for _, sql := range []string{
+		"explain select id, count(*) from big group by id",
+		"explain select distinct id, name from big",
+	} {
+		plan := strings.Join(explainForTest(t, c, bp, sql), "\n")
+		if !strings.Contains(plan, "sorted") || !strings.Contains(plan, "Order By") {
+			t.Errorf("%s: expected a sort\n%s", sql, plan)
+		}
+	}
+	MemoryBudget = 1 << 20
+	plan := strings.Join(explainForTest(t, c, bp, "explain select id, count(*) from big group by id"), "\n")
+	if !strings.Contains(plan, "hashed") || strings.Contains(plan, "Order By") {
+		t.Errorf("expected a hash aggregate\n%s", plan)
+	}
+
+	queries := []string{
+		"select id, count(*) from big group by id",
+		"select grp, name, sum(id) from big group by grp, name",
+		"select distinct name from big",
+		"select big.name, small.label from big join small on big.grp = small.id",
+		"select b1.id, b2.id from big b1 join big b2 on b1.id = b2.id where b1.id < 500",
+		"select id, name from big order by name, id desc",
+	}
+	EnablePhysicalOptimization = false
+	expected := make([]string, len(queries))
+	for i, sql := range queries {
+		expected[i] = rowsForTest(t, c, bp, sql, !strings.Contains(sql, "order by"))
+	}
+	EnablePhysicalOptimization = true
+	before, _ := filepath.Glob(filepath.Join(os.TempDir(), "godb-sort-*"))
+	for _, budget := range []int{1 << 20, 5000} {
+		MemoryBudget = budget
+		for i, sql := range queries {
+			if got := rowsForTest(t, c, bp, sql, !strings.Contains(sql, "order by")); got != expected[i] {
+				t.Errorf("%s with a budget of %d: got a different result", sql, budget)
+			}
+		}
+	}
+	// the runs of external sorts are removed
+	if after, _ := filepath.Glob(filepath.Join(os.TempDir(), "godb-sort-*")); len(after) != len(before) {
+		t.Errorf("expected the sort runs to be removed, found %v", after)
+	}
+}
+
+func TestHashOperatorPasses(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, physicalPlanTestTables...)
+	hf, err := c.GetTable("big")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	grp := &FieldExpr{hf.Descriptor().Fields[1]}
+	name := &FieldExpr{hf.Descriptor().Fields[2]}
+	count := func(op Operator) int {
+		tid := NewTID()
+		bp.BeginTransaction(tid)
+		defer bp.CommitTransaction(tid)
+		n := 0
+		if err := forEachTuple(op, tid, func(*Tuple) { n++ }); err != nil {
+			t.Fatalf(err.Error())
+		}
+		return n
+	}
+
+	cnt := &CountAggState{}
+	cnt.Init("cnt", grp)
+	for _, passes := range []int{1, 3} {
+		agg := NewGroupedAggregator([]AggState{cnt}, []Expr{name}, hf)
+		agg.passes = passes
+		if n := count(agg); n != 300 {
+			t.Errorf("expected 300 groups in %d passes, got %d", passes, n)
+		}
+		op, _ := NewProjectOp([]Expr{grp}, []string{"grp"}, true, hf)
+		op.(*Project).passes = passes
+		if n := count(op); n != 10 {
+			t.Errorf("expected 10 distinct values in %d passes, got %d", passes, n)
+		}
+	}
+
+	// a hash join whose build side does not fit in its buffer reads the
+	// probe side once per chunk
+	small, _ := c.GetTable("small")
+	join := NewHashJoin(hf, grp, small, &FieldExpr{small.Descriptor().Fields[0]}, false, 1000)
+	if n := count(join); n != 1000 {
+		t.Errorf("expected 1000 joined tuples, got %d", n)
+	}
+}
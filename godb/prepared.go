@@ -0,0 +1,410 @@
+package godb
+
+import (
+	"container/list"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The number of statements whose plans are kept by the plan cache of a
+// database.
+var PlanCacheSize = 128
+
+// The number of plans kept for a statement and a set of parameter types.  A
+// plan is used by one execution at a time, so more are needed when a
+// statement is executed again while a previous execution is still running.
+const plansPerStatement = 4
+
+// The values of the parameters of a statement being planned, and the
+// constants of the plan that hold the value of each parameter.
+type paramBinding struct {
+	values []DBValue
+	slots  [][]*ConstExpr
+}
+
+// Returns parameter n, whose constant is set again every time the plan it is
+// in is executed.
+func (b *paramBinding) bind(n int) (*ParamExpr, error) {
+	if b == nil || n > len(b.values) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("no value bound for parameter $%d", n)}
+	}
+	v := b.values[n-1]
+	ce := &ConstExpr{v, valueType(v)}
+	b.slots[n-1] = append(b.slots[n-1], ce)
+	return &ParamExpr{n, ce}, nil
+}
+
+// A plan of a prepared statement, along with the constants that hold the
+// values of its parameters.
+type planInstance struct {
+	op    Operator
+	slots [][]*ConstExpr
+	owner *boundPlan // the execution using the plan, if any
+}
+
+// Set the parameters of the plan to values, which must have the types the
+// plan was made for.
+func (p *planInstance) bind(values []DBValue) {
+	for i, slots := range p.slots {
+		for _, ce := range slots {
+			ce.val = values[i]
+		}
+	}
+}
+
+// The parsed statement of a prepared statement, and its plans for each set
+// of parameter types it has been executed with.  Plans made with a different
+// schema or statistics version than the current one are not used.
+type planCacheEntry struct {
+	key          string
+	stmt         sqlparser.Statement
+	version      uint64
+	statsVersion uint64
+	plans        map[string][]*planInstance
+}
+
+// An LRU cache of the plans of prepared statements, keyed by their SQL and
+// the schema they were planned in.
+type planCache struct {
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List // of *planCacheEntry, the most recently used first
+}
+
+func newPlanCache(capacity int) *planCache {
+	return &planCache{capacity, make(map[string]*list.Element), list.New()}
+}
+
+// Returns the entry with the given key, or nil if there is none or if it is
+// out of date.
+func (pc *planCache) get(key string, version uint64, statsVersion uint64) *planCacheEntry {
+	elem, ok := pc.entries[key]
+	if !ok {
+		return nil
+	}
+	e := elem.Value.(*planCacheEntry)
+	if e.version != version || e.statsVersion != statsVersion {
+		pc.lru.Remove(elem)
+		delete(pc.entries, key)
+		return nil
+	}
+	pc.lru.MoveToFront(elem)
+	return e
+}
+
+// Add an entry, evicting the least recently used one if the cache is full.
+func (pc *planCache) put(e *planCacheEntry) {
+	if elem, ok := pc.entries[e.key]; ok {
+		pc.lru.Remove(elem)
+	}
+	pc.entries[e.key] = pc.lru.PushFront(e)
+	for pc.lru.Len() > max(pc.capacity, 0) {
+		last := pc.lru.Back()
+		pc.lru.Remove(last)
+		delete(pc.entries, last.Value.(*planCacheEntry).key)
+	}
+}
+
+func (pc *planCache) len() int {
+	return pc.lru.Len()
+}
+
+// A statement that is parsed once and can be executed many times with
+// different values for its parameters, which are written ? or $1, $2, ... in
+// its SQL.  Queries, inserts and deletes are planned once for each set of
+// parameter types they are executed with, and their plans are kept in the
+// database's plan cache until a table, view or schema is created or dropped,
+// or statistics change.  Other statements are parsed again every time they are
+// executed.
+type PreparedStatement struct {
+	session   *Session
+	sql       string // with parameters written :v1, :v2, ...
+	numParams int
+	cached    bool // whether the statement is a query, insert or delete
+}
+
+var dollarParamRe = regexp.MustCompile(`'(?:[^']|'')*'|\$([0-9]+)|\?`)
+
+// Rewrite the parameters $1, $2, ... of a statement as :v1, :v2, ..., which
+// sqlparser parses, as it does ?.  The two forms cannot be mixed.
+func rewriteParams(query string) (string, error) {
+	dollar, question := false, false
+	query = dollarParamRe.ReplaceAllStringFunc(query, func(s string) string {
+		switch {
+		case s == "?":
+			question = true
+		case s[0] == '$':
+			dollar = true
+			return ":v" + s[1:]
+		}
+		return s
+	})
+	if dollar && question {
+		return "", GoDBError{ParseError, "cannot mix ? and $n parameters"}
+	}
+	return query, nil
+}
+
+// Returns the number of parameters of a statement, the largest n of its
+// parameters $n.
+func countParams(stmt sqlparser.Statement) int {
+	n := 0
+	if w, ok := stmt.(*withSelect); ok {
+		for _, cte := range w.ctes {
+			n = max(n, countParams(cte.stmt))
+		}
+		stmt = w.SelectStatement
+	}
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if v, ok := node.(*sqlparser.SQLVal); ok && v.Type == sqlparser.ValArg {
+			var i int
+			if _, err := fmt.Sscanf(string(v.Val), ":v%d", &i); err == nil {
+				n = max(n, i)
+			}
+		}
+		return true, nil
+	}, stmt)
+	return n
+}
+
+// Prepare a statement for execution with [PreparedStatement.Bind] in the
+// default session of c; see [Session.Prepare].
+func Prepare(c *Catalog, sql string) (*PreparedStatement, error) {
+	return c.schemas.session.Prepare(sql)
+}
+
+// Prepare a statement for execution with [PreparedStatement.Bind] in the
+// session, whose current schema unqualified table names are resolved in when
+// it is bound.  Syntax errors are reported here; errors that depend on the
+// parameter values, such as type mismatches, are reported when it is bound.
+func (session *Session) Prepare(sql string) (*PreparedStatement, error) {
+	query, err := rewriteParams(sql)
+	if err != nil {
+		return nil, err
+	}
+	s := &PreparedStatement{session, query, 0, false}
+	query = rewriteWindows(rewriteCasts(query))
+	if explainRe.MatchString(query) || analyzeRe.MatchString(query) {
+		return s, nil
+	}
+	if _, _, _, ok := splitCreateAs(query); ok {
+		return s, nil
+	}
+	stmt, _, err := parseSQL(query)
+	if err != nil {
+		return nil, err
+	}
+	s.numParams = countParams(stmt)
+	switch stmt.(type) {
+	case *sqlparser.Select, *sqlparser.Union, *sqlparser.ParenSelect, *withSelect, *sqlparser.Insert, *sqlparser.Delete:
+		s.cached = true
+	}
+	return s, nil
+}
+
+// Returns the number of parameters of the statement; the statement must be
+// bound to that many values.  It is 0 for statements that are not queries,
+// inserts or deletes, whose parameters are only checked when they are bound.
+func (s *PreparedStatement) NumParams() int {
+	return s.numParams
+}
+
+// Convert a parameter value to a DBValue.  Go integers, floats, strings,
+// booleans and times are accepted, as well as DBValues.
+func toDBValue(arg any) (DBValue, error) {
+	switch v := arg.(type) {
+	case DBValue:
+		return v, nil
+	case int:
+		return IntField{int64(v)}, nil
+	case int8:
+		return IntField{int64(v)}, nil
+	case int16:
+		return IntField{int64(v)}, nil
+	case int32:
+		return IntField{int64(v)}, nil
+	case int64:
+		return IntField{v}, nil
+	case uint8:
+		return IntField{int64(v)}, nil
+	case uint16:
+		return IntField{int64(v)}, nil
+	case uint32:
+		return IntField{int64(v)}, nil
+	case float32:
+		return FloatField{float64(v)}, nil
+	case float64:
+		return FloatField{v}, nil
+	case string:
+		return StringField{v}, nil
+	case []byte:
+		return StringField{string(v)}, nil
+	case bool:
+		return BoolField{v}, nil
+	case time.Time:
+		return NewTimestampField(v), nil
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("unsupported parameter value %v of type %T", arg, arg)}
+}
+
+// Bind the parameters of the statement to args, returning the statement's
+// type and, for statements that produce tuples, the operator that produces
+// them, as [Parse] does.  The operator of a query, insert or delete uses a
+// cached plan, if one of the same parameter types is not in use by another
+// execution.
+func (s *PreparedStatement) Bind(args ...any) (QueryType, Operator, error) {
+	values := make([]DBValue, len(args))
+	for i, arg := range args {
+		v, err := toDBValue(arg)
+		if err != nil {
+			return UnknownQueryType, nil, err
+		}
+		values[i] = v
+	}
+	if !s.cached {
+		schemas := s.session.schema.schemas
+		schemas.binding = &paramBinding{values, make([][]*ConstExpr, len(values))}
+		defer func() { schemas.binding = nil }()
+		return s.session.Parse(s.sql)
+	}
+	if len(values) != s.numParams {
+		return UnknownQueryType, nil, GoDBError{ParseError, fmt.Sprintf("expected %d parameter values, got %d", s.numParams, len(values))}
+	}
+	b := &boundPlan{s, values, nil}
+	inst, err := s.instance(values)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	b.inst = inst
+	inst.owner = b
+	return IteratorType, b, nil
+}
+
+// Returns the up-to-date cache entry of the statement, parsing the statement
+// again if there is none.
+func (s *PreparedStatement) entry() (*planCacheEntry, error) {
+	schemas := s.session.schema.schemas
+	key := s.session.schema.schema + "\x00" + s.sql
+	if e := schemas.plans.get(key, schemas.version, schemas.statsVersion); e != nil {
+		return e, nil
+	}
+	stmt, _, err := parseSQL(rewriteWindows(rewriteCasts(s.sql)))
+	if err != nil {
+		return nil, err
+	}
+	e := &planCacheEntry{key, stmt, schemas.version, schemas.statsVersion, make(map[string][]*planInstance)}
+	schemas.plans.put(e)
+	return e, nil
+}
+
+// Returns a plan for the statement with parameters of the types of values
+// that no execution is using, bound to values.
+func (s *PreparedStatement) instance(values []DBValue) (*planInstance, error) {
+	e, err := s.entry()
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, len(values))
+	for i, v := range values {
+		types[i] = valueType(v).String()
+	}
+	sig := strings.Join(types, ",")
+	for _, inst := range e.plans[sig] {
+		if inst.owner == nil {
+			inst.bind(values)
+			return inst, nil
+		}
+	}
+
+	schemas := s.session.schema.schemas
+	binding := &paramBinding{values, make([][]*ConstExpr, len(values))}
+	schemas.binding = binding
+	_, op, err := planStatement(s.session.schema, e.stmt, nil)
+	schemas.binding = nil
+	if err != nil {
+		return nil, err
+	}
+	inst := &planInstance{op, binding.slots, nil}
+	if len(e.plans[sig]) < plansPerStatement {
+		e.plans[sig] = append(e.plans[sig], inst)
+	}
+	return inst, nil
+}
+
+// An execution of a prepared statement with particular parameter values.  The
+// tuples are computed by a cached plan, which is used by this execution alone
+// from the time it is bound until its tuples have all been read.
+type boundPlan struct {
+	stmt   *PreparedStatement
+	values []DBValue
+	inst   *planInstance
+}
+
+func (b *boundPlan) Descriptor() *TupleDesc {
+	return b.inst.op.Descriptor()
+}
+
+func (b *boundPlan) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(b.Open(tid))
+}
+
+// Open an iterator over the tuples of the plan, which lets other executions
+// use the plan once it is closed.
+func (b *boundPlan) Open(tid TransactionID) (*OpIterator, error) {
+	if b.inst.owner != b {
+		if b.inst.owner != nil {
+			// another execution took the plan after this one finished
+			inst, err := b.stmt.instance(b.values)
+			if err != nil {
+				return nil, err
+			}
+			b.inst = inst
+		}
+		b.inst.owner = b
+	}
+	b.inst.bind(b.values)
+	resetMaterialized(b.inst.op)
+	it, err := b.inst.op.Open(tid)
+	if err != nil {
+		b.release()
+		return nil, err
+	}
+	return newOpIterator(it.Next, func() error {
+		err := it.Close()
+		b.release()
+		return err
+	}), nil
+}
+
+// Let other executions use the plan.
+func (b *boundPlan) release() {
+	if b.inst.owner == b {
+		b.inst.owner = nil
+	}
+}
+
+// Discard the tuples stored by the [Materialize] operators of a plan, which
+// may be out of date when the plan runs again in the same transaction.
+func resetMaterialized(op Operator) {
+	switch m := op.(type) {
+	case *Materialize:
+		m.tuples, m.complete = nil, false
+	case *CommonTable:
+		// its queries are not children of the plan, as it is a leaf of each
+		// plan that reads it
+		if m.plan != nil {
+			m.complete = false
+			resetMaterialized(m.plan)
+		}
+		if m.step != nil {
+			resetMaterialized(m.step)
+		}
+	}
+	for _, child := range planChildren(op) {
+		resetMaterialized(*child)
+	}
+}
@@ -0,0 +1,215 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// Bind a prepared statement and run it in its own transaction, returning the
+// tuples it produced.
+func execPreparedForTest(t *testing.T, bp *BufferPool, s *PreparedStatement, args ...any) []*Tuple {
+	t.Helper()
+	qType, op, err := s.Bind(args...)
+	if err != nil {
+		t.Fatalf("%v: %s", args, err.Error())
+	}
+	if qType != IteratorType {
+		return nil
+	}
+	tid := BeginTransactionForTest(t, bp)
+	defer bp.CommitTransaction(tid)
+	var tups []*Tuple
+	if err := forEachTuple(op, tid, func(tup *Tuple) { tups = append(tups, tup) }); err != nil {
+		t.Fatalf("%v: %s", args, err.Error())
+	}
+	return tups
+}
+
+func TestPreparedStatement(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, physicalPlanTestTables...)
+
+	s, err := Prepare(c, "select id, name from big where grp = ? and id < ?")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if s.NumParams() != 2 {
+		t.Fatalf("expected 2 parameters, got %d", s.NumParams())
+	}
+	for _, grp := range []int{3, 7} {
+		tups := execPreparedForTest(t, bp, s, grp, 100)
+		if len(tups) != 10 {
+			t.Fatalf("grp %d: expected 10 rows, got %d", grp, len(tups))
+		}
+		for _, tup := range tups {
+			if id := tup.Fields[0].(IntField).Value; id%10 != int64(grp) || id >= 100 {
+				t.Errorf("grp %d: unexpected row %v", grp, tup.Fields)
+			}
+		}
+	}
+	if _, _, err := s.Bind(3); err == nil {
+		t.Errorf("expected an error binding too few parameters")
+	}
+
+	// the plan is cached, and reused by later executions
+	if c.schemas.plans.len() != 1 {
+		t.Errorf("expected a cached plan, found %d", c.schemas.plans.len())
+	}
+	_, op1, _ := s.Bind(1, 50)
+	execPreparedForTest(t, bp, s, 1, 50)
+	_, op2, _ := s.Bind(2, 50)
+	if op1.(*boundPlan).inst == op2.(*boundPlan).inst {
+		t.Errorf("expected an execution in progress not to share its plan")
+	}
+
+	// executions of the same statement can be interleaved
+	tid := BeginTransactionForTest(t, bp)
+	it1, err := op1.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	it2, err := op2.Iterator(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, it := range []struct {
+		iter func() (*Tuple, error)
+		grp  int64
+	}{{it1, 1}, {it2, 2}, {it1, 1}, {it2, 2}} {
+		tup, err := it.iter()
+		if err != nil || tup == nil {
+			t.Fatalf("expected a row, got %v, %v", tup, err)
+		}
+		if id := tup.Fields[0].(IntField).Value; id%10 != it.grp {
+			t.Errorf("expected a row of grp %d, got %v", it.grp, tup.Fields)
+		}
+	}
+	bp.CommitTransaction(tid)
+}
+
+func TestPreparedStatementSyntax(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, physicalPlanTestTables...)
+
+	s, err := Prepare(c, "select label from small where id >= $2 and label <> $1 limit $3")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if s.NumParams() != 3 {
+		t.Fatalf("expected 3 parameters, got %d", s.NumParams())
+	}
+	if tups := execPreparedForTest(t, bp, s, "l4", 2, 10); len(tups) != 7 {
+		t.Errorf("expected 7 rows, got %d", len(tups))
+	}
+	if tups := execPreparedForTest(t, bp, s, "l4", 2, 1); len(tups) != 1 {
+		t.Errorf("expected 1 row with a limit of 1, got %d", len(tups))
+	}
+	if _, _, err := s.Bind("l4", 2, "x"); err == nil {
+		t.Errorf("expected an error for a string limit")
+	}
+
+	// $n inside string literals is not a parameter
+	s, err = Prepare(c, "select label from small where label = '$1'")
+	if err != nil || s.NumParams() != 0 {
+		t.Errorf("expected no parameters, got %d (%v)", s.NumParams(), err)
+	}
+	if _, err := Prepare(c, "select label from small where id = ? and id = $1"); err == nil {
+		t.Errorf("expected an error mixing ? and $n parameters")
+	}
+	if _, _, err := Parse(c, "select label from small where id = ?"); err == nil {
+		t.Errorf("expected an error parsing a statement with unbound parameters")
+	}
+
+	ins, err := Prepare(c, "insert into small values (?, ?)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 10; i < 15; i++ {
+		execPreparedForTest(t, bp, ins, i, fmt.Sprintf("l%d", i))
+	}
+	if n := countRowsForTest(t, c, bp, "small"); n != 15 {
+		t.Errorf("expected 15 rows after the inserts, got %d", n)
+	}
+}
+
+// Parameters that are converted to the type of what they are compared with,
+// or cast, take their new value every time the cached plan is executed.
+func TestPreparedStatementCoercions(t *testing.T) {
+	bp, c := makeTestCatalogWith(t,
+		"create table e (name varchar, hired date, price decimal(6,2), ratio float)",
+		"insert into e values ('a', '2019-03-01', 1.50, 0.5), ('b', '2020-07-15', 2.00, 2.0), ('c', '2022-01-10', 3.25, 3.5)")
+
+	for _, tt := range []struct {
+		sql  string
+		args []any
+		want []string
+	}{
+		// a date parsed from a string
+		{"select name from e where hired > ?", []any{"2019-01-01", "2021-06-01", "2023-01-01"}, []string{"a b c", "c", ""}},
+		// a decimal from an int
+		{"select name from e where price >= ?", []any{1, 2, 4}, []string{"a b c", "b c", ""}},
+		// a float from an int
+		{"select name from e where ratio > ?", []any{0, 2, 3}, []string{"a b c", "c", "c"}},
+		// an explicit cast
+		{"select name from e where cast(? as int) = 2 and price < 3", []any{2, 3}, []string{"a b", ""}},
+		{"select name, cast(? as date) from e where name = 'a'", []any{"2024-05-01", "2025-12-31"}, []string{"a,2024-05-01", "a,2025-12-31"}},
+	} {
+		s, err := Prepare(c, tt.sql)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.sql, err.Error())
+		}
+		for i, arg := range tt.args {
+			var rows []string
+			for _, tup := range execPreparedForTest(t, bp, s, arg) {
+				vals := make([]string, len(tup.Fields))
+				for j, v := range tup.Fields {
+					vals[j] = valueString(v)
+				}
+				rows = append(rows, strings.Join(vals, ","))
+			}
+			if got := strings.Join(rows, " "); got != tt.want[i] {
+				t.Errorf("%s with %v: got %q, expected %q", tt.sql, arg, got, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestPlanCacheInvalidation(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, physicalPlanTestTables...)
+
+	s, err := Prepare(c, "select count(*) from small where id >= ?")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	_, op, _ := s.Bind(0)
+	inst := op.(*boundPlan).inst
+	execPreparedForTest(t, bp, s, 0)
+	for _, sql := range []string{"create table other (a int)", "analyze", "drop table other"} {
+		mustExecForTest(t, c, bp, sql)
+		_, op, err := s.Bind(0)
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if op.(*boundPlan).inst == inst {
+			t.Errorf("expected the plan to be discarded after %s", sql)
+		}
+		inst = op.(*boundPlan).inst
+		execPreparedForTest(t, bp, s, 0)
+	}
+
+	// the least recently used plans are evicted
+	defer func(size int) { PlanCacheSize = size }(PlanCacheSize)
+	PlanCacheSize = 2
+	_, c = makeTestCatalogWith(t, physicalPlanTestTables...)
+	for i := 0; i < 5; i++ {
+		s, err := Prepare(c, fmt.Sprintf("select id from small where id = ? + %d", i))
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if _, _, err := s.Bind(1); err != nil {
+			t.Fatalf(err.Error())
+		}
+	}
+	if n := c.schemas.plans.len(); n != 2 {
+		t.Errorf("expected 2 cached plans, found %d", n)
+	}
+}
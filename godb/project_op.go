@@ -9,8 +9,13 @@ type Project struct {
 	outputNames  []string
 	child        Operator
 	distinct     bool
-	//add additional fields here
-	// TODO: some code goes here
+
+	// How duplicates are removed, as for [Aggregator]: if sorted is set, the
+	// child is sorted on the select fields, so that duplicates are adjacent;
+	// otherwise the keys of the tuples seen are recorded, in the given number
+	// of passes over the child.
+	sorted bool
+	passes int
 }
 
 // Construct a projection operator. It saves the list of selected field, child,
@@ -45,68 +50,78 @@ func (p *Project) Descriptor() *TupleDesc {
 
 }
 
-func contains(s []Tuple, t Tuple) bool {
-	for _, seen := range s {
-		if seen.equals(&t) {
-			return true
-		}
-	}
-	return false
-}
-
 // Project operator implementation. This function should iterate over the
-// results of the child iterator, projecting out the fields from each tuple. In
-// the case of distinct projection, duplicate tuples should be removed. To
-// implement this you will need to record in some data structure with the
-// distinct tuples seen so far. Note that support for the distinct keyword is
-// optional as specified in the lab 2 assignment.
+// results of the child iterator, evaluating the select expressions on each
+// tuple. In the case of distinct projection, duplicate tuples are removed by
+// recording the keys of the tuples seen so far, or by comparing each tuple with
+// the previous one if the child is sorted.
 func (p *Project) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
-	// make this not a slice
-	seen := []Tuple{}
-	fields := []FieldType{}
+	return iteratorOf(p.Open(tid))
+}
 
-	for _, val := range p.selectFields {
-		fieldType := val.GetExprType()
-		fields = append(fields, fieldType)
-	}
+func (p *Project) Open(tid TransactionID) (*OpIterator, error) {
+	seen := make(map[any]bool)
+	var prev any // the key of the last tuple returned from a sorted child
+	pass := 0
+	desc := p.Descriptor()
+	exprs := compileExprs(p.selectFields, p.child.Descriptor())
 
-	it, err := p.child.Iterator(tid)
+	it, err := p.child.Open(tid)
 	if err != nil {
 		return nil, err
 	}
 
-	return func() (*Tuple, error) {
-
+	return newOpIterator(func() (*Tuple, error) {
 		for {
-			tup, err := it()
-			if err != nil {
+			if err := checkCanceled(tid); err != nil {
 				return nil, err
 			}
-			if tup == nil {
-				return nil, nil
-			}
-
-			outTup, err := tup.project(fields)
+			tup, err := it.Next()
 			if err != nil {
 				return nil, err
 			}
-
-			if contains(seen, *outTup) {
+			if tup == nil {
+				if pass+1 >= p.passes {
+					return nil, nil
+				}
+				pass++
+				seen = make(map[any]bool)
+				if it, err = p.child.Open(tid); err != nil {
+					return nil, err
+				}
 				continue
-			} else {
-				seenDescFields := make([]FieldType, len(outTup.Desc.Fields))
-				copy(seenDescFields, outTup.Desc.Fields)
-
-				seen = append(seen, Tuple{
-					TupleDesc{seenDescFields}, outTup.Fields, outTup.Rid})
+			}
 
-				// reset the names using the outputNames
-				for i := range outTup.Desc.Fields {
-					outTup.Desc.Fields[i].Fname = p.outputNames[i]
+			fields := make([]DBValue, len(p.selectFields))
+			for i, expr := range exprs {
+				fields[i], err = expr(tup)
+				if err != nil {
+					return nil, err
+				}
+			}
+			outTup := &Tuple{*desc, fields, nil}
+
+			if p.distinct {
+				key := outTup.tupleKey()
+				if p.sorted {
+					if key == prev {
+						continue
+					}
+					prev = key
+				} else {
+					if p.passes > 1 && partitionOf(key, p.passes) != pass {
+						continue
+					}
+					if seen[key] {
+						continue
+					}
+					seen[key] = true
 				}
-
-				return outTup, nil
 			}
+			return outTup, nil
 		}
-	}, nil
+	}, func() error {
+		seen = nil
+		return closeIterators(it)
+	}), nil
 }
@@ -0,0 +1,195 @@
+package godb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The name of the schema of the catalog loaded from the catalog file.
+const DefaultSchema = "public"
+
+// The schemas of a database.
+//
+// The catalog loaded from the catalog file is the default schema.  Every other
+// schema has its own root path, a subdirectory of the default schema's root
+// path named after it, which holds its catalog file, statistics and table
+// files.  All schemas share the default schema's BufferPool, so a query may
+// join tables from different schemas.
+//
+// Table ids and the schema version are shared by all schemas, so that ids are
+// unique within the database (log records refer to tables by id) and any
+// change to any schema changes the version.
+type schemaSet struct {
+	schemas     map[string]*Catalog
+	main        *Catalog // the default schema
+	session     *Session // the session of Parse and Prepare
+	nextTableId int
+	version     uint64
+
+	statsVersion uint64        // increases every time statistics are computed or become stale
+	plans        *planCache    // the plans of prepared statements
+	binding      *paramBinding // the parameter values of the statement being planned, if any
+}
+
+func newSchemaSet(main *Catalog) *schemaSet {
+	return &schemaSet{map[string]*Catalog{DefaultSchema: main}, main, &Session{main}, 0, 0, 0, newPlanCache(PlanCacheSize), nil}
+}
+
+// A connection to a database, which has its own current schema: the schema
+// that unqualified table names are resolved in, as set by USE.  Each
+// connection to a [Server] and of the database/sql driver has a session of its
+// own; [Parse] and [Prepare] share the default session of the catalog.
+type Session struct {
+	schema *Catalog
+}
+
+// Start a session whose current schema is the default schema.
+func (c *Catalog) NewSession() *Session {
+	return &Session{c.schemas.main}
+}
+
+// Returns the name of the schema that unqualified table names are resolved
+// in, as set by USE.
+func (s *Session) CurrentSchema() string {
+	return s.schema.schema
+}
+
+// Set the schema that unqualified table names are resolved in.
+func (s *Session) use(name string) error {
+	schema, err := s.schema.getSchema(name)
+	if err != nil {
+		return err
+	}
+	s.schema = schema
+	return nil
+}
+
+// Returns the name of the schema c belongs to.
+func (c *Catalog) SchemaName() string {
+	return c.schema
+}
+
+// Returns the name of the schema that unqualified table names are resolved in
+// by [Parse], as set by USE.
+func (c *Catalog) CurrentSchema() string {
+	return c.schemas.session.CurrentSchema()
+}
+
+// Returns the names of all schemas, in sorted order.
+func (c *Catalog) SchemaNames() []string {
+	names := make([]string, 0, len(c.schemas.schemas))
+	for name := range c.schemas.schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the named schema, or c if name is empty.
+func (c *Catalog) getSchema(name string) (*Catalog, error) {
+	if name == "" {
+		return c, nil
+	}
+	s, ok := c.schemas.schemas[strings.ToLower(name)]
+	if !ok {
+		return nil, GoDBError{NoSuchTableError, fmt.Sprintf("no schema '%s' found", name)}
+	}
+	return s, nil
+}
+
+// Returns the schema a possibly qualified table name refers to, and the name
+// of the table within it.  Unqualified names are in schema c.
+func (c *Catalog) resolveTableName(tn sqlparser.TableName) (*Catalog, string, error) {
+	s, err := c.getSchema(tn.Qualifier.String())
+	if err != nil {
+		return nil, "", err
+	}
+	return s, strings.ToLower(tn.Name.CompliantName()), nil
+}
+
+// Split a table name of the form schema.table, returning the schema it is in
+// and the name of the table.
+func (c *Catalog) resolveDottedName(name string) (*Catalog, string, error) {
+	schema, table, ok := strings.Cut(name, ".")
+	if !ok {
+		return c, name, nil
+	}
+	s, err := c.getSchema(schema)
+	return s, table, err
+}
+
+// Create a new, empty schema, along with the directory that holds its files.
+func (c *Catalog) createSchema(name string) error {
+	name = strings.ToLower(name)
+	if _, ok := c.schemas.schemas[name]; ok {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("schema %s already exists", name)}
+	}
+	s := c.newSchema(name)
+	if err := os.MkdirAll(s.rootPath, 0755); err != nil {
+		return err
+	}
+	c.schemas.version++
+	return nil
+}
+
+// Add a schema with the given name to the set of schemas, without creating or
+// loading any of its files.
+func (c *Catalog) newSchema(name string) *Catalog {
+	main := c.schemas.main
+	s := NewCatalog(main.filePath, main.bufferPool, main.rootPath+"/"+name)
+	s.schema = name
+	s.schemas = c.schemas
+	c.schemas.schemas[name] = s
+	return s
+}
+
+// Drop a schema that has no tables or views, and remove its directory, which
+// may still hold the files of tables that were dropped.  The default schema
+// and c, the current schema of the session dropping it, cannot be dropped;
+// other sessions whose current schema it is find no tables in it until they
+// USE another one.
+func (c *Catalog) dropSchema(name string) error {
+	s, err := c.getSchema(name)
+	if err != nil {
+		return err
+	}
+	if s == c.schemas.main || s == c {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop schema %s while it is in use", s.schema)}
+	}
+	if len(s.tableMap) > 0 || len(s.viewMap) > 0 {
+		return GoDBError{IllegalOperationError, fmt.Sprintf("cannot drop schema %s, it is not empty", s.schema)}
+	}
+	delete(c.schemas.schemas, s.schema)
+	c.schemas.version++
+	return os.RemoveAll(s.rootPath)
+}
+
+// Returns every schema, the default schema first.
+func (c *Catalog) allSchemas() []*Catalog {
+	all := []*Catalog{c.schemas.main}
+	for _, name := range c.SchemaNames() {
+		if s := c.schemas.schemas[name]; s != c.schemas.main {
+			all = append(all, s)
+		}
+	}
+	return all
+}
+
+// Load the catalog files of the schemas other than the default one.  A schema
+// whose catalog file does not exist yet is empty.
+func (c *Catalog) loadSchemas(names []string) error {
+	for _, name := range names {
+		s := c.newSchema(name)
+		if _, err := os.Stat(s.rootPath + "/" + s.filePath); os.IsNotExist(err) {
+			continue
+		}
+		if err := s.parseCatalogFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package godb
+
+import (
+	"os"
+	"testing"
+)
+
+var schemaTestTables = []string{
+	"create table customers (id int, name varchar)",
+	"insert into customers values (1, 'ann'), (2, 'bob')",
+	"create schema sales",
+	"create table sales.orders (id int, cid int, amount int)",
+	"insert into sales.orders values (10, 1, 5), (11, 1, 7), (12, 2, 3)",
+}
+
+func TestSchemaQualifiedNames(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, schemaTestTables...)
+	if _, err := os.Stat(c.rootPath + "/sales"); err != nil {
+		t.Errorf("expected a directory for schema sales: %s", err.Error())
+	}
+	if _, err := execForTest(t, c, bp, "select * from orders"); err == nil {
+		t.Errorf("expected orders to be unknown outside schema sales")
+	}
+	tups := mustExecForTest(t, c, bp, "select customers.name, sales.orders.amount from customers join sales.orders on customers.id = orders.cid where amount > 4")
+	if len(tups) != 2 {
+		t.Fatalf("expected 2 rows from the cross-schema join, got %d", len(tups))
+	}
+	for _, tup := range tups {
+		if tup.Fields[0].(StringField).Value != "ann" {
+			t.Errorf("unexpected row %v", tup.Fields)
+		}
+	}
+	if n := countRowsForTest(t, c, bp, "sales.orders"); n != 3 {
+		t.Errorf("expected 3 orders, got %d", n)
+	}
+
+	qType, _, err := Parse(c, "use sales")
+	if err != nil || qType != UseSchemaQueryType {
+		t.Fatalf("use sales: %v", err)
+	}
+	if c.CurrentSchema() != "sales" {
+		t.Errorf("expected current schema sales, got %s", c.CurrentSchema())
+	}
+	mustExecForTest(t, c, bp, "delete from orders where id = 12")
+	if n := countRowsForTest(t, c, bp, "orders"); n != 2 {
+		t.Errorf("expected 2 orders after delete, got %d", n)
+	}
+	if n := len(mustExecForTest(t, c, bp, "select name from public.customers")); n != 2 {
+		t.Errorf("expected 2 customers, got %d", n)
+	}
+	// a table with the same name in another schema is a different table
+	mustExecForTest(t, c, bp, "create table customers (id int)")
+	if n := countRowsForTest(t, c, bp, "customers"); n != 0 {
+		t.Errorf("expected sales.customers to be empty, got %d rows", n)
+	}
+	if _, err := execForTest(t, c, bp, "select * from customers join public.customers on customers.id = customers.id"); err == nil {
+		t.Errorf("expected an error joining two tables with the same name and no alias")
+	}
+	tups = mustExecForTest(t, c, bp, "select p.name from public.customers p join orders o on p.id = o.cid")
+	if len(tups) != 2 {
+		t.Errorf("expected 2 rows joining with aliases, got %d", len(tups))
+	}
+
+	if _, err := execForTest(t, c, bp, "select * from nosuch.orders"); err == nil {
+		t.Errorf("expected an error for an unknown schema")
+	}
+	if _, _, err := Parse(c, "use nosuch"); err == nil {
+		t.Errorf("expected an error using an unknown schema")
+	}
+}
+
+func TestSchemaViewsAndIds(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, schemaTestTables...)
+	// the view's statement is resolved in its own schema
+	mustExecForTest(t, c, bp, "create view sales.big as select id from orders where amount > 4")
+	if n := len(mustExecForTest(t, c, bp, "select * from sales.big")); n != 2 {
+		t.Errorf("expected 2 rows from sales.big, got %d", n)
+	}
+	if _, err := execForTest(t, c, bp, "select * from big"); err == nil {
+		t.Errorf("expected big to be unknown outside schema sales")
+	}
+
+	customers, _ := c.GetTableInfo("customers")
+	sales, _ := c.getSchema("sales")
+	orders, _ := sales.GetTableInfo("orders")
+	if customers.id == orders.id {
+		t.Errorf("expected tables in different schemas to have different ids")
+	}
+	if info, err := c.GetTableInfoId(orders.id); err != nil || info != orders {
+		t.Errorf("expected to find sales.orders by id")
+	}
+
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2, err := reloadCatalogForTest(t, c)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	sales2, err := c2.getSchema("sales")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if sales2.String() != sales.String() {
+		t.Errorf("expected schema sales\n%s\ngot\n%s", sales.String(), sales2.String())
+	}
+	if orders2, _ := sales2.GetTableInfo("orders"); orders2 == nil || orders2.id != orders.id {
+		t.Errorf("expected sales.orders to keep its id after reloading")
+	}
+	if c2.SchemaVersion() != c.SchemaVersion() {
+		t.Errorf("expected schema version %d, got %d", c.SchemaVersion(), c2.SchemaVersion())
+	}
+}
+
+func TestSchemaSessions(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, schemaTestTables...)
+	mustExecForTest(t, c, bp, "create table sales.customers (id int, name varchar)")
+	mustExecForTest(t, c, bp, "insert into sales.customers values (3, 'cat')")
+
+	// each session has its own current schema
+	s1, s2 := c.NewSession(), c.NewSession()
+	if qType, _, err := s1.Parse("use sales"); err != nil || qType != UseSchemaQueryType {
+		t.Fatalf("use sales: %v", err)
+	}
+	if s1.CurrentSchema() != "sales" || s2.CurrentSchema() != DefaultSchema || c.CurrentSchema() != DefaultSchema {
+		t.Errorf("expected USE to change the current schema of its session alone")
+	}
+
+	// a prepared statement is planned in the current schema of its session,
+	// and cached plans are not shared between schemas
+	p1, err := s1.Prepare("select name from customers")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	p2, err := s2.Prepare("select name from customers")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for _, run := range []struct {
+		ps   *PreparedStatement
+		want int
+	}{{p1, 1}, {p2, 2}, {p1, 1}, {p2, 2}} {
+		if n := len(execPreparedForTest(t, bp, run.ps)); n != run.want {
+			t.Errorf("expected %d customers, got %d", run.want, n)
+		}
+	}
+
+	// a session cannot drop its own current schema
+	mustExecForTest(t, c, bp, "create schema tmp")
+	if _, _, err := s1.Parse("use tmp"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, _, err := s1.Parse("drop schema tmp"); err == nil {
+		t.Errorf("expected an error dropping the current schema of the session")
+	}
+	if _, _, err := s2.Parse("drop schema tmp"); err != nil {
+		t.Errorf("drop schema tmp: %v", err)
+	}
+}
+
+func TestDropSchema(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, schemaTestTables...)
+	if _, _, err := Parse(c, "drop schema sales"); err == nil {
+		t.Errorf("expected an error dropping a schema that is not empty")
+	}
+	if _, _, err := Parse(c, "drop schema public"); err == nil {
+		t.Errorf("expected an error dropping the default schema")
+	}
+	mustExecForTest(t, c, bp, "drop table sales.orders")
+	before := c.SchemaVersion()
+	qType, _, err := Parse(c, "drop schema sales")
+	if err != nil || qType != DropSchemaQueryType {
+		t.Fatalf("drop schema sales: %v", err)
+	}
+	if c.SchemaVersion() <= before {
+		t.Errorf("expected dropping a schema to increase the schema version")
+	}
+	if _, err := os.Stat(c.rootPath + "/sales"); !os.IsNotExist(err) {
+		t.Errorf("expected the directory of schema sales to be removed")
+	}
+	if names := c.SchemaNames(); len(names) != 1 || names[0] != DefaultSchema {
+		t.Errorf("expected only the default schema, got %v", names)
+	}
+}
@@ -0,0 +1,515 @@
+package godb
+
+// The set operations UNION, INTERSECT and EXCEPT, with or without ALL.
+//
+// UNION ALL returns the tuples of both of its inputs; the other operations
+// find duplicates by hashing the keys of tuples.  The two inputs must have the
+// same number of columns, and the types of each column must have a common
+// type (see [commonType]), to which the planner casts the column of the other
+// input.  The result has the column names of the left input, and an ORDER BY
+// or LIMIT after the last operand applies to the result.  As in standard SQL,
+// INTERSECT binds more tightly than UNION and EXCEPT, which are left
+// associative.  Since keys are compared by value, a decimal and an integer
+// cast to a decimal match if they are equal, whatever their scales.  A set
+// operation in a FROM subquery or a view is computed into a table that the
+// query scans (see [subqueryTable]).
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The types of set operations, in addition to the sqlparser.Union types
+// UnionStr, UnionAllStr and UnionDistinctStr.
+const (
+	intersectStr    = "intersect"
+	intersectAllStr = "intersect all"
+	exceptStr       = "except"
+	exceptAllStr    = "except all"
+)
+
+// sqlparser only parses UNION, so the INTERSECT and EXCEPT keywords of query
+// are rewritten to UNION before it is parsed, and the set operations are
+// restored in the parsed statement by [restoreSetOps].  Returns the rewritten
+// query and the keywords of its set operations in the order they appear, or
+// none if it has no INTERSECT or EXCEPT.
+func rewriteSetOps(query string) (string, []string) {
+	lower := strings.ToLower(query)
+	var out strings.Builder
+	var ops []string
+	rewritten := false
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := lower[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case isIdentByte(c) && (i == 0 || !isIdentByte(lower[i-1])):
+			j := i
+			for j < len(query) && isIdentByte(lower[j]) {
+				j++
+			}
+			switch word := lower[i:j]; word {
+			case "union":
+				ops = append(ops, word)
+			case "intersect", "except":
+				ops = append(ops, word)
+				out.WriteString("union")
+				rewritten = true
+				i = j - 1
+				continue
+			}
+			out.WriteString(query[i:j])
+			i = j - 1
+			continue
+		}
+		out.WriteByte(query[i])
+	}
+	if !rewritten {
+		return query, nil
+	}
+	return out.String(), ops
+}
+
+// Set the types of the set operations of stmt, which was parsed from a query
+// rewritten by [rewriteSetOps], to the operations of ops.  The operations are
+// restored in the order they appear in the query, including those of its
+// subqueries.
+func restoreSetOps(stmt sqlparser.Statement, ops []string) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	next := 0
+	var restore func(node sqlparser.SQLNode)
+	restore = func(node sqlparser.SQLNode) {
+		sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+			s, ok := node.(*sqlparser.Union)
+			if !ok {
+				return true, nil
+			}
+			restore(s.Left)
+			if next < len(ops) && ops[next] != "union" {
+				all := s.Type == sqlparser.UnionAllStr
+				s.Type = ops[next]
+				if all {
+					s.Type += " all"
+				}
+			}
+			next++
+			restore(s.Right)
+			restore(s.OrderBy)
+			restore(s.Limit)
+			return false, nil
+		}, node)
+	}
+	restore(stmt)
+	if next != len(ops) {
+		return GoDBError{ParseError, "INTERSECT and EXCEPT are not supported here"}
+	}
+	return nil
+}
+
+// Plan a query, which is a SELECT, a parenthesized query or a set operation.
+func planSelectStatement(c *Catalog, s sqlparser.SelectStatement) (*OperatorCard, error) {
+	switch s := s.(type) {
+	case *sqlparser.Select:
+		plan, err := parseStatement(c, s)
+		if err != nil {
+			return nil, err
+		}
+		return makePhysicalPlan(c, plan)
+	case *sqlparser.ParenSelect:
+		return planSelectStatement(c, s.Select)
+	case *sqlparser.Union:
+		return planSetOperations(c, s)
+	case *withSelect:
+		scope, err := c.withCommonTables(s)
+		if err != nil {
+			return nil, err
+		}
+		return planSelectStatement(scope, s.SelectStatement)
+	}
+	return nil, GoDBError{ParseError, "invalid query"}
+}
+
+// Returns the table of a subquery in a FROM clause, with the given alias, that
+// is a set operation or a parenthesized query.  Unlike a SELECT, it cannot be
+// expanded into a logical plan, so its tuples are computed into a
+// [CommonTable], like those of a WITH query that is read more than once.
+func subqueryTable(c *Catalog, alias string, stmt sqlparser.SelectStatement) ([]*LogicalTableNode, error) {
+	op, err := planSelectStatement(c, stmt)
+	if err != nil {
+		return nil, err
+	}
+	table, err := NewCommonTable(alias, nil, op)
+	if err != nil {
+		return nil, err
+	}
+	var file DBFile = table
+	return []*LogicalTableNode{{alias, alias, &file, c}}, nil
+}
+
+// Plan a chain of set operations, such as a UNION b INTERSECT c, followed by
+// the ORDER BY and LIMIT of the last one.
+func planSetOperations(c *Catalog, u *sqlparser.Union) (*OperatorCard, error) {
+	var operands []sqlparser.SelectStatement
+	var ops []string
+	var flatten func(s sqlparser.SelectStatement)
+	flatten = func(s sqlparser.SelectStatement) {
+		if u, ok := s.(*sqlparser.Union); ok && len(u.OrderBy) == 0 && u.Limit == nil {
+			flatten(u.Left)
+			ops = append(ops, u.Type)
+			flatten(u.Right)
+			return
+		}
+		operands = append(operands, s)
+	}
+	flatten(u.Left)
+	ops = append(ops, u.Type)
+	flatten(u.Right)
+
+	inputs := make([]*OperatorCard, len(operands))
+	for i, s := range operands {
+		op, err := planSelectStatement(c, s)
+		if err != nil {
+			return nil, err
+		}
+		inputs[i] = op
+	}
+
+	// intersections first, then the other operations from left to right
+	terms := []*OperatorCard{inputs[0]}
+	var termOps []string
+	for i, op := range ops {
+		if op == intersectStr || op == intersectAllStr {
+			last := len(terms) - 1
+			t, err := planSetOp(op, terms[last], inputs[i+1])
+			if err != nil {
+				return nil, err
+			}
+			terms[last] = t
+			continue
+		}
+		terms = append(terms, inputs[i+1])
+		termOps = append(termOps, op)
+	}
+	result := terms[0]
+	for i, op := range termOps {
+		var err error
+		if result, err = planSetOp(op, result, terms[i+1]); err != nil {
+			return nil, err
+		}
+	}
+
+	orderBys, limit, err := parseOrderAndLimit(c, u.OrderBy, u.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return planOrderAndLimit(c, orderBys, limit, map[string]*PlanNode{}, result)
+}
+
+// Plan a set operation of type op over the tuples of left and right, whose
+// columns are cast to their common types.
+func planSetOp(op string, left *OperatorCard, right *OperatorCard) (*OperatorCard, error) {
+	name := strings.ToUpper(op)
+	ldesc, rdesc := left.Descriptor(), right.Descriptor()
+	if len(ldesc.Fields) != len(rdesc.Fields) {
+		return nil, GoDBError{TypeMismatchError, fmt.Sprintf("each side of %s must have the same number of columns, got %d and %d", name, len(ldesc.Fields), len(rdesc.Fields))}
+	}
+	types := make([]DBType, len(ldesc.Fields))
+	for i, lf := range ldesc.Fields {
+		rf := rdesc.Fields[i]
+		t, ok := commonType(lf.Ftype, rf.Ftype)
+		if !ok {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("column %d of %s has types %s and %s, which cannot be matched", i+1, name, lf.Ftype, rf.Ftype)}
+		}
+		types[i] = t
+	}
+	left, right = castColumns(left, types), castColumns(right, types)
+
+	nl, nr := estimatedRows(left), estimatedRows(right)
+	cost := estimatedCost(left) + estimatedCost(right)
+	width := float64(estimatedTupleMemory(left.Descriptor()))
+	switch op {
+	case sqlparser.UnionAllStr:
+		return costedCard(NewUnion(left, right, true), int(nl+nr), cost+(nl+nr)*costPerTuple, nil), nil
+	case sqlparser.UnionStr, sqlparser.UnionDistinctStr:
+		u := NewUnion(left, right, false)
+		u.passes = hashPasses((nl + nr) * width)
+		return costedCard(u, int(nl+nr), float64(u.passes)*(cost+(nl+nr)*(costPerTuple+costPerHash)), nil), nil
+	case intersectStr, intersectAllStr:
+		i := NewIntersect(left, right, op == intersectAllStr)
+		i.passes = hashPasses(nr * width)
+		return costedCard(i, int(min(nl, nr)), float64(i.passes)*(cost+(nl+nr)*(costPerTuple+costPerHash)), nil), nil
+	case exceptStr, exceptAllStr:
+		e := NewExcept(left, right, op == exceptAllStr)
+		e.passes = hashPasses(nr * width)
+		return costedCard(e, int(nl), float64(e.passes)*(cost+(nl+nr)*(costPerTuple+costPerHash)), nil), nil
+	}
+	return nil, GoDBError{ParseError, fmt.Sprintf("unsupported set operation %s", name)}
+}
+
+// Returns input, or a projection of it whose columns are cast to types if
+// they are not of those types already.
+func castColumns(input *OperatorCard, types []DBType) *OperatorCard {
+	desc := input.Descriptor()
+	exprs := make([]Expr, len(desc.Fields))
+	names := make([]string, len(desc.Fields))
+	cast := false
+	for i, f := range desc.Fields {
+		exprs[i], names[i] = &FieldExpr{f}, f.Fname
+		if f.Ftype != types[i] {
+			exprs[i] = &CastExpr{exprs[i], types[i], -1}
+			cast = true
+		}
+	}
+	if !cast {
+		return input
+	}
+	proj := &Project{selectFields: exprs, outputNames: names, child: input}
+	return costedCard(proj, input.Cardinality, estimatedCost(input)+estimatedRows(input)*costPerTuple, nil)
+}
+
+// Returns the name of a set operator in plans, e.g., "Union All".
+func setOpName(name string, all bool) string {
+	if all {
+		return name + " All"
+	}
+	return name
+}
+
+// Union returns the tuples of its left input followed by those of its right
+// one, with the descriptor of the left one.  Unless all is set, duplicates
+// are removed by recording the keys of the tuples returned, in the given
+// number of passes over the inputs, each of which only returns the tuples
+// whose keys hash to that pass.
+type Union struct {
+	left, right Operator
+	all         bool
+	passes      int
+}
+
+func NewUnion(left Operator, right Operator, all bool) *Union {
+	return &Union{left, right, all, 1}
+}
+
+func (u *Union) Descriptor() *TupleDesc {
+	return u.left.Descriptor()
+}
+
+func (u *Union) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(u.Open(tid))
+}
+
+// Open an iterator over the tuples of both inputs.  Closing it closes the
+// iterator of the input being read and drops the keys seen.
+func (u *Union) Open(tid TransactionID) (*OpIterator, error) {
+	desc := u.Descriptor()
+	inputs := []Operator{u.left, u.right}
+	passes := max(u.passes, 1)
+	if u.all {
+		passes = 1
+	}
+	pass, input := 0, 0
+	seen := make(map[any]bool)
+	it, err := u.left.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	return newOpIterator(func() (*Tuple, error) {
+		for {
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			t, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				if input++; input == len(inputs) {
+					input = 0
+					pass++
+					seen = make(map[any]bool)
+				}
+				if pass == passes {
+					return nil, nil
+				}
+				if it, err = inputs[input].Open(tid); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			out := &Tuple{*desc, t.Fields, nil}
+			if !u.all {
+				key := out.tupleKey()
+				if passes > 1 && partitionOf(key, passes) != pass || seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			return out, nil
+		}
+	}, func() error {
+		seen = nil
+		return closeIterators(it)
+	}), nil
+}
+
+// Intersect returns the tuples of its left input that are also tuples of its
+// right one.  Without all, each such tuple is returned once; with all, a
+// tuple is returned as many times as it occurs in both inputs.  The keys of
+// the right input are counted in a hash table, in the given number of passes
+// over both inputs.
+type Intersect struct {
+	left, right Operator
+	all         bool
+	passes      int
+}
+
+func NewIntersect(left Operator, right Operator, all bool) *Intersect {
+	return &Intersect{left, right, all, 1}
+}
+
+func (i *Intersect) Descriptor() *TupleDesc {
+	return i.left.Descriptor()
+}
+
+func (i *Intersect) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(i.Open(tid))
+}
+
+func (i *Intersect) Open(tid TransactionID) (*OpIterator, error) {
+	return openHashSetOp(tid, i.left, i.right, i.passes, func(counts map[any]int, key any) bool {
+		if counts[key] == 0 {
+			return false
+		}
+		if i.all {
+			counts[key]--
+		} else {
+			counts[key] = 0
+		}
+		return true
+	})
+}
+
+// Except returns the tuples of its left input that are not tuples of its
+// right one.  Without all, each such tuple is returned once; with all, a
+// tuple is returned as many times as it occurs in the left input more than in
+// the right one.  The keys of the right input are counted in a hash table, in
+// the given number of passes over both inputs.
+type Except struct {
+	left, right Operator
+	all         bool
+	passes      int
+}
+
+func NewExcept(left Operator, right Operator, all bool) *Except {
+	return &Except{left, right, all, 1}
+}
+
+func (e *Except) Descriptor() *TupleDesc {
+	return e.left.Descriptor()
+}
+
+func (e *Except) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(e.Open(tid))
+}
+
+func (e *Except) Open(tid TransactionID) (*OpIterator, error) {
+	return openHashSetOp(tid, e.left, e.right, e.passes, func(counts map[any]int, key any) bool {
+		if e.all {
+			if counts[key] > 0 {
+				counts[key]--
+				return false
+			}
+			return true
+		}
+		// a key is recorded once it is returned, so that it is returned once
+		if _, ok := counts[key]; ok {
+			return false
+		}
+		counts[key] = 0
+		return true
+	})
+}
+
+// Open an iterator over the tuples of left for which keep returns true, given
+// the counts of the keys of the tuples of right, which keep may update.  In
+// each of the given number of passes, the keys of the tuples of right that
+// hash to the pass are counted, and then the tuples of left that hash to it
+// are read.
+func openHashSetOp(tid TransactionID, left Operator, right Operator, passes int, keep func(counts map[any]int, key any) bool) (*OpIterator, error) {
+	passes = max(passes, 1)
+	pass := 0
+	var counts map[any]int
+
+	// Count the keys of the tuples of right that hash to the pass.
+	count := func() error {
+		counts = make(map[any]int)
+		it, err := right.Open(tid)
+		if err != nil {
+			return err
+		}
+		defer it.Close()
+		for {
+			if err := checkCanceled(tid); err != nil {
+				return err
+			}
+			t, err := it.Next()
+			if t == nil || err != nil {
+				return err
+			}
+			key := t.tupleKey()
+			if passes == 1 || partitionOf(key, passes) == pass {
+				counts[key]++
+			}
+		}
+	}
+
+	if err := count(); err != nil {
+		return nil, err
+	}
+	it, err := left.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	return newOpIterator(func() (*Tuple, error) {
+		for {
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			t, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				if pass++; pass == passes {
+					return nil, nil
+				}
+				if err := count(); err != nil {
+					return nil, err
+				}
+				if it, err = left.Open(tid); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			key := t.tupleKey()
+			if passes > 1 && partitionOf(key, passes) != pass {
+				continue
+			}
+			if keep(counts, key) {
+				return t, nil
+			}
+		}
+	}, func() error {
+		counts = nil
+		return closeIterators(it)
+	}), nil
+}
@@ -0,0 +1,159 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSetOperations(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table a (x int, s varchar)")
+	mustExecForTest(t, c, bp, "create table b (y int, t varchar)")
+	mustExecForTest(t, c, bp, "create table f (v float)")
+	mustExecForTest(t, c, bp, "insert into a values (1, 'a'), (1, 'a'), (2, 'b'), (3, 'c')")
+	mustExecForTest(t, c, bp, "insert into b values (1, 'a'), (3, 'c'), (3, 'c'), (4, 'd')")
+	mustExecForTest(t, c, bp, "insert into f values (2.5), (1.0)")
+
+	for sql, want := range map[string]string{
+		"select x from a union select y from b order by x":                            "1 2 3 4",
+		"select x from a union distinct select y from b order by x":                   "1 2 3 4",
+		"select x from a union all select y from b order by x":                        "1 1 1 2 3 3 3 4",
+		"select x, s from a intersect select y, t from b order by x":                  "1,a 3,c",
+		"select x from a intersect all select y from b order by x":                    "1 3",
+		"select x from a except select y from b":                                      "2",
+		"select x from a except all select y from b order by x":                       "1 2",
+		"select y from b except all select x from a order by y":                       "3 4",
+		"select x from a union select y from b order by x desc limit 2":               "4 3",
+		"(select x from a order by x desc limit 1) union all select y from b limit 2": "3 1",
+		// INTERSECT binds more tightly than UNION
+		"select x from a union select y from b intersect select y from b where y = 4 order by x":   "1 2 3 4",
+		"(select x from a union select y from b) intersect select y from b where y = 4 order by x": "4",
+		"select x from a except select y from b where y = 1 except select y from b where y = 3":    "2",
+		// the columns of both sides are cast to their common type
+		"select x from a union select v from f order by x": "1 2 2.5 3",
+		// keywords in strings are left alone
+		"select s from a where s <> 'intersect' except select t from b": "b",
+		// set operations in subqueries are computed into a table
+		"select x from (select x from a except select y from b) q":                           "2",
+		"select q.y from (select y from b union select x from a) q where q.y > 2 order by y": "3 4",
+		"select count(*) from ((select x from a) union all (select y from b)) q":             "8",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+
+	// the result has the names of the left side
+	tups := mustExecForTest(t, c, bp, "select x as n from a union select y from b")
+	if name := tups[0].Desc.Fields[0].Fname; name != "n" {
+		t.Errorf("expected column n, got %s", name)
+	}
+
+	mustExecForTest(t, c, bp, "create table d (z int)")
+	mustExecForTest(t, c, bp, "insert into d select x from a except select y from b")
+	if n := countRowsForTest(t, c, bp, "d"); n != 1 {
+		t.Errorf("expected 1 row inserted, got %d", n)
+	}
+
+	for _, sql := range []string{
+		"select x, s from a union select y from b",
+		"select x from a intersect select t from b",
+		"select x from (select x from a union select y, t from b) q",
+	} {
+		if _, err := execForTest(t, c, bp, sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
+
+// Decimals of different scales, and integers cast to decimals, are the same
+// value if they are numerically equal.
+func TestSetOperationDecimals(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table a (x int)")
+	mustExecForTest(t, c, bp, "create table b (d decimal(10,2))")
+	mustExecForTest(t, c, bp, "insert into a values (2), (3)")
+	mustExecForTest(t, c, bp, "insert into b values (2), (4)")
+
+	for sql, want := range map[string]string{
+		"select x from a intersect select d from b":                      "2",
+		"select x from a union select d from b order by x":               "2 3 4.00",
+		"select d from b union select x from a order by d":               "2.00 3 4.00",
+		"select x from a except select d from b":                         "3",
+		"select d from b except all select x from a":                     "4.00",
+		"select count(*) from (select x from a union select d from b) q": "3",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+
+	mustExecForTest(t, c, bp, "insert into b select x from a except select d from b")
+	if got := rowsForTest(t, c, bp, "select d from b order by d", false); got != "2.00 3.00 4.00" {
+		t.Errorf("expected 3.00 to be inserted, got %q", got)
+	}
+}
+
+// Views and CREATE TABLE AS statements can be set operations.
+func TestSetOperationViews(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table a (x int)")
+	mustExecForTest(t, c, bp, "create table b (y int)")
+	mustExecForTest(t, c, bp, "insert into a values (1), (2), (3)")
+	mustExecForTest(t, c, bp, "insert into b values (2), (4)")
+
+	mustExecForTest(t, c, bp, "create view onlya as select x from a except select y from b")
+	mustExecForTest(t, c, bp, "create table both_ab as select x from a intersect select y from b")
+	for sql, want := range map[string]string{
+		"select x from onlya order by x":                               "1 3",
+		"select o.x from onlya o join b on o.x + 1 = b.y order by o.x": "1 3",
+		"select x from both_ab":                                        "2",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+	if _, err := execForTest(t, c, bp, "drop table b"); err == nil {
+		t.Errorf("expected an error dropping a table read by a view")
+	}
+}
+
+func TestSetOperationPlans(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table a (x int, s varchar)")
+	values := make([]string, 200)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, 'name %d')", i%50, i)
+	}
+	mustExecForTest(t, c, bp, "insert into a values "+strings.Join(values, ", "))
+	mustExecForTest(t, c, bp, "analyze table a")
+
+	plan := strings.Join(explainForTest(t, c, bp, "explain select x from a union all select x from a intersect select x from a where x < 10 except all select x from a"), "\n")
+	for _, op := range []string{"Union All", "Intersect hashed", "Except All hashed"} {
+		if !strings.Contains(plan, op) {
+			t.Errorf("expected %s in the plan:\n%s", op, plan)
+		}
+	}
+
+	// hash tables that do not fit in memory are built in several passes
+	defer func(budget int) { MemoryBudget = budget }(MemoryBudget)
+	MemoryBudget = 500
+	plan = strings.Join(explainForTest(t, c, bp, "explain select s from a union select s from a"), "\n")
+	if !strings.Contains(plan, "passes") {
+		t.Errorf("expected several passes in the plan:\n%s", plan)
+	}
+	for sql, want := range map[string]int{
+		"select s from a union select s from a":                                                        200,
+		"select x from a union select x from a":                                                        50,
+		"select s from a intersect select s from a where x < 10":                                       40,
+		"select s from a except select s from a where x < 10":                                          160,
+		"select x from a except all select x from a where x < 10":                                      160,
+		"select x from a intersect all select x from a where x < 10":                                   40,
+		"select x from a intersect all select x from a where x < 10 union select x from a where x = 7": 10,
+	} {
+		if got := len(mustExecForTest(t, c, bp, sql)); got != want {
+			t.Errorf("%s: expected %d rows, got %d", sql, want, got)
+		}
+	}
+}
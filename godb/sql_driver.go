@@ -0,0 +1,338 @@
+package godb
+
+// A database/sql driver for GoDB, registered as "godb".  The data source name
+// is the directory of the database, as given to [Open]:
+//
+//	db, err := sql.Open("godb", "data")
+//
+// The connections to a directory share a single [DB], which is closed when
+// the last of them is, but each connection has its own current schema, set by
+// USE.  Statements run outside of a transaction commit when they complete, or,
+// for queries, when their rows are closed.
+//
+// Values are returned as int64 for ints, float64 for floats, string for
+// strings and decimals, bool for booleans and time.Time for dates and
+// timestamps.
+//
+// A statement run with a context, as by QueryContext, stops with the error
+// of the context once it is canceled or its deadline passes.
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+)
+
+func init() {
+	sql.Register("godb", &Driver{})
+}
+
+// The database/sql driver of GoDB.
+type Driver struct{}
+
+// The DBs opened by the driver, by directory, and their numbers of
+// connections.
+var driverDBs = struct {
+	sync.Mutex
+	dbs   map[string]*DB
+	conns map[string]int
+}{dbs: make(map[string]*DB), conns: make(map[string]int)}
+
+// Open a connection to the database in the directory name.
+func (d *Driver) Open(name string) (driver.Conn, error) {
+	dir, err := filepath.Abs(name)
+	if err != nil {
+		return nil, err
+	}
+	driverDBs.Lock()
+	defer driverDBs.Unlock()
+	db, ok := driverDBs.dbs[dir]
+	if !ok {
+		if db, err = Open(dir); err != nil {
+			return nil, err
+		}
+		driverDBs.dbs[dir] = db
+	}
+	driverDBs.conns[dir]++
+	return &driverConn{db, dir, db.c.NewSession(), nil, false}, nil
+}
+
+// A connection of the driver, which runs statements in its transaction, if
+// it has one, or each in its own transaction.
+type driverConn struct {
+	db      *DB
+	dir     string
+	session *Session // the current schema of the connection
+	tx      *Tx
+	closed  bool
+}
+
+func (c *driverConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	if c.db.closed {
+		return nil, driver.ErrBadConn
+	}
+	s, err := c.session.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &driverStmt{c, query, s}, nil
+}
+
+// Close the connection, rolling back its transaction, and close its DB if it
+// is the last connection to it.
+func (c *driverConn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.tx != nil {
+		c.tx.Rollback()
+		c.tx = nil
+	}
+	driverDBs.Lock()
+	defer driverDBs.Unlock()
+	driverDBs.conns[c.dir]--
+	if driverDBs.conns[c.dir] > 0 {
+		return nil
+	}
+	delete(driverDBs.conns, c.dir)
+	delete(driverDBs.dbs, c.dir)
+	return c.db.Close()
+}
+
+func (c *driverConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// Begin a transaction.  GoDB has a single isolation level, which is the
+// default one.
+func (c *driverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.tx != nil {
+		return nil, GoDBError{IllegalTransactionError, "cannot start transaction while in transaction"}
+	}
+	if sql.IsolationLevel(opts.Isolation) != sql.LevelDefault {
+		return nil, GoDBError{IllegalTransactionError, "isolation levels are not supported"}
+	}
+	tx, err := c.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	c.tx = tx
+	return &driverTx{c}, nil
+}
+
+// The transaction of a connection.
+type driverTx struct {
+	c *driverConn
+}
+
+func (t *driverTx) Commit() error {
+	tx := t.c.tx
+	if tx == nil {
+		return ErrTxDone
+	}
+	t.c.tx = nil
+	return tx.Commit()
+}
+
+func (t *driverTx) Rollback() error {
+	tx := t.c.tx
+	if tx == nil {
+		return ErrTxDone
+	}
+	t.c.tx = nil
+	return tx.Rollback()
+}
+
+// A prepared statement of a connection.
+type driverStmt struct {
+	c     *driverConn
+	query string
+	s     *PreparedStatement
+}
+
+func (s *driverStmt) Close() error {
+	return nil
+}
+
+// Returns the number of parameters of the statement, or -1 if they are only
+// known when it runs.
+func (s *driverStmt) NumInput() int {
+	if !s.s.cached {
+		return -1
+	}
+	return s.s.NumParams()
+}
+
+// Returns the values of the arguments of a statement, which are given by
+// position.
+func driverArgs(args []driver.NamedValue) ([]any, error) {
+	vals := make([]any, len(args))
+	for _, a := range args {
+		if a.Name != "" {
+			return nil, errors.New("godb: named parameters are not supported")
+		}
+		vals[a.Ordinal-1] = a.Value
+	}
+	return vals, nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, a := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: a}
+	}
+	return named
+}
+
+func (s *driverStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *driverStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	vals, err := driverArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var res Result
+	if s.c.tx != nil {
+		res, err = s.c.tx.exec(ctx, s.query, s.s, vals)
+	} else {
+		res, err = s.c.db.exec(ctx, s.query, s.s, vals)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return driverResult{res}, nil
+}
+
+func (s *driverStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *driverStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	vals, err := driverArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	var rows *Rows
+	if s.c.tx != nil {
+		rows, err = s.c.tx.query(ctx, s.query, s.s, vals)
+	} else {
+		rows, err = s.c.db.query(ctx, s.query, s.s, vals)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &driverRows{rows}, nil
+}
+
+// The result of a statement run by the driver.
+type driverResult struct {
+	res Result
+}
+
+func (r driverResult) LastInsertId() (int64, error) {
+	return 0, errors.New("godb: LastInsertId is not supported")
+}
+
+func (r driverResult) RowsAffected() (int64, error) {
+	return r.res.RowsAffected, nil
+}
+
+// The rows of a query run by the driver.
+type driverRows struct {
+	rows *Rows
+}
+
+func (r *driverRows) Columns() []string {
+	cols := r.rows.Columns()
+	if cols == nil {
+		return []string{}
+	}
+	return cols
+}
+
+func (r *driverRows) Close() error {
+	return r.rows.Close()
+}
+
+func (r *driverRows) Next(dest []driver.Value) error {
+	if !r.rows.Next() {
+		if err := r.rows.Err(); err != nil {
+			return err
+		}
+		return io.EOF
+	}
+	for i, v := range r.rows.Tuple().Fields {
+		dest[i] = driverValue(v)
+	}
+	return nil
+}
+
+// Convert a value to one of the types of driver.Value.
+func driverValue(v DBValue) driver.Value {
+	switch v := v.(type) {
+	case IntField:
+		return v.Value
+	case FloatField:
+		return v.Value
+	case StringField:
+		return v.Value
+	case BoolField:
+		return v.Value
+	case DateField:
+		return v.Time()
+	case TimestampField:
+		return v.Time()
+	case DecimalField:
+		return v.String()
+	}
+	return v
+}
+
+// Returns the SQL name of the type of column i, as in CREATE TABLE.
+func (r *driverRows) ColumnTypeDatabaseTypeName(i int) string {
+	switch r.rows.desc.Fields[i].Ftype {
+	case IntType:
+		return "INT"
+	case StringType:
+		return "VARCHAR"
+	case FloatType:
+		return "DOUBLE"
+	case BoolType:
+		return "BOOLEAN"
+	case DateType:
+		return "DATE"
+	case TimestampType:
+		return "TIMESTAMP"
+	case DecimalType:
+		return "DECIMAL"
+	}
+	return ""
+}
+
+// Returns the Go type the values of column i are returned as.
+func (r *driverRows) ColumnTypeScanType(i int) reflect.Type {
+	switch r.rows.desc.Fields[i].Ftype {
+	case IntType:
+		return reflect.TypeOf(int64(0))
+	case FloatType:
+		return reflect.TypeOf(float64(0))
+	case BoolType:
+		return reflect.TypeOf(false)
+	case DateType, TimestampType:
+		return reflect.TypeOf(time.Time{})
+	case StringType, DecimalType:
+		return reflect.TypeOf("")
+	}
+	return reflect.TypeOf(new(any)).Elem()
+}
@@ -0,0 +1,126 @@
+package godb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSQLDriver(t *testing.T) {
+	db, err := sql.Open("godb", t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	if _, err := db.Exec("create table t (id int, name varchar, price decimal(6,2), day date)"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	day := time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)
+	ins, err := db.Prepare("insert into t values ($1, $2, 1.25, $3)")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i := 0; i < 4; i++ {
+		res, err := ins.Exec(i, "x", "2024-02-29")
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if n, _ := res.RowsAffected(); n != 1 {
+			t.Errorf("expected 1 row affected, got %d", n)
+		}
+	}
+	ins.Close()
+
+	rows, err := db.Query("select id, name, price, day from t where id > ? order by id", 0)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for i, name := range []string{"INT", "VARCHAR", "DECIMAL", "DATE"} {
+		if types[i].DatabaseTypeName() != name {
+			t.Errorf("expected column %d to be %s, got %s", i, name, types[i].DatabaseTypeName())
+		}
+	}
+	n := 0
+	for rows.Next() {
+		var id int
+		var name, price string
+		var d time.Time
+		if err := rows.Scan(&id, &name, &price, &d); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if id != n+1 || name != "x" || price != "1.25" || !d.Equal(day) {
+			t.Errorf("unexpected row %d %s %s %v", id, name, price, d)
+		}
+		n++
+	}
+	if err := rows.Err(); err != nil || n != 3 {
+		t.Errorf("expected 3 rows, got %d (%v)", n, err)
+	}
+
+	// the deletes of a transaction are seen by its own queries
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := tx.Exec("delete from t where id < ?", 2); err != nil {
+		t.Fatalf(err.Error())
+	}
+	var count int
+	if err := tx.QueryRow("select count(*) from t").Scan(&count); err != nil || count != 2 {
+		t.Errorf("expected 2 rows in the transaction, got %d (%v)", count, err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := db.Exec("select * from missing"); err == nil {
+		t.Errorf("expected an error querying a missing table")
+	}
+	if _, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelSerializable}); err == nil {
+		t.Errorf("expected an error for an unsupported isolation level")
+	}
+}
+
+func TestSQLDriverSchemaPerConnection(t *testing.T) {
+	db, err := sql.Open("godb", t.TempDir())
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer db.Close()
+	for _, stmt := range []string{"create table t (x int)", "create schema s", "create table s.t (x int)", "insert into s.t values (1)"} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %s", stmt, err.Error())
+		}
+	}
+	ctx := context.Background()
+	c1, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer c1.Close()
+	c2, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer c2.Close()
+	if _, err := c1.ExecContext(ctx, "use s"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	// USE on one connection does not change the schema of the other
+	for _, conn := range []struct {
+		c    *sql.Conn
+		want int
+	}{{c1, 1}, {c2, 0}} {
+		var n int
+		if err := conn.c.QueryRowContext(ctx, "select count(*) from t").Scan(&n); err != nil {
+			t.Fatalf(err.Error())
+		}
+		if n != conn.want {
+			t.Errorf("expected %d rows in t, got %d", conn.want, n)
+		}
+	}
+}
@@ -1,10 +1,20 @@
 package godb
 
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+
+	"github.com/tylertreat/BoomFilters"
+)
+
 /*
  TableStats represents statistics (e.g., histograms) about base tables in a
  query.
 
- Not used for labs 1-3.
+ Statistics are computed by ANALYZE (see [Catalog.Analyze]) and stored in a
+ statistics file next to the catalog file, so that they survive restarts.
 */
 
 // Interface for statistics that are maintained for a table.
@@ -15,6 +25,22 @@ type Stats interface {
 }
 
 type TableStats struct {
+	numTuples int64
+	numPages  int
+	columns   []*ColumnStats
+
+	// number of tuples inserted or deleted since the statistics were computed
+	modifications int64
+}
+
+// Statistics about a single column.  Values of every type are mapped to int64
+// keys that preserve their order (see [histogramKey]), so a single kind of
+// histogram serves all columns.
+type ColumnStats struct {
+	name     string
+	ftype    DBType
+	distinct int64
+	hist     *IntHistogram // nil if the table is empty
 }
 
 // The default cost to read a page from disk. This value can be adjusted to
@@ -25,14 +51,161 @@ const CostPerPage = 1000
 // though our tests assume that you have at least 100 bins in your histograms.
 const NumHistBins = 100
 
+// Statistics become stale once more than staleStatsMinimum tuples plus
+// staleStatsFraction of the table have been inserted or deleted.  A [DB]
+// recomputes them in the background after the transaction that made them
+// stale commits.
+const (
+	staleStatsFraction = 0.2
+	staleStatsMinimum  = 50
+)
+
 // Create a new TableStats object, that keeps track of statistics on each column of a table.
+//
+// The file is scanned twice: once to count tuples, estimate the number of
+// distinct values of each column and find its range, and once to fill the
+// histograms.
 func ComputeTableStats(bp *BufferPool, dbFile DBFile) (*TableStats, error) {
 	tid := NewTID()
 
 	bp.BeginTransaction(tid)
 	defer bp.CommitTransaction(tid)
 
-	return &TableStats{}, nil
+	desc := dbFile.Descriptor()
+	stats := &TableStats{0, dbFile.NumPages(), make([]*ColumnStats, len(desc.Fields)), 0}
+	hlls := make([]*boom.HyperLogLog, len(desc.Fields))
+	mins := make([]int64, len(desc.Fields))
+	maxs := make([]int64, len(desc.Fields))
+	for i, f := range desc.Fields {
+		stats.columns[i] = &ColumnStats{f.Fname, f.Ftype, 0, nil}
+		hll, err := boom.NewDefaultHyperLogLog(0.01)
+		if err != nil {
+			return nil, err
+		}
+		// the default FNV-1 hash only mixes the last byte of a value into
+		// the low bits, which the HyperLogLog does not use to pick a register
+		hll.SetHash(fnv.New32a())
+		hlls[i] = hll
+	}
+
+	err := forEachTuple(dbFile, tid, func(t *Tuple) {
+		for i, v := range t.Fields {
+			key := histogramKey(v)
+			if stats.numTuples == 0 || key < mins[i] {
+				mins[i] = key
+			}
+			if stats.numTuples == 0 || key > maxs[i] {
+				maxs[i] = key
+			}
+			hlls[i].Add(valueBytes(v))
+		}
+		stats.numTuples++
+	})
+	if err != nil || stats.numTuples == 0 {
+		return stats, err
+	}
+
+	for i, col := range stats.columns {
+		col.distinct = min(int64(hlls[i].Count()), stats.numTuples)
+		if col.hist, err = NewIntHistogram(NumHistBins, mins[i], maxs[i]); err != nil {
+			return nil, err
+		}
+	}
+	err = forEachTuple(dbFile, tid, func(t *Tuple) {
+		for i, v := range t.Fields {
+			stats.columns[i].hist.AddValue(histogramKey(v))
+		}
+	})
+	return stats, err
+}
+
+func forEachTuple(op Operator, tid TransactionID, f func(*Tuple)) error {
+	it, err := op.Open(tid)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+	for {
+		t, err := it.Next()
+		if err != nil {
+			return err
+		}
+		if t == nil {
+			return nil
+		}
+		f(t)
+	}
+}
+
+// Map v to an int64 such that the order of the keys of values of the same
+// type is the order of the values.  Strings are mapped by their first seven
+// bytes, and floats and decimals by the bits of their floating point value,
+// which preserves their order but not the distances between them.
+func histogramKey(v DBValue) int64 {
+	switch v := v.(type) {
+	case IntField:
+		return v.Value
+	case BoolField:
+		return boolToInt(v.Value)
+	case DateField:
+		return v.Value
+	case TimestampField:
+		return v.Value
+	case FloatField:
+		return floatKey(v.Value)
+	case DecimalField:
+		return floatKey(v.float())
+	case StringField:
+		var b [8]byte
+		copy(b[1:], v.Value)
+		return int64(binary.BigEndian.Uint64(b[:]))
+	}
+	return 0
+}
+
+func floatKey(f float64) int64 {
+	bits := math.Float64bits(f)
+	if bits&(1<<63) != 0 {
+		// negative numbers order the other way
+		return -int64(bits &^ (1 << 63))
+	}
+	return int64(bits)
+}
+
+// The bytes used to count the distinct values of a column.
+func valueBytes(v DBValue) []byte {
+	if s, ok := v.(StringField); ok {
+		return []byte(s.Value)
+	}
+	if d, ok := v.(DecimalField); ok {
+		// 1.5 and 1.50 are the same value
+		return []byte(d.rat().String())
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(histogramKey(v)))
+}
+
+// Returns the stats for an empty table with the given fields.
+func emptyTableStats(desc *TupleDesc) *TableStats {
+	stats := &TableStats{0, 0, make([]*ColumnStats, len(desc.Fields)), 0}
+	for i, f := range desc.Fields {
+		stats.columns[i] = &ColumnStats{f.Fname, f.Ftype, 0, nil}
+	}
+	return stats
+}
+
+// Returns true if enough tuples have been inserted or deleted since the
+// statistics were computed that they should be recomputed.
+func (t *TableStats) stale() bool {
+	return float64(t.modifications) > staleStatsMinimum+staleStatsFraction*float64(t.numTuples)
+}
+
+func (t *TableStats) column(field string) *ColumnStats {
+	for _, c := range t.columns {
+		if c.name == field {
+			return c
+		}
+	}
+	return nil
 }
 
 // Estimates the cost of sequentially scanning the file, given that the cost to
@@ -44,19 +217,111 @@ func ComputeTableStats(bp *BufferPool, dbFile DBFile) (*TableStats, error) {
 // to read as a full page. (Most real hard drives can't efficiently address
 // regions smaller than a page at a time.)
 func (t *TableStats) EstimateScanCost() float64 {
-	return 0.0
+	return float64(t.numPages) * CostPerPage
 }
 
 // This method returns the number of tuples in the relation, given that a
 // predicate with selectivity is applied.
 func (t *TableStats) EstimateCardinality(selectivity float64) int {
-
-	return 0
+	return int(float64(t.numTuples) * selectivity)
 }
 
 // Given a field name, boolean predicate, and a constant, look up the relevant
 // histogram and estimate the selectivity of the filter.
+//
+// Equality is estimated from the number of distinct values of the field, and
+// other comparisons from its histogram.  Returns 1 for fields with no
+// statistics and for LIKE.
 func (t *TableStats) EstimateSelectivity(field string, op BoolOp, value DBValue) (float64, error) {
+	col := t.column(field)
+	if col == nil || op == OpLike {
+		return 1.0, nil
+	}
+	if col.hist == nil {
+		return 0.0, nil
+	}
+	if valueType(value) != col.ftype {
+		// the predicate is on an expression over the field, e.g., a cast
+		return 1.0, nil
+	}
+	key := histogramKey(value)
+	switch op {
+	case OpEq, OpNeq:
+		sel := 0.0
+		if key >= col.hist.vMin && key <= col.hist.vMax && col.distinct > 0 {
+			sel = 1.0 / float64(col.distinct)
+		}
+		if op == OpNeq {
+			sel = 1.0 - sel
+		}
+		return sel, nil
+	}
+	return col.hist.EstimateSelectivity(op, key), nil
+}
+
+// The statistics file holds the statistics of every analyzed table as JSON.
+type statsFile struct {
+	Tables []tableStatsEntry `json:"tables"`
+}
+
+type tableStatsEntry struct {
+	Table         string             `json:"table"`
+	Tuples        int64              `json:"tuples"`
+	Pages         int                `json:"pages"`
+	Modifications int64              `json:"modifications"`
+	Columns       []columnStatsEntry `json:"columns"`
+}
+
+type columnStatsEntry struct {
+	Name     string  `json:"name"`
+	Type     string  `json:"type"`
+	Distinct int64   `json:"distinct"`
+	Min      int64   `json:"min"`
+	Max      int64   `json:"max"`
+	Bins     []int64 `json:"bins,omitempty"`
+}
+
+func (t *TableStats) toEntry(table string) tableStatsEntry {
+	e := tableStatsEntry{table, t.numTuples, t.numPages, t.modifications, nil}
+	for _, c := range t.columns {
+		ce := columnStatsEntry{Name: c.name, Type: c.ftype.String(), Distinct: c.distinct}
+		if c.hist != nil {
+			ce.Min, ce.Max, ce.Bins = c.hist.vMin, c.hist.vMax, c.hist.bins
+		}
+		e.Columns = append(e.Columns, ce)
+	}
+	return e
+}
 
-	return 0.0, nil
+// Rebuild the statistics of a table with the given descriptor from an entry
+// of the statistics file.  Returns an error if the entry does not describe
+// the fields of desc, e.g., because the table was dropped and recreated.
+func (e *tableStatsEntry) toStats(desc *TupleDesc) (*TableStats, error) {
+	if len(e.Columns) != len(desc.Fields) {
+		return nil, GoDBError{MalformedDataError, fmt.Sprintf("statistics of %s have %d columns, expected %d", e.Table, len(e.Columns), len(desc.Fields))}
+	}
+	stats := &TableStats{e.Tuples, e.Pages, make([]*ColumnStats, len(e.Columns)), e.Modifications}
+	for i, ce := range e.Columns {
+		f := desc.Fields[i]
+		if ce.Name != f.Fname || ce.Type != f.Ftype.String() {
+			return nil, GoDBError{MalformedDataError, fmt.Sprintf("statistics of %s do not match column %s", e.Table, f.Fname)}
+		}
+		col := &ColumnStats{f.Fname, f.Ftype, ce.Distinct, nil}
+		if len(ce.Bins) > 0 {
+			hist, err := NewIntHistogram(int64(len(ce.Bins)), ce.Min, ce.Max)
+			if err != nil {
+				return nil, err
+			}
+			if len(hist.bins) != len(ce.Bins) {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("malformed histogram for %s.%s", e.Table, f.Fname)}
+			}
+			copy(hist.bins, ce.Bins)
+			for _, n := range ce.Bins {
+				hist.count += n
+			}
+			col.hist = hist
+		}
+		stats.columns[i] = col
+	}
+	return stats, nil
 }
@@ -0,0 +1,140 @@
+package godb
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"testing"
+)
+
+func TestIntHistogramSelectivity(t *testing.T) {
+	h, err := NewIntHistogram(10, 1, 100)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	for v := int64(1); v <= 100; v++ {
+		h.AddValue(v)
+	}
+	cases := []struct {
+		op   BoolOp
+		v    int64
+		want float64
+	}{
+		{OpEq, 50, 0.01},
+		{OpNeq, 50, 0.99},
+		{OpGt, 50, 0.5},
+		{OpGe, 50, 0.51},
+		{OpLt, 50, 0.49},
+		{OpLe, 50, 0.5},
+		{OpGt, 0, 1},
+		{OpLt, 0, 0},
+		{OpEq, 101, 0},
+		{OpLe, 100, 1},
+	}
+	for _, c := range cases {
+		if got := h.EstimateSelectivity(c.op, c.v); math.Abs(got-c.want) > 0.005 {
+			t.Errorf("selectivity of %s %d: expected %f, got %f", c.op, c.v, c.want, got)
+		}
+	}
+
+	// a span wider than an int64 must not overflow
+	h, err = NewIntHistogram(NumHistBins, math.MinInt64, math.MaxInt64)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	h.AddValue(-1 << 60)
+	h.AddValue(1 << 60)
+	if got := h.EstimateSelectivity(OpLt, 0); math.Abs(got-0.5) > 0.01 {
+		t.Errorf("expected half of the values below 0, got %f", got)
+	}
+}
+
+var statsTestTables = []string{
+	"create table people (id int, name varchar, age int)",
+	insertForTest("people", 100, func(i int) string { return fmt.Sprintf("(%d, 'p%d', %d)", i, i%10, 20+i%40) }),
+}
+
+func TestTableStatsEstimates(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, statsTestTables...)
+	if err := c.Analyze("people"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	stats := c.GetTableStats("people")
+	if stats.numTuples != 100 {
+		t.Errorf("expected 100 tuples, got %d", stats.numTuples)
+	}
+	if d := stats.column("name").distinct; d < 9 || d > 11 {
+		t.Errorf("expected about 10 distinct names, got %d", d)
+	}
+	sel, err := stats.EstimateSelectivity("name", OpEq, StringField{"p3"})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if n := stats.EstimateCardinality(sel); n < 8 || n > 12 {
+		t.Errorf("expected about 10 rows with name = 'p3', got %d", n)
+	}
+	sel, err = stats.EstimateSelectivity("age", OpLt, IntField{30})
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if math.Abs(sel-0.25) > 0.05 {
+		t.Errorf("expected selectivity of age < 30 about 0.25, got %f", sel)
+	}
+	if sel, _ := stats.EstimateSelectivity("nosuch", OpEq, IntField{1}); sel != 1 {
+		t.Errorf("expected selectivity 1 for an unknown field, got %f", sel)
+	}
+
+	// deleting most of the table makes the statistics stale; planning does
+	// not recompute them, but refreshing stale statistics does
+	mustExecForTest(t, c, bp, "delete from people where id >= 20")
+	mustExecForTest(t, c, bp, "select name from people where age < 30")
+	if stats := c.GetTableStats("people"); stats.numTuples != 100 || !stats.stale() {
+		t.Errorf("expected stale statistics with 100 tuples, got %d", stats.numTuples)
+	}
+	if err := c.RefreshStaleStats(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if stats := c.GetTableStats("people"); stats.numTuples != 20 || stats.modifications != 0 {
+		t.Errorf("expected recomputed statistics with 20 tuples, got %d (%d modifications)", stats.numTuples, stats.modifications)
+	}
+}
+
+func TestAnalyzePersistsStats(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, statsTestTables...)
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	qType, _, err := Parse(c, "analyze table people")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if qType != AnalyzeQueryType {
+		t.Errorf("expected AnalyzeQueryType, got %v", qType)
+	}
+	if _, err := os.Stat(c.statsFilePath()); err != nil {
+		t.Fatalf("expected statistics file: %s", err.Error())
+	}
+
+	c2 := NewCatalog(c.filePath, bp, c.rootPath)
+	if err := c2.parseCatalogFile(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if err := c2.loadStats(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	before, after := c.GetTableStats("people"), c2.GetTableStats("people")
+	if after == nil || after.numTuples != before.numTuples {
+		t.Fatalf("statistics were not restored")
+	}
+	for _, op := range []BoolOp{OpEq, OpLt, OpGe} {
+		s1, _ := before.EstimateSelectivity("age", op, IntField{42})
+		s2, _ := after.EstimateSelectivity("age", op, IntField{42})
+		if s1 != s2 {
+			t.Errorf("selectivity of age %s 42 changed from %f to %f", op, s1, s2)
+		}
+	}
+
+	if _, _, err := Parse(c, "analyze nosuch"); err == nil {
+		t.Errorf("expected error analyzing a missing table")
+	}
+}
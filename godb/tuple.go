@@ -7,7 +7,6 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
-	"strconv"
 	"strings"
 	"unsafe"
 )
@@ -16,9 +15,14 @@ import (
 type DBType int
 
 const (
-	IntType     DBType = iota
-	StringType  DBType = iota
-	UnknownType DBType = iota //used internally, during parsing, because sometimes the type is unknown
+	IntType       DBType = iota
+	StringType    DBType = iota
+	FloatType     DBType = iota
+	BoolType      DBType = iota
+	DateType      DBType = iota
+	TimestampType DBType = iota
+	DecimalType   DBType = iota
+	UnknownType   DBType = iota //used internally, during parsing, because sometimes the type is unknown
 )
 
 func (t DBType) String() string {
@@ -27,6 +31,16 @@ func (t DBType) String() string {
 		return "int"
 	case StringType:
 		return "string"
+	case FloatType:
+		return "float"
+	case BoolType:
+		return "bool"
+	case DateType:
+		return "date"
+	case TimestampType:
+		return "timestamp"
+	case DecimalType:
+		return "decimal"
 	}
 	return "unknown"
 }
@@ -62,20 +76,6 @@ func (d1 *TupleDesc) equals(d2 *TupleDesc) bool {
 
 }
 
-// Hint: heap_page need function there:  (desc *TupleDesc) bytesPerTuple() int
-func (desc *TupleDesc) bytesPerTuple() int {
-	size := 0
-	for i := 0; i < len(desc.Fields); i++ {
-		switch desc.Fields[i].Ftype {
-		case IntType:
-			size += (int)(unsafe.Sizeof(int64(0)))
-		case StringType:
-			size += ((int)(unsafe.Sizeof(byte('a')))) * StringLength
-		}
-	}
-	return size
-}
-
 // Given a FieldType f and a TupleDesc desc, find the best
 // matching field in desc for f.  A match is defined as
 // having the same Ftype and the same name, preferring a match
@@ -160,22 +160,24 @@ type Tuple struct {
 type recordID interface {
 }
 
-// Serialize the contents of the tuple into a byte array Since all tuples are of
-// fixed size, this method should simply write the fields in sequential order
-// into the supplied buffer.
+// Serialize the contents of the tuple into a byte array.  Fields are written
+// in sequential order into the supplied buffer, in little endian order (see
+// [binary.Write]).
 //
-// See the function [binary.Write].  Objects should be serialized in little
-// endian oder.
+// Integers are written as 8 bytes.  Strings are variable length: an int32
+// byte count followed by the bytes of the string.  A string that has been
+// moved to overflow pages (see [HeapFile.insertTuple]) is written as a count
+// of -1 followed by the int32 number of the first overflow page and the int32
+// length of the value.
 //
-// Strings can be converted to byte arrays by casting to []byte. Note that all
-// strings need to be padded to StringLength bytes (set in types.go). For
-// example if StringLength is set to 5, the string 'mit' should be written as
-// 'm', 'i', 't', 0, 0
+// Floats are written as 8 byte IEEE 754 values, booleans as a single byte,
+// dates as an int32 number of days, timestamps as an int64 number of
+// microseconds, and decimals as an int64 unscaled value followed by an int8
+// scale.
 //
 // May return an error if the buffer has insufficient capacity to store the
 // tuple.
 func (t *Tuple) writeTo(b *bytes.Buffer) error {
-	// TODO: some code goes here
 	for j := 0; j < len(t.Fields); j++ {
 		f := t.Fields[j]
 		switch f := f.(type) {
@@ -185,13 +187,45 @@ func (t *Tuple) writeTo(b *bytes.Buffer) error {
 				return err
 			}
 		case StringField:
-			err := binary.Write(b, binary.LittleEndian, []byte(f.Value))
+			err := binary.Write(b, binary.LittleEndian, int32(len(f.Value)))
+			if err != nil {
+				return err
+			}
+			_, err = b.WriteString(f.Value)
+			if err != nil {
+				return err
+			}
+		case overflowField:
+			err := binary.Write(b, binary.LittleEndian, []int32{-1, int32(f.firstPage), int32(f.length)})
 			if err != nil {
 				return err
 			}
-			rem := StringLength - len(f.Value)
-			//pad to StringLength
-			err = binary.Write(b, binary.LittleEndian, make([]byte, rem))
+		case FloatField:
+			err := binary.Write(b, binary.LittleEndian, f.Value)
+			if err != nil {
+				return err
+			}
+		case BoolField:
+			err := binary.Write(b, binary.LittleEndian, f.Value)
+			if err != nil {
+				return err
+			}
+		case DateField:
+			err := binary.Write(b, binary.LittleEndian, int32(f.Value))
+			if err != nil {
+				return err
+			}
+		case TimestampField:
+			err := binary.Write(b, binary.LittleEndian, f.Value)
+			if err != nil {
+				return err
+			}
+		case DecimalField:
+			err := binary.Write(b, binary.LittleEndian, f.Value)
+			if err != nil {
+				return err
+			}
+			err = binary.Write(b, binary.LittleEndian, int8(f.Scale))
 			if err != nil {
 				return err
 			}
@@ -200,22 +234,40 @@ func (t *Tuple) writeTo(b *bytes.Buffer) error {
 	return nil
 }
 
+// Return the number of bytes [Tuple.writeTo] writes for this tuple.
+func (t *Tuple) recordSize() int {
+	size := 0
+	for _, f := range t.Fields {
+		switch f := f.(type) {
+		case IntField:
+			size += int(unsafe.Sizeof(int64(0)))
+		case StringField:
+			size += int(unsafe.Sizeof(int32(0))) + len(f.Value)
+		case overflowField:
+			size += 3 * int(unsafe.Sizeof(int32(0)))
+		case FloatField:
+			size += int(unsafe.Sizeof(float64(0)))
+		case BoolField:
+			size += int(unsafe.Sizeof(false))
+		case DateField:
+			size += int(unsafe.Sizeof(int32(0)))
+		case TimestampField:
+			size += int(unsafe.Sizeof(int64(0)))
+		case DecimalField:
+			size += int(unsafe.Sizeof(int64(0))) + int(unsafe.Sizeof(int8(0)))
+		}
+	}
+	return size
+}
+
 // Read the contents of a tuple with the specified [TupleDesc] from the
-// specified buffer, returning a Tuple.
-//
-// See [binary.Read]. Objects should be deserialized in little endian oder.
-//
-// All strings are stored as StringLength byte objects.
-//
-// Strings with length < StringLength will be padded with zeros, and these
-// trailing zeros should be removed from the strings.  A []byte can be cast
-// directly to string.
+// specified buffer, returning a Tuple.  This is the inverse of
+// [Tuple.writeTo]; strings stored on overflow pages are returned as
+// placeholders that [HeapFile] resolves when the tuple is read.
 //
 // May return an error if the buffer has insufficent data to deserialize the
 // tuple.
 func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
-	// TODO: some code goes here
-	bs := make([]byte, StringLength)
 	fs := make([]DBValue, len(desc.Fields))
 	for i := 0; i < len(desc.Fields); i++ {
 		switch desc.Fields[i].Ftype {
@@ -227,11 +279,57 @@ func readTupleFrom(b *bytes.Buffer, desc *TupleDesc) (*Tuple, error) {
 			}
 			fs[i] = IntField{intField}
 		case StringType:
-			err := binary.Read(b, binary.LittleEndian, bs)
+			var length int32
+			err := binary.Read(b, binary.LittleEndian, &length)
 			if err != nil {
 				return nil, err
 			}
-			fs[i] = StringField{string(bytes.TrimRight(bs, "\x00"))}
+			if length == -1 {
+				var ref [2]int32
+				if err := binary.Read(b, binary.LittleEndian, &ref); err != nil {
+					return nil, err
+				}
+				fs[i] = overflowField{int(ref[0]), int(ref[1])}
+				continue
+			}
+			if length < 0 || int(length) > b.Len() {
+				return nil, GoDBError{MalformedDataError, fmt.Sprintf("invalid string length %d", length)}
+			}
+			fs[i] = StringField{string(b.Next(int(length)))}
+		case FloatType:
+			var v float64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			fs[i] = FloatField{v}
+		case BoolType:
+			var v bool
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			fs[i] = BoolField{v}
+		case DateType:
+			var v int32
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			fs[i] = DateField{int64(v)}
+		case TimestampType:
+			var v int64
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			fs[i] = TimestampField{v}
+		case DecimalType:
+			var v int64
+			var scale int8
+			if err := binary.Read(b, binary.LittleEndian, &v); err != nil {
+				return nil, err
+			}
+			if err := binary.Read(b, binary.LittleEndian, &scale); err != nil {
+				return nil, err
+			}
+			fs[i] = DecimalField{v, int(scale)}
 		}
 	}
 
@@ -305,25 +403,14 @@ func (t *Tuple) compareField(t2 *Tuple, field Expr) (orderByState, error) {
 		return order, err
 	}
 
-	switch field.GetExprType().Ftype {
-	case IntType:
-		v1 := v1.(IntField).Value
-		v2 := v2.(IntField).Value
-		if v1 < v2 {
+	cmp, ok := compareValues(v1, v2)
+	if ok {
+		switch {
+		case cmp < 0:
 			return OrderedLessThan, nil
-		} else if v1 == v2 {
+		case cmp == 0:
 			return OrderedEqual, nil
-		} else {
-			return OrderedGreaterThan, nil
-		}
-	case StringType:
-		v1 := v1.(StringField).Value
-		v2 := v2.(StringField).Value
-		if v1 < v2 {
-			return OrderedLessThan, nil
-		} else if v1 == v2 {
-			return OrderedEqual, nil
-		} else {
+		default:
 			return OrderedGreaterThan, nil
 		}
 	}
@@ -356,8 +443,22 @@ func (t *Tuple) project(fields []FieldType) (*Tuple, error) {
 	return &Tuple{TupleDesc{fieldTypes}, fieldVals, nil}, nil
 }
 
-// Compute a key for the tuple to be used in a map structure
+// Compute a key for the tuple to be used in a map structure.  Decimals are
+// normalized, so that numerically equal values (e.g., 1.5 and 1.50) have the
+// same key.
 func (t *Tuple) tupleKey() any {
+	for i, v := range t.Fields {
+		if d, ok := v.(DecimalField); ok && d.normalize() != d {
+			fields := append([]DBValue(nil), t.Fields...)
+			for j := i; j < len(fields); j++ {
+				if d, ok := fields[j].(DecimalField); ok {
+					fields[j] = d.normalize()
+				}
+			}
+			t = &Tuple{t.Desc, fields, t.Rid}
+			break
+		}
+	}
 	var buf bytes.Buffer
 	t.writeTo(&buf)
 	return buf.String()
@@ -408,13 +509,7 @@ func (d *TupleDesc) HeaderString(aligned bool) string {
 func (t *Tuple) PrettyPrintString(aligned bool) string {
 	outstr := ""
 	for i, f := range t.Fields {
-		str := ""
-		switch f := f.(type) {
-		case IntField:
-			str = strconv.FormatInt(f.Value, 10)
-		case StringField:
-			str = f.Value
-		}
+		str := valueString(f)
 		if aligned {
 			outstr = fmt.Sprintf("%s %s", outstr, fmtCol(str, len(t.Fields)))
 		} else {
@@ -9,19 +9,20 @@ import (
 type GoDBErrorCode int
 
 const (
-	TupleNotFoundError      GoDBErrorCode = iota
-	PageFullError           GoDBErrorCode = iota
-	IncompatibleTypesError  GoDBErrorCode = iota
-	TypeMismatchError       GoDBErrorCode = iota
-	MalformedDataError      GoDBErrorCode = iota
-	BufferPoolFullError     GoDBErrorCode = iota
-	ParseError              GoDBErrorCode = iota
-	DuplicateTableError     GoDBErrorCode = iota
-	NoSuchTableError        GoDBErrorCode = iota
-	AmbiguousNameError      GoDBErrorCode = iota
-	IllegalOperationError   GoDBErrorCode = iota
-	DeadlockError           GoDBErrorCode = iota
-	IllegalTransactionError GoDBErrorCode = iota
+	TupleNotFoundError       GoDBErrorCode = iota
+	PageFullError            GoDBErrorCode = iota
+	IncompatibleTypesError   GoDBErrorCode = iota
+	TypeMismatchError        GoDBErrorCode = iota
+	MalformedDataError       GoDBErrorCode = iota
+	BufferPoolFullError      GoDBErrorCode = iota
+	ParseError               GoDBErrorCode = iota
+	DuplicateTableError      GoDBErrorCode = iota
+	NoSuchTableError         GoDBErrorCode = iota
+	AmbiguousNameError       GoDBErrorCode = iota
+	IllegalOperationError    GoDBErrorCode = iota
+	DeadlockError            GoDBErrorCode = iota
+	IllegalTransactionError  GoDBErrorCode = iota
+	ConstraintViolationError GoDBErrorCode = iota
 )
 
 //go:generate stringer -type=GoDBErrorCode
@@ -36,8 +37,7 @@ func (e GoDBError) Error() string {
 }
 
 const (
-	PageSize     int = 4096
-	StringLength int = 32
+	PageSize int = 4096
 )
 
 type Page interface {
@@ -63,6 +63,10 @@ type DBFile interface {
 
 type Operator interface {
 	Descriptor() *TupleDesc
+	// Open an iterator over the tuples of the operator, which must be
+	// closed unless it is read to the end.
+	Open(tid TransactionID) (*OpIterator, error)
+	// Returns the Next method of an iterator opened by Open.
 	Iterator(tid TransactionID) (func() (*Tuple, error), error)
 }
 
@@ -92,7 +96,7 @@ var BoolOpMap = map[string]BoolOp{
 func (i1 IntField) EvalPred(v2 DBValue, op BoolOp) bool {
 	i2, ok := v2.(IntField)
 	if !ok {
-		return false
+		return evalPredOnValues(i1, v2, op)
 	}
 	x1 := i1.Value
 	x2 := i2.Value
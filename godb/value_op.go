@@ -27,8 +27,12 @@ func (v *ValueOp) Descriptor() *TupleDesc {
 }
 
 func (v *ValueOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(v.Open(tid))
+}
+
+func (v *ValueOp) Open(tid TransactionID) (*OpIterator, error) {
 	curTup := 0
-	return func() (*Tuple, error) {
+	return newOpIterator(func() (*Tuple, error) {
 		if curTup >= len(v.exprs) {
 			return nil, nil
 		}
@@ -45,5 +49,5 @@ func (v *ValueOp) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
 		curTup++
 
 		return &Tuple{*v.td, fields, nil}, nil
-	}, nil
+	}, nil), nil
 }
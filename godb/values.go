@@ -0,0 +1,594 @@
+package godb
+
+// Field values for the column types other than int and string: FLOAT/DOUBLE,
+// BOOLEAN, DATE, TIMESTAMP and DECIMAL.
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Floating point field value
+type FloatField struct {
+	Value float64
+}
+
+// Boolean field value
+type BoolField struct {
+	Value bool
+}
+
+// Date field value, the number of days since 1970-01-01
+type DateField struct {
+	Value int64
+}
+
+// Timestamp field value, the number of microseconds since the Unix epoch (UTC)
+type TimestampField struct {
+	Value int64
+}
+
+// Fixed precision decimal field value, equal to Value * 10^-Scale
+type DecimalField struct {
+	Value int64
+	Scale int
+}
+
+const (
+	dateLayout      = "2006-01-02"
+	timestampLayout = "2006-01-02 15:04:05.999999"
+
+	// the largest scale a decimal can have; 10^maxDecimalScale fits in an int64
+	maxDecimalScale = 18
+)
+
+// Layouts accepted when parsing timestamps, in addition to dates.
+var timestampLayouts = []string{
+	timestampLayout,
+	"2006-01-02T15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999",
+	time.UnixDate,
+}
+
+// Return the date of t, in t's location.
+func NewDateField(t time.Time) DateField {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return DateField{midnight.Unix() / (24 * 60 * 60)}
+}
+
+func NewTimestampField(t time.Time) TimestampField {
+	return TimestampField{t.UnixMicro()}
+}
+
+func (d DateField) Time() time.Time {
+	return time.Unix(d.Value*24*60*60, 0).UTC()
+}
+
+func (ts TimestampField) Time() time.Time {
+	return time.UnixMicro(ts.Value).UTC()
+}
+
+func (f FloatField) String() string {
+	return strconv.FormatFloat(f.Value, 'g', -1, 64)
+}
+
+func (b BoolField) String() string {
+	return strconv.FormatBool(b.Value)
+}
+
+func (d DateField) String() string {
+	return d.Time().Format(dateLayout)
+}
+
+func (ts TimestampField) String() string {
+	return ts.Time().Format(timestampLayout)
+}
+
+func (d DecimalField) String() string {
+	s := strconv.FormatInt(d.Value, 10)
+	if d.Scale <= 0 {
+		return s
+	}
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	if len(s) <= d.Scale {
+		s = strings.Repeat("0", d.Scale-len(s)+1) + s
+	}
+	s = s[:len(s)-d.Scale] + "." + s[len(s)-d.Scale:]
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// Parse a date in YYYY-MM-DD format.
+func parseDate(s string) (DateField, error) {
+	t, err := time.Parse(dateLayout, strings.TrimSpace(s))
+	if err != nil {
+		return DateField{}, GoDBError{TypeMismatchError, fmt.Sprintf("invalid date '%s'", s)}
+	}
+	return NewDateField(t), nil
+}
+
+// Parse a timestamp such as "2006-01-02 15:04:05.123", an RFC 3339 timestamp,
+// or a date, which is taken to be midnight UTC.
+func parseTimestamp(s string) (TimestampField, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range timestampLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return NewTimestampField(t), nil
+		}
+	}
+	if d, err := parseDate(s); err == nil {
+		return NewTimestampField(d.Time()), nil
+	}
+	return TimestampField{}, GoDBError{TypeMismatchError, fmt.Sprintf("invalid timestamp '%s'", s)}
+}
+
+// Parse a boolean, accepting true/false, t/f, yes/no and 1/0.
+func parseBool(s string) (BoolField, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "true", "t", "yes", "y", "1":
+		return BoolField{true}, nil
+	case "false", "f", "no", "n", "0":
+		return BoolField{false}, nil
+	}
+	return BoolField{}, GoDBError{TypeMismatchError, fmt.Sprintf("invalid boolean '%s'", s)}
+}
+
+// Parse a decimal number such as -12.50; the scale of the result is the number
+// of digits after the decimal point.
+func parseDecimal(s string) (DecimalField, error) {
+	s = strings.TrimSpace(s)
+	r, ok := new(big.Rat).SetString(s)
+	if !ok || strings.ContainsAny(s, "eE/") {
+		return DecimalField{}, GoDBError{TypeMismatchError, fmt.Sprintf("invalid decimal '%s'", s)}
+	}
+	scale := 0
+	if dot := strings.Index(s, "."); dot != -1 {
+		scale = len(s) - dot - 1
+	}
+	return decimalFromRat(r, scale)
+}
+
+// Convert r to a decimal with the given scale, rounding half away from zero.
+func decimalFromRat(r *big.Rat, scale int) (DecimalField, error) {
+	if scale > maxDecimalScale {
+		scale = maxDecimalScale
+	}
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(pow10(scale)))
+	num, den := scaled.Num(), scaled.Denom()
+	q, m := new(big.Int).QuoRem(num, den, new(big.Int))
+	// round half away from zero
+	if new(big.Int).Mul(new(big.Int).Abs(m), big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	if !q.IsInt64() {
+		return DecimalField{}, GoDBError{TypeMismatchError, fmt.Sprintf("decimal value %s out of range", r.FloatString(scale))}
+	}
+	return DecimalField{q.Int64(), scale}, nil
+}
+
+func pow10(n int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(n)), nil)
+}
+
+func (d DecimalField) rat() *big.Rat {
+	return new(big.Rat).SetFrac(big.NewInt(d.Value), pow10(d.Scale))
+}
+
+func (d DecimalField) float() float64 {
+	f, _ := d.rat().Float64()
+	return f
+}
+
+// Return d with the given scale, rounding if the scale is reduced.
+func (d DecimalField) rescale(scale int) (DecimalField, error) {
+	if scale == d.Scale {
+		return d, nil
+	}
+	return decimalFromRat(d.rat(), scale)
+}
+
+// Return d with its trailing fractional zeros removed, so that numerically
+// equal decimals of different scales are identical.
+func (d DecimalField) normalize() DecimalField {
+	for d.Scale > 0 && d.Value%10 == 0 {
+		d.Value /= 10
+		d.Scale--
+	}
+	return d
+}
+
+// Return the number of significant digits of d.
+func (d DecimalField) precision() int {
+	v := d.Value
+	if v < 0 {
+		v = -v
+	}
+	return max(len(strconv.FormatInt(v, 10)), d.Scale)
+}
+
+// Compare two values of the same type, or two numeric values, returning -1, 0
+// or 1.  Returns false if the values cannot be compared.
+func compareValues(v1 DBValue, v2 DBValue) (int, bool) {
+	if t1, t2 := valueType(v1), valueType(v2); t1 != t2 && isNumericType(t1) && isNumericType(t2) {
+		wider := t1
+		if numericRank[t2] > numericRank[t1] {
+			wider = t2
+		}
+		var err1, err2 error
+		v1, err1 = promoteNumeric(v1, wider)
+		v2, err2 = promoteNumeric(v2, wider)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+	}
+	switch x := v1.(type) {
+	case IntField:
+		y, ok := v2.(IntField)
+		return compareOrdered(x.Value, y.Value), ok
+	case StringField:
+		y, ok := v2.(StringField)
+		return strings.Compare(x.Value, y.Value), ok
+	case FloatField:
+		y, ok := v2.(FloatField)
+		return compareOrdered(x.Value, y.Value), ok
+	case BoolField:
+		y, ok := v2.(BoolField)
+		if !ok {
+			return 0, false
+		}
+		return compareOrdered(boolToInt(x.Value), boolToInt(y.Value)), true
+	case DateField:
+		y, ok := v2.(DateField)
+		return compareOrdered(x.Value, y.Value), ok
+	case TimestampField:
+		y, ok := v2.(TimestampField)
+		return compareOrdered(x.Value, y.Value), ok
+	case DecimalField:
+		y, ok := v2.(DecimalField)
+		if !ok {
+			return 0, false
+		}
+		if x.Scale == y.Scale {
+			return compareOrdered(x.Value, y.Value), true
+		}
+		return x.rat().Cmp(y.rat()), true
+	}
+	return 0, false
+}
+
+func compareOrdered[T int64 | float64](x1 T, x2 T) int {
+	if x1 < x2 {
+		return -1
+	} else if x1 > x2 {
+		return 1
+	}
+	return 0
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Evaluate op given the result of comparing two values.
+func evalComparison(cmp int, op BoolOp) bool {
+	switch op {
+	case OpEq:
+		return cmp == 0
+	case OpNeq:
+		return cmp != 0
+	case OpGt:
+		return cmp > 0
+	case OpGe:
+		return cmp >= 0
+	case OpLt:
+		return cmp < 0
+	case OpLe:
+		return cmp <= 0
+	default:
+		return false
+	}
+}
+
+func evalPredOnValues(v1 DBValue, v2 DBValue, op BoolOp) bool {
+	cmp, ok := compareValues(v1, v2)
+	return ok && evalComparison(cmp, op)
+}
+
+func (f1 FloatField) EvalPred(v2 DBValue, op BoolOp) bool {
+	return evalPredOnValues(f1, v2, op)
+}
+
+func (b1 BoolField) EvalPred(v2 DBValue, op BoolOp) bool {
+	return evalPredOnValues(b1, v2, op)
+}
+
+func (d1 DateField) EvalPred(v2 DBValue, op BoolOp) bool {
+	return evalPredOnValues(d1, v2, op)
+}
+
+func (t1 TimestampField) EvalPred(v2 DBValue, op BoolOp) bool {
+	return evalPredOnValues(t1, v2, op)
+}
+
+func (d1 DecimalField) EvalPred(v2 DBValue, op BoolOp) bool {
+	return evalPredOnValues(d1, v2, op)
+}
+
+// Parse the text form of a value of type t, as found in a CSV file.
+func parseValue(s string, t DBType) (DBValue, error) {
+	switch t {
+	case IntType:
+		if intVal, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+			return IntField{intVal}, nil
+		}
+		// integers may be written as floats, e.g., 1.0
+		floatVal, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("couldn't convert value %s to int", s)}
+		}
+		return IntField{int64(floatVal)}, nil
+	case StringType:
+		return StringField{s}, nil
+	case FloatType:
+		floatVal, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+		if err != nil {
+			return nil, GoDBError{TypeMismatchError, fmt.Sprintf("couldn't convert value %s to float", s)}
+		}
+		return FloatField{floatVal}, nil
+	case BoolType:
+		return parseBool(s)
+	case DateType:
+		return parseDate(s)
+	case TimestampType:
+		return parseTimestamp(s)
+	case DecimalType:
+		return parseDecimal(s)
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot parse values of type %s", t)}
+}
+
+// Convert v to a value of type t.  Numeric values convert to any numeric type
+// (floats and decimals only to int if they are whole numbers), and strings are
+// parsed as values of type t.
+func convertValue(v DBValue, t DBType) (DBValue, error) {
+	from := valueType(v)
+	if from == t {
+		return v, nil
+	}
+	if isNumericType(from) && isNumericType(t) {
+		if numericRank[from] < numericRank[t] {
+			return promoteNumeric(v, t)
+		}
+		switch x := v.(type) {
+		case FloatField:
+			if t == DecimalType {
+				r := new(big.Rat)
+				if r.SetFloat64(x.Value) == nil {
+					break
+				}
+				return decimalFromRat(r, decimalScaleOf(x.Value))
+			}
+			if x.Value == math.Trunc(x.Value) && math.Abs(x.Value) < math.MaxInt64 {
+				return IntField{int64(x.Value)}, nil
+			}
+		case DecimalField:
+			if r := x.rat(); r.IsInt() && r.Num().IsInt64() {
+				return IntField{r.Num().Int64()}, nil
+			}
+		}
+	} else if s, ok := v.(StringField); ok {
+		return parseValue(s.Value, t)
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot convert %s to %s", valueString(v), t)}
+}
+
+// The number of decimal places needed to print f exactly, up to
+// maxDecimalScale.
+func decimalScaleOf(f float64) int {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if dot := strings.Index(s, "."); dot != -1 {
+		return min(len(s)-dot-1, maxDecimalScale)
+	}
+	return 0
+}
+
+// Return the type of a value, or UnknownType if v is not a stored value.
+func valueType(v DBValue) DBType {
+	switch v.(type) {
+	case IntField:
+		return IntType
+	case StringField:
+		return StringType
+	case FloatField:
+		return FloatType
+	case BoolField:
+		return BoolType
+	case DateField:
+		return DateType
+	case TimestampField:
+		return TimestampType
+	case DecimalField:
+		return DecimalType
+	}
+	return UnknownType
+}
+
+// Return the text form of a value, as printed in query results.
+func valueString(v DBValue) string {
+	switch v := v.(type) {
+	case IntField:
+		return strconv.FormatInt(v.Value, 10)
+	case StringField:
+		return v.Value
+	case fmt.Stringer:
+		return v.String()
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// Return the zero value of type t, e.g., the result of an aggregate over no
+// values, as GoDB has no NULL.
+func zeroValue(t DBType) DBValue {
+	switch t {
+	case StringType:
+		return StringField{""}
+	case FloatType:
+		return FloatField{0}
+	case BoolType:
+		return BoolField{false}
+	case DateType:
+		return DateField{0}
+	case TimestampType:
+		return TimestampField{0}
+	case DecimalType:
+		return DecimalField{0, 0}
+	}
+	return IntField{0}
+}
+
+// ================== Arithmetic ======================
+
+// Numeric types ordered from narrowest to widest; arithmetic on values of
+// different numeric types is done in the wider type.
+var numericRank = map[DBType]int{IntType: 0, DecimalType: 1, FloatType: 2}
+
+func isNumericType(t DBType) bool {
+	_, ok := numericRank[t]
+	return ok
+}
+
+// Convert a numeric value to the (wider) numeric type t.
+func promoteNumeric(v DBValue, t DBType) (DBValue, error) {
+	switch t {
+	case FloatType:
+		switch v := v.(type) {
+		case IntField:
+			return FloatField{float64(v.Value)}, nil
+		case DecimalField:
+			return FloatField{v.float()}, nil
+		case FloatField:
+			return v, nil
+		}
+	case DecimalType:
+		switch v := v.(type) {
+		case IntField:
+			return DecimalField{v.Value, 0}, nil
+		case DecimalField:
+			return v, nil
+		}
+	case IntType:
+		if v, ok := v.(IntField); ok {
+			return v, nil
+		}
+	}
+	return nil, GoDBError{TypeMismatchError, fmt.Sprintf("cannot convert %v to %s", v, t)}
+}
+
+func floatArith(op string) func([]any) any {
+	return func(args []any) any {
+		x, y := args[0].(FloatField).Value, args[1].(FloatField).Value
+		switch op {
+		case "+":
+			return x + y
+		case "-":
+			return x - y
+		case "*":
+			return x * y
+		default:
+			if y == 0 {
+				return GoDBError{IllegalOperationError, "division by zero"}
+			}
+			return x / y
+		}
+	}
+}
+
+func decimalArith(op string) func([]any) any {
+	return func(args []any) any {
+		x, y := args[0].(DecimalField), args[1].(DecimalField)
+		r := new(big.Rat)
+		scale := max(x.Scale, y.Scale)
+		switch op {
+		case "+":
+			r.Add(x.rat(), y.rat())
+		case "-":
+			r.Sub(x.rat(), y.rat())
+		case "*":
+			r.Mul(x.rat(), y.rat())
+			scale = x.Scale + y.Scale
+		default:
+			if y.Value == 0 {
+				return GoDBError{IllegalOperationError, "division by zero"}
+			}
+			r.Quo(x.rat(), y.rat())
+			// keep a few more digits than the operands have
+			scale += 4
+		}
+		d, err := decimalFromRat(r, scale)
+		if err != nil {
+			return err
+		}
+		return d
+	}
+}
+
+func dateAddDays(args []any) any {
+	return DateField{args[0].(DateField).Value + args[1].(int64)}
+}
+
+func dateSubDays(args []any) any {
+	return DateField{args[0].(DateField).Value - args[1].(int64)}
+}
+
+func dateDiff(args []any) any {
+	return args[0].(DateField).Value - args[1].(DateField).Value
+}
+
+func timestampAddSeconds(args []any) any {
+	return TimestampField{args[0].(TimestampField).Value + args[1].(int64)*int64(time.Second/time.Microsecond)}
+}
+
+func timestampDiff(args []any) any {
+	return (args[0].(TimestampField).Value - args[1].(TimestampField).Value) / int64(time.Second/time.Microsecond)
+}
+
+// Return the time of a date or timestamp argument.
+func timeOf(v any) time.Time {
+	switch v := v.(type) {
+	case DateField:
+		return v.Time()
+	case TimestampField:
+		return v.Time()
+	}
+	return time.Time{}
+}
+
+func yearFunc(args []any) any {
+	return int64(timeOf(args[0]).Year())
+}
+
+func monthFunc(args []any) any {
+	return int64(timeOf(args[0]).Month())
+}
+
+func dayFunc(args []any) any {
+	return int64(timeOf(args[0]).Day())
+}
+
+func nowFunc(args []any) any {
+	return NewTimestampField(time.Now())
+}
+
+func currentDateFunc(args []any) any {
+	return NewDateField(time.Now())
+}
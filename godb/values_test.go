@@ -0,0 +1,182 @@
+package godb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestValuesSerialization(t *testing.T) {
+	td := TupleDesc{[]FieldType{
+		{"f", "", FloatType},
+		{"b", "", BoolType},
+		{"d", "", DateType},
+		{"ts", "", TimestampType},
+		{"dec", "", DecimalType},
+	}}
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 890000000, time.UTC)
+	tup := Tuple{td, []DBValue{
+		FloatField{-2.5},
+		BoolField{true},
+		NewDateField(ts),
+		NewTimestampField(ts),
+		DecimalField{-12345, 2},
+	}, nil}
+	buf := new(bytes.Buffer)
+	if err := tup.writeTo(buf); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if buf.Len() != tup.recordSize() {
+		t.Errorf("wrote %d bytes, expected %d", buf.Len(), tup.recordSize())
+	}
+	got, err := readTupleFrom(buf, &td)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if !got.equals(&tup) {
+		t.Errorf("expected %v, got %v", tup, got)
+	}
+	if s := got.Fields[2].(DateField).String(); s != "2021-03-04" {
+		t.Errorf("unexpected date %s", s)
+	}
+	if s := got.Fields[4].(DecimalField).String(); s != "-123.45" {
+		t.Errorf("unexpected decimal %s", s)
+	}
+}
+
+func TestValuesParseAndCompare(t *testing.T) {
+	cases := []struct {
+		s    string
+		t    DBType
+		want DBValue
+	}{
+		{"1.25", FloatType, FloatField{1.25}},
+		{"TRUE", BoolType, BoolField{true}},
+		{"0", BoolType, BoolField{false}},
+		{"1970-01-02", DateType, DateField{1}},
+		{"1970-01-01 00:00:01", TimestampType, TimestampField{1000000}},
+		{"-0.50", DecimalType, DecimalField{-50, 2}},
+		{"42", IntType, IntField{42}},
+	}
+	for _, c := range cases {
+		v, err := parseValue(c.s, c.t)
+		if err != nil {
+			t.Errorf("parsing %s as %s: %s", c.s, c.t, err.Error())
+			continue
+		}
+		if v != c.want {
+			t.Errorf("parsing %s as %s: expected %v, got %v", c.s, c.t, c.want, v)
+		}
+	}
+	if _, err := parseValue("2021-13-01", DateType); err == nil {
+		t.Errorf("expected error parsing invalid date")
+	}
+
+	if !(DecimalField{150, 2}).EvalPred(DecimalField{15, 1}, OpEq) {
+		t.Errorf("expected 1.50 = 1.5")
+	}
+	if !(IntField{2}).EvalPred(DecimalField{15, 1}, OpGt) {
+		t.Errorf("expected 2 > 1.5")
+	}
+	if !(FloatField{0.5}).EvalPred(IntField{1}, OpLt) {
+		t.Errorf("expected 0.5 < 1")
+	}
+	if (DateField{1}).EvalPred(IntField{1}, OpEq) {
+		t.Errorf("dates and ints should not compare equal")
+	}
+}
+
+func TestValuesArithmetic(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table m (id int, price decimal(8,2), weight double, ok boolean, day date, at timestamp)")
+	mustExecForTest(t, c, bp, "insert into m values (1, 10.005, 1.5, true, '2024-02-28', '2024-02-28 12:00:00'), (2, 2.5, 0.25, false, '2024-03-01', '2024-03-01 00:00:00')")
+
+	tups := mustExecForTest(t, c, bp, "select price from m where id = 1")
+	if d := tups[0].Fields[0].(DecimalField); d != (DecimalField{1001, 2}) {
+		t.Errorf("expected price rounded to 10.01, got %v", d)
+	}
+	tups = mustExecForTest(t, c, bp, "select price * 2, weight + 1, id + 0.5, day + 1, year(day) from m where id = 2")
+	want := []DBValue{DecimalField{500, 2}, FloatField{1.25}, DecimalField{25, 1}, NewDateField(time.Date(2024, 3, 2, 0, 0, 0, 0, time.UTC)), IntField{2024}}
+	for i, w := range want {
+		if tups[0].Fields[i] != w {
+			t.Errorf("expression %d: expected %v, got %v", i, w, tups[0].Fields[i])
+		}
+	}
+	mustExecForTest(t, c, bp, "create table due (id int, day date, at timestamp)")
+	mustExecForTest(t, c, bp, "insert into due values (1, '2024-03-01', '2024-02-29 00:00:00')")
+	tups = mustExecForTest(t, c, bp, "select due.day - m.day, due.at - m.at from m join due on m.id = due.id")
+	if tups[0].Fields[0] != (IntField{2}) || tups[0].Fields[1] != (IntField{12 * 3600}) {
+		t.Errorf("unexpected differences %v", tups[0].Fields)
+	}
+	tups = mustExecForTest(t, c, bp, "select sum(price), avg(weight) from m")
+	if tups[0].Fields[0] != (DecimalField{1251, 2}) || tups[0].Fields[1] != (FloatField{0.875}) {
+		t.Errorf("unexpected aggregates %v", tups[0].Fields)
+	}
+	if n := len(mustExecForTest(t, c, bp, "select id from m where ok = true")); n != 1 {
+		t.Errorf("expected 1 row with ok = true, got %d", n)
+	}
+	if n := len(mustExecForTest(t, c, bp, "select id from m where weight > 0.3")); n != 1 {
+		t.Errorf("expected 1 row with weight > 0.3, got %d", n)
+	}
+	if _, err := execForTest(t, c, bp, "select price / 0 from m"); err == nil {
+		t.Errorf("expected division by zero error")
+	}
+	if _, err := execForTest(t, c, bp, "insert into m values (3, 1234567.5, 0, true, '2024-01-01', '2024-01-01')"); err == nil {
+		t.Errorf("expected error inserting a value too large for decimal(8,2)")
+	}
+
+	// a bare decimal column keeps the scale of each value, but numerically
+	// equal values are the same for DISTINCT and GROUP BY
+	mustExecForTest(t, c, bp, "create table amounts (a decimal)")
+	mustExecForTest(t, c, bp, "insert into amounts values (1.5), (1.50), (2), (2.000)")
+	if n := len(mustExecForTest(t, c, bp, "select distinct a from amounts")); n != 2 {
+		t.Errorf("expected 2 distinct amounts, got %d", n)
+	}
+	if n := len(mustExecForTest(t, c, bp, "select a, count(*) from amounts group by a")); n != 2 {
+		t.Errorf("expected 2 groups of amounts, got %d", n)
+	}
+
+	// the column types survive a round trip through the catalog file
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2 := NewCatalog(c.filePath, bp, c.rootPath)
+	if err := c2.parseCatalogFile(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	info, err := c2.GetTableInfo("m")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if s := info.String(); s != "m(id int, price decimal(8,2), weight float, ok bool, day date, at timestamp)\n" {
+		t.Errorf("unexpected catalog entry %s", s)
+	}
+}
+
+func TestValuesLoadFromCSV(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table ev (name varchar, day date, score float, paid bool, amount decimal(6,2))")
+	csv := filepath.Join(t.TempDir(), "ev.csv")
+	data := "name,day,score,paid,amount\nann,2023-05-01,1.5,true,3.10\nbob,2023-05-02,2.5,false,4\n"
+	if err := os.WriteFile(csv, []byte(data), 0644); err != nil {
+		t.Fatalf(err.Error())
+	}
+	info, err := c.GetTableInfo("ev")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	f, err := os.Open(csv)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer f.Close()
+	if err := info.file.(*HeapFile).LoadFromCSV(f, true, ",", false); err != nil {
+		t.Fatalf(err.Error())
+	}
+	tups := mustExecForTest(t, c, bp, "select name, amount from ev where amount > 3.5")
+	if len(tups) != 1 || tups[0].Fields[0].(StringField).Value != "bob" || tups[0].Fields[1] != (DecimalField{400, 2}) {
+		t.Errorf("unexpected rows %v", tups)
+	}
+}
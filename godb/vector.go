@@ -0,0 +1,523 @@
+package godb
+
+// Vectorized execution.
+//
+// Batch operators exchange batches of up to [BatchSize] tuples, stored as a
+// vector of values for each column, rather than one tuple per call.  The
+// vectors of int and string columns hold plain int64s and strings.  The
+// expressions of a batch operator that are fields of its input are bound to
+// their column when it is opened, so that evaluating them over a batch
+// returns the column itself instead of looking each field up by name, as
+// [FieldExpr.EvalExpr] does for every tuple; other expressions are compiled
+// and evaluated row by row.
+//
+// Batch operators and tuple-at-a-time operators coexist in a plan: a
+// [Vectorized] operator returns the tuples of the batches of a batch plan,
+// and a [BatchScan] reads the tuples of any operator in batches.  When
+// [EnableVectorizedExecution] is set, the planner runs the filters,
+// projections without DISTINCT, single pass hash aggregates and hash joins of
+// SELECT statements as batch operators.
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// The maximum number of tuples in a batch.
+var BatchSize = 2048
+
+// Whether the planner runs queries with batch operators where it can.
+var EnableVectorizedExecution = false
+
+// A batch of tuples, stored as a vector of values for each column.  Batches
+// are not modified once they have been returned by an iterator, so operators
+// may share columns between their input and output batches.
+type Batch struct {
+	desc *TupleDesc
+	cols []vector
+	n    int
+}
+
+// Returns an empty batch with room for capacity tuples.
+func newBatch(desc *TupleDesc, capacity int) *Batch {
+	cols := make([]vector, len(desc.Fields))
+	for i, f := range desc.Fields {
+		cols[i] = newVector(f.Ftype, capacity)
+	}
+	return &Batch{desc, cols, 0}
+}
+
+// Returns the number of tuples in the batch.
+func (b *Batch) Len() int {
+	return b.n
+}
+
+// Returns the values of column i.
+func (b *Batch) Column(i int) []DBValue {
+	vals := make([]DBValue, b.n)
+	for j := range vals {
+		vals[j] = b.cols[i].get(j)
+	}
+	return vals
+}
+
+// Returns tuple i of the batch.
+func (b *Batch) Row(i int) *Tuple {
+	fields := make([]DBValue, len(b.cols))
+	for j, col := range b.cols {
+		fields[j] = col.get(i)
+	}
+	return &Tuple{*b.desc, fields, nil}
+}
+
+func (b *Batch) appendTuple(t *Tuple) {
+	for j, v := range t.Fields {
+		b.cols[j].append(v)
+	}
+	b.n++
+}
+
+// Returns a batch of the rows of b in sel.
+func (b *Batch) gather(sel []int) *Batch {
+	out := &Batch{b.desc, make([]vector, len(b.cols)), len(sel)}
+	for j, col := range b.cols {
+		out.cols[j] = col.gather(sel)
+	}
+	return out
+}
+
+// How the values of a vector are stored.
+type vectorKind int
+
+const (
+	intVector    vectorKind = iota // ints holds the values of IntFields
+	stringVector                   // strs holds the values of StringFields
+	valueVector                    // vals holds values of any type
+)
+
+// A column of a batch.  Int and string columns hold their values unboxed, so
+// that operators such as [BatchFilter] work on them without calling through
+// the DBValue interface for every value; columns of other types, and columns
+// that turn out to hold values of another type, hold DBValues.
+type vector struct {
+	kind vectorKind
+	ints []int64
+	strs []string
+	vals []DBValue
+}
+
+// Returns an empty vector for values of type t with room for capacity values.
+func newVector(t DBType, capacity int) vector {
+	switch t {
+	case IntType:
+		return vector{kind: intVector, ints: make([]int64, 0, capacity)}
+	case StringType:
+		return vector{kind: stringVector, strs: make([]string, 0, capacity)}
+	}
+	return vector{kind: valueVector, vals: make([]DBValue, 0, capacity)}
+}
+
+func (v *vector) len() int {
+	switch v.kind {
+	case intVector:
+		return len(v.ints)
+	case stringVector:
+		return len(v.strs)
+	}
+	return len(v.vals)
+}
+
+// Returns value i of the vector.
+func (v *vector) get(i int) DBValue {
+	switch v.kind {
+	case intVector:
+		return IntField{v.ints[i]}
+	case stringVector:
+		return StringField{v.strs[i]}
+	}
+	return v.vals[i]
+}
+
+func (v *vector) append(x DBValue) {
+	switch x := x.(type) {
+	case IntField:
+		if v.kind == intVector {
+			v.ints = append(v.ints, x.Value)
+			return
+		}
+	case StringField:
+		if v.kind == stringVector {
+			v.strs = append(v.strs, x.Value)
+			return
+		}
+	}
+	v.box()
+	v.vals = append(v.vals, x)
+}
+
+// Append value i of w.
+func (v *vector) appendFrom(w *vector, i int) {
+	switch {
+	case v.kind == intVector && w.kind == intVector:
+		v.ints = append(v.ints, w.ints[i])
+	case v.kind == stringVector && w.kind == stringVector:
+		v.strs = append(v.strs, w.strs[i])
+	default:
+		v.append(w.get(i))
+	}
+}
+
+// Store the values of the vector as DBValues.
+func (v *vector) box() {
+	if v.kind == valueVector {
+		return
+	}
+	n := v.len()
+	vals := make([]DBValue, n, max(n, cap(v.ints), cap(v.strs)))
+	for i := range vals {
+		vals[i] = v.get(i)
+	}
+	*v = vector{kind: valueVector, vals: vals}
+}
+
+// Returns a vector of the values of v in sel.
+func (v *vector) gather(sel []int) vector {
+	switch v.kind {
+	case intVector:
+		ints := make([]int64, len(sel))
+		for k, i := range sel {
+			ints[k] = v.ints[i]
+		}
+		return vector{kind: intVector, ints: ints}
+	case stringVector:
+		strs := make([]string, len(sel))
+		for k, i := range sel {
+			strs[k] = v.strs[i]
+		}
+		return vector{kind: stringVector, strs: strs}
+	}
+	vals := make([]DBValue, len(sel))
+	for k, i := range sel {
+		vals[k] = v.vals[i]
+	}
+	return vector{kind: valueVector, vals: vals}
+}
+
+// An open iterator over the batches of a batch operator, which, like an
+// [OpIterator], closes itself once it returns nil or an error.
+type BatchIterator struct {
+	next  func() (*Batch, error)
+	close func() error
+	done  bool
+}
+
+func newBatchIterator(next func() (*Batch, error), close func() error) *BatchIterator {
+	openIterators.Add(1)
+	return &BatchIterator{next: next, close: close}
+}
+
+// Returns the next batch, which is never empty, or nil once there are no
+// more.
+func (it *BatchIterator) Next() (*Batch, error) {
+	if it.done {
+		return nil, nil
+	}
+	b, err := it.next()
+	if b == nil || err != nil {
+		if cerr := it.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return b, err
+}
+
+// Release the resources of the iterator.  Closing a closed iterator has no
+// effect.
+func (it *BatchIterator) Close() error {
+	if it.done {
+		return nil
+	}
+	it.done = true
+	openIterators.Add(-1)
+	if it.close != nil {
+		return it.close()
+	}
+	return nil
+}
+
+// An operator that produces its tuples in batches.
+type BatchOperator interface {
+	Descriptor() *TupleDesc
+	OpenBatches(tid TransactionID) (*BatchIterator, error)
+}
+
+// An expression bound to the columns of the batches it is evaluated over.
+type vectorExpr struct {
+	expr     Expr
+	col      int      // the column of a field expression, or -1
+	compiled evalFunc // the compiled expression, for other expressions
+}
+
+// Bind e to the columns of batches of tuples described by desc.
+func bindVectorExpr(e Expr, desc *TupleDesc) vectorExpr {
+	if f, ok := e.(*FieldExpr); ok {
+		if i, err := findFieldInTd(f.selectField, desc); err == nil {
+			return vectorExpr{e, i, nil}
+		}
+	}
+	return vectorExpr{e, -1, compileExpr(e, desc)}
+}
+
+func bindVectorExprs(exprs []Expr, desc *TupleDesc) []vectorExpr {
+	bound := make([]vectorExpr, len(exprs))
+	for i, e := range exprs {
+		bound[i] = bindVectorExpr(e, desc)
+	}
+	return bound
+}
+
+// Returns the values of the expression for the tuples of b.  The values of a
+// field are the column of the batch, which must not be modified.
+func (e vectorExpr) eval(b *Batch) (vector, error) {
+	if e.col >= 0 {
+		return b.cols[e.col], nil
+	}
+	vals := newVector(e.expr.GetExprType().Ftype, b.n)
+	if c, ok := e.expr.(*ConstExpr); ok {
+		for i := 0; i < b.n; i++ {
+			vals.append(c.val)
+		}
+		return vals, nil
+	}
+	t := Tuple{*b.desc, make([]DBValue, len(b.cols)), nil}
+	for i := 0; i < b.n; i++ {
+		for j := range b.cols {
+			t.Fields[j] = b.cols[j].get(i)
+		}
+		v, err := e.compiled(&t)
+		if err != nil {
+			return vector{}, err
+		}
+		vals.append(v)
+	}
+	return vals, nil
+}
+
+// Reads the tuples of an operator in batches.
+type BatchScan struct {
+	child Operator
+}
+
+func NewBatchScan(child Operator) *BatchScan {
+	return &BatchScan{child}
+}
+
+func (s *BatchScan) Descriptor() *TupleDesc {
+	return s.child.Descriptor()
+}
+
+func (s *BatchScan) OpenBatches(tid TransactionID) (*BatchIterator, error) {
+	it, err := s.child.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	desc := s.child.Descriptor()
+	return newBatchIterator(func() (*Batch, error) {
+		if err := checkCanceled(tid); err != nil {
+			return nil, err
+		}
+		b := newBatch(desc, BatchSize)
+		for b.n < BatchSize {
+			t, err := it.Next()
+			if err != nil {
+				return nil, err
+			}
+			if t == nil {
+				break
+			}
+			b.appendTuple(t)
+		}
+		if b.n == 0 {
+			return nil, nil
+		}
+		return b, nil
+	}, it.Close), nil
+}
+
+// Vectorized is the [Operator] that returns the tuples of the batches of a
+// batch plan.
+type Vectorized struct {
+	plan BatchOperator
+}
+
+func NewVectorized(plan BatchOperator) *Vectorized {
+	return &Vectorized{plan}
+}
+
+func (v *Vectorized) Descriptor() *TupleDesc {
+	return v.plan.Descriptor()
+}
+
+func (v *Vectorized) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(v.Open(tid))
+}
+
+func (v *Vectorized) Open(tid TransactionID) (*OpIterator, error) {
+	it, err := v.plan.OpenBatches(tid)
+	if err != nil {
+		return nil, err
+	}
+	var cur *Batch
+	i := 0
+	return newOpIterator(func() (*Tuple, error) {
+		for cur == nil || i == cur.n {
+			if cur, err = it.Next(); cur == nil || err != nil {
+				return nil, err
+			}
+			i = 0
+		}
+		i++
+		return cur.Row(i - 1), nil
+	}, func() error {
+		cur = nil
+		return it.Close()
+	}), nil
+}
+
+// Returns the batch operators b reads from.
+func batchChildren(b BatchOperator) []BatchOperator {
+	switch b := b.(type) {
+	case *BatchFilter:
+		return []BatchOperator{b.child}
+	case *BatchProject:
+		return []BatchOperator{b.child}
+	case *BatchAggregator:
+		return []BatchOperator{b.child}
+	case *BatchHashJoin:
+		return []BatchOperator{b.left, b.right}
+	}
+	return nil
+}
+
+// Returns the tuple-at-a-time operators the batch plan rooted at b reads
+// from, as [planChildren] does.
+func batchLeaves(b BatchOperator) []*Operator {
+	if s, ok := b.(*BatchScan); ok {
+		return []*Operator{&s.child}
+	}
+	var leaves []*Operator
+	for _, c := range batchChildren(b) {
+		leaves = append(leaves, batchLeaves(c)...)
+	}
+	return leaves
+}
+
+// Returns a line describing the batch operator b, for EXPLAIN.
+func describeBatchOperator(b BatchOperator) string {
+	exprs := func(es []Expr) string {
+		var buf bytes.Buffer
+		for i, e := range es {
+			if i > 0 {
+				buf.WriteString(", ")
+			}
+			buf.WriteString(exprToStr(e))
+		}
+		return buf.String()
+	}
+	switch b := b.(type) {
+	case *BatchScan:
+		return "Batch Scan"
+	case *BatchFilter:
+		return fmt.Sprintf("Batch Filter %s %s %s", exprToStr(b.left), opToStr(b.op), exprToStr(b.right))
+	case *BatchProject:
+		return fmt.Sprintf("Batch Project %s -> %v", exprs(b.selectFields), b.outputNames)
+	case *BatchAggregator:
+		s := "Batch Aggregate"
+		for _, agg := range b.newAggState {
+			s += fmt.Sprintf(" %s(%s)", aggName(agg), agg.GetTupleDesc().HeaderString(false))
+		}
+		if len(b.groupByFields) > 0 {
+			s += ", Group By " + exprs(b.groupByFields)
+		}
+		return s
+	case *BatchHashJoin:
+		build := "right"
+		if b.buildLeft {
+			build = "left"
+		}
+		return fmt.Sprintf("Batch Hash Join, %s == %s, build %s", exprToStr(b.leftField), exprToStr(b.rightField), build)
+	}
+	return fmt.Sprintf("%T", b)
+}
+
+// Print the batch plan rooted at b, and the plans of the operators it reads
+// from, as [OutputPhysicalPlan] does.
+func outputBatchPlan(printf func(format string, a ...any), b BatchOperator, indent string) {
+	printf("%s%s\n", indent, describeBatchOperator(b))
+	if s, ok := b.(*BatchScan); ok {
+		OutputPhysicalPlan(printf, s.child, indent+"\t")
+		return
+	}
+	for _, c := range batchChildren(b) {
+		outputBatchPlan(printf, c, indent+"\t")
+	}
+}
+
+// Returns the lines describing the batch plan rooted at b, each indented
+// by indent and two more spaces per level.
+func batchPlanLines(b BatchOperator, indent string) []string {
+	lines := []string{indent + describeBatchOperator(b)}
+	for _, c := range batchChildren(b) {
+		lines = append(lines, batchPlanLines(c, indent+"  ")...)
+	}
+	return lines
+}
+
+// Replace the operators of the plan rooted at op that have batch
+// implementations with [Vectorized] batch plans.
+func vectorizePlan(op Operator) Operator {
+	if b, ok := toBatchOperator(op); ok {
+		if oc, ok := op.(*OperatorCard); ok {
+			vc := *oc
+			vc.Op = NewVectorized(b)
+			return &vc
+		}
+		return NewVectorized(b)
+	}
+	for _, child := range planChildren(op) {
+		*child = vectorizePlan(*child)
+	}
+	return op
+}
+
+// Returns the batch operator that computes the tuples of op, if op has a
+// batch implementation.
+func toBatchOperator(op Operator) (BatchOperator, bool) {
+	if oc, ok := op.(*OperatorCard); ok {
+		op = oc.Op
+	}
+	switch op := op.(type) {
+	case *Filter:
+		return &BatchFilter{op.left, op.op, op.right, batchInput(op.child)}, true
+	case *Project:
+		if !op.distinct {
+			return &BatchProject{op.selectFields, op.outputNames, batchInput(op.child)}, true
+		}
+	case *Aggregator:
+		if !op.sorted && op.passes <= 1 {
+			return &BatchAggregator{op.groupByFields, op.newAggState, batchInput(op.child)}, true
+		}
+	case *HashJoin:
+		return &BatchHashJoin{op.leftField, op.rightField, batchInput(*op.left), batchInput(*op.right), op.buildLeft, op.maxBufferSize}, true
+	}
+	return nil, false
+}
+
+// Returns a batch operator that computes the tuples of op, scanning them in
+// batches if op has no batch implementation.
+func batchInput(op Operator) BatchOperator {
+	if b, ok := toBatchOperator(op); ok {
+		return b
+	}
+	return NewBatchScan(vectorizePlan(op))
+}
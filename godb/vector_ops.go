@@ -0,0 +1,468 @@
+package godb
+
+// The vectorized operators, which compute the same tuples as [Filter],
+// [Project] without DISTINCT, [Aggregator] with a single hashed pass and
+// [HashJoin] over batches of their inputs.
+
+// BatchFilter returns the tuples of its child batches that satisfy a
+// predicate.
+type BatchFilter struct {
+	left  Expr
+	op    BoolOp
+	right Expr
+	child BatchOperator
+}
+
+func NewBatchFilter(constExpr Expr, op BoolOp, field Expr, child BatchOperator) *BatchFilter {
+	return &BatchFilter{field, op, constExpr, child}
+}
+
+func (f *BatchFilter) Descriptor() *TupleDesc {
+	return f.child.Descriptor()
+}
+
+func (f *BatchFilter) OpenBatches(tid TransactionID) (*BatchIterator, error) {
+	child, err := f.child.OpenBatches(tid)
+	if err != nil {
+		return nil, err
+	}
+	desc := f.child.Descriptor()
+	left, right := bindVectorExpr(f.left, desc), bindVectorExpr(f.right, desc)
+	sel := make([]int, 0, BatchSize)
+	return newBatchIterator(func() (*Batch, error) {
+		for {
+			b, err := child.Next()
+			if b == nil || err != nil {
+				return nil, err
+			}
+			lvals, err := left.eval(b)
+			if err != nil {
+				return nil, err
+			}
+			rvals, err := right.eval(b)
+			if err != nil {
+				return nil, err
+			}
+			sel = selectRows(&lvals, &rvals, f.op, b.n, sel[:0])
+			switch len(sel) {
+			case 0:
+				continue
+			case b.n:
+				return b, nil
+			}
+			return b.gather(sel), nil
+		}
+	}, child.Close), nil
+}
+
+// Append to sel the rows i < n for which l[i] op r[i] holds, and return it.
+// Int and string vectors are compared without boxing their values.
+func selectRows(l, r *vector, op BoolOp, n int, sel []int) []int {
+	switch {
+	case l.kind == intVector && r.kind == intVector && op != OpLike:
+		return selectOrdered(l.ints[:n], r.ints[:n], op, sel)
+	case l.kind == stringVector && r.kind == stringVector && op != OpLike:
+		return selectOrdered(l.strs[:n], r.strs[:n], op, sel)
+	}
+	for i := 0; i < n; i++ {
+		if l.get(i).EvalPred(r.get(i), op) {
+			sel = append(sel, i)
+		}
+	}
+	return sel
+}
+
+func selectOrdered[T int64 | string](l, r []T, op BoolOp, sel []int) []int {
+	switch op {
+	case OpEq:
+		for i, x := range l {
+			if x == r[i] {
+				sel = append(sel, i)
+			}
+		}
+	case OpNeq:
+		for i, x := range l {
+			if x != r[i] {
+				sel = append(sel, i)
+			}
+		}
+	case OpGt:
+		for i, x := range l {
+			if x > r[i] {
+				sel = append(sel, i)
+			}
+		}
+	case OpGe:
+		for i, x := range l {
+			if x >= r[i] {
+				sel = append(sel, i)
+			}
+		}
+	case OpLt:
+		for i, x := range l {
+			if x < r[i] {
+				sel = append(sel, i)
+			}
+		}
+	case OpLe:
+		for i, x := range l {
+			if x <= r[i] {
+				sel = append(sel, i)
+			}
+		}
+	}
+	return sel
+}
+
+// BatchProject evaluates a list of expressions over its child batches.
+type BatchProject struct {
+	selectFields []Expr
+	outputNames  []string
+	child        BatchOperator
+}
+
+func NewBatchProject(selectFields []Expr, outputNames []string, child BatchOperator) *BatchProject {
+	return &BatchProject{selectFields, outputNames, child}
+}
+
+func (p *BatchProject) Descriptor() *TupleDesc {
+	return (&Project{selectFields: p.selectFields, outputNames: p.outputNames}).Descriptor()
+}
+
+func (p *BatchProject) OpenBatches(tid TransactionID) (*BatchIterator, error) {
+	child, err := p.child.OpenBatches(tid)
+	if err != nil {
+		return nil, err
+	}
+	desc := p.Descriptor()
+	exprs := bindVectorExprs(p.selectFields, p.child.Descriptor())
+	return newBatchIterator(func() (*Batch, error) {
+		b, err := child.Next()
+		if b == nil || err != nil {
+			return nil, err
+		}
+		out := &Batch{desc, make([]vector, len(exprs)), b.n}
+		for i, e := range exprs {
+			if out.cols[i], err = e.eval(b); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	}, child.Close), nil
+}
+
+// BatchAggregator computes aggregates over the groups of its child batches,
+// which it finds by hashing their group by keys.
+type BatchAggregator struct {
+	groupByFields []Expr
+	newAggState   []AggState
+	child         BatchOperator
+}
+
+func NewBatchAggregator(emptyAggState []AggState, groupByFields []Expr, child BatchOperator) *BatchAggregator {
+	return &BatchAggregator{groupByFields, emptyAggState, child}
+}
+
+func (a *BatchAggregator) Descriptor() *TupleDesc {
+	return (&Aggregator{groupByFields: a.groupByFields, newAggState: a.newAggState}).Descriptor()
+}
+
+// Returns the key of the group of row i, given the values of the group by
+// expressions.
+func groupKey(gvals []vector, i int) any {
+	if len(gvals) == 1 {
+		if d, ok := gvals[0].get(i).(DecimalField); ok {
+			return d.normalize()
+		}
+		return gvals[0].get(i)
+	}
+	key := Tuple{Fields: make([]DBValue, len(gvals))}
+	for j := range gvals {
+		key.Fields[j] = gvals[j].get(i)
+	}
+	return key.tupleKey()
+}
+
+// Open an iterator over the results of the aggregate, which reads all of the
+// child batches before returning the first one.  The aggregates whose states
+// take the values of an expression evaluate it over each batch, and the
+// others add its tuples one at a time.
+func (a *BatchAggregator) OpenBatches(tid TransactionID) (*BatchIterator, error) {
+	child, err := a.child.OpenBatches(tid)
+	if err != nil {
+		return nil, err
+	}
+	childDesc := a.child.Descriptor()
+	gby := bindVectorExprs(a.groupByFields, childDesc)
+	inputs := make([]*vectorExpr, len(a.newAggState))
+	for j, as := range a.newAggState {
+		if vs, ok := as.(valueAggState); ok && vs.valueExpr() != nil {
+			e := bindVectorExpr(vs.valueExpr(), childDesc)
+			inputs[j] = &e
+		}
+	}
+
+	groups := make(map[any]int)
+	var keys [][]DBValue    // the group by values of each group
+	var states [][]AggState // the aggregation states of each group
+	newGroup := func(key []DBValue) int {
+		s := make([]AggState, len(a.newAggState))
+		for j, as := range a.newAggState {
+			s[j] = as.Copy()
+		}
+		keys = append(keys, key)
+		states = append(states, s)
+		return len(states) - 1
+	}
+	if len(gby) == 0 {
+		newGroup(nil)
+	}
+
+	// Add the tuples of b to the states of their groups.
+	add := func(b *Batch) error {
+		gvals := make([]vector, len(gby))
+		for j, e := range gby {
+			vals, err := e.eval(b)
+			if err != nil {
+				return err
+			}
+			gvals[j] = vals
+		}
+		rowGroup := make([]int, b.n)
+		if len(gby) > 0 {
+			for i := range rowGroup {
+				key := groupKey(gvals, i)
+				g, ok := groups[key]
+				if !ok {
+					gkey := make([]DBValue, len(gvals))
+					for j := range gvals {
+						gkey[j] = gvals[j].get(i)
+					}
+					g = newGroup(gkey)
+					groups[key] = g
+				}
+				rowGroup[i] = g
+			}
+		}
+		for j, as := range a.newAggState {
+			var vals vector
+			computed := false
+			if inputs[j] != nil {
+				// rows whose value cannot be computed are skipped by
+				// AddTuple, as for the Aggregator
+				var err error
+				vals, err = inputs[j].eval(b)
+				computed = err == nil
+			}
+			if _, ok := as.(valueAggState); ok && (computed || inputs[j] == nil) {
+				for i, g := range rowGroup {
+					var v DBValue
+					if computed {
+						v = vals.get(i)
+					}
+					states[g][j].(valueAggState).addValue(v)
+				}
+			} else {
+				for i, g := range rowGroup {
+					states[g][j].AddTuple(b.Row(i))
+				}
+			}
+		}
+		return nil
+	}
+
+	desc := a.Descriptor()
+	aggregated := false
+	next := 0 // the next group to return
+	return newBatchIterator(func() (*Batch, error) {
+		for !aggregated {
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			b, err := child.Next()
+			if err != nil {
+				return nil, err
+			}
+			if b == nil {
+				aggregated = true
+				break
+			}
+			if err := add(b); err != nil {
+				return nil, err
+			}
+		}
+		if next == len(states) {
+			return nil, nil
+		}
+		out := newBatch(desc, min(BatchSize, len(states)-next))
+		for ; next < len(states) && out.n < BatchSize; next++ {
+			fields := append([]DBValue(nil), keys[next]...)
+			for _, s := range states[next] {
+				fields = append(fields, s.Finalize().Fields...)
+			}
+			out.appendTuple(&Tuple{Fields: fields})
+		}
+		return out, nil
+	}, func() error {
+		groups, keys, states = nil, nil, nil
+		return child.Close()
+	}), nil
+}
+
+// BatchHashJoin is the vectorized [HashJoin]: it builds a hash table over the
+// rows of the batches of one of its inputs, in chunks of at most
+// maxBufferSize bytes, and probes it with the batches of the other one.
+type BatchHashJoin struct {
+	leftField, rightField Expr
+
+	left, right BatchOperator
+
+	buildLeft     bool
+	maxBufferSize int
+}
+
+func NewBatchHashJoin(left BatchOperator, leftField Expr, right BatchOperator, rightField Expr, buildLeft bool, maxBufferSize int) *BatchHashJoin {
+	return &BatchHashJoin{leftField, rightField, left, right, buildLeft, maxBufferSize}
+}
+
+func (hj *BatchHashJoin) Descriptor() *TupleDesc {
+	return hj.left.Descriptor().merge(hj.right.Descriptor())
+}
+
+// A row of a batch of the build side of a hash join, along with its join
+// value.
+type batchHashEntry struct {
+	val DBValue
+	b   *Batch
+	row int
+}
+
+// Append row i of b to the columns of out starting at column from.
+func appendRow(out *Batch, from int, b *Batch, i int) {
+	for j := range b.cols {
+		out.cols[from+j].appendFrom(&b.cols[j], i)
+	}
+}
+
+func (hj *BatchHashJoin) OpenBatches(tid TransactionID) (*BatchIterator, error) {
+	build, buildField, probe, probeField := hj.right, hj.rightField, hj.left, hj.leftField
+	if hj.buildLeft {
+		build, buildField, probe, probeField = hj.left, hj.leftField, hj.right, hj.rightField
+	}
+	buildIter, err := build.OpenBatches(tid)
+	if err != nil {
+		return nil, err
+	}
+	buildExpr := bindVectorExpr(buildField, build.Descriptor())
+	probeExpr := bindVectorExpr(probeField, probe.Descriptor())
+	rowSize := estimatedTupleMemory(build.Descriptor())
+	leftWidth := len(hj.left.Descriptor().Fields)
+	desc := hj.Descriptor()
+
+	var table map[any][]batchHashEntry
+	buildDone := false
+
+	// Fill the hash table with the next chunk of the build side, which holds
+	// at least one batch.  Returns false once the build side is exhausted.
+	nextChunk := func() (bool, error) {
+		table = make(map[any][]batchHashEntry)
+		size, n := 0, 0
+		for !buildDone && (n == 0 || size < hj.maxBufferSize) {
+			if err := checkCanceled(tid); err != nil {
+				return false, err
+			}
+			b, err := buildIter.Next()
+			if err != nil {
+				return false, err
+			}
+			if b == nil {
+				buildDone = true
+				break
+			}
+			vals, err := buildExpr.eval(b)
+			if err != nil {
+				return false, err
+			}
+			for i := 0; i < b.n; i++ {
+				v := vals.get(i)
+				key := hashKey(v)
+				table[key] = append(table[key], batchHashEntry{v, b, i})
+			}
+			size += b.n * rowSize
+			n += b.n
+		}
+		return n > 0, nil
+	}
+
+	var probeIter *BatchIterator
+	var probeBatch *Batch
+	var probeVals vector
+	row := 0 // the next row of probeBatch
+	var matches []batchHashEntry
+	return newBatchIterator(func() (*Batch, error) {
+		out := newBatch(desc, BatchSize)
+		for out.n < BatchSize {
+			if len(matches) > 0 {
+				m := matches[0]
+				matches = matches[1:]
+				if !probeVals.get(row-1).EvalPred(m.val, OpEq) {
+					continue
+				}
+				if hj.buildLeft {
+					appendRow(out, 0, m.b, m.row)
+					appendRow(out, leftWidth, probeBatch, row-1)
+				} else {
+					appendRow(out, 0, probeBatch, row-1)
+					appendRow(out, leftWidth, m.b, m.row)
+				}
+				out.n++
+				continue
+			}
+			if probeBatch != nil && row < probeBatch.n {
+				matches = table[hashKey(probeVals.get(row))]
+				row++
+				continue
+			}
+			if err := checkCanceled(tid); err != nil {
+				return nil, err
+			}
+			if probeIter == nil {
+				ok, err := nextChunk()
+				if err != nil {
+					return nil, err
+				}
+				if !ok {
+					break
+				}
+				if probeIter, err = probe.OpenBatches(tid); err != nil {
+					return nil, err
+				}
+			}
+			var err error
+			if probeBatch, err = probeIter.Next(); err != nil {
+				return nil, err
+			}
+			if probeBatch == nil {
+				probeIter = nil
+				continue
+			}
+			if probeVals, err = probeExpr.eval(probeBatch); err != nil {
+				return nil, err
+			}
+			row = 0
+		}
+		if out.n == 0 {
+			return nil, nil
+		}
+		return out, nil
+	}, func() error {
+		table, matches, probeBatch = nil, nil, nil
+		err := buildIter.Close()
+		if probeIter != nil {
+			if perr := probeIter.Close(); err == nil {
+				err = perr
+			}
+		}
+		return err
+	}), nil
+}
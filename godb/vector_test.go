@@ -0,0 +1,154 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestVectorizedQueries(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (id int, dept int, name varchar, salary float)")
+	mustExecForTest(t, c, bp, "create table dept (id int, title varchar)")
+	values := make([]string, 300)
+	for i := range values {
+		values[i] = fmt.Sprintf("(%d, %d, 'e%d', %d.5)", i, i%7, i%13, i*3)
+	}
+	mustExecForTest(t, c, bp, "insert into emp values "+strings.Join(values, ", "))
+	mustExecForTest(t, c, bp, "insert into dept values (0, 'a'), (1, 'b'), (2, 'c'), (3, 'd'), (5, 'f'), (5, 'g')")
+	if err := c.Analyze(""); err != nil {
+		t.Fatalf(err.Error())
+	}
+
+	// small batches and hash tables, so that batches and chunks split
+	defer func(size, budget int) { BatchSize, MemoryBudget = size, budget }(BatchSize, MemoryBudget)
+	BatchSize, MemoryBudget = 7, 2000
+
+	queries := []string{
+		"select id, name from emp where dept = 3",
+		"select id + 1, name from emp where salary > 100",
+		"select count(*), sum(salary), avg(id), min(name), max(id) from emp",
+		"select count(*) from emp where id < 0",
+		"select dept, count(*), sum(id), max(salary) from emp group by dept",
+		"select dept, name, count(*) from emp group by dept, name",
+		"select emp.id, dept.title from emp join dept on emp.dept = dept.id",
+		"select a.id, b.id from emp a join emp b on a.id = b.dept",
+		"select dept.title, count(*) from emp join dept on emp.dept = dept.id where emp.id > 100 group by dept.title",
+		"select distinct name from emp where dept = 1",
+		"select name, id from emp where dept = 2 order by id limit 5",
+	}
+	defer func(enabled bool) { EnableVectorizedExecution = enabled }(EnableVectorizedExecution)
+	for _, sql := range queries {
+		sorted := !strings.Contains(sql, "order by")
+		EnableVectorizedExecution = false
+		want := rowsForTest(t, c, bp, sql, sorted)
+		EnableVectorizedExecution = true
+		if got := rowsForTest(t, c, bp, sql, sorted); got != want {
+			t.Errorf("%s: vectorized execution returned\n%s\nexpected\n%s", sql, got, want)
+		}
+	}
+
+	lines := explainForTest(t, c, bp, "explain select dept.title, count(*) from emp join dept on emp.dept = dept.id group by dept.title")
+	plan := strings.Join(lines, "\n")
+	for _, op := range []string{"Vectorized", "Batch Aggregate", "Batch Hash Join", "Batch Scan"} {
+		if !strings.Contains(plan, op) {
+			t.Errorf("expected %s in the plan:\n%s", op, plan)
+		}
+	}
+}
+
+func TestBatchAdapters(t *testing.T) {
+	td := TupleDesc{Fields: []FieldType{{Fname: "x", Ftype: IntType}, {Fname: "s", Ftype: StringType}}}
+	mf := &MemFile{desc: &td}
+	for i := 0; i < 10; i++ {
+		mf.insertTuple(&Tuple{td, []DBValue{IntField{int64(i)}, StringField{fmt.Sprint(i % 3)}}, nil}, 0)
+	}
+	defer func(size int) { BatchSize = size }(BatchSize)
+	BatchSize = 4
+
+	filter := NewBatchFilter(&ConstExpr{StringField{"1"}, StringType}, OpEq, &FieldExpr{td.Fields[1]}, NewBatchScan(mf))
+	project := NewBatchProject([]Expr{&FieldExpr{td.Fields[0]}}, []string{"x"}, filter)
+
+	tid := NewTID()
+	open := openIterators.Load()
+	bit, err := project.OpenBatches(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var sizes []int
+	for {
+		b, err := bit.Next()
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		if b == nil {
+			break
+		}
+		sizes = append(sizes, b.Len())
+	}
+	if fmt.Sprint(sizes) != "[1 2]" {
+		t.Errorf("expected batches of [1 2] tuples, got %v", sizes)
+	}
+
+	it, err := NewVectorized(project).Open(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	var xs []int64
+	for tup, err := it.Next(); tup != nil || err != nil; tup, err = it.Next() {
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		xs = append(xs, tup.Fields[0].(IntField).Value)
+	}
+	if fmt.Sprint(xs) != "[1 4 7]" {
+		t.Errorf("expected [1 4 7], got %v", xs)
+	}
+
+	// an abandoned batch plan closes the iterators of its inputs
+	it, err = NewVectorized(project).Open(tid)
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if tup, err := it.Next(); tup == nil || err != nil {
+		t.Fatalf("expected a tuple, got %v (%v)", tup, err)
+	}
+	it.Close()
+	if n := openIterators.Load(); n != open {
+		t.Errorf("expected %d open iterators, got %d", open, n)
+	}
+}
+
+func TestBatchVectors(t *testing.T) {
+	desc := &TupleDesc{[]FieldType{{"a", "", IntType}, {"b", "", StringType}, {"c", "", FloatType}}}
+	b := newBatch(desc, 4)
+	for i := 0; i < 4; i++ {
+		b.appendTuple(&Tuple{Fields: []DBValue{IntField{int64(i)}, StringField{fmt.Sprint("s", i)}, FloatField{float64(i)}}})
+	}
+	// int and string columns hold their values unboxed
+	if b.cols[0].kind != intVector || b.cols[1].kind != stringVector || b.cols[2].kind != valueVector {
+		t.Fatalf("unexpected vector kinds %v %v %v", b.cols[0].kind, b.cols[1].kind, b.cols[2].kind)
+	}
+	if got := fmt.Sprint(b.Row(2).Fields); got != "[{2} {s2} 2]" {
+		t.Errorf("unexpected row %s", got)
+	}
+
+	ge := newVector(IntType, 4)
+	for i := 0; i < 4; i++ {
+		ge.append(IntField{2})
+	}
+	if sel := selectRows(&b.cols[0], &ge, OpGe, b.n, nil); fmt.Sprint(sel) != "[2 3]" {
+		t.Errorf("expected rows [2 3] with a >= 2, got %v", sel)
+	}
+	if g := b.gather([]int{3, 1}); fmt.Sprint(g.Column(1)) != "[{s3} {s1}]" || g.cols[1].kind != stringVector {
+		t.Errorf("unexpected gathered column %v", g.Column(1))
+	}
+
+	// a value of another type boxes the vector
+	v := newVector(IntType, 2)
+	v.append(IntField{1})
+	v.append(DecimalField{25, 1})
+	if v.kind != valueVector || v.len() != 2 || v.get(0) != (IntField{1}) || v.get(1) != (DecimalField{25, 1}) {
+		t.Errorf("unexpected boxed vector %v", v)
+	}
+}
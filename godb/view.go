@@ -0,0 +1,205 @@
+package godb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// A view is a named SELECT statement stored in the catalog.  Views are not
+// materialized; a reference to a view in a FROM clause is expanded by
+// parseFrom into a subquery, exactly as if the SELECT had been written inline.
+type View struct {
+	name string
+	sql  string // normalized text of the SELECT statement
+}
+
+func (v *View) String() string {
+	return fmt.Sprintf("view %s as %s\n", v.name, v.sql)
+}
+
+// Returns the parsed statement of the view.
+func (v *View) statement() (sqlparser.SelectStatement, error) {
+	stmt, _, err := parseSQL(v.sql)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(sqlparser.SelectStatement)
+	if !ok {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected a select statement, got '%s'", v.sql)}
+	}
+	return sel, nil
+}
+
+// Returns the tables and subqueries of a reference in a FROM clause to the
+// view, with the given alias, whose statement is resolved in c.  A view whose
+// statement is a set operation is computed into a table (see [subqueryTable]).
+func (v *View) reference(c *Catalog, alias string) ([]*LogicalTableNode, []*LogicalPlan, error) {
+	stmt, err := v.statement()
+	if err != nil {
+		return nil, nil, err
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		tables, err := subqueryTable(c, alias, stmt)
+		return tables, nil, err
+	}
+	plan, err := parseStatement(c, sel)
+	if err != nil {
+		return nil, nil, err
+	}
+	plan.alias = alias
+	return nil, []*LogicalPlan{plan}, nil
+}
+
+// Returns true if the view's statement reads from the named table or view.
+func (v *View) references(name string) bool {
+	stmt, err := v.statement()
+	if err != nil {
+		return false
+	}
+	found := false
+	sqlparser.Walk(func(node sqlparser.SQLNode) (bool, error) {
+		if tn, ok := node.(sqlparser.TableName); ok && strings.ToLower(tn.Name.CompliantName()) == name {
+			found = true
+		}
+		return !found, nil
+	}, stmt)
+	return found
+}
+
+var viewEntryRe = regexp.MustCompile(`(?is)^\s*view\s+(\S+)\s+as\s+(.*?)\s*$`)
+
+// Parse a "view <name> as <select>" line of the catalog file.
+func parseViewEntry(line string) (string, string, bool) {
+	m := viewEntryRe.FindStringSubmatch(line)
+	if m == nil {
+		return "", "", false
+	}
+	return strings.ToLower(m[1]), m[2], true
+}
+
+func parseSelectStatement(sql string) (*sqlparser.Select, error) {
+	stmt, err := sqlparser.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	sel, ok := stmt.(*sqlparser.Select)
+	if !ok {
+		return nil, GoDBError{ParseError, fmt.Sprintf("expected a select statement, got '%s'", sql)}
+	}
+	return sel, nil
+}
+
+// The parser does not understand CREATE TABLE ... AS SELECT or CREATE VIEW,
+// so both are recognized here before the statement reaches sqlparser.
+var createAsRe = regexp.MustCompile(`(?is)^\s*create\s+(table|view)\s+([a-z_][a-z0-9_]*(?:\.[a-z_][a-z0-9_]*)?)\s+as\s+(select\b.*?)\s*;?\s*$`)
+
+// If query is a CREATE TABLE/VIEW ... AS SELECT statement, returns the kind of
+// object ("table" or "view"), its name and the text of the SELECT.
+func splitCreateAs(query string) (string, string, string, bool) {
+	m := createAsRe.FindStringSubmatch(query)
+	if m == nil {
+		return "", "", "", false
+	}
+	return strings.ToLower(m[1]), strings.ToLower(m[2]), m[3], true
+}
+
+// Plan a CREATE TABLE ... AS SELECT or CREATE VIEW ... AS SELECT statement.
+//
+// For a view, the statement is checked by planning it and then recorded in
+// the catalog; no operator is returned.  For a table, the new table's schema
+// is taken from the Descriptor() of the SELECT's physical plan, the table is
+// added to the catalog, and an InsertOp that fills it is returned.
+//
+// The name may be qualified by a schema.  The SELECT of a view is resolved in
+// the view's schema, since that is where it is resolved when the view is used;
+// the SELECT of a table is resolved in the current schema.
+func parseCreateAs(c *Catalog, kind string, name string, query string) (QueryType, Operator, error) {
+	schema, name, err := c.resolveDottedName(name)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	if kind == "view" {
+		c = schema
+	}
+	if _, err := schema.GetTableInfo(name); err == nil {
+		return UnknownQueryType, nil, GoDBError{DuplicateTableError, fmt.Sprintf("table %s already exists", name)}
+	}
+	if schema.getView(name) != nil {
+		return UnknownQueryType, nil, GoDBError{DuplicateTableError, fmt.Sprintf("view %s already exists", name)}
+	}
+	parsed, _, err := parseSQL(query)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+	stmt, ok := parsed.(sqlparser.SelectStatement)
+	if !ok {
+		return UnknownQueryType, nil, GoDBError{ParseError, fmt.Sprintf("expected a select statement, got '%s'", query)}
+	}
+	op, err := planSelectStatement(c, stmt)
+	if err != nil {
+		return UnknownQueryType, nil, err
+	}
+
+	if kind == "view" {
+		schema.addView(name, sqlparser.String(stmt))
+		return CreateViewQueryType, nil, nil
+	}
+
+	desc := op.Descriptor().copy()
+	seen := make(map[string]bool)
+	for i := range desc.Fields {
+		fname := desc.Fields[i].Fname
+		if seen[fname] {
+			return UnknownQueryType, nil, GoDBError{AmbiguousNameError, fmt.Sprintf("column %s appears more than once in the select list of %s; use an alias", fname, name)}
+		}
+		seen[fname] = true
+		desc.Fields[i].TableQualifier = ""
+	}
+	// the table is only added to the catalog once the insert that fills it
+	// completes, so a failed statement (or an EXPLAIN) leaves no table behind
+	create := &pendingTable{schema, name, *desc}
+	insertOp := NewInsertOp(create.file(), op)
+	insertOp.catalog = schema
+	insertOp.create = create
+	return CreateTableAsQueryType, insertOp, nil
+}
+
+// The table created by a CREATE TABLE AS statement.
+type pendingTable struct {
+	schema *Catalog
+	name   string
+	desc   TupleDesc
+}
+
+// The heap file that will store the table.  The file is not created on disk
+// until the insert starts.
+func (p *pendingTable) file() *HeapFile {
+	return &HeapFile{td: &p.desc, numPages: 0, backingFile: p.schema.tableNameToFile(p.name), lastEmptyPage: -1, bufPool: p.schema.bufferPool}
+}
+
+// Create the (empty) file of the table, if no table with its name has been
+// created since the statement was planned.
+func (p *pendingTable) create(hf *HeapFile) error {
+	if _, err := p.schema.GetTableInfo(p.name); err == nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("table %s already exists", p.name)}
+	}
+	return hf.truncate()
+}
+
+// Throw away the tuples inserted into the table by a statement that failed.
+func (p *pendingTable) discard(hf *HeapFile) {
+	hf.truncate()
+}
+
+// Add the filled table to the catalog.
+func (p *pendingTable) register(hf *HeapFile) error {
+	if _, err := p.schema.GetTableInfo(p.name); err == nil {
+		return GoDBError{DuplicateTableError, fmt.Sprintf("table %s already exists", p.name)}
+	}
+	p.schema.registerTable(&Table{p.schema.schemas.nextTableId, p.name, p.desc, emptyTableStats(&p.desc), hf, nil})
+	return nil
+}
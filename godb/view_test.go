@@ -0,0 +1,126 @@
+package godb
+
+import (
+	"testing"
+)
+
+var viewTestTables = []string{
+	"create table emp (id int, name varchar, dept int, salary int)",
+	"create table dept (id int, dname varchar)",
+	"insert into emp values (1, 'ann', 1, 100), (2, 'bob', 1, 200), (3, 'cat', 2, 300), (4, 'dan', 2, 50)",
+	"insert into dept values (1, 'eng'), (2, 'ops')",
+}
+
+func TestSplitCreateAs(t *testing.T) {
+	kind, name, sel, ok := splitCreateAs("CREATE VIEW Rich AS select name from emp where salary > 100;")
+	if !ok || kind != "view" || name != "rich" || sel != "select name from emp where salary > 100" {
+		t.Errorf("unexpected split: %v %s %s '%s'", ok, kind, name, sel)
+	}
+	if _, _, _, ok := splitCreateAs("create table t (a int)"); ok {
+		t.Errorf("plain create table should not be treated as create as select")
+	}
+}
+
+func TestCreateTableAsSelect(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, viewTestTables...)
+	tups := mustExecForTest(t, c, bp, "create table rich as select emp.name, emp.salary as pay from emp where emp.salary >= 200")
+	if len(tups) != 1 || tups[0].Fields[0].(IntField).Value != 2 {
+		t.Errorf("expected insert of 2 rows, got %v", tups)
+	}
+
+	info, err := c.GetTableInfo("rich")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	expected := TupleDesc{[]FieldType{{"name", "", StringType}, {"pay", "", IntType}}}
+	if !info.desc.equals(&expected) {
+		t.Errorf("unexpected schema %v", info.desc)
+	}
+	if n := countRowsForTest(t, c, bp, "rich"); n != 2 {
+		t.Errorf("expected 2 rows in rich, got %d", n)
+	}
+
+	if _, _, err := Parse(c, "create table rich as select name from emp"); err == nil {
+		t.Errorf("expected error creating existing table")
+	}
+	if _, _, err := Parse(c, "create table twice as select emp.id, dept.id from emp, dept where emp.dept = dept.id"); err == nil {
+		t.Errorf("expected error for duplicate column names")
+	}
+
+	// the table is only added once the statement has run to completion
+	if _, _, err := Parse(c, "create table later as select name from emp"); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if _, err := c.GetTableInfo("later"); err == nil {
+		t.Errorf("expected planning to leave no table behind")
+	}
+	if _, err := execForTest(t, c, bp, "create table failed as select emp.salary / (emp.salary - 200) as q from emp"); err == nil {
+		t.Errorf("expected division by zero")
+	}
+	if _, err := c.GetTableInfo("failed"); err == nil {
+		t.Errorf("expected a failed fill to leave no table behind")
+	}
+	mustExecForTest(t, c, bp, "create table failed as select emp.name from emp")
+	if n := countRowsForTest(t, c, bp, "failed"); n != 4 {
+		t.Errorf("expected 4 rows in failed, got %d", n)
+	}
+}
+
+func TestCreateView(t *testing.T) {
+	bp, c := makeTestCatalogWith(t, viewTestTables...)
+	qType, op, err := Parse(c, "create view staff as select emp.name, dept.dname, emp.salary from emp join dept on emp.dept = dept.id")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	if qType != CreateViewQueryType || op != nil {
+		t.Fatalf("expected CreateViewQueryType with no operator")
+	}
+	if _, _, err := Parse(c, "create view staff as select name from emp"); err == nil {
+		t.Errorf("expected error creating existing view")
+	}
+	if _, _, err := Parse(c, "create view bad as select name from nosuch"); err == nil {
+		t.Errorf("expected error creating view over missing table")
+	}
+
+	tups := mustExecForTest(t, c, bp, "select name from staff where dname = 'ops' order by name asc")
+	if len(tups) != 2 || tups[0].Fields[0].(StringField).Value != "cat" || tups[1].Fields[0].(StringField).Value != "dan" {
+		t.Errorf("unexpected view result %v", tups)
+	}
+	tups = mustExecForTest(t, c, bp, "select s.name from staff s where s.salary > 100")
+	if len(tups) != 2 {
+		t.Errorf("expected 2 rows from aliased view, got %d", len(tups))
+	}
+
+	// views over views
+	mustExecForTest(t, c, bp, "create view eng as select name, salary from staff where dname = 'eng'")
+	if n := countRowsForTest(t, c, bp, "eng"); n != 2 {
+		t.Errorf("expected 2 rows in eng, got %d", n)
+	}
+	if _, err := execForTest(t, c, bp, "drop table emp"); err == nil {
+		t.Errorf("expected error dropping table used by a view")
+	}
+	if _, err := execForTest(t, c, bp, "drop view staff"); err == nil {
+		t.Errorf("expected error dropping view used by another view")
+	}
+
+	// views survive a round trip through the catalog file
+	if err := c.SaveToFile(c.filePath, c.rootPath); err != nil {
+		t.Fatalf(err.Error())
+	}
+	c2 := NewCatalog(c.filePath, bp, c.rootPath)
+	if err := c2.parseCatalogFile(); err != nil {
+		t.Fatalf(err.Error())
+	}
+	if v := c2.getView("staff"); v == nil || v.sql != c.getView("staff").sql {
+		t.Errorf("view not restored from catalog file")
+	}
+	if n := countRowsForTest(t, c2, bp, "eng"); n != 2 {
+		t.Errorf("expected 2 rows in eng after reload, got %d", n)
+	}
+
+	mustExecForTest(t, c, bp, "drop view eng")
+	mustExecForTest(t, c, bp, "drop view staff")
+	if c.getView("staff") != nil {
+		t.Errorf("view not dropped")
+	}
+}
@@ -0,0 +1,461 @@
+package godb
+
+// Parsing and planning of window functions, e.g.,
+//
+//	SELECT name, RANK() OVER (PARTITION BY dept ORDER BY salary DESC) FROM emp
+//	SELECT day, SUM(amount) OVER (ORDER BY day ROWS BETWEEN 6 PRECEDING AND CURRENT ROW) FROM sales
+//
+// sqlparser does not know OVER, so [rewriteWindows] turns each window
+// function call into a call of window_over, whose arguments are the function
+// call and the parts of the window, which [parseWindow] turns back into a
+// window function.  The window functions of a select list are computed after
+// grouping, by one [Window] operator for each distinct PARTITION BY and ORDER
+// BY, over its input sorted on them.
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/xwb1989/sqlparser"
+)
+
+// The functions that [rewriteWindows] turns the clauses of windows into.
+const (
+	windowFuncName      = "window_over"
+	windowPartitionFunc = "window_partition"
+	windowOrderFunc     = "window_order"
+	windowDescFunc      = "window_desc"
+	windowFrameFunc     = "window_frame"
+)
+
+// The functions that are only computed over windows.
+var windowFuncs = map[string]bool{
+	"row_number": true,
+	"rank":       true,
+	"dense_rank": true,
+	"lag":        true,
+	"lead":       true,
+}
+
+var overRe = regexp.MustCompile(`(?i)\)\s*over\s*\(`)
+
+// Rewrite each f(args) OVER (PARTITION BY p, ... ORDER BY o [DESC], ...
+// frame) in query to
+//
+//	window_over(f(args), window_partition(p, ...), window_order(window_desc(o), ...), window_frame('frame'))
+//
+// which sqlparser can parse.  Windows whose clauses cannot be recognized are
+// left alone, for sqlparser to report.
+func rewriteWindows(query string) string {
+	matches := overRe.FindAllStringIndex(query, -1)
+	// rewrite from the last window backwards, so that the positions of the
+	// others do not move
+	for m := len(matches) - 1; m >= 0; m-- {
+		closeCall, openSpec := matches[m][0], matches[m][1]-1
+		if inStringLiteral(query, closeCall) {
+			continue
+		}
+		closeSpec := matchingParen(query, openSpec)
+		openCall := openingParen(query, closeCall)
+		if closeSpec == -1 || openCall == -1 {
+			continue
+		}
+		start := openCall
+		for start > 0 && isIdentByte(query[start-1]) {
+			start--
+		}
+		if start == openCall {
+			continue
+		}
+		spec, ok := encodeWindowSpec(query[openSpec+1 : closeSpec])
+		if !ok {
+			continue
+		}
+		query = query[:start] + windowFuncName + "(" + query[start:closeCall+1] + spec + ")" + query[closeSpec+1:]
+	}
+	return query
+}
+
+// Returns the index of the parenthesis that the one at close closes, or -1.
+func openingParen(query string, close int) int {
+	for i := close - 1; i >= 0; i-- {
+		if query[i] == '(' && !inStringLiteral(query, i) && matchingParen(query, i) == close {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the clauses of a window specification as the arguments that follow
+// the function call in a call of window_over, each preceded by a comma.
+func encodeWindowSpec(spec string) (string, bool) {
+	// find the clauses, which start with keywords outside of parentheses
+	type clause struct {
+		keyword    string
+		at         int // the position of the keyword
+		start, end int // of the text after the keyword
+	}
+	var clauses []clause
+	lower := strings.ToLower(spec)
+	depth := 0
+	var quote byte
+	for i := 0; i < len(spec); i++ {
+		ch := lower[i]
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '(':
+			depth++
+		case ch == ')':
+			depth--
+		case depth == 0 && isIdentByte(ch) && (i == 0 || !isIdentByte(lower[i-1])):
+			j := i
+			for j < len(spec) && isIdentByte(lower[j]) {
+				j++
+			}
+			words := strings.Fields(lower[j:])
+			switch word := lower[i:j]; {
+			case (word == "partition" || word == "order") && len(words) > 0 && strings.HasPrefix(words[0], "by"):
+				by := strings.Index(lower[j:], "by") + j + len("by")
+				clauses = append(clauses, clause{word, i, by, len(spec)})
+				j = by
+			case word == "rows" || word == "range":
+				clauses = append(clauses, clause{word, i, i, len(spec)})
+				i = len(spec)
+				continue
+			}
+			i = j - 1
+		}
+	}
+	if len(clauses) == 0 {
+		return "", strings.TrimSpace(spec) == ""
+	}
+	if strings.TrimSpace(spec[:clauses[0].at]) != "" {
+		return "", false // e.g., the name of a window
+	}
+	var out strings.Builder
+	for k, cl := range clauses {
+		if k+1 < len(clauses) {
+			cl.end = clauses[k+1].at
+		}
+		text := strings.TrimSpace(spec[cl.start:cl.end])
+		switch cl.keyword {
+		case "partition":
+			out.WriteString(", " + windowPartitionFunc + "(" + text + ")")
+		case "order":
+			items := splitTopLevel(text, ',')
+			for i, item := range items {
+				item = strings.TrimSpace(item)
+				words := strings.Fields(strings.ToLower(item))
+				switch words[len(words)-1] {
+				case "desc":
+					item = windowDescFunc + "(" + strings.TrimSpace(item[:len(item)-len("desc")]) + ")"
+				case "asc":
+					item = strings.TrimSpace(item[:len(item)-len("asc")])
+				}
+				items[i] = item
+			}
+			out.WriteString(", " + windowOrderFunc + "(" + strings.Join(items, ", ") + ")")
+		default:
+			out.WriteString(", " + windowFrameFunc + "('" + strings.Join(strings.Fields(text), " ") + "')")
+		}
+	}
+	return out.String(), true
+}
+
+// The PARTITION BY and ORDER BY clauses and frame of a window, whose
+// expressions follow the arguments of the window function in the args of its
+// [LogicalSelectNode].
+type windowSpec struct {
+	nargs      int // the number of arguments of the function
+	npartition int // the number of PARTITION BY expressions
+	ascending  []bool
+	frame      windowFrame
+}
+
+// Returns the arguments of a window function, and the PARTITION BY and ORDER
+// BY expressions of its window.
+func (lsn *LogicalSelectNode) windowParts() ([]*LogicalSelectNode, []*LogicalSelectNode, []*LogicalSelectNode) {
+	w := lsn.window
+	return lsn.args[:w.nargs], lsn.args[w.nargs : w.nargs+w.npartition], lsn.args[w.nargs+w.npartition:]
+}
+
+// Parse a call of window_over produced by [rewriteWindows].
+func parseWindow(c *Catalog, expr *sqlparser.FuncExpr, alias string) (*LogicalSelectNode, error) {
+	var call *sqlparser.FuncExpr
+	if len(expr.Exprs) > 0 {
+		if ae, ok := expr.Exprs[0].(*sqlparser.AliasedExpr); ok {
+			call, _ = ae.Expr.(*sqlparser.FuncExpr)
+		}
+	}
+	if call == nil {
+		return nil, GoDBError{ParseError, "OVER must follow a function call"}
+	}
+	name := strings.ToLower(call.Name.String())
+	if !windowFuncs[name] && !isAgg(name) {
+		return nil, GoDBError{ParseError, fmt.Sprintf("%s is not a window function", name)}
+	}
+
+	var args []*LogicalSelectNode
+	for _, e := range call.Exprs {
+		if star, ok := e.(*sqlparser.StarExpr); ok && name == "count" {
+			field := NewFieldSelectNode(strings.ToLower(sqlparser.String(star.TableName)), "*", "")
+			args = append(args, &field)
+			continue
+		}
+		arg, err := parseSelect(c, e)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	nargs := map[string][2]int{"row_number": {0, 0}, "rank": {0, 0}, "dense_rank": {0, 0}, "lag": {1, 3}, "lead": {1, 3}}[name]
+	if isAgg(name) {
+		nargs = [2]int{1, 1}
+	}
+	if len(args) < nargs[0] || len(args) > nargs[1] {
+		return nil, GoDBError{ParseError, fmt.Sprintf("wrong number of arguments to window function %s", name)}
+	}
+
+	window := &windowSpec{nargs: len(args), frame: defaultWindowFrame}
+	var partition, order []*LogicalSelectNode
+	for _, e := range expr.Exprs[1:] {
+		ae, ok := e.(*sqlparser.AliasedExpr)
+		if !ok {
+			return nil, GoDBError{ParseError, "malformed window"}
+		}
+		clause, ok := ae.Expr.(*sqlparser.FuncExpr)
+		if !ok {
+			return nil, GoDBError{ParseError, "malformed window"}
+		}
+		switch strings.ToLower(clause.Name.String()) {
+		case windowPartitionFunc:
+			for _, pe := range clause.Exprs {
+				p, err := parseSelect(c, pe)
+				if err != nil {
+					return nil, err
+				}
+				partition = append(partition, p)
+			}
+		case windowOrderFunc:
+			for _, oe := range clause.Exprs {
+				ascending := true
+				if ae, ok := oe.(*sqlparser.AliasedExpr); ok {
+					if desc, ok := ae.Expr.(*sqlparser.FuncExpr); ok && strings.ToLower(desc.Name.String()) == windowDescFunc && len(desc.Exprs) == 1 {
+						oe, ascending = desc.Exprs[0], false
+					}
+				}
+				o, err := parseSelect(c, oe)
+				if err != nil {
+					return nil, err
+				}
+				order = append(order, o)
+				window.ascending = append(window.ascending, ascending)
+			}
+		case windowFrameFunc:
+			var text string
+			if len(clause.Exprs) == 1 {
+				if ae, ok := clause.Exprs[0].(*sqlparser.AliasedExpr); ok {
+					if v, ok := ae.Expr.(*sqlparser.SQLVal); ok {
+						text = string(v.Val)
+					}
+				}
+			}
+			frame, err := parseWindowFrame(text)
+			if err != nil {
+				return nil, err
+			}
+			window.frame = frame
+		default:
+			return nil, GoDBError{ParseError, "malformed window"}
+		}
+	}
+	window.npartition = len(partition)
+	args = append(append(args, partition...), order...)
+	node := NewWindowSelectNode(name, args, window, alias)
+	return &node, nil
+}
+
+// Parse the frame of a window, which is one of
+//
+//	ROWS|RANGE start
+//	ROWS|RANGE BETWEEN start AND end
+//
+// where start and end are UNBOUNDED PRECEDING, n PRECEDING, CURRENT ROW, n
+// FOLLOWING or UNBOUNDED FOLLOWING.  A frame with only a start ends at the
+// current row.  RANGE frames have no offsets.
+func parseWindowFrame(text string) (windowFrame, error) {
+	bad := GoDBError{ParseError, fmt.Sprintf("malformed window frame '%s'", text)}
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) < 2 || words[0] != "rows" && words[0] != "range" {
+		return windowFrame{}, bad
+	}
+	frame := windowFrame{rows: words[0] == "rows", end: frameBound{currentRow, 0}}
+	parseBound := func(words []string) (frameBound, bool) {
+		if len(words) != 2 {
+			return frameBound{}, false
+		}
+		switch {
+		case words[0] == "current" && words[1] == "row":
+			return frameBound{currentRow, 0}, true
+		case words[0] == "unbounded" && words[1] == "preceding":
+			return frameBound{unboundedPreceding, 0}, true
+		case words[0] == "unbounded" && words[1] == "following":
+			return frameBound{unboundedFollowing, 0}, true
+		}
+		n, err := strconv.Atoi(words[0])
+		if err != nil || n < 0 {
+			return frameBound{}, false
+		}
+		switch words[1] {
+		case "preceding":
+			return frameBound{offsetPreceding, n}, true
+		case "following":
+			return frameBound{offsetFollowing, n}, true
+		}
+		return frameBound{}, false
+	}
+	var ok bool
+	if words[1] == "between" {
+		and := -1
+		for i, w := range words {
+			if w == "and" {
+				and = i
+			}
+		}
+		if and == -1 {
+			return windowFrame{}, bad
+		}
+		if frame.start, ok = parseBound(words[2:and]); !ok {
+			return windowFrame{}, bad
+		}
+		if frame.end, ok = parseBound(words[and+1:]); !ok {
+			return windowFrame{}, bad
+		}
+	} else if frame.start, ok = parseBound(words[1:]); !ok {
+		return windowFrame{}, bad
+	}
+	if frame.start.kind == unboundedFollowing || frame.end.kind == unboundedPreceding || frame.start.kind > frame.end.kind {
+		return windowFrame{}, GoDBError{ParseError, fmt.Sprintf("window frame '%s' is empty", text)}
+	}
+	if !frame.rows && (frame.start.kind == offsetPreceding || frame.start.kind == offsetFollowing ||
+		frame.end.kind == offsetPreceding || frame.end.kind == offsetFollowing) {
+		return windowFrame{}, GoDBError{ParseError, "RANGE frames with an offset are not supported, use ROWS"}
+	}
+	return frame, nil
+}
+
+// Returns the window functions of a select list.
+func extractWindows(selects []*LogicalSelectNode) []*LogicalSelectNode {
+	var windows []*LogicalSelectNode
+	var extract func(s *LogicalSelectNode)
+	extract = func(s *LogicalSelectNode) {
+		switch s.exprType {
+		case ExprWindow:
+			windows = append(windows, s)
+		case ExprFunc:
+			for _, arg := range s.args {
+				extract(arg)
+			}
+		}
+	}
+	for _, s := range selects {
+		extract(s)
+	}
+	return windows
+}
+
+// Plan the window functions of a select list over the tuples of input, with a
+// [Window] for each distinct window, over its input sorted on its PARTITION
+// BY and ORDER BY expressions.  Sets the cachedField of each function to the
+// column of its results.
+func planWindows(c *Catalog, windows []*LogicalSelectNode, tableMap map[string]*PlanNode, input *OperatorCard) (*OperatorCard, error) {
+	// the functions of each window, in the order of their first use
+	var keys []string
+	groups := make(map[string][]*LogicalSelectNode)
+	for _, w := range windows {
+		_, partition, order := w.windowParts()
+		key := fmt.Sprint(w.window.ascending)
+		for _, e := range append(partition, order...) {
+			key += " " + exprKey(e)
+		}
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], w)
+	}
+
+	n := 0 // the number of functions planned, which names their results
+	for _, key := range keys {
+		desc := input.Descriptor()
+		_, partition, order := groups[key][0].windowParts()
+		exprs := func(nodes []*LogicalSelectNode) ([]Expr, error) {
+			es := make([]Expr, len(nodes))
+			for i, node := range nodes {
+				e, _, err := node.generateExpr(c, desc, tableMap)
+				if err != nil {
+					return nil, err
+				}
+				es[i] = e
+			}
+			return es, nil
+		}
+		partitionBy, err := exprs(partition)
+		if err != nil {
+			return nil, err
+		}
+		orderBy, err := exprs(order)
+		if err != nil {
+			return nil, err
+		}
+
+		var funcs []*WindowFunc
+		for _, w := range groups[key] {
+			fargs, _, _ := w.windowParts()
+			var args []Expr
+			if len(fargs) == 1 && fargs[0].exprType == ExprField && fargs[0].field == "*" {
+				args = []Expr{&ConstExpr{IntField{1}, IntType}} // count(*)
+			} else if args, err = exprs(fargs); err != nil {
+				return nil, err
+			}
+			name := w.alias
+			for _, f := range desc.Fields {
+				if f.Fname == name {
+					name = "" // the alias would make the name ambiguous
+				}
+			}
+			if name == "" {
+				name = fmt.Sprintf("%s()%d", *w.funcOp, n)
+			}
+			n++
+			f, err := newWindowFunc(*w.funcOp, args, w.window.frame, name)
+			if err != nil {
+				return nil, err
+			}
+			funcs = append(funcs, f)
+			field := f.field
+			w.cachedField = &field
+		}
+
+		sorted := input
+		if len(partitionBy)+len(orderBy) > 0 {
+			ascending := make([]bool, len(partitionBy), len(partitionBy)+len(orderBy))
+			for i := range ascending {
+				ascending[i] = true
+			}
+			ascending = append(ascending, groups[key][0].window.ascending...)
+			if sorted, err = planOrderBy(append(append([]Expr{}, partitionBy...), orderBy...), ascending, input); err != nil {
+				return nil, err
+			}
+		}
+		win := NewWindow(partitionBy, orderBy, groups[key][0].window.ascending, funcs, sorted)
+		input = costedCard(win, input.Cardinality, estimatedCost(sorted)+estimatedRows(sorted)*costPerTuple, nil)
+	}
+	return input, nil
+}
@@ -0,0 +1,335 @@
+package godb
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The kinds of bounds of a window frame, in the order of the rows they
+// bound.
+type frameBoundKind int
+
+const (
+	unboundedPreceding frameBoundKind = iota
+	offsetPreceding
+	currentRow
+	offsetFollowing
+	unboundedFollowing
+)
+
+// A bound of a window frame.
+type frameBound struct {
+	kind   frameBoundKind
+	offset int // the number of rows, for offsetPreceding and offsetFollowing
+}
+
+func (b frameBound) String() string {
+	switch b.kind {
+	case unboundedPreceding:
+		return "UNBOUNDED PRECEDING"
+	case offsetPreceding:
+		return fmt.Sprintf("%d PRECEDING", b.offset)
+	case offsetFollowing:
+		return fmt.Sprintf("%d FOLLOWING", b.offset)
+	case unboundedFollowing:
+		return "UNBOUNDED FOLLOWING"
+	}
+	return "CURRENT ROW"
+}
+
+// The rows of a partition that an aggregate over a window is computed over,
+// for each row: in a ROWS frame its bounds count rows, in a RANGE frame the
+// current row stands for all of its peers, the rows with the same ORDER BY
+// values.
+type windowFrame struct {
+	rows       bool
+	start, end frameBound
+}
+
+// The frame of windows without one: the rows up to the last peer of the
+// current row, which is the whole partition if the window is not ordered.
+var defaultWindowFrame = windowFrame{false, frameBound{unboundedPreceding, 0}, frameBound{currentRow, 0}}
+
+func (f windowFrame) String() string {
+	unit := "RANGE"
+	if f.rows {
+		unit = "ROWS"
+	}
+	return fmt.Sprintf("%s BETWEEN %s AND %s", unit, f.start, f.end)
+}
+
+// A window function, computed by a [Window] for each row of its input.
+type WindowFunc struct {
+	name  string // row_number, rank, dense_rank, lag, lead or an aggregate
+	args  []Expr
+	agg   AggState // the empty state of an aggregate, which is copied for each frame
+	frame windowFrame
+	field FieldType // the column of its results
+}
+
+// Returns the window function name over args, whose results are the column
+// fname.  For aggregates, frame is the rows they are computed over.
+func newWindowFunc(name string, args []Expr, frame windowFrame, fname string) (*WindowFunc, error) {
+	f := &WindowFunc{name: name, args: args, frame: frame, field: FieldType{fname, "", IntType}}
+	switch name {
+	case "row_number", "rank", "dense_rank":
+	case "lag", "lead":
+		t := args[0].GetExprType().Ftype
+		f.field.Ftype = t
+		if len(args) > 1 {
+			if c, ok := args[1].(*ConstExpr); !ok || c.constType != IntType || c.val.(IntField).Value < 0 {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("the offset of %s must be a non-negative integer constant", name)}
+			}
+		}
+		if len(args) > 2 {
+			if dt := args[2].GetExprType().Ftype; dt != t && !implicitlyCoercible(dt, t) {
+				return nil, GoDBError{TypeMismatchError, fmt.Sprintf("the default of %s must be a %s value, not %s", name, t, dt)}
+			}
+		}
+	default:
+		agg, err := newAggState(name, fname, args[0])
+		if err != nil {
+			return nil, err
+		}
+		f.agg = agg
+		f.field.Ftype = agg.GetTupleDesc().Fields[0].Ftype
+	}
+	return f, nil
+}
+
+func (f *WindowFunc) String() string {
+	args := make([]string, len(f.args))
+	for i, a := range f.args {
+		args[i] = exprToStr(a)
+	}
+	s := fmt.Sprintf("%s(%s)", f.name, strings.Join(args, ", "))
+	if f.agg != nil {
+		s += " " + f.frame.String()
+	}
+	return s
+}
+
+// Computes window functions over the tuples of its child, which is sorted on
+// the PARTITION BY expressions followed by the ORDER BY expressions of the
+// window.  Each output tuple is an input tuple followed by the values of the
+// functions for it.  The tuples of a partition are held in memory while they
+// are computed.
+type Window struct {
+	partitionBy []Expr
+	orderBy     []Expr
+	ascending   []bool // for each ORDER BY expression
+	funcs       []*WindowFunc
+	child       Operator
+}
+
+func NewWindow(partitionBy []Expr, orderBy []Expr, ascending []bool, funcs []*WindowFunc, child Operator) *Window {
+	return &Window{partitionBy, orderBy, ascending, funcs, child}
+}
+
+// The fields of the child followed by those of the functions.
+func (w *Window) Descriptor() *TupleDesc {
+	desc := w.child.Descriptor().copy()
+	for _, f := range w.funcs {
+		desc.Fields = append(desc.Fields, f.field)
+	}
+	return desc
+}
+
+func (w *Window) Iterator(tid TransactionID) (func() (*Tuple, error), error) {
+	return iteratorOf(w.Open(tid))
+}
+
+// Returns a function that reports whether two tuples have the same values of
+// exprs, which holds for all tuples if there are none.
+func sameValues(exprs []Expr) func(p, q *Tuple) bool {
+	if len(exprs) == 0 {
+		return func(p, q *Tuple) bool { return true }
+	}
+	ascending := make([]bool, len(exprs))
+	for i := range ascending {
+		ascending[i] = true
+	}
+	ms := OrderedBy(exprs, ascending)
+	return func(p, q *Tuple) bool { return !ms.less(p, q) && !ms.less(q, p) }
+}
+
+// Open an iterator that reads the tuples of the child a partition at a time,
+// and returns them with the values of the functions.  Closing it closes the
+// iterator of the child.
+func (w *Window) Open(tid TransactionID) (*OpIterator, error) {
+	desc := w.Descriptor()
+	samePartition, peers := sameValues(w.partitionBy), sameValues(w.orderBy)
+	it, err := w.child.Open(tid)
+	if err != nil {
+		return nil, err
+	}
+	var next *Tuple // the first tuple of the next partition
+	var out []*Tuple
+	return newOpIterator(func() (*Tuple, error) {
+		for len(out) == 0 {
+			var partition []*Tuple
+			if next != nil {
+				partition = append(partition, next)
+				next = nil
+			}
+			for {
+				if err := checkCanceled(tid); err != nil {
+					return nil, err
+				}
+				t, err := it.Next()
+				if err != nil {
+					return nil, err
+				}
+				if t == nil {
+					break
+				}
+				if len(partition) > 0 && !samePartition(partition[0], t) {
+					next = t
+					break
+				}
+				partition = append(partition, t)
+			}
+			if len(partition) == 0 {
+				return nil, nil
+			}
+			if out, err = w.computePartition(partition, peers, desc); err != nil {
+				return nil, err
+			}
+		}
+		t := out[0]
+		out = out[1:]
+		return t, nil
+	}, it.Close), nil
+}
+
+// Returns the tuples of a partition with the values of the functions.
+func (w *Window) computePartition(rows []*Tuple, peers func(p, q *Tuple) bool, desc *TupleDesc) ([]*Tuple, error) {
+	n := len(rows)
+	// the first row of the peers of each row, the row after the last, and the
+	// number of groups of peers before it
+	peerStart, peerEnd, group := make([]int, n), make([]int, n), make([]int, n)
+	for i := 1; i < n; i++ {
+		if peers(rows[i-1], rows[i]) {
+			peerStart[i], group[i] = peerStart[i-1], group[i-1]
+		} else {
+			peerStart[i], group[i] = i, group[i-1]+1
+		}
+	}
+	for i := n - 1; i >= 0; i-- {
+		if i+1 < n && peerStart[i+1] == peerStart[i] {
+			peerEnd[i] = peerEnd[i+1]
+		} else {
+			peerEnd[i] = i + 1
+		}
+	}
+
+	out := make([]*Tuple, n)
+	for i, t := range rows {
+		fields := make([]DBValue, len(t.Fields), len(desc.Fields))
+		copy(fields, t.Fields)
+		out[i] = &Tuple{*desc, fields, nil}
+	}
+	for _, f := range w.funcs {
+		if f.agg != nil {
+			for i, v := range f.aggregate(rows, peerStart, peerEnd) {
+				out[i].Fields = append(out[i].Fields, v)
+			}
+			continue
+		}
+		for i := range rows {
+			var v DBValue
+			var err error
+			switch f.name {
+			case "row_number":
+				v = IntField{int64(i + 1)}
+			case "rank":
+				v = IntField{int64(peerStart[i] + 1)}
+			case "dense_rank":
+				v = IntField{int64(group[i] + 1)}
+			default:
+				v, err = f.offsetValue(rows, i)
+			}
+			if err != nil {
+				return nil, err
+			}
+			out[i].Fields = append(out[i].Fields, v)
+		}
+	}
+	return out, nil
+}
+
+// Returns the value of lag or lead for row i of a partition.
+func (f *WindowFunc) offsetValue(rows []*Tuple, i int) (DBValue, error) {
+	offset := 1
+	if len(f.args) > 1 {
+		offset = int(f.args[1].(*ConstExpr).val.(IntField).Value)
+	}
+	if f.name == "lag" {
+		offset = -offset
+	}
+	if j := i + offset; j >= 0 && j < len(rows) {
+		return f.args[0].EvalExpr(rows[j])
+	}
+	if len(f.args) < 3 {
+		return zeroValue(f.field.Ftype), nil
+	}
+	v, err := f.args[2].EvalExpr(rows[i])
+	if err != nil {
+		return nil, err
+	}
+	return castValue(v, f.field.Ftype, -1)
+}
+
+// Returns the values of an aggregate over the frame of each row of a
+// partition.  Frames that start at the first row are aggregated
+// incrementally, as their ends never move back; others are aggregated anew
+// for each row.
+func (f *WindowFunc) aggregate(rows []*Tuple, peerStart, peerEnd []int) []DBValue {
+	n := len(rows)
+	bound := func(b frameBound, i int, end bool) int {
+		switch b.kind {
+		case unboundedPreceding:
+			return 0
+		case offsetPreceding:
+			return i - b.offset
+		case offsetFollowing:
+			return i + b.offset
+		case unboundedFollowing:
+			return n - 1
+		}
+		switch {
+		case f.frame.rows:
+			return i
+		case end:
+			return peerEnd[i] - 1
+		}
+		return peerStart[i]
+	}
+	result := func(state AggState, empty bool) DBValue {
+		if empty && f.name == "count" {
+			return IntField{0}
+		} else if empty {
+			return zeroValue(f.field.Ftype)
+		}
+		return state.Finalize().Fields[0]
+	}
+
+	values := make([]DBValue, n)
+	state, added := f.agg.Copy(), 0
+	for i := range rows {
+		lo, hi := max(bound(f.frame.start, i, false), 0), min(bound(f.frame.end, i, true), n-1)
+		if f.frame.start.kind == unboundedPreceding {
+			for ; added <= hi; added++ {
+				state.AddTuple(rows[added])
+			}
+			values[i] = result(state, hi < 0)
+			continue
+		}
+		frame := f.agg.Copy()
+		for j := lo; j <= hi; j++ {
+			frame.AddTuple(rows[j])
+		}
+		values[i] = result(frame, lo > hi)
+	}
+	return values
+}
@@ -0,0 +1,72 @@
+package godb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWindowRewrite(t *testing.T) {
+	for in, want := range map[string]string{
+		"select rank() over (order by x desc) from t":                                                         "select window_over(rank(), window_order(window_desc(x))) from t",
+		"select sum(x) OVER (partition by g, h order by x rows between 1 preceding and 1 following) r from t": "select window_over(sum(x), window_partition(g, h), window_order(x), window_frame('rows between 1 preceding and 1 following')) r from t",
+		"select count(*) over () from t":                                                                      "select window_over(count(*)) from t",
+		"select lag(x, 1) over (order by (x + 1) asc) from t":                                                 "select window_over(lag(x, 1), window_order((x + 1))) from t",
+		// OVER in strings is left alone
+		"select s from t where s = 'f() over (x)'": "select s from t where s = 'f() over (x)'",
+	} {
+		if got := rewriteWindows(in); got != want {
+			t.Errorf("rewriteWindows(%q) = %q, expected %q", in, got, want)
+		}
+	}
+}
+
+func TestWindowFunctions(t *testing.T) {
+	bp, c := makeEmptyTestCatalog(t)
+	mustExecForTest(t, c, bp, "create table emp (name varchar, dept varchar, salary int)")
+	mustExecForTest(t, c, bp, `insert into emp values ('a', 'eng', 100), ('b', 'eng', 200), ('c', 'eng', 200), ('d', 'eng', 300),
+		('e', 'ops', 50), ('f', 'ops', 70), ('g', 'ops', 70)`)
+
+	for sql, want := range map[string]string{
+		"select name, row_number() over (order by salary desc, name) from emp order by name":       "a,4 b,2 c,3 d,1 e,7 f,5 g,6",
+		"select name, rank() over (order by salary) from emp order by name":                        "a,4 b,5 c,5 d,7 e,1 f,2 g,2",
+		"select name, dense_rank() over (order by salary) r from emp order by r, name":             "e,1 f,2 g,2 a,3 b,4 c,4 d,5",
+		"select name, rank() over (partition by dept order by salary desc) from emp order by name": "a,4 b,2 c,2 d,1 e,3 f,1 g,1",
+		// the default frame ends at the last peer of the row
+		"select name, sum(salary) over (partition by dept order by salary) from emp order by name":                              "a,100 b,500 c,500 d,800 e,50 f,190 g,190",
+		"select name, count(*) over (partition by dept) from emp order by name":                                                 "a,4 b,4 c,4 d,4 e,3 f,3 g,3",
+		"select name, max(salary) over (partition by dept) from emp order by name":                                              "a,300 b,300 c,300 d,300 e,70 f,70 g,70",
+		"select name, sum(salary) over (order by name rows between 1 preceding and 1 following) from emp order by name":         "a,300 b,500 c,700 d,550 e,420 f,190 g,140",
+		"select name, avg(salary) over (partition by dept order by name rows 1 preceding) from emp order by name":               "a,100 b,150 c,200 d,250 e,50 f,60 g,70",
+		"select name, min(salary) over (order by name rows between 2 following and unbounded following) from emp order by name": "a,50 b,50 c,50 d,70 e,70 f,0 g,0",
+		"select name, count(name) over (order by name rows between unbounded preceding and 1 preceding) from emp order by name": "a,0 b,1 c,2 d,3 e,4 f,5 g,6",
+		"select name, lag(name) over (order by name), lead(salary, 2, -1) over (order by name) from emp order by name":          "a,,200 b,a,300 c,b,50 d,c,70 e,d,70 f,e,-1 g,f,-1",
+		"select name, lag(salary, 1, 0) over (partition by dept order by name) from emp order by name":                          "a,0 b,100 c,200 d,200 e,0 f,50 g,70",
+		// windows over the groups of a grouped query, ordered by an aggregate
+		"select dept, sum(salary), rank() over (order by sum(salary) desc) from emp group by dept order by dept": "eng,800,1 ops,190,2",
+		// functions of windows
+		"select name, row_number() over (order by name) * 10 from emp where dept = 'ops' order by name": "e,10 f,20 g,30",
+	} {
+		if got := rowsForTest(t, c, bp, sql, false); got != want {
+			t.Errorf("%s: got %q, expected %q", sql, got, want)
+		}
+	}
+
+	plan := strings.Join(explainForTest(t, c, bp, "explain select name, rank() over (partition by dept order by salary), sum(salary) over (partition by dept order by salary) from emp"), "\n")
+	if strings.Count(plan, "Window partition by") != 1 {
+		t.Errorf("expected one Window computing both functions:\n%s", plan)
+	}
+
+	for _, sql := range []string{
+		"select upper(name) over (order by name) from emp",
+		"select rank(salary) over (order by name) from emp",
+		"select lag(name, salary) over (order by name) from emp",
+		"select lag(salary, 1, 'x') over (order by name) from emp",
+		"select sum(name) over (order by name) from emp",
+		"select sum(salary) over (order by name range between 1 preceding and current row) from emp",
+		"select sum(salary) over (order by name rows between current row and 1 preceding) from emp",
+	} {
+		if _, err := execForTest(t, c, bp, sql); err == nil {
+			t.Errorf("%s: expected an error", sql)
+		}
+	}
+}
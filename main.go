@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,14 +18,19 @@ import (
 )
 
 var helpText = `Enter a SQL query terminated by a ; to process it.  Commands prefixed with \ are processed as shell commands.
+Prefix a query with EXPLAIN to show its plan, or with EXPLAIN ANALYZE to run it and show the actual rows, time and pages of each operator.
+Use EXPLAIN (FORMAT JSON) or EXPLAIN (FORMAT DOT) to export the plan, and EXPLAIN (ANALYZE, FORMAT JSON) to include the statistics.
 
 Available shell commands:
 	\h : This help
 	\c path/to/catalog : Change the current database to a specified catalog file
-	\d : List tables and fields in the current database
+	\d : List tables and fields in the current schema
 	\f : List available functions for use in queries
 	\a : Toggle aligned vs csv output
     \o : Toggle query optimization
+	\v : Toggle vectorized execution
+	\p [workers] : Set the number of workers queries are split among, or show it.  1 disables parallel execution
+	\t [seconds] : Set the time queries may run for before they are canceled, or show it.  0 means no limit
 	\l table path/to/file [sep] [hasHeader]: Append csv file to end of table.  Default to sep = ',', hasHeader = 'true'
 	\z : Compute statistics for the database`
 
@@ -31,22 +39,63 @@ func printCatalog(c *godb.Catalog) {
 	fmt.Printf("\033[34m%s\n\033[0m", s)
 }
 
-func main() {
-	alarm := make(chan int, 1)
+// Commit tid, then recompute the statistics that its modifications made
+// stale, as a DB does after each commit, so that later queries are planned
+// with them.
+func commit(bp *godb.BufferPool, c *godb.Catalog, tid godb.TransactionID) {
+	bp.CommitTransaction(tid)
+	if err := c.RefreshStaleStats(); err != nil {
+		fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+	}
+}
 
+// Serve the database in dir over the PostgreSQL protocol until interrupted.
+func serve(addr string, dir string) {
+	db, err := godb.Open(dir)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	s := godb.NewServer(db)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		c := make(chan os.Signal)
+		<-interrupt
+		s.Close()
+	}()
+	fmt.Printf("Serving %s on %s\n", dir, addr)
+	if err := s.ListenAndServe(addr); err != nil {
+		log.Print(err.Error())
+	}
+	if err := db.Close(); err != nil {
+		log.Fatal(err.Error())
+	}
+}
 
+func main() {
+	listen := flag.String("listen", "", "serve the database over the PostgreSQL protocol on this address, e.g., :5432, instead of starting the shell")
+	dir := flag.String("dir", "godb", "the directory of the database")
+	flag.Parse()
+	if *listen != "" {
+		serve(*listen, *dir)
+		return
+	}
+
+	// Ctrl-C cancels the running query
+	var queryMu sync.Mutex
+	var cancelQuery context.CancelFunc
+	go func() {
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGINT)
-		go func() {
-			for {
-				<-c
-				alarm <- 1
+		for range c {
+			queryMu.Lock()
+			if cancelQuery != nil {
+				cancelQuery()
 				fmt.Println("Interrupted query.")
 			}
-		}()
-
+			queryMu.Unlock()
+		}
 	}()
+	var statementTimeout time.Duration
 
 	bp, err := godb.NewBufferPool(10000)
 	if err != nil {
@@ -54,7 +103,7 @@ func main() {
 	}
 
 	catName := "catalog.txt"
-	catPath := "godb"
+	catPath := *dir
 
 	c, err := godb.NewCatalogFromFile(catName, bp, catPath)
 	if err != nil {
@@ -134,8 +183,42 @@ func main() {
 				} else {
 					fmt.Println("\033[32;1mOptimization disabled\033[0m\n\n")
 				}
+			case 'v':
+				godb.EnableVectorizedExecution = !godb.EnableVectorizedExecution
+				if godb.EnableVectorizedExecution {
+					fmt.Println("Vectorized execution enabled")
+				} else {
+					fmt.Println("Vectorized execution disabled")
+				}
+			case 'p':
+				if len(text) > 3 {
+					n, err := strconv.Atoi(strings.TrimSpace(text[3:]))
+					if err != nil || n < 1 {
+						fmt.Printf("\033[31;1mExpected a number of workers after \\p\033[0m\n")
+						continue
+					}
+					godb.Parallelism = n
+				}
+				fmt.Printf("Queries run with %d workers\n", godb.Parallelism)
+			case 't':
+				if len(text) > 3 {
+					secs, err := strconv.ParseFloat(strings.TrimSpace(text[3:]), 64)
+					if err != nil || secs < 0 {
+						fmt.Printf("\033[31;1mExpected a number of seconds after \\t\033[0m\n")
+						continue
+					}
+					statementTimeout = time.Duration(secs * float64(time.Second))
+				}
+				if statementTimeout == 0 {
+					fmt.Println("No statement timeout")
+				} else {
+					fmt.Printf("Statement timeout is %v\n", statementTimeout)
+				}
 			case 'z':
-				c.ComputeTableStats()
+				if err := c.Analyze(""); err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					continue
+				}
 				fmt.Printf("\033[32;1mAnalysis Complete\033[0m\n\n")
 			case '?':
 				fallthrough
@@ -184,13 +267,6 @@ func main() {
 		}
 		query = strings.TrimSpace(query + " " + text[0:len(text)-1])
 
-		explain := false
-		if strings.HasPrefix(strings.ToLower(query), "explain") {
-			queryParts := strings.Split(query, " ")
-			query = strings.Join(queryParts[1:], " ")
-			explain = true
-		}
-
 		queryType, plan, err := godb.Parse(c, query)
 		query = ""
 		nresults := 0
@@ -222,13 +298,12 @@ func main() {
 			fmt.Printf("\033[31;1mUnknown query type\033[0m\n")
 			continue
 
+		case godb.CreateTableAsQueryType:
+			// the returned insert fills the table and then adds it to the
+			// catalog, which is saved once it has run
+			fallthrough
+
 		case godb.IteratorType:
-			if explain {
-				fmt.Printf("\033[32m")
-				godb.PrintPhysicalPlan(plan, "")
-				fmt.Printf("\033[0m\n")
-				break
-			}
 			if autocommit {
 				tid = godb.NewTID()
 				err := bp.BeginTransaction(tid)
@@ -239,18 +314,46 @@ func main() {
 			}
 			start := time.Now()
 
-			iter, err := plan.Iterator(tid)
+			var ctx context.Context
+			var cancel context.CancelFunc
+			if statementTimeout > 0 {
+				ctx, cancel = context.WithTimeout(context.Background(), statementTimeout)
+			} else {
+				ctx, cancel = context.WithCancel(context.Background())
+			}
+			queryMu.Lock()
+			cancelQuery = cancel
+			queryMu.Unlock()
+			restore := godb.SetStatementContext(tid, ctx)
+			endQuery := func() {
+				restore()
+				queryMu.Lock()
+				cancelQuery = nil
+				queryMu.Unlock()
+				cancel()
+			}
+
+			it, err := plan.Open(tid)
 			if err != nil {
+				endQuery()
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+				if autocommit {
+					bp.AbortTransaction(tid)
+				}
 				continue
 			}
 
 			fmt.Printf("\033[32;4m%s\033[0m\n", plan.Descriptor().HeaderString(aligned))
 
+			failed := false
 			for {
-				tup, err := iter()
+				tup, err := it.Next()
 				if err != nil {
+					if godb.IsCanceled(err) {
+						fmt.Println("Aborting")
+					}
 					fmt.Printf("%s\n", err.Error())
+					failed = true
 					break
 				}
 				if tup == nil {
@@ -259,17 +362,22 @@ func main() {
 					fmt.Printf("\033[32m%s\033[0m\n", tup.PrettyPrintString(aligned))
 				}
 				nresults++
-				select {
-				case <-alarm:
-					fmt.Println("Aborting")
-					goto outer
-				default:
+			}
+			it.Close()
+			endQuery()
+			if queryType == godb.CreateTableAsQueryType && !failed {
+				if err := c.SaveToFile(catName, catPath); err != nil {
+					fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+					failed = true
 				}
 			}
 			if autocommit {
-				bp.CommitTransaction(tid)
+				if failed {
+					bp.AbortTransaction(tid)
+				} else {
+					commit(bp, c, tid)
+				}
 			}
-		outer:
 			fmt.Printf("\033[32;1m(%d results)\033[0m\n", nresults)
 			duration := time.Since(start)
 			fmt.Printf("\033[32;1m%v\033[0m\n\n", duration)
@@ -301,21 +409,31 @@ func main() {
 				fmt.Printf("\033[31;1m%s\033[0m\n", "Cannot commit transaction unless in transaction")
 				continue
 			}
-			bp.CommitTransaction(tid)
+			commit(bp, c, tid)
 			autocommit = true
 			fmt.Printf("\033[32;1mCOMMIT\033[0m\n\n")
-		case godb.CreateTableQueryType:
+		case godb.CreateTableQueryType, godb.CreateViewQueryType:
 			fmt.Printf("\033[32;1mCREATE\033[0m\n\n")
 			err := c.SaveToFile(catName, catPath)
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 			}
-		case godb.DropTableQueryType:
+		case godb.AnalyzeQueryType:
+			fmt.Printf("\033[32;1mANALYZE\033[0m\n\n")
+		case godb.DropTableQueryType, godb.DropSchemaQueryType:
 			fmt.Printf("\033[32;1mDROP\033[0m\n\n")
 			err := c.SaveToFile(catName, catPath)
 			if err != nil {
 				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
 			}
+		case godb.CreateSchemaQueryType:
+			fmt.Printf("\033[32;1mCREATE SCHEMA\033[0m\n\n")
+			err := c.SaveToFile(catName, catPath)
+			if err != nil {
+				fmt.Printf("\033[31;1m%s\033[0m\n", err.Error())
+			}
+		case godb.UseSchemaQueryType:
+			fmt.Printf("\033[32;1mUSE %s\033[0m\n\n", c.CurrentSchema())
 		}
 	}
 }